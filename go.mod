@@ -8,7 +8,7 @@ require (
 	github.com/lib/pq v1.1.1 // indirect
 	github.com/mattermost/go-i18n v1.10.0 // indirect
 	github.com/mattermost/mattermost-server v0.0.0-20190506132237-dce6cb601f15
-	github.com/nicksnyder/go-i18n v1.10.0 // indirect
+	github.com/nicksnyder/go-i18n v1.10.0
 	github.com/pelletier/go-toml v1.4.0 // indirect
 	github.com/pkg/errors v0.8.1
 	github.com/segmentio/analytics-go v2.0.1-0.20160426181448-2d840d861c32+incompatible
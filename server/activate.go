@@ -10,7 +10,7 @@ import (
 func (p *Plugin) OnActivate() error {
 	p.API.LogDebug("Activating NPS plugin")
 
-	if !p.canSendDiagnostics() {
+	if !p.canCollectResponses() {
 		errMsg := "Not activating NPS plugin because diagnostics are disabled"
 		p.API.LogError(errMsg)
 		return errors.New(errMsg)
@@ -24,11 +24,31 @@ func (p *Plugin) OnActivate() error {
 
 	p.serverVersion = getServerVersion(p.API.GetServerVersion())
 
+	actionSecret, appErr := p.ensureSecret(ACTION_SECRET_KEY)
+	if appErr != nil {
+		return errors.Wrap(appErr, "Failed to ensure action secret exists")
+	}
+	p.actionSecret = actionSecret
+
+	anonymousSalt, appErr := p.ensureSecret(ANONYMOUS_SALT_KEY)
+	if appErr != nil {
+		return errors.Wrap(appErr, "Failed to ensure anonymous salt exists")
+	}
+	p.anonymousSalt = anonymousSalt
+
+	if err := p.loadTranslations(); err != nil {
+		p.API.LogWarn("Failed to load translations. Surveybot messages will be sent in English.", "err", err.Error())
+	}
+
 	if err := p.initializeClient(); err != nil {
 		p.API.LogError("Failed to initialize Segment client", "err", err.Error())
 		return err
 	}
 
+	if err := p.API.RegisterCommand(getCommand()); err != nil {
+		return errors.Wrap(err, "Failed to register /nps command")
+	}
+
 	now := p.now().UTC()
 
 	if err := p.clearStaleLocks(now); err != nil {
@@ -47,6 +67,16 @@ func (p *Plugin) OnActivate() error {
 		go p.checkForNextSurvey(now)
 	}
 
+	go p.checkForRecurringSurvey(now)
+
+	p.startJobs()
+
+	return nil
+}
+
+func (p *Plugin) OnDeactivate() error {
+	p.stopAllJobs()
+
 	return nil
 }
 
@@ -34,6 +34,10 @@ func TestOnActivate(t *testing.T) {
 		api.On("GetUserByUsername", "surveybot").Return(&model.User{Id: botUserID}, nil)
 		api.On("GetBot", botUserID, true).Return(&model.Bot{UserId: botUserID}, nil)
 		api.On("GetServerVersion").Return(serverVersion)
+		api.On("KVGet", ACTION_SECRET_KEY).Return([]byte("secret"), nil)
+		api.On("KVGet", ANONYMOUS_SALT_KEY).Return([]byte("salt"), nil)
+		api.On("GetBundlePath").Return("/foo/bar", nil)
+		api.On("RegisterCommand", getCommand()).Return(nil)
 		api.On("KVList", 0, 100).Return([]string{}, nil)
 		api.On("KVGet", fmt.Sprintf(SERVER_UPGRADE_KEY, serverVersion)).Return(mustMarshalJSON(&serverUpgrade{}), nil)
 		defer api.AssertExpectations(t)
@@ -52,6 +56,8 @@ func TestOnActivate(t *testing.T) {
 
 		assert.Equal(t, botUserID, p.botUserID)
 		assert.Equal(t, serverVersion, p.serverVersion)
+		assert.Equal(t, []byte("secret"), p.actionSecret)
+		assert.Equal(t, []byte("salt"), p.anonymousSalt)
 		assert.NotNil(t, p.client)
 	})
 
@@ -66,6 +72,10 @@ func TestOnActivate(t *testing.T) {
 		api.On("GetUserByUsername", "surveybot").Return(&model.User{Id: botUserID}, nil)
 		api.On("GetBot", botUserID, true).Return(&model.Bot{UserId: botUserID}, nil)
 		api.On("GetServerVersion").Return(serverVersion)
+		api.On("KVGet", ACTION_SECRET_KEY).Return([]byte("secret"), nil)
+		api.On("KVGet", ANONYMOUS_SALT_KEY).Return([]byte("salt"), nil)
+		api.On("GetBundlePath").Return("/foo/bar", nil)
+		api.On("RegisterCommand", getCommand()).Return(nil)
 		api.On("KVList", 0, 100).Return([]string{}, nil)
 		api.On("KVGet", fmt.Sprintf(SERVER_UPGRADE_KEY, serverVersion)).Return(nil, &model.AppError{})
 		defer api.AssertExpectations(t)
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const (
+	// ANONYMOUS_SALT_KEY is used to store the randomly generated salt used to hash user IDs when surveys are anonymous.
+	// It's generated the first time that the plugin is activated.
+	ANONYMOUS_SALT_KEY = "AnonymousSalt"
+
+	// The minimum number of scores that must be given to a survey before its results are shown when surveys are
+	// anonymous. Can be overridden by MinAnonymousGroupSize.
+	DEFAULT_MIN_ANONYMOUS_GROUP_SIZE = 5
+
+	// ANONYMOUS_ID_SIZE is the number of bytes of the hash that are kept in an anonymous ID. Encoded as hex, that's the
+	// same length as a user ID, so keys containing it fit within model.KEY_VALUE_KEY_MAX_RUNES.
+	ANONYMOUS_ID_SIZE = 13
+)
+
+// getAnonymousID returns a salted one-way hash of the user's ID. It's the same for every response from a user, so it
+// can be used to tell whether a user has already responded without identifying them.
+func (p *Plugin) getAnonymousID(userID string) string {
+	mac := hmac.New(sha256.New, p.anonymousSalt)
+	mac.Write([]byte(userID))
+
+	return hex.EncodeToString(mac.Sum(nil)[:ANONYMOUS_ID_SIZE])
+}
+
+// getResponseUserID returns the ID that the user's responses are stored and reported under. That's the user's
+// anonymous ID when surveys are anonymous and their actual ID otherwise.
+func (p *Plugin) getResponseUserID(userID string) string {
+	if p.getConfiguration().AnonymousSurveys {
+		return p.getAnonymousID(userID)
+	}
+
+	return userID
+}
+
+// anonymize removes anything that identifies the user from a response that was stored before surveys were made
+// anonymous.
+func (p *Plugin) anonymize(response *surveyResponse) *surveyResponse {
+	if response.Anonymous {
+		return response
+	}
+
+	anonymized := *response
+	anonymized.UserID = p.getAnonymousID(response.UserID)
+	anonymized.UserRole = ""
+	anonymized.UserCreateAt = 0
+	anonymized.Anonymous = true
+
+	return &anonymized
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetAnonymousID(t *testing.T) {
+	userID := model.NewId()
+
+	p := &Plugin{anonymousSalt: []byte("salt")}
+
+	anonymousID := p.getAnonymousID(userID)
+
+	assert.NotEqual(t, userID, anonymousID)
+	assert.NotContains(t, anonymousID, userID)
+	assert.Equal(t, anonymousID, p.getAnonymousID(userID))
+	assert.NotEqual(t, anonymousID, p.getAnonymousID(model.NewId()))
+	assert.NotEqual(t, anonymousID, (&Plugin{anonymousSalt: []byte("other")}).getAnonymousID(userID))
+
+	assert.Len(t, anonymousID, len(userID))
+	assert.True(t, utf8.RuneCountInString(fmt.Sprintf(RESPONSE_KEY, "5.10.10", anonymousID)) <= model.KEY_VALUE_KEY_MAX_RUNES)
+}
+
+func TestGetResponseUserID(t *testing.T) {
+	userID := model.NewId()
+
+	t.Run("should use the user's ID", func(t *testing.T) {
+		p := &Plugin{configuration: &configuration{}}
+
+		assert.Equal(t, userID, p.getResponseUserID(userID))
+	})
+
+	t.Run("should use the user's anonymous ID when surveys are anonymous", func(t *testing.T) {
+		p := &Plugin{configuration: &configuration{AnonymousSurveys: true}}
+
+		assert.Equal(t, p.getAnonymousID(userID), p.getResponseUserID(userID))
+	})
+}
+
+func TestAnonymize(t *testing.T) {
+	userID := model.NewId()
+
+	p := &Plugin{anonymousSalt: []byte("salt")}
+
+	response := &surveyResponse{
+		UserID:        userID,
+		ServerVersion: "5.10.0",
+		UserRole:      "system_admin",
+		UserCreateAt:  1234,
+		LicenseSKU:    "e20",
+		Score:         7,
+	}
+
+	anonymized := p.anonymize(response)
+
+	assert.Equal(t, &surveyResponse{
+		UserID:        p.getAnonymousID(userID),
+		ServerVersion: "5.10.0",
+		LicenseSKU:    "e20",
+		Score:         7,
+		Anonymous:     true,
+	}, anonymized)
+	assert.Equal(t, userID, response.UserID, "should not modify the original response")
+
+	assert.Equal(t, anonymized, p.anonymize(anonymized), "should not hash an anonymous ID again")
+}
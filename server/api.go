@@ -10,7 +10,6 @@ import (
 
 	"github.com/mattermost/mattermost-server/model"
 	"github.com/mattermost/mattermost-server/plugin"
-	"github.com/pkg/errors"
 )
 
 type apiHandler func(w http.ResponseWriter, r *http.Request)
@@ -31,6 +30,51 @@ func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 			Method:  http.MethodPost,
 			Handler: requiresUserId(p.submitScore),
 		},
+		{
+			Path:    "/api/v1/answer",
+			Method:  http.MethodPost,
+			Handler: requiresUserId(p.submitAnswerDialog),
+		},
+		{
+			Path:    "/api/v1/opt_out",
+			Method:  http.MethodPost,
+			Handler: requiresUserId(p.submitOptOut),
+		},
+		{
+			Path:    "/api/v1/questions",
+			Method:  http.MethodGet,
+			Handler: requiresUserId(p.requiresSystemAdmin(p.getSurveyQuestions)),
+		},
+		{
+			Path:    "/api/v1/questions",
+			Method:  http.MethodPut,
+			Handler: requiresUserId(p.requiresSystemAdmin(p.updateSurveyQuestions)),
+		},
+		{
+			Path:    "/api/v1/results",
+			Method:  http.MethodGet,
+			Handler: requiresUserId(p.requiresSystemAdmin(p.getResults)),
+		},
+		{
+			Path:    "/api/v1/export",
+			Method:  http.MethodGet,
+			Handler: requiresUserId(p.requiresSystemAdmin(p.exportResults)),
+		},
+		{
+			Path:    "/api/v1/user_data",
+			Method:  http.MethodGet,
+			Handler: requiresUserId(p.requiresSystemAdmin(p.exportUserData)),
+		},
+		{
+			Path:    "/api/v1/user_data",
+			Method:  http.MethodDelete,
+			Handler: requiresUserId(p.requiresSystemAdmin(p.purgeUserData)),
+		},
+		{
+			Path:    "/api/v1/webhook_failures",
+			Method:  http.MethodGet,
+			Handler: requiresUserId(p.requiresSystemAdmin(p.getWebhookFailures)),
+		},
 	}
 
 	routeFound := false
@@ -64,7 +108,7 @@ func (p *Plugin) userConnected(w http.ResponseWriter, r *http.Request) {
 }
 
 func (p *Plugin) checkForDMs(userID string) *model.AppError {
-	if !p.canSendDiagnostics() {
+	if !p.canCollectResponses() {
 		return nil
 	}
 
@@ -83,17 +127,27 @@ func (p *Plugin) checkForDMs(userID string) *model.AppError {
 		return err
 	}
 
+	p.checkForUserDMs(user, now)
+
+	return nil
+}
+
+// checkForUserDMs sends the user any admin notice or survey DMs that are due. The caller is expected to hold the user's
+// USER_LOCK_KEY.
+func (p *Plugin) checkForUserDMs(user *model.User, now time.Time) {
 	if _, err := p.checkForAdminNoticeDM(user); err != nil {
-		p.API.LogError("Failed to check for notice of scheduled survey for user", "err", err, "user_id", userID)
+		p.API.LogError("Failed to check for notice of scheduled survey for user", "err", err, "user_id", user.Id)
 	}
 
 	if _, err := p.checkForSurveyDM(user, now); err != nil {
-		p.API.LogError("Failed to check for survey for user", "err", err, "user_id", userID)
+		p.API.LogError("Failed to check for survey for user", "err", err, "user_id", user.Id)
 	}
-
-	return nil
 }
 
+// submitScore handles the user answering one of the survey questions. The question is identified by the question_id
+// in the action's Context, and older survey posts without one are treated as answering the NPS question. Answers must
+// be signed for the user's current survey, except that survey posts sent before answers were signed are replaced with
+// a signed copy so that the user can answer again.
 func (p *Plugin) submitScore(w http.ResponseWriter, r *http.Request) {
 	userID := r.Header.Get("Mattermost-User-ID")
 
@@ -118,55 +172,333 @@ func (p *Plugin) submitScore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var score int
-	if i, err := getScore(surveyResponse.Context["selected_option"].(string)); err != nil {
+	userSurvey, appErr := p.getUserSurveyState(userID)
+	if appErr != nil {
+		p.API.LogError("Failed to get user survey state", "user_id", userID, "err", appErr)
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !userSurvey.isSurveyPost(surveyResponse.PostId) {
+		p.API.LogWarn("Rejected answer for a post that isn't the user's survey", "user_id", userID, "post_id", surveyResponse.PostId)
+
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	signed := p.verifyActionContext(surveyResponse.Context, userID, userSurvey)
+	if _, hasSignature := surveyResponse.Context["signature"]; !signed && hasSignature {
+		p.API.LogWarn("Rejected answer with an invalid signature", "user_id", userID, "post_id", surveyResponse.PostId)
+
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if p.isSurveyClosed(userSurvey) {
+		// Replace the late survey with the closed message in case it wasn't updated when the survey expired
+		response := model.PostActionIntegrationResponse{
+			Update:        p.buildSurveyClosedPost(user),
+			EphemeralText: p.getUserTranslateFunc(user)(surveyClosedError),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response.ToJson())
+		return
+	}
+
+	questions, appErr := p.getQuestions()
+	if appErr != nil {
+		p.API.LogError("Failed to get survey questions", "err", appErr)
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !signed {
+		// The survey was sent before answers were signed, so replace it with a signed copy for the user to answer again
+		response, appErr := p.getOrCreateSurveyResponse(user, p.now().UTC())
+		if appErr != nil {
+			p.API.LogError("Failed to get survey response", "user_id", userID, "err", appErr)
+
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		update := model.PostActionIntegrationResponse{
+			Update:        p.buildSurveyPost(user, userSurvey, questions, response),
+			EphemeralText: p.getUserTranslateFunc(user)(surveyUnsignedError),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(update.ToJson())
+		return
+	}
+
+	questionID := NPS_QUESTION_ID
+	if id, ok := surveyResponse.Context["question_id"].(string); ok && id != "" {
+		questionID = id
+	}
+
+	question := findQuestion(questions, questionID)
+	if question == nil {
+		p.API.LogError("Score response is for an unknown question", "question_id", questionID)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if question.Type == QUESTION_TYPE_FREE_TEXT {
+		// The answer will be submitted to submitAnswerDialog
+		if appErr := p.openAnswerDialog(user, surveyResponse.TriggerId, surveyResponse.PostId, question); appErr != nil {
+			p.API.LogError("Failed to open survey question dialog", "err", appErr)
+
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write((&model.PostActionIntegrationResponse{}).ToJson())
+		return
+	}
+
+	selectedOption, ok := surveyResponse.Context["selected_option"].(string)
+	if !ok {
+		p.API.LogError("Score response is missing selected_option")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	answer, err := question.parseAnswer(selectedOption)
+	if err != nil {
 		p.API.LogError("Score response contains invalid score")
 		w.WriteHeader(http.StatusBadRequest)
 		return
-	} else {
-		score = int(i)
 	}
 
-	p.API.LogDebug(fmt.Sprintf("Received score of %d from %s", score, r.Header.Get("Mattermost-User-ID")))
+	if question.ID == NPS_QUESTION_ID && p.getConfiguration().getScoreChangeWindow() > 0 {
+		if response, appErr := p.getOrCreateSurveyResponse(user, p.now().UTC()); appErr != nil {
+			p.API.LogWarn("Failed to check if score can be changed", "err", appErr)
+		} else if p.isScoreLocked(response) {
+			// Show the user their final score without the dropdown
+			update := model.PostActionIntegrationResponse{
+				Update:        p.buildSurveyPost(user, userSurvey, questions, response),
+				EphemeralText: p.getUserTranslateFunc(user)(surveyScoreLockedError),
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(update.ToJson())
+			return
+		}
+	}
 
-	now := p.now().UTC()
+	p.API.LogDebug(fmt.Sprintf("Received answer of %s to %s from %s", answer, question.ID, userID))
 
-	if err := p.sendScore(score, userID, now.UnixNano()/int64(time.Millisecond)); err != nil {
-		p.API.LogError("Failed to send Surveybot feedback to Segment", "err", err.Error())
+	response := p.recordAnswer(user, question, answer, p.now().UTC())
 
-		// Still appear to the end user as if their feedback was actually sent
+	// Send response to update score post
+	update := model.PostActionIntegrationResponse{
+		Update: p.buildSurveyPost(user, userSurvey, questions, response),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(update.ToJson())
+}
+
+// submitAnswerDialog handles the user submitting the dialog opened for a QUESTION_TYPE_FREE_TEXT question.
+func (p *Plugin) submitAnswerDialog(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	var request *model.SubmitDialogRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 2*MAX_FREE_TEXT_ANSWER_LENGTH+2048)).Decode(&request); err != nil {
+		p.API.LogError("Failed to decode survey answer dialog", "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if request.Cancelled {
+		w.WriteHeader(http.StatusOK)
+		return
 	}
 
-	isFirstResponse, appErr := p.markSurveyAnswered(userID, now)
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		p.API.LogError("Failed to get user", "user_id", userID, "err", appErr)
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	userSurvey, appErr := p.getUserSurveyState(userID)
+	if appErr != nil {
+		p.API.LogError("Failed to get user survey state", "user_id", userID, "err", appErr)
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// The dialog's CallbackId is the ID of the survey post that it was opened from
+	if !userSurvey.isSurveyPost(request.CallbackId) {
+		p.API.LogWarn("Rejected answer for a post that isn't the user's survey", "user_id", userID, "post_id", request.CallbackId)
+
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	questions, appErr := p.getQuestions()
+	if appErr != nil {
+		p.API.LogError("Failed to get survey questions", "err", appErr)
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	question := findQuestion(questions, request.State)
+	if question == nil || question.Type != QUESTION_TYPE_FREE_TEXT {
+		p.API.LogError("Survey answer dialog is for an unknown question", "question_id", request.State)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	submitted, _ := request.Submission["answer"].(string)
+
+	answer, err := question.parseAnswer(submitted)
+	if err != nil {
+		// Let the user fix their answer without closing the dialog
+		response := &model.SubmitDialogResponse{
+			Errors: map[string]string{
+				"answer": p.getUserTranslateFunc(user)(answerDialogInvalidError),
+			},
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response.ToJson())
+		return
+	}
+
+	if p.isSurveyClosed(userSurvey) {
+		response := &model.SubmitDialogResponse{
+			Errors: map[string]string{
+				"answer": p.getUserTranslateFunc(user)(surveyClosedError),
+			},
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response.ToJson())
+		return
+	}
+
+	response := p.recordAnswer(user, question, answer, p.now().UTC())
+
+	if appErr := p.updateSurveyPost(user, userSurvey, request.CallbackId, questions, response); appErr != nil {
+		p.API.LogWarn("Failed to update survey post", "err", appErr)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// recordAnswer sends and stores the user's answer to a question. Returns the user's response to the survey including
+// the new answer.
+func (p *Plugin) recordAnswer(user *model.User, question *surveyQuestion, answer string, now time.Time) *surveyResponse {
+	timestamp := now.UnixNano() / int64(time.Millisecond)
+
+	redactionCount := 0
+	if question.Type == QUESTION_TYPE_FREE_TEXT {
+		// Remove any personal information before the answer is stored or sent anywhere
+		answer, redactionCount = p.redactFeedback(answer)
+	}
+
+	response, changed, appErr := p.storeAnswer(user, question.ID, answer, redactionCount, now)
+	if appErr != nil {
+		p.API.LogWarn("Failed to store survey score", "err", appErr)
+
+		// Still show the user their answer even though it wasn't stored
+		response = &surveyResponse{}
+		changed = response.setAnswer(question.ID, answer, now)
+	}
+
+	switch {
+	case !changed:
+		// The user picked the same score again, so it's already been sent
+	case question.ID == NPS_QUESTION_ID:
+		score, _ := strconv.Atoi(answer)
+
+		// The revision lets the latest score be identified when a user changes their answer
+		if err := p.sendScore(score, len(response.ScoreHistory), user.Id, timestamp); err != nil {
+			p.API.LogError("Failed to send Surveybot score", "err", err.Error())
+
+			// Still appear to the end user as if their feedback was actually sent
+		}
+	default:
+		if err := p.sendAnswer(question.ID, answer, redactionCount, user.Id, timestamp); err != nil {
+			p.API.LogError("Failed to send Surveybot answer", "err", err.Error())
+		}
+	}
+
+	isFirstResponse, appErr := p.markSurveyAnswered(user.Id, now)
 	if appErr != nil {
 		p.API.LogWarn("Failed to mark survey as answered", "err", appErr)
 	}
 
 	// Thank the user for their feedback when they first answer the survey
 	if isFirstResponse {
-		p.CreateBotDMPost(userID, p.buildFeedbackRequestPost())
+		if post, appErr := p.CreateBotDMPost(user.Id, p.buildFeedbackRequestPost(user, response)); appErr == nil {
+			// Replies to the feedback request are treated as feedback on this survey
+			if appErr := p.setFeedbackPostID(user.Id, post.Id); appErr != nil {
+				p.API.LogWarn("Failed to save feedback request post", "err", appErr)
+			}
+		}
 	}
 
-	// Send response to update score post
-	response := model.PostActionIntegrationResponse{
-		Update: p.buildAnsweredSurveyPost(user, score),
+	return response
+}
+
+// updateSurveyPost shows the user's latest answers on the survey post that was sent to them.
+func (p *Plugin) updateSurveyPost(user *model.User, userSurvey *userSurveyState, postID string, questions []*surveyQuestion, response *surveyResponse) *model.AppError {
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil {
+		return appErr
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(response.ToJson())
+	channel, appErr := p.API.GetDirectChannel(user.Id, p.botUserID)
+	if appErr != nil {
+		return appErr
+	}
+
+	if post.ChannelId != channel.Id || post.UserId != p.botUserID {
+		return &model.AppError{Message: "Post is not a survey sent to the user"}
+	}
+
+	updated := p.buildSurveyPost(user, userSurvey, questions, response)
+	post.Type = updated.Type
+	post.Props = updated.Props
+
+	_, appErr = p.API.UpdatePost(post)
+	return appErr
 }
 
-func getScore(selectedOption string) (int64, error) {
-	score, err := strconv.ParseInt(selectedOption, 10, 0)
-	if err != nil {
-		return 0, err
+func (p *Plugin) getResults(w http.ResponseWriter, r *http.Request) {
+	surveyID := p.serverVersion
+	if id := r.URL.Query().Get("survey"); id != "" {
+		surveyID = id
+	} else if version := r.URL.Query().Get("version"); version != "" {
+		surveyID = getServerVersion(version)
+	}
+
+	results, appErr := p.getSurveyResults(surveyID)
+	if appErr != nil {
+		p.API.LogError("Failed to get survey results", "survey_id", surveyID, "err", appErr)
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 
-	if score < 0 || score > 10 {
-		return 0, errors.New("score out of range")
+	if results == nil {
+		http.NotFound(w, r)
+		return
 	}
 
-	return score, nil
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
 }
 
 func requiresUserId(handler apiHandler) apiHandler {
@@ -179,3 +511,24 @@ func requiresUserId(handler apiHandler) apiHandler {
 		handler(w, r)
 	}
 }
+
+func (p *Plugin) requiresSystemAdmin(handler apiHandler) apiHandler {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("Mattermost-User-ID")
+
+		user, appErr := p.API.GetUser(userID)
+		if appErr != nil {
+			p.API.LogError("Failed to get user", "user_id", userID, "err", appErr)
+
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if !isSystemAdmin(user) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		handler(w, r)
+	}
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -14,6 +17,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCheckForDMs(t *testing.T) {
@@ -91,12 +95,20 @@ func TestCheckForDMs(t *testing.T) {
 func TestSubmitScore(t *testing.T) {
 	botUserID := model.NewId()
 	userID := model.NewId()
+	postID := model.NewId()
 	userSurveyKey := fmt.Sprintf(USER_SURVEY_KEY, userID)
+	serverVersion := "5.10.0"
+	responseKey := fmt.Sprintf(RESPONSE_KEY, serverVersion, userID)
 
 	now := toDate(2018, time.April, 1)
 
 	makeAPIMock := func() *plugintest.API {
 		api := &plugintest.API{}
+
+		// Answers are stored while holding the user's lock
+		api.On("KVCompareAndSet", fmt.Sprintf(USER_LOCK_KEY, userID), []byte(nil), mustMarshalJSON(now)).Return(true, nil).Maybe()
+		api.On("KVDelete", fmt.Sprintf(USER_LOCK_KEY, userID)).Return(nil).Maybe()
+
 		api.On("LogDebug", mock.Anything).Maybe()
 
 		// Disabling diagnostics allows the handler to run, but prevents data from being sent to Segment
@@ -108,25 +120,51 @@ func TestSubmitScore(t *testing.T) {
 				EnableDiagnostics: model.NewBool(false),
 			},
 		}).Maybe()
+		api.On("GetTeamMembersForUser", userID, 0, 50).Return([]*model.TeamMember{}, nil).Maybe()
+		api.On("GetLicense").Return(nil).Maybe()
+		api.On("KVGet", QUESTIONS_KEY).Return(nil, nil).Maybe()
 
 		return api
 	}
 
 	t.Run("should send score to segment, respond for additional feedback, and update the score post", func(t *testing.T) {
+		feedbackPostID := model.NewId()
+
 		api := makeAPIMock()
 		api.On("GetUser", userID).Return(&model.User{
 			Id: userID,
 		}, nil)
-		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{}), nil)
+		api.On("KVGet", responseKey).Return(nil, nil)
+		api.On("KVSet", responseKey, mustMarshalJSON(&surveyResponse{
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			UserRole:      "user",
+			CreateAt:      now,
+			Score:         10,
+			ScoreAt:       now,
+			ScoreBucket:   SCORE_BUCKET_PROMOTER,
+		})).Return(nil)
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			ScorePostId:   postID,
+		}), nil)
 		api.On("KVSet", userSurveyKey, mustMarshalJSON(&userSurveyState{
-			AnsweredAt: now,
+			ServerVersion: serverVersion,
+			ScorePostId:   postID,
+			AnsweredAt:    now,
 		})).Return(nil)
 		api.On("GetDirectChannel", userID, botUserID).Return(&model.Channel{}, nil)
-		api.On("CreatePost", mock.Anything).Return(&model.Post{}, nil)
+		api.On("CreatePost", mock.Anything).Return(&model.Post{Id: feedbackPostID}, nil)
+		api.On("KVSet", userSurveyKey, mustMarshalJSON(&userSurveyState{
+			ServerVersion:  serverVersion,
+			ScorePostId:    postID,
+			FeedbackPostId: feedbackPostID,
+		})).Return(nil)
 		defer api.AssertExpectations(t)
 
 		p := Plugin{
-			botUserID: botUserID,
+			botUserID:     botUserID,
+			serverVersion: serverVersion,
 			now: func() time.Time {
 				return now
 			},
@@ -135,9 +173,10 @@ func TestSubmitScore(t *testing.T) {
 
 		recorder := httptest.NewRecorder()
 		request := httptest.NewRequest(http.MethodPost, "/score", bytes.NewReader(mustMarshalJSON(&model.PostActionIntegrationRequest{
-			Context: map[string]interface{}{
+			PostId: postID,
+			Context: makeSignedContext(userID, serverVersion, map[string]interface{}{
 				"selected_option": "10",
-			},
+			}),
 		})))
 		request.Header.Set("Mattermost-User-ID", userID)
 
@@ -155,13 +194,39 @@ func TestSubmitScore(t *testing.T) {
 		api.On("GetUser", userID).Return(&model.User{
 			Id: userID,
 		}, nil)
+		api.On("KVGet", responseKey).Return(mustMarshalJSON(&surveyResponse{
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			UserRole:      "user",
+			CreateAt:      now.Add(-time.Minute),
+			Score:         3,
+			ScoreAt:       now.Add(-time.Minute),
+		}), nil)
+		api.On("KVSet", responseKey, mustMarshalJSON(&surveyResponse{
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			UserRole:      "user",
+			CreateAt:      now.Add(-time.Minute),
+			Score:         10,
+			ScoreAt:       now,
+			ScoreBucket:   SCORE_BUCKET_PROMOTER,
+			ScoreHistory: []*scoreRevision{
+				{
+					Score:   3,
+					ScoreAt: now.Add(-time.Minute),
+				},
+			},
+		})).Return(nil)
 		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
-			AnsweredAt: now.Add(-time.Minute),
+			ServerVersion: serverVersion,
+			ScorePostId:   postID,
+			AnsweredAt:    now.Add(-time.Minute),
 		}), nil)
 		defer api.AssertExpectations(t)
 
 		p := Plugin{
-			botUserID: botUserID,
+			botUserID:     botUserID,
+			serverVersion: serverVersion,
 			now: func() time.Time {
 				return now
 			},
@@ -170,9 +235,10 @@ func TestSubmitScore(t *testing.T) {
 
 		recorder := httptest.NewRecorder()
 		request := httptest.NewRequest(http.MethodPost, "/score", bytes.NewReader(mustMarshalJSON(&model.PostActionIntegrationRequest{
-			Context: map[string]interface{}{
+			PostId: postID,
+			Context: makeSignedContext(userID, serverVersion, map[string]interface{}{
 				"selected_option": "10",
-			},
+			}),
 		})))
 		request.Header.Set("Mattermost-User-ID", userID)
 
@@ -185,17 +251,131 @@ func TestSubmitScore(t *testing.T) {
 		assert.IsType(t, &model.PostActionIntegrationResponse{}, mustUnmarshalJSON(body, &model.PostActionIntegrationResponse{}))
 	})
 
+	t.Run("should not send the score again if the user picks the same score", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "nps")
+		require.Nil(t, err)
+		defer os.RemoveAll(dir)
+
+		existing := &surveyResponse{
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			UserRole:      "user",
+			CreateAt:      now.Add(-time.Minute),
+			Score:         10,
+			ScoreAt:       now.Add(-time.Minute),
+			ScoreBucket:   SCORE_BUCKET_PROMOTER,
+		}
+
+		api := makeAPIMock()
+		api.On("GetUser", userID).Return(&model.User{
+			Id: userID,
+		}, nil)
+		api.On("KVGet", responseKey).Return(mustMarshalJSON(existing), nil)
+		api.On("KVSet", responseKey, mustMarshalJSON(existing)).Return(nil)
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			ScorePostId:   postID,
+			AnsweredAt:    now.Add(-time.Minute),
+		}), nil)
+		defer api.AssertExpectations(t)
+
+		p := Plugin{
+			botUserID:     botUserID,
+			serverVersion: serverVersion,
+			configuration: &configuration{
+				AnalyticsSink:     SINK_FILE,
+				AnalyticsFilePath: filepath.Join(dir, "events.json"),
+			},
+			now: func() time.Time {
+				return now
+			},
+		}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/score", bytes.NewReader(mustMarshalJSON(&model.PostActionIntegrationRequest{
+			PostId: postID,
+			Context: makeSignedContext(userID, serverVersion, map[string]interface{}{
+				"selected_option": "10",
+			}),
+		})))
+		request.Header.Set("Mattermost-User-ID", userID)
+
+		p.submitScore(recorder, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+
+		_, err = os.Stat(filepath.Join(dir, "events.json"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("should not change a score once it's locked", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("GetUser", userID).Return(&model.User{
+			Id: userID,
+		}, nil)
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			ScorePostId:   postID,
+			AnsweredAt:    now.Add(-2 * time.Hour),
+		}), nil)
+		api.On("KVGet", responseKey).Return(mustMarshalJSON(&surveyResponse{
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			UserRole:      "user",
+			CreateAt:      now.Add(-2 * time.Hour),
+			Score:         3,
+			ScoreAt:       now.Add(-2 * time.Hour),
+		}), nil)
+		defer api.AssertExpectations(t)
+
+		p := Plugin{
+			botUserID:     botUserID,
+			serverVersion: serverVersion,
+			configuration: &configuration{ScoreChangeWindowHours: 1},
+			now: func() time.Time {
+				return now
+			},
+		}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/score", bytes.NewReader(mustMarshalJSON(&model.PostActionIntegrationRequest{
+			PostId: postID,
+			Context: makeSignedContext(userID, serverVersion, map[string]interface{}{
+				"selected_option": "10",
+			}),
+		})))
+		request.Header.Set("Mattermost-User-ID", userID)
+
+		p.submitScore(recorder, request)
+
+		var response *model.PostActionIntegrationResponse
+		json.NewDecoder(recorder.Result().Body).Decode(&response)
+
+		assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+		assert.Equal(t, defaultTranslations[surveyScoreLockedError], response.EphemeralText)
+
+		attachments := response.Update.Props["attachments"].([]interface{})
+		assert.Equal(t, "You selected 3 out of 10.", attachments[0].(map[string]interface{})["text"])
+	})
+
 	t.Run("should only log warning if unable to mark survey answered", func(t *testing.T) {
 		api := makeAPIMock()
 		api.On("GetUser", userID).Return(&model.User{
 			Id: userID,
 		}, nil)
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			ScorePostId:   postID,
+		}), nil).Once()
 		api.On("KVGet", userSurveyKey).Return(nil, &model.AppError{})
 		api.On("LogWarn", mock.Anything, mock.Anything, mock.Anything)
 		defer api.AssertExpectations(t)
 
 		p := Plugin{
-			botUserID: botUserID,
+			botUserID:     botUserID,
+			serverVersion: serverVersion,
 			now: func() time.Time {
 				return now
 			},
@@ -204,9 +384,10 @@ func TestSubmitScore(t *testing.T) {
 
 		recorder := httptest.NewRecorder()
 		request := httptest.NewRequest(http.MethodPost, "/score", bytes.NewReader(mustMarshalJSON(&model.PostActionIntegrationRequest{
-			Context: map[string]interface{}{
+			PostId: postID,
+			Context: makeSignedContext(userID, serverVersion, map[string]interface{}{
 				"selected_option": "10",
-			},
+			}),
 		})))
 		request.Header.Set("Mattermost-User-ID", userID)
 
@@ -219,11 +400,204 @@ func TestSubmitScore(t *testing.T) {
 		assert.IsType(t, &model.PostActionIntegrationResponse{}, mustUnmarshalJSON(body, &model.PostActionIntegrationResponse{}))
 	})
 
+	t.Run("should reject a score for a post that isn't the user's survey", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("GetUser", userID).Return(&model.User{
+			Id: userID,
+		}, nil)
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			ScorePostId:   postID,
+		}), nil)
+		api.On("LogWarn", mock.Anything, "user_id", userID, "post_id", "otherpost")
+		defer api.AssertExpectations(t)
+
+		p := Plugin{}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/score", bytes.NewReader(mustMarshalJSON(&model.PostActionIntegrationRequest{
+			PostId: "otherpost",
+			Context: makeSignedContext(userID, serverVersion, map[string]interface{}{
+				"selected_option": "10",
+			}),
+		})))
+		request.Header.Set("Mattermost-User-ID", userID)
+
+		p.submitScore(recorder, request)
+
+		assert.Equal(t, http.StatusForbidden, recorder.Result().StatusCode)
+	})
+
+	t.Run("should reject a score without a valid signature", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("GetUser", userID).Return(&model.User{
+			Id: userID,
+		}, nil)
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
+			SurveyID:      "5.10.0-dwh",
+			ServerVersion: serverVersion,
+			ScorePostId:   postID,
+		}), nil)
+		api.On("LogWarn", mock.Anything, "user_id", userID, "post_id", postID)
+		defer api.AssertExpectations(t)
+
+		p := Plugin{}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/score", bytes.NewReader(mustMarshalJSON(&model.PostActionIntegrationRequest{
+			PostId: postID,
+			// Signed for the previous survey sent to the user
+			Context: makeSignedContext(userID, serverVersion, map[string]interface{}{
+				"selected_option": "10",
+			}),
+		})))
+		request.Header.Set("Mattermost-User-ID", userID)
+
+		p.submitScore(recorder, request)
+
+		assert.Equal(t, http.StatusForbidden, recorder.Result().StatusCode)
+	})
+
+	t.Run("should replace a survey post sent before answers were signed", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("GetUser", userID).Return(&model.User{
+			Id: userID,
+		}, nil)
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			ScorePostId:   postID,
+		}), nil)
+		api.On("KVGet", responseKey).Return(nil, nil)
+		defer api.AssertExpectations(t)
+
+		p := Plugin{
+			botUserID:     botUserID,
+			serverVersion: serverVersion,
+			now: func() time.Time {
+				return now
+			},
+		}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/score", bytes.NewReader(mustMarshalJSON(&model.PostActionIntegrationRequest{
+			PostId: postID,
+			Context: map[string]interface{}{
+				"selected_option": "10",
+			},
+		})))
+		request.Header.Set("Mattermost-User-ID", userID)
+
+		p.submitScore(recorder, request)
+
+		var response *model.PostActionIntegrationResponse
+		json.NewDecoder(recorder.Result().Body).Decode(&response)
+
+		assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+		assert.Equal(t, defaultTranslations[surveyUnsignedError], response.EphemeralText)
+
+		attachments := response.Update.Props["attachments"].([]interface{})
+		actions := attachments[0].(map[string]interface{})["actions"].([]interface{})
+		context := actions[0].(map[string]interface{})["integration"].(map[string]interface{})["context"].(map[string]interface{})
+		assert.NotEmpty(t, context["signature"])
+	})
+
+	t.Run("should reject a score from a user who was never sent a survey", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("GetUser", userID).Return(&model.User{
+			Id: userID,
+		}, nil)
+		api.On("KVGet", userSurveyKey).Return(nil, nil)
+		api.On("LogWarn", mock.Anything, "user_id", userID, "post_id", postID)
+		defer api.AssertExpectations(t)
+
+		p := Plugin{}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/score", bytes.NewReader(mustMarshalJSON(&model.PostActionIntegrationRequest{
+			PostId: postID,
+			Context: makeSignedContext(userID, serverVersion, map[string]interface{}{
+				"selected_option": "10",
+			}),
+		})))
+		request.Header.Set("Mattermost-User-ID", userID)
+
+		p.submitScore(recorder, request)
+
+		assert.Equal(t, http.StatusForbidden, recorder.Result().StatusCode)
+	})
+
+	t.Run("should return error if unable to get user survey state", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("GetUser", userID).Return(&model.User{
+			Id: userID,
+		}, nil)
+		api.On("KVGet", userSurveyKey).Return(nil, &model.AppError{})
+		api.On("LogError", mock.Anything, "user_id", userID, "err", mock.Anything)
+		defer api.AssertExpectations(t)
+
+		p := Plugin{}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/score", bytes.NewReader(mustMarshalJSON(&model.PostActionIntegrationRequest{
+			PostId: postID,
+			Context: makeSignedContext(userID, serverVersion, map[string]interface{}{
+				"selected_option": "10",
+			}),
+		})))
+		request.Header.Set("Mattermost-User-ID", userID)
+
+		p.submitScore(recorder, request)
+
+		assert.Equal(t, http.StatusInternalServerError, recorder.Result().StatusCode)
+	})
+
+	t.Run("should return bad request if the selected option isn't a string", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("GetUser", userID).Return(&model.User{
+			Id: userID,
+		}, nil)
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			ScorePostId:   postID,
+		}), nil)
+		api.On("LogError", mock.Anything)
+		defer api.AssertExpectations(t)
+
+		p := Plugin{}
+		p.SetAPI(api)
+
+		for _, context := range []map[string]interface{}{
+			{"question_id": NPS_QUESTION_ID},
+			{"selected_option": 10},
+			{"selected_option": nil},
+		} {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/score", bytes.NewReader(mustMarshalJSON(&model.PostActionIntegrationRequest{
+				PostId:  postID,
+				Context: makeSignedContext(userID, serverVersion, context),
+			})))
+			request.Header.Set("Mattermost-User-ID", userID)
+
+			p.submitScore(recorder, request)
+
+			assert.Equal(t, http.StatusBadRequest, recorder.Result().StatusCode)
+		}
+	})
+
 	t.Run("should return bad request if score is missing or invalid", func(t *testing.T) {
 		api := makeAPIMock()
 		api.On("GetUser", userID).Return(&model.User{
 			Id: userID,
 		}, nil)
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			ScorePostId:   postID,
+		}), nil)
 		api.On("LogError", mock.Anything)
 		defer api.AssertExpectations(t)
 
@@ -232,9 +606,10 @@ func TestSubmitScore(t *testing.T) {
 
 		recorder := httptest.NewRecorder()
 		request := httptest.NewRequest(http.MethodPost, "/score", bytes.NewReader(mustMarshalJSON(&model.PostActionIntegrationRequest{
-			Context: map[string]interface{}{
+			PostId: postID,
+			Context: makeSignedContext(userID, serverVersion, map[string]interface{}{
 				"selected_option": "hmm",
-			},
+			}),
 		})))
 		request.Header.Set("Mattermost-User-ID", userID)
 
@@ -256,9 +631,10 @@ func TestSubmitScore(t *testing.T) {
 
 		recorder := httptest.NewRecorder()
 		request := httptest.NewRequest(http.MethodPost, "/score", bytes.NewReader(mustMarshalJSON(&model.PostActionIntegrationRequest{
-			Context: map[string]interface{}{
+			PostId: postID,
+			Context: makeSignedContext(userID, serverVersion, map[string]interface{}{
 				"selected_option": "10",
-			},
+			}),
 		})))
 		request.Header.Set("Mattermost-User-ID", userID)
 
@@ -327,60 +703,424 @@ func TestSubmitScore(t *testing.T) {
 	})
 }
 
-func TestGetScore(t *testing.T) {
-	for _, test := range []struct {
-		Name           string
-		SelectedOption string
-		ExpectedScore  int64
-		ExpectError    bool
-	}{
-		{
-			Name:           "a number",
-			SelectedOption: "7",
-			ExpectedScore:  7,
-		},
-		{
-			Name:           "zero",
-			SelectedOption: "0",
-			ExpectedScore:  0,
-		},
-		{
-			Name:           "ten",
-			SelectedOption: "10",
-			ExpectedScore:  10,
-		},
-		{
-			Name:           "too low",
-			SelectedOption: "-400",
-			ExpectError:    true,
-		},
-		{
-			Name:           "too high",
-			SelectedOption: "1000000",
-			ExpectError:    true,
-		},
-		{
-			Name:           "garbage",
-			SelectedOption: "garbage",
-			ExpectError:    true,
-		},
-		{
-			Name:           "empty",
-			SelectedOption: "",
-			ExpectError:    true,
-		},
-	} {
-		t.Run(test.Name, func(t *testing.T) {
-			score, err := getScore(test.SelectedOption)
-
-			assert.Equal(t, test.ExpectedScore, score)
-			if test.ExpectError {
-				assert.NotNil(t, err)
-			} else {
-				assert.Nil(t, err)
-			}
-		})
+// makeSignedContext adds the signed survey details that the survey post's actions contain to the given action context.
+// The signature uses an empty secret to match a Plugin created without one.
+func makeSignedContext(userID string, serverVersion string, context map[string]interface{}) map[string]interface{} {
+	p := &Plugin{}
+	for key, value := range p.getActionContext(userID, &userSurveyState{ServerVersion: serverVersion}) {
+		context[key] = value
+	}
+
+	return context
+}
+
+func TestSubmitScoreWithQuestions(t *testing.T) {
+	botUserID := model.NewId()
+	userID := model.NewId()
+	postID := model.NewId()
+	userSurveyKey := fmt.Sprintf(USER_SURVEY_KEY, userID)
+	serverVersion := "5.10.0"
+	responseKey := fmt.Sprintf(RESPONSE_KEY, serverVersion, userID)
+
+	now := toDate(2018, time.April, 1)
+
+	questions := []*surveyQuestion{
+		npsQuestion,
+		{ID: "remote", Type: QUESTION_TYPE_YES_NO, Text: "Do you work remotely?"},
+		{ID: "comments", Type: QUESTION_TYPE_FREE_TEXT, Text: "Anything else?"},
+	}
+
+	makeAPIMock := func() *plugintest.API {
+		api := &plugintest.API{}
+
+		// Answers are stored while holding the user's lock
+		api.On("KVCompareAndSet", fmt.Sprintf(USER_LOCK_KEY, userID), []byte(nil), mustMarshalJSON(now)).Return(true, nil).Maybe()
+		api.On("KVDelete", fmt.Sprintf(USER_LOCK_KEY, userID)).Return(nil).Maybe()
+
+		api.On("LogDebug", mock.Anything).Maybe()
+		api.On("GetConfig").Return(&model.Config{
+			ServiceSettings: model.ServiceSettings{
+				SiteURL: model.NewString("https://mattermost.example.com"),
+			},
+			LogSettings: model.LogSettings{
+				EnableDiagnostics: model.NewBool(false),
+			},
+		}).Maybe()
+		api.On("GetUser", userID).Return(&model.User{
+			Id: userID,
+		}, nil)
+		api.On("KVGet", QUESTIONS_KEY).Return(mustMarshalJSON(questions), nil)
+		return api
+	}
+
+	makePlugin := func(api *plugintest.API) *Plugin {
+		p := &Plugin{
+			botUserID:     botUserID,
+			serverVersion: serverVersion,
+			now: func() time.Time {
+				return now
+			},
+		}
+		p.SetAPI(api)
+
+		return p
 	}
+
+	t.Run("should store the answer to a custom question and show it on the survey post", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			ScorePostId:   postID,
+			AnsweredAt:    now.Add(-time.Minute),
+		}), nil)
+		api.On("KVGet", responseKey).Return(mustMarshalJSON(&surveyResponse{
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			CreateAt:      now.Add(-time.Minute),
+			Score:         9,
+			ScoreAt:       now.Add(-time.Minute),
+		}), nil)
+		api.On("KVSet", responseKey, mustMarshalJSON(&surveyResponse{
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			CreateAt:      now.Add(-time.Minute),
+			Score:         9,
+			ScoreAt:       now.Add(-time.Minute),
+			Answers: map[string]*surveyAnswer{
+				"remote": {Value: ANSWER_YES, AnswerAt: now},
+			},
+		})).Return(nil)
+		defer api.AssertExpectations(t)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/score", bytes.NewReader(mustMarshalJSON(&model.PostActionIntegrationRequest{
+			PostId: postID,
+			Context: makeSignedContext(userID, serverVersion, map[string]interface{}{
+				"question_id":     "remote",
+				"selected_option": ANSWER_YES,
+			}),
+		})))
+		request.Header.Set("Mattermost-User-ID", userID)
+
+		makePlugin(api).submitScore(recorder, request)
+
+		result := recorder.Result()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+
+		var response *model.PostActionIntegrationResponse
+		require.Nil(t, json.NewDecoder(result.Body).Decode(&response))
+
+		attachments := response.Update.Props["attachments"].([]interface{})
+		require.Len(t, attachments, 3)
+		assert.Equal(t, "You selected 9 out of 10.", attachments[0].(map[string]interface{})["text"])
+		assert.Equal(t, "You answered Yes.", attachments[1].(map[string]interface{})["text"])
+		assert.Equal(t, "", attachments[2].(map[string]interface{})["text"])
+		assert.Equal(t, "", response.Update.Type)
+	})
+
+	t.Run("should open a dialog for a free text question", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			ScorePostId:   postID,
+		}), nil)
+		api.On("OpenInteractiveDialog", mock.MatchedBy(func(request model.OpenDialogRequest) bool {
+			return request.TriggerId == "trigger" && request.Dialog.CallbackId == postID && request.Dialog.State == "comments"
+		})).Return(nil)
+		defer api.AssertExpectations(t)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/score", bytes.NewReader(mustMarshalJSON(&model.PostActionIntegrationRequest{
+			PostId:    postID,
+			TriggerId: "trigger",
+			Context: makeSignedContext(userID, serverVersion, map[string]interface{}{
+				"question_id": "comments",
+			}),
+		})))
+		request.Header.Set("Mattermost-User-ID", userID)
+
+		makePlugin(api).submitScore(recorder, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+	})
+
+	t.Run("should return bad request for an unknown question", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			ScorePostId:   postID,
+		}), nil)
+		api.On("LogError", mock.Anything, mock.Anything, mock.Anything)
+		defer api.AssertExpectations(t)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/score", bytes.NewReader(mustMarshalJSON(&model.PostActionIntegrationRequest{
+			PostId: postID,
+			Context: makeSignedContext(userID, serverVersion, map[string]interface{}{
+				"question_id":     "missing",
+				"selected_option": ANSWER_YES,
+			}),
+		})))
+		request.Header.Set("Mattermost-User-ID", userID)
+
+		makePlugin(api).submitScore(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Result().StatusCode)
+	})
+
+	t.Run("should return bad request for an invalid answer", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			ScorePostId:   postID,
+		}), nil)
+		api.On("LogError", mock.Anything)
+		defer api.AssertExpectations(t)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/score", bytes.NewReader(mustMarshalJSON(&model.PostActionIntegrationRequest{
+			PostId: postID,
+			Context: makeSignedContext(userID, serverVersion, map[string]interface{}{
+				"question_id":     "remote",
+				"selected_option": "maybe",
+			}),
+		})))
+		request.Header.Set("Mattermost-User-ID", userID)
+
+		makePlugin(api).submitScore(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Result().StatusCode)
+	})
+}
+
+func TestSubmitAnswerDialog(t *testing.T) {
+	botUserID := model.NewId()
+	userID := model.NewId()
+	postID := model.NewId()
+	channelID := model.NewId()
+	userSurveyKey := fmt.Sprintf(USER_SURVEY_KEY, userID)
+	serverVersion := "5.10.0"
+	responseKey := fmt.Sprintf(RESPONSE_KEY, serverVersion, userID)
+
+	now := toDate(2018, time.April, 1)
+
+	questions := []*surveyQuestion{
+		{ID: "comments", Type: QUESTION_TYPE_FREE_TEXT, Text: "Anything else?"},
+	}
+
+	makeAPIMock := func() *plugintest.API {
+		api := &plugintest.API{}
+
+		// Answers are stored while holding the user's lock
+		api.On("KVCompareAndSet", fmt.Sprintf(USER_LOCK_KEY, userID), []byte(nil), mustMarshalJSON(now)).Return(true, nil).Maybe()
+		api.On("KVDelete", fmt.Sprintf(USER_LOCK_KEY, userID)).Return(nil).Maybe()
+
+		api.On("GetConfig").Return(&model.Config{
+			ServiceSettings: model.ServiceSettings{
+				SiteURL: model.NewString("https://mattermost.example.com"),
+			},
+			LogSettings: model.LogSettings{
+				EnableDiagnostics: model.NewBool(false),
+			},
+		}).Maybe()
+		api.On("GetUser", userID).Return(&model.User{
+			Id: userID,
+		}, nil)
+		api.On("KVGet", QUESTIONS_KEY).Return(mustMarshalJSON(questions), nil).Maybe()
+		return api
+	}
+
+	makePlugin := func(api *plugintest.API) *Plugin {
+		p := &Plugin{
+			botUserID:     botUserID,
+			serverVersion: serverVersion,
+			now: func() time.Time {
+				return now
+			},
+		}
+		p.SetAPI(api)
+
+		return p
+	}
+
+	makeRequest := func(answer string) *http.Request {
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/answer", bytes.NewReader(mustMarshalJSON(&model.SubmitDialogRequest{
+			CallbackId: postID,
+			State:      "comments",
+			Submission: map[string]interface{}{
+				"answer": answer,
+			},
+		})))
+		request.Header.Set("Mattermost-User-ID", userID)
+
+		return request
+	}
+
+	t.Run("should store the answer and update the survey post", func(t *testing.T) {
+		feedbackPostID := model.NewId()
+
+		api := makeAPIMock()
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			ScorePostId:   postID,
+		}), nil)
+		api.On("KVGet", responseKey).Return(nil, nil)
+		api.On("GetTeamMembersForUser", userID, 0, 50).Return([]*model.TeamMember{}, nil)
+		api.On("GetLicense").Return(nil)
+		api.On("KVSet", responseKey, mustMarshalJSON(&surveyResponse{
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			UserRole:      "user",
+			CreateAt:      now,
+			Answers: map[string]*surveyAnswer{
+				"comments": {Value: "More coffee", AnswerAt: now},
+			},
+		})).Return(nil)
+		api.On("KVSet", userSurveyKey, mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			ScorePostId:   postID,
+			AnsweredAt:    now,
+		})).Return(nil)
+		api.On("GetDirectChannel", userID, botUserID).Return(&model.Channel{Id: channelID}, nil)
+		api.On("CreatePost", mock.Anything).Return(&model.Post{Id: feedbackPostID}, nil)
+		api.On("KVSet", userSurveyKey, mustMarshalJSON(&userSurveyState{
+			ServerVersion:  serverVersion,
+			ScorePostId:    postID,
+			FeedbackPostId: feedbackPostID,
+		})).Return(nil)
+		api.On("GetPost", postID).Return(&model.Post{
+			Id:        postID,
+			ChannelId: channelID,
+			UserId:    botUserID,
+		}, nil)
+		api.On("UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+			attachments := post.Props["attachments"].([]*model.SlackAttachment)
+			return post.Id == postID && attachments[0].Text == "You answered: More coffee"
+		})).Return(&model.Post{}, nil)
+		defer api.AssertExpectations(t)
+
+		recorder := httptest.NewRecorder()
+
+		makePlugin(api).submitAnswerDialog(recorder, makeRequest("More coffee"))
+
+		assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+	})
+
+	t.Run("should redact personal information from the answer before storing it", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			ScorePostId:   postID,
+			AnsweredAt:    now,
+		}), nil)
+		api.On("KVGet", responseKey).Return(nil, nil)
+		api.On("GetTeamMembersForUser", userID, 0, 50).Return([]*model.TeamMember{}, nil)
+		api.On("GetLicense").Return(nil)
+		api.On("KVSet", responseKey, mustMarshalJSON(&surveyResponse{
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			UserRole:      "user",
+			CreateAt:      now,
+			Answers: map[string]*surveyAnswer{
+				"comments": {Value: "Email me at [REDACTED EMAIL]", AnswerAt: now, RedactionCount: 1},
+			},
+		})).Return(nil)
+		api.On("GetDirectChannel", userID, botUserID).Return(&model.Channel{Id: channelID}, nil)
+		api.On("GetPost", postID).Return(&model.Post{
+			Id:        postID,
+			ChannelId: channelID,
+			UserId:    botUserID,
+		}, nil)
+		api.On("UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+			attachments := post.Props["attachments"].([]*model.SlackAttachment)
+			return post.Id == postID && attachments[0].Text == "You answered: Email me at [REDACTED EMAIL]"
+		})).Return(&model.Post{}, nil)
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api)
+		p.configuration = &configuration{FeedbackRedactionDetectors: REDACTION_DETECTOR_EMAIL}
+
+		recorder := httptest.NewRecorder()
+
+		p.submitAnswerDialog(recorder, makeRequest("Email me at someone@example.com"))
+
+		assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+	})
+
+	t.Run("should not update a post outside of the user's DM with Surveybot", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			ScorePostId:   postID,
+		}), nil).Once()
+		api.On("KVGet", userSurveyKey).Return(nil, &model.AppError{})
+		api.On("LogWarn", mock.Anything, "err", mock.Anything)
+		api.On("GetDirectChannel", userID, botUserID).Return(&model.Channel{Id: channelID}, nil)
+		api.On("GetPost", postID).Return(&model.Post{
+			Id:        postID,
+			ChannelId: model.NewId(),
+			UserId:    botUserID,
+		}, nil)
+		defer api.AssertExpectations(t)
+
+		recorder := httptest.NewRecorder()
+
+		makePlugin(api).submitAnswerDialog(recorder, makeRequest("More coffee"))
+
+		assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+	})
+
+	t.Run("should return a dialog error for an empty answer", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			ScorePostId:   postID,
+		}), nil)
+		defer api.AssertExpectations(t)
+
+		recorder := httptest.NewRecorder()
+
+		makePlugin(api).submitAnswerDialog(recorder, makeRequest("   "))
+
+		result := recorder.Result()
+
+		var response *model.SubmitDialogResponse
+		require.Nil(t, json.NewDecoder(result.Body).Decode(&response))
+
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Contains(t, response.Errors, "answer")
+	})
+
+	t.Run("should reject an answer for a post that isn't the user's survey", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			ScorePostId:   model.NewId(),
+		}), nil)
+		api.On("LogWarn", mock.Anything, "user_id", userID, "post_id", postID)
+		defer api.AssertExpectations(t)
+
+		recorder := httptest.NewRecorder()
+
+		makePlugin(api).submitAnswerDialog(recorder, makeRequest("More coffee"))
+
+		assert.Equal(t, http.StatusForbidden, recorder.Result().StatusCode)
+	})
+
+	t.Run("should do nothing when the dialog is cancelled", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/answer", bytes.NewReader(mustMarshalJSON(&model.SubmitDialogRequest{
+			Cancelled: true,
+		})))
+		request.Header.Set("Mattermost-User-ID", userID)
+
+		makePlugin(api).submitAnswerDialog(recorder, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+	})
 }
 
 func TestRequiresUserId(t *testing.T) {
@@ -415,3 +1155,82 @@ func TestRequiresUserId(t *testing.T) {
 		assert.False(t, called)
 	})
 }
+
+func TestRequiresSystemAdmin(t *testing.T) {
+	userID := model.NewId()
+
+	t.Run("should call handler when user is a system admin", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetUser", userID).Return(&model.User{
+			Id:    userID,
+			Roles: model.SYSTEM_ADMIN_ROLE_ID + " " + model.SYSTEM_USER_ROLE_ID,
+		}, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		called := false
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		}
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Mattermost-User-ID", userID)
+
+		p.requiresSystemAdmin(handler)(recorder, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+		assert.True(t, called)
+	})
+
+	t.Run("should return HTTP 403 when user is not a system admin", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetUser", userID).Return(&model.User{
+			Id:    userID,
+			Roles: model.SYSTEM_USER_ROLE_ID,
+		}, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		called := false
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		}
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Mattermost-User-ID", userID)
+
+		p.requiresSystemAdmin(handler)(recorder, request)
+
+		assert.Equal(t, http.StatusForbidden, recorder.Result().StatusCode)
+		assert.False(t, called)
+	})
+
+	t.Run("should return HTTP 500 when unable to get user", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("GetUser", userID).Return(nil, &model.AppError{})
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		called := false
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		}
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Mattermost-User-ID", userID)
+
+		p.requiresSystemAdmin(handler)(recorder, request)
+
+		assert.Equal(t, http.StatusInternalServerError, recorder.Result().StatusCode)
+		assert.False(t, called)
+	})
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin"
+)
+
+const (
+	COMMAND_TRIGGER = "nps"
+
+	// The format used for dates passed to slash commands
+	COMMAND_DATE_FORMAT = "2006-01-02"
+
+	// The maximum number of failed webhook deliveries listed by /nps webhook-failures
+	COMMAND_MAX_WEBHOOK_FAILURES = 20
+)
+
+const commandHelpText = "###### Net Promoter Score Survey - Slash Command Help\n" +
+	"* `/nps status` - Show the state of the survey for the current version of Mattermost\n" +
+	"* `/nps schedule YYYY-MM-DD` - Schedule the survey for the current version to start on the given date\n" +
+	"* `/nps cancel` - Cancel the survey for the current version\n" +
+	"* `/nps send-now @username` - Send the survey to a user immediately\n" +
+	"* `/nps results [version]` - Show the results of the survey for the current or given version\n" +
+	"* `/nps user-data @username` - Show everything stored about a user\n" +
+	"* `/nps delete-user-data @username` - Delete everything stored about a user\n" +
+	"* `/nps webhook-failures [retry]` - Show the survey events that couldn't be sent to the event webhooks or try sending them again"
+
+func getCommand() *model.Command {
+	return &model.Command{
+		Trigger:          COMMAND_TRIGGER,
+		DisplayName:      "Net Promoter Score",
+		Description:      "Inspect and control Net Promoter Score surveys.",
+		AutoComplete:     true,
+		AutoCompleteDesc: "Available commands: status, schedule, cancel, send-now, results, user-data, delete-user-data, webhook-failures, help",
+		AutoCompleteHint: "[command]",
+	}
+}
+
+func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	user, appErr := p.API.GetUser(args.UserId)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	if !isSystemAdmin(user) {
+		return commandResponse("Only System Admins can use the /nps command."), nil
+	}
+
+	fields := strings.Fields(args.Command)
+
+	subcommand := ""
+	if len(fields) > 1 {
+		subcommand = fields[1]
+	}
+
+	var params []string
+	if len(fields) > 2 {
+		params = fields[2:]
+	}
+
+	now := p.now().UTC()
+
+	switch subcommand {
+	case "status":
+		return p.executeStatusCommand(), nil
+	case "schedule":
+		return p.executeScheduleCommand(params, now), nil
+	case "cancel":
+		return p.executeCancelCommand(now), nil
+	case "send-now":
+		return p.executeSendNowCommand(params, now), nil
+	case "results":
+		return p.executeResultsCommand(params), nil
+	case "user-data":
+		return p.executeUserDataCommand(params), nil
+	case "delete-user-data":
+		return p.executeDeleteUserDataCommand(params, now), nil
+	case "webhook-failures":
+		return p.executeWebhookFailuresCommand(params, now), nil
+	default:
+		return commandResponse(commandHelpText), nil
+	}
+}
+
+func (p *Plugin) executeStatusCommand() *model.CommandResponse {
+	var survey *surveyState
+	if err := p.KVGet(fmt.Sprintf(SURVEY_KEY, p.serverVersion), &survey); err != nil {
+		p.API.LogError("Failed to get survey state", "err", err)
+		return commandResponse("Failed to get survey state. Check the server logs for more information.")
+	}
+
+	if survey == nil {
+		return commandResponse(fmt.Sprintf("No survey is scheduled for Mattermost %s.", p.serverVersion))
+	}
+
+	results, err := p.getSurveyResults(p.serverVersion)
+	if err != nil {
+		p.API.LogError("Failed to get survey results", "err", err)
+		return commandResponse("Failed to get survey results. Check the server logs for more information.")
+	}
+
+	state := "scheduled to start"
+	if survey.Cancelled {
+		state = "cancelled. It was scheduled to start"
+	} else if !p.now().Before(survey.StartAt) {
+		state = "running. It started"
+	}
+
+	return commandResponse(fmt.Sprintf(
+		"The survey for %s is %s on %s.\n\nSurveys sent: %d\nSurveys answered: %d",
+		survey.describe(),
+		state,
+		survey.StartAt.Format("January 2, 2006"),
+		results.Sent,
+		results.Answered,
+	))
+}
+
+func (p *Plugin) executeScheduleCommand(params []string, now time.Time) *model.CommandResponse {
+	if len(params) != 1 {
+		return commandResponse("Please specify a date like `/nps schedule 2019-06-01`.")
+	}
+
+	startAt, err := time.Parse(COMMAND_DATE_FORMAT, params[0])
+	if err != nil {
+		return commandResponse(fmt.Sprintf("Unable to parse date %s. Please specify a date like `/nps schedule 2019-06-01`.", params[0]))
+	}
+
+	survey, appErr := p.updateSurveyState(now, func(survey *surveyState) {
+		survey.StartAt = startAt
+		survey.Cancelled = false
+	})
+	if appErr != nil {
+		p.API.LogError("Failed to schedule survey", "err", appErr)
+		return commandResponse("Failed to schedule survey. Check the server logs for more information.")
+	} else if survey == nil {
+		return commandResponse("The survey is currently being updated by another process. Please try again.")
+	}
+
+	return commandResponse(fmt.Sprintf("The survey for %s is scheduled to start on %s.", survey.describe(), survey.StartAt.Format("January 2, 2006")))
+}
+
+func (p *Plugin) executeCancelCommand(now time.Time) *model.CommandResponse {
+	survey, appErr := p.updateSurveyState(now, func(survey *surveyState) {
+		survey.Cancelled = true
+	})
+	if appErr != nil {
+		p.API.LogError("Failed to cancel survey", "err", appErr)
+		return commandResponse("Failed to cancel survey. Check the server logs for more information.")
+	} else if survey == nil {
+		return commandResponse("The survey is currently being updated by another process. Please try again.")
+	}
+
+	return commandResponse(fmt.Sprintf("The survey for %s has been cancelled. Use `/nps schedule` to reschedule it.", survey.describe()))
+}
+
+// updateSurveyState applies the given change to the latest surveyState for the current server version, creating it if
+// it doesn't exist yet. Returns nil without making any changes if another instance of the plugin is checking for
+// surveys.
+func (p *Plugin) updateSurveyState(now time.Time, update func(survey *surveyState)) (*surveyState, *model.AppError) {
+	locked, err := p.tryLock(LOCK_KEY, now)
+	if err != nil {
+		return nil, err
+	} else if !locked {
+		return nil, nil
+	}
+	defer p.unlock(LOCK_KEY)
+
+	var survey *surveyState
+	if err := p.KVGet(fmt.Sprintf(SURVEY_KEY, p.serverVersion), &survey); err != nil {
+		return nil, err
+	}
+
+	if survey == nil {
+		survey = &surveyState{
+			ServerVersion: p.serverVersion,
+			CreateAt:      now,
+			StartAt:       now.Add(p.getConfiguration().getTimeUntilSurvey()),
+		}
+	}
+
+	update(survey)
+
+	if err := p.saveSurveyState(survey); err != nil {
+		return nil, err
+	}
+
+	return survey, nil
+}
+
+func (p *Plugin) executeSendNowCommand(params []string, now time.Time) *model.CommandResponse {
+	if len(params) != 1 {
+		return commandResponse("Please specify a user like `/nps send-now @username`.")
+	}
+
+	username := strings.TrimPrefix(params[0], "@")
+
+	user, appErr := p.API.GetUserByUsername(username)
+	if appErr != nil {
+		return commandResponse(fmt.Sprintf("Unable to find user @%s.", username))
+	}
+
+	if user.IsBot {
+		return commandResponse("Surveys can't be sent to bots.")
+	}
+
+	userLockKey := fmt.Sprintf(USER_LOCK_KEY, user.Id)
+
+	locked, appErr := p.tryLock(userLockKey, now)
+	if appErr != nil || !locked {
+		return commandResponse(fmt.Sprintf("Surveybot is currently busy with @%s. Please try again.", username))
+	}
+	defer p.unlock(userLockKey)
+
+	if optedOut, appErr := p.hasUserOptedOut(user.Id); appErr != nil {
+		p.API.LogError("Failed to check if user has opted out of surveys", "user_id", user.Id, "err", appErr)
+		return commandResponse("Failed to get user preferences. Check the server logs for more information.")
+	} else if optedOut {
+		return commandResponse(fmt.Sprintf("@%s has opted out of surveys, so the survey wasn't sent.", username))
+	}
+
+	var survey *surveyState
+	if appErr := p.KVGet(fmt.Sprintf(SURVEY_KEY, p.serverVersion), &survey); appErr != nil {
+		p.API.LogError("Failed to get survey state", "err", appErr)
+		return commandResponse("Failed to get survey state. Check the server logs for more information.")
+	}
+
+	if survey == nil {
+		// No survey has been scheduled, so send the one for the current server version
+		survey = &surveyState{
+			ServerVersion: p.serverVersion,
+		}
+	}
+
+	if appErr := p.sendSurveyDM(user, survey, now); appErr != nil {
+		return commandResponse(fmt.Sprintf("Failed to send survey to @%s. Check the server logs for more information.", username))
+	}
+
+	return commandResponse(fmt.Sprintf("The survey has been sent to @%s.", username))
+}
+
+func (p *Plugin) executeResultsCommand(params []string) *model.CommandResponse {
+	serverVersion := p.serverVersion
+	if len(params) > 0 {
+		serverVersion = getServerVersion(params[0])
+	}
+
+	results, appErr := p.getSurveyResults(serverVersion)
+	if appErr != nil {
+		p.API.LogError("Failed to get survey results", "err", appErr)
+		return commandResponse("Failed to get survey results. Check the server logs for more information.")
+	} else if results == nil {
+		return commandResponse(fmt.Sprintf("No survey has been scheduled for Mattermost %s.", serverVersion))
+	}
+
+	return commandResponse(formatSurveyResults(results))
+}
+
+func (p *Plugin) executeUserDataCommand(params []string) *model.CommandResponse {
+	if len(params) != 1 {
+		return commandResponse("Please specify a user like `/nps user-data @username`.")
+	}
+
+	userID, errResponse := p.getCommandUserID(params[0])
+	if errResponse != nil {
+		return errResponse
+	}
+
+	data, appErr := p.getUserData(userID, !p.getConfiguration().AnonymousSurveys)
+	if appErr != nil {
+		p.API.LogError("Failed to get user data", "user_id", userID, "err", appErr)
+		return commandResponse("Failed to get user data. Check the server logs for more information.")
+	}
+
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		p.API.LogError("Failed to format user data", "user_id", userID, "err", err.Error())
+		return commandResponse("Failed to get user data. Check the server logs for more information.")
+	}
+
+	return commandResponse(fmt.Sprintf("Surveybot has stored the following about %s:\n```json\n%s\n```", params[0], b))
+}
+
+func (p *Plugin) executeDeleteUserDataCommand(params []string, now time.Time) *model.CommandResponse {
+	if len(params) != 1 {
+		return commandResponse("Please specify a user like `/nps delete-user-data @username`.")
+	}
+
+	userID, errResponse := p.getCommandUserID(params[0])
+	if errResponse != nil {
+		return errResponse
+	}
+
+	data, appErr := p.deleteUserData(userID, now)
+	if appErr != nil {
+		p.API.LogError("Failed to delete user data", "user_id", userID, "err", appErr)
+		return commandResponse("Failed to delete user data. Check the server logs for more information.")
+	} else if data == nil {
+		return commandResponse(fmt.Sprintf("Surveybot is currently busy with %s. Please try again.", params[0]))
+	}
+
+	return commandResponse(fmt.Sprintf("Deleted %d survey responses and everything else stored about %s.", len(data.Responses), params[0]))
+}
+
+func (p *Plugin) executeWebhookFailuresCommand(params []string, now time.Time) *model.CommandResponse {
+	if len(params) == 1 && params[0] == "retry" {
+		count, appErr := p.retryDeadLetters(now)
+		if appErr != nil {
+			p.API.LogError("Failed to retry failed webhook deliveries", "err", appErr)
+			return commandResponse("Failed to retry webhook deliveries. Check the server logs for more information.")
+		}
+
+		return commandResponse(fmt.Sprintf("%d failed webhook deliveries will be tried again shortly.", count))
+	} else if len(params) != 0 {
+		return commandResponse("Please use `/nps webhook-failures` to show failed webhook deliveries or `/nps webhook-failures retry` to try sending them again.")
+	}
+
+	pending, appErr := p.getWebhookQueueIndex()
+	if appErr != nil {
+		p.API.LogError("Failed to get queued webhook deliveries", "err", appErr)
+		return commandResponse("Failed to get webhook deliveries. Check the server logs for more information.")
+	}
+
+	deadLetters, appErr := p.getWebhookDeliveries(WEBHOOK_DEAD_LETTER_KEY)
+	if appErr != nil {
+		p.API.LogError("Failed to get failed webhook deliveries", "err", appErr)
+		return commandResponse("Failed to get webhook deliveries. Check the server logs for more information.")
+	}
+
+	text := fmt.Sprintf("Webhook deliveries waiting to be sent: %d\nWebhook deliveries that failed too many times: %d", len(pending), len(deadLetters))
+
+	if len(deadLetters) > 0 {
+		shown := deadLetters
+		if len(shown) > COMMAND_MAX_WEBHOOK_FAILURES {
+			shown = shown[len(shown)-COMMAND_MAX_WEBHOOK_FAILURES:]
+
+			text += fmt.Sprintf("\n\nShowing the %d most recent failures.", COMMAND_MAX_WEBHOOK_FAILURES)
+		}
+
+		text += "\n\n| Event | URL | Created | Attempts | Last Error |\n| --- | --- | --- | --- | --- |"
+
+		for _, delivery := range shown {
+			text += fmt.Sprintf(
+				"\n| %s | %s | %s | %d | %s |",
+				delivery.Event.Event,
+				delivery.URL,
+				delivery.CreateAt.Format("January 2, 2006 15:04 MST"),
+				delivery.Attempts,
+				strings.Replace(delivery.LastError, "|", "\\|", -1),
+			)
+		}
+
+		text += "\n\nUse `/nps webhook-failures retry` to try sending them again."
+	}
+
+	return commandResponse(text)
+}
+
+// getCommandUserID returns the ID of the user passed to a slash command as either @username or a user ID. Users that
+// have been permanently deleted can only be found by their ID. Returns a response to send instead if no user is found.
+func (p *Plugin) getCommandUserID(param string) (string, *model.CommandResponse) {
+	username := strings.TrimPrefix(param, "@")
+
+	if user, appErr := p.API.GetUserByUsername(username); appErr == nil {
+		return user.Id, nil
+	}
+
+	if model.IsValidId(param) {
+		return param, nil
+	}
+
+	return "", commandResponse(fmt.Sprintf("Unable to find user @%s.", username))
+}
+
+func commandResponse(text string) *model.CommandResponse {
+	return &model.CommandResponse{
+		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+		Text:         text,
+		Username:     "surveybot",
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuteCommand(t *testing.T) {
+	adminID := model.NewId()
+	botUserID := model.NewId()
+	now := toDate(2019, time.June, 1)
+	serverVersion := "5.12.0"
+	surveyKey := fmt.Sprintf(SURVEY_KEY, serverVersion)
+
+	makeAPIMock := func() *plugintest.API {
+		api := &plugintest.API{}
+		api.On("GetUser", adminID).Return(&model.User{
+			Id:    adminID,
+			Roles: model.SYSTEM_ADMIN_ROLE_ID + " " + model.SYSTEM_USER_ROLE_ID,
+		}, nil)
+		return api
+	}
+
+	makePlugin := func(api *plugintest.API) *Plugin {
+		p := &Plugin{
+			botUserID:     botUserID,
+			serverVersion: serverVersion,
+			now: func() time.Time {
+				return now
+			},
+		}
+		p.SetAPI(api)
+
+		return p
+	}
+
+	execute := func(p *Plugin, command string) *model.CommandResponse {
+		resp, err := p.ExecuteCommand(nil, &model.CommandArgs{
+			UserId:  adminID,
+			Command: command,
+		})
+
+		require.Nil(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, model.COMMAND_RESPONSE_TYPE_EPHEMERAL, resp.ResponseType)
+
+		return resp
+	}
+
+	t.Run("should only be usable by system admins", func(t *testing.T) {
+		userID := model.NewId()
+
+		api := &plugintest.API{}
+		api.On("GetUser", userID).Return(&model.User{
+			Id:    userID,
+			Roles: model.SYSTEM_USER_ROLE_ID,
+		}, nil)
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api)
+
+		resp, err := p.ExecuteCommand(nil, &model.CommandArgs{
+			UserId:  userID,
+			Command: "/nps cancel",
+		})
+
+		require.Nil(t, err)
+		assert.Contains(t, resp.Text, "Only System Admins")
+	})
+
+	t.Run("should return an error if unable to get the user", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetUser", adminID).Return(nil, &model.AppError{})
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api)
+
+		resp, err := p.ExecuteCommand(nil, &model.CommandArgs{
+			UserId:  adminID,
+			Command: "/nps status",
+		})
+
+		assert.Nil(t, resp)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("should show help for unknown subcommands", func(t *testing.T) {
+		api := makeAPIMock()
+		defer api.AssertExpectations(t)
+
+		resp := execute(makePlugin(api), "/nps")
+
+		assert.Equal(t, commandHelpText, resp.Text)
+	})
+
+	t.Run("status should report when no survey is scheduled", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVGet", surveyKey).Return(nil, nil)
+		defer api.AssertExpectations(t)
+
+		resp := execute(makePlugin(api), "/nps status")
+
+		assert.Equal(t, "No survey is scheduled for Mattermost 5.12.0.", resp.Text)
+	})
+
+	t.Run("status should report a running survey", func(t *testing.T) {
+		userID := model.NewId()
+
+		api := makeAPIMock()
+		api.On("KVGet", surveyKey).Return(mustMarshalJSON(&surveyState{
+			ServerVersion: serverVersion,
+			StartAt:       now.Add(-24 * time.Hour),
+		}), nil)
+		api.On("KVList", 0, 100).Return([]string{fmt.Sprintf(USER_SURVEY_KEY, userID)}, nil)
+		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, userID)).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			SentAt:        now,
+		}), nil)
+		defer api.AssertExpectations(t)
+
+		resp := execute(makePlugin(api), "/nps status")
+
+		assert.Equal(t, "The survey for Mattermost 5.12.0 is running. It started on May 31, 2019.\n\nSurveys sent: 1\nSurveys answered: 0", resp.Text)
+	})
+
+	t.Run("schedule should update the survey start date", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVCompareAndSet", LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVGet", surveyKey).Return(mustMarshalJSON(&surveyState{
+			ServerVersion: serverVersion,
+			CreateAt:      now.Add(-24 * time.Hour),
+			StartAt:       now.Add(DEFAULT_TIME_UNTIL_SURVEY),
+			Cancelled:     true,
+		}), nil)
+		api.On("KVSet", surveyKey, mustMarshalJSON(&surveyState{
+			ServerVersion: serverVersion,
+			CreateAt:      now.Add(-24 * time.Hour),
+			StartAt:       toDate(2019, time.June, 5),
+		})).Return(nil)
+		api.On("KVDelete", LOCK_KEY).Return(nil)
+		defer api.AssertExpectations(t)
+
+		resp := execute(makePlugin(api), "/nps schedule 2019-06-05")
+
+		assert.Equal(t, "The survey for Mattermost 5.12.0 is scheduled to start on June 5, 2019.", resp.Text)
+	})
+
+	t.Run("schedule should reject an invalid date", func(t *testing.T) {
+		api := makeAPIMock()
+		defer api.AssertExpectations(t)
+
+		resp := execute(makePlugin(api), "/nps schedule tomorrow")
+
+		assert.Contains(t, resp.Text, "Unable to parse date")
+	})
+
+	t.Run("schedule should not change anything if another process holds the lock", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVCompareAndSet", LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(false, nil)
+		defer api.AssertExpectations(t)
+
+		resp := execute(makePlugin(api), "/nps schedule 2019-06-05")
+
+		assert.Contains(t, resp.Text, "Please try again")
+	})
+
+	t.Run("cancel should create a cancelled survey if none exists", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVCompareAndSet", LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVGet", surveyKey).Return(nil, nil)
+		api.On("KVSet", surveyKey, mustMarshalJSON(&surveyState{
+			ServerVersion: serverVersion,
+			CreateAt:      now,
+			StartAt:       now.Add(DEFAULT_TIME_UNTIL_SURVEY),
+			Cancelled:     true,
+		})).Return(nil)
+		api.On("KVDelete", LOCK_KEY).Return(nil)
+		defer api.AssertExpectations(t)
+
+		resp := execute(makePlugin(api), "/nps cancel")
+
+		assert.Contains(t, resp.Text, "has been cancelled")
+	})
+
+	t.Run("send-now should send the survey to the given user", func(t *testing.T) {
+		user := &model.User{
+			Id:       model.NewId(),
+			Username: "someone",
+		}
+		postID := model.NewId()
+
+		api := makeAPIMock()
+		api.On("GetUserByUsername", "someone").Return(user, nil)
+		api.On("KVGet", fmt.Sprintf(SURVEY_KEY, serverVersion)).Return(nil, nil)
+		api.On("KVCompareAndSet", fmt.Sprintf(USER_LOCK_KEY, user.Id), []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVGet", fmt.Sprintf(USER_PREFERENCES_KEY, user.Id)).Return(nil, nil)
+		api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything).Maybe()
+		api.On("KVGet", QUESTIONS_KEY).Return(nil, nil)
+		api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: model.NewString("https://mattermost.example.com")}})
+		api.On("GetDirectChannel", user.Id, botUserID).Return(&model.Channel{}, nil)
+		api.On("CreatePost", mock.Anything).Return(&model.Post{Id: postID}, nil)
+		api.On("KVSet", fmt.Sprintf(USER_SURVEY_KEY, user.Id), mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			SentAt:        now,
+			ScorePostId:   postID,
+		})).Return(nil)
+		api.On("KVDelete", fmt.Sprintf(USER_LOCK_KEY, user.Id)).Return(nil)
+		defer api.AssertExpectations(t)
+
+		resp := execute(makePlugin(api), "/nps send-now @someone")
+
+		assert.Equal(t, "The survey has been sent to @someone.", resp.Text)
+	})
+
+	t.Run("send-now should not send the survey to a user who opted out", func(t *testing.T) {
+		user := &model.User{
+			Id:       model.NewId(),
+			Username: "someone",
+		}
+
+		api := makeAPIMock()
+		api.On("GetUserByUsername", "someone").Return(user, nil)
+		api.On("KVCompareAndSet", fmt.Sprintf(USER_LOCK_KEY, user.Id), []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVGet", fmt.Sprintf(USER_PREFERENCES_KEY, user.Id)).Return(mustMarshalJSON(&userPreferences{SurveysDisabled: true}), nil)
+		api.On("KVDelete", fmt.Sprintf(USER_LOCK_KEY, user.Id)).Return(nil)
+		defer api.AssertExpectations(t)
+
+		resp := execute(makePlugin(api), "/nps send-now @someone")
+
+		assert.Equal(t, "@someone has opted out of surveys, so the survey wasn't sent.", resp.Text)
+	})
+
+	t.Run("send-now should report an unknown user", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("GetUserByUsername", "nobody").Return(nil, &model.AppError{})
+		defer api.AssertExpectations(t)
+
+		resp := execute(makePlugin(api), "/nps send-now @nobody")
+
+		assert.Equal(t, "Unable to find user @nobody.", resp.Text)
+	})
+
+	t.Run("results should show results for the requested version", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVGet", fmt.Sprintf(SURVEY_KEY, "5.11.0")).Return(mustMarshalJSON(&surveyState{
+			ServerVersion: "5.11.0",
+		}), nil)
+		api.On("KVList", 0, 100).Return([]string{}, nil)
+		defer api.AssertExpectations(t)
+
+		resp := execute(makePlugin(api), "/nps results 5.11.2")
+
+		assert.Contains(t, resp.Text, "survey results for Mattermost 5.11.0")
+	})
+
+	t.Run("results should report when no survey exists", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVGet", surveyKey).Return(nil, nil)
+		defer api.AssertExpectations(t)
+
+		resp := execute(makePlugin(api), "/nps results")
+
+		assert.Equal(t, "No survey has been scheduled for Mattermost 5.12.0.", resp.Text)
+	})
+
+	t.Run("user-data should show everything stored about the user", func(t *testing.T) {
+		user := &model.User{
+			Id:       model.NewId(),
+			Username: "someone",
+		}
+		responseKey := fmt.Sprintf(RESPONSE_KEY, serverVersion, user.Id)
+
+		api := makeAPIMock()
+		api.On("GetUserByUsername", "someone").Return(user, nil)
+		api.On("KVList", 0, 100).Return([]string{
+			responseKey,
+			fmt.Sprintf(USER_SURVEY_KEY, model.NewId()),
+		}, nil)
+		api.On("KVGet", responseKey).Return(mustMarshalJSON(&surveyResponse{
+			UserID:        user.Id,
+			ServerVersion: serverVersion,
+			Score:         7,
+		}), nil)
+		defer api.AssertExpectations(t)
+
+		resp := execute(makePlugin(api), "/nps user-data @someone")
+
+		assert.Contains(t, resp.Text, "Surveybot has stored the following about @someone")
+		assert.Contains(t, resp.Text, `"score": 7`)
+	})
+
+	t.Run("user-data should accept the ID of a deleted user", func(t *testing.T) {
+		userID := model.NewId()
+
+		api := makeAPIMock()
+		api.On("GetUserByUsername", userID).Return(nil, &model.AppError{})
+		api.On("KVList", 0, 100).Return([]string{}, nil)
+		defer api.AssertExpectations(t)
+
+		resp := execute(makePlugin(api), "/nps user-data "+userID)
+
+		assert.Contains(t, resp.Text, userID)
+	})
+
+	t.Run("delete-user-data should delete everything stored about the user", func(t *testing.T) {
+		user := &model.User{
+			Id:       model.NewId(),
+			Username: "someone",
+		}
+		userLockKey := fmt.Sprintf(USER_LOCK_KEY, user.Id)
+		userSurveyKey := fmt.Sprintf(USER_SURVEY_KEY, user.Id)
+
+		api := makeAPIMock()
+		api.On("GetUserByUsername", "someone").Return(user, nil)
+		api.On("KVCompareAndSet", userLockKey, []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVList", 0, 100).Return([]string{userSurveyKey}, nil)
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{ServerVersion: serverVersion}), nil)
+		api.On("KVDelete", userSurveyKey).Return(nil)
+		api.On("KVDelete", userLockKey).Return(nil)
+		defer api.AssertExpectations(t)
+
+		resp := execute(makePlugin(api), "/nps delete-user-data @someone")
+
+		assert.Equal(t, "Deleted 0 survey responses and everything else stored about @someone.", resp.Text)
+	})
+
+	t.Run("webhook-failures should list failed deliveries", func(t *testing.T) {
+		deadLetterKey := fmt.Sprintf(WEBHOOK_DEAD_LETTER_KEY, model.NewId())
+
+		api := makeAPIMock()
+		api.On("KVGet", WEBHOOK_QUEUE_INDEX_KEY).Return(mustMarshalJSON([]string{model.NewId()}), nil)
+		api.On("KVList", 0, 100).Return([]string{
+			fmt.Sprintf(WEBHOOK_QUEUE_KEY, model.NewId()),
+			deadLetterKey,
+		}, nil)
+		api.On("KVGet", deadLetterKey).Return(mustMarshalJSON(&webhookDelivery{
+			URL:       "https://example.com/2",
+			Event:     sinkEvent{Event: NPS_FEEDBACK},
+			CreateAt:  now,
+			Attempts:  WEBHOOK_MAX_ATTEMPTS,
+			LastError: "webhook returned unexpected status code 500",
+		}), nil)
+		defer api.AssertExpectations(t)
+
+		resp := execute(makePlugin(api), "/nps webhook-failures")
+
+		assert.Contains(t, resp.Text, "Webhook deliveries waiting to be sent: 1\nWebhook deliveries that failed too many times: 1")
+		assert.Contains(t, resp.Text, "| nps_feedback | https://example.com/2 | June 1, 2019 00:00 UTC | 10 | webhook returned unexpected status code 500 |")
+	})
+
+	t.Run("webhook-failures retry should requeue failed deliveries", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVList", 0, 100).Return([]string{}, nil)
+		defer api.AssertExpectations(t)
+
+		resp := execute(makePlugin(api), "/nps webhook-failures retry")
+
+		assert.Equal(t, "0 failed webhook deliveries will be tried again shortly.", resp.Text)
+	})
+
+	t.Run("delete-user-data should report an unknown user", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("GetUserByUsername", "nobody").Return(nil, &model.AppError{})
+		defer api.AssertExpectations(t)
+
+		resp := execute(makePlugin(api), "/nps delete-user-data @nobody")
+
+		assert.Equal(t, "Unable to find user @nobody.", resp.Text)
+	})
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"reflect"
+	"time"
 
+	"github.com/mattermost/mattermost-server/model"
 	"github.com/pkg/errors"
 )
 
@@ -19,6 +21,132 @@ import (
 // copy appropriate for your types.
 type configuration struct {
 	EnableSurvey bool
+
+	// AnalyticsSink selects where survey responses are sent. It should be one of the SINK_* constants, and it
+	// defaults to SINK_SEGMENT if left blank.
+	AnalyticsSink string
+
+	// AnalyticsWebhookURL is the URL that responses are sent to when using SINK_WEBHOOK. Like the EventWebhookURLs,
+	// failed deliveries are retried in the background.
+	AnalyticsWebhookURL string
+
+	// AnalyticsFilePath is the path of the file that responses are written to when using SINK_FILE.
+	AnalyticsFilePath string
+
+	// EventWebhookURLs contains URLs, separated by whitespace, that every score and feedback event is sent to as JSON
+	// in addition to the AnalyticsSink. Failed deliveries are retried in the background.
+	EventWebhookURLs string
+
+	// SurveySchedule selects when surveys are scheduled. It should be one of the SCHEDULE_* constants, and it defaults
+	// to SCHEDULE_UPGRADE if left blank.
+	SurveySchedule string
+
+	// SurveyIntervalDays is the number of days between surveys when using SCHEDULE_INTERVAL.
+	SurveyIntervalDays int
+
+	// DaysUntilSurvey is the number of days between when a survey is scheduled and when it's sent to users. Defaults
+	// to DEFAULT_TIME_UNTIL_SURVEY if left blank.
+	DaysUntilSurvey int
+
+	// MinDaysBetweenUserSurveys is the minimum number of days before a user can be sent another survey. Defaults to
+	// DEFAULT_MIN_TIME_BETWEEN_USER_SURVEYS if left blank.
+	MinDaysBetweenUserSurveys int
+
+	// MinDaysBetweenSurveyEmails is the minimum number of days between emails notifying admins of a scheduled survey.
+	// Defaults to DEFAULT_MIN_TIME_BETWEEN_SURVEY_EMAILS if left blank.
+	MinDaysBetweenSurveyEmails int
+
+	// SurveyTargetTeams is a comma-separated list of team names. When set, only members of one of those teams are sent
+	// surveys.
+	SurveyTargetTeams string
+
+	// SurveyTargetChannels is a comma-separated list of channel IDs. When set, only members of one of those channels
+	// are sent surveys.
+	SurveyTargetChannels string
+
+	// SurveyTargetRoles is a comma-separated list of the roles returned by getUserRole. When set, only users with one of
+	// those roles are sent surveys.
+	SurveyTargetRoles string
+
+	// SurveyMinAccountAgeDays is the number of days a user's account must exist before they're sent a survey. Defaults
+	// to the time until a survey is sent if left blank.
+	SurveyMinAccountAgeDays int
+
+	// SurveySamplePercent is the percentage of targeted users that are sent each survey. Everyone is sent the survey if
+	// it's left blank or set to 100.
+	SurveySamplePercent int
+
+	// SurveyRolloutDays is the number of days that surveys are spread over after they start. Each user is sent the
+	// survey at a different point in that window. Everyone is sent the survey as soon as it starts if left blank.
+	SurveyRolloutDays int
+
+	// SurveyReminderDays is the number of days after a survey is sent that a user who hasn't answered it is sent a
+	// single reminder. No reminder is sent if left blank.
+	SurveyReminderDays int
+
+	// SurveyExpiryDays is the number of days after a survey is sent that it's closed if the user hasn't answered it.
+	// Surveys stay open until the next one is sent if left blank.
+	SurveyExpiryDays int
+
+	// FeedbackWindowHours is the number of hours after a user answers a survey that their messages to Surveybot are
+	// treated as feedback on it. Defaults to DEFAULT_FEEDBACK_WINDOW if left blank. Replies to the feedback request are
+	// always treated as feedback.
+	FeedbackWindowHours int
+
+	// ScoreChangeWindowHours is the number of hours after a user first gives a score that they can change it. Users can
+	// change their score until the survey is replaced if left blank.
+	ScoreChangeWindowHours int
+
+	// AnonymousSurveys, when true, stores and reports responses under a salted hash of the user's ID instead of the ID
+	// itself and leaves out the user's role and account age.
+	AnonymousSurveys bool
+
+	// MinAnonymousGroupSize is the number of scores that a survey needs before its results are shown or its scores are
+	// exported when surveys are anonymous. Defaults to DEFAULT_MIN_ANONYMOUS_GROUP_SIZE if left blank.
+	MinAnonymousGroupSize int
+
+	// FeedbackRedactionDetectors is a comma-separated list of the REDACTION_DETECTOR_* types of personal information
+	// that are removed from feedback and free text answers before they're stored or sent anywhere.
+	FeedbackRedactionDetectors string
+
+	// FeedbackRedactionPatterns contains regular expressions, one per line, for anything else that should be removed
+	// from feedback and free text answers. They run before the built-in detectors.
+	FeedbackRedactionPatterns string
+
+	// ResponseRetentionDays is the number of days that survey responses are kept for before they're deleted by the
+	// data cleanup job. Responses are kept forever if left blank.
+	ResponseRetentionDays int
+
+	// DeleteDeactivatedUserData, when true, has the data cleanup job delete everything stored about deactivated users.
+	// Data stored about users that have been permanently deleted is always removed.
+	DeleteDeactivatedUserData bool
+
+	// ResultsDigestChannelID is the ID of a channel that a summary of the current survey's results is posted to every
+	// ResultsDigestIntervalDays while it runs. No summaries are posted if left blank.
+	ResultsDigestChannelID string
+
+	// ResultsDigestIntervalDays is the number of days between summaries of a survey's results. Defaults to
+	// DEFAULT_RESULTS_DIGEST_INTERVAL if left blank.
+	ResultsDigestIntervalDays int
+
+	// SurveyMessageTemplate, SurveyAnsweredMessageTemplate, FeedbackRequestMessageTemplate,
+	// FeedbackResponseMessageTemplate, AdminNoticeMessageTemplate, AdminEmailSubjectTemplate, and AdminEmailBodyTemplate
+	// are text/templates that replace the default text of Surveybot's messages. See messageTemplateVariables for the
+	// variables available to each one. The default message is sent if left blank.
+	SurveyMessageTemplate           string
+	SurveyAnsweredMessageTemplate   string
+	FeedbackRequestMessageTemplate  string
+	FeedbackResponseMessageTemplate string
+	AdminNoticeMessageTemplate      string
+	AdminEmailSubjectTemplate       string
+	AdminEmailBodyTemplate          string
+
+	// DetractorFeedbackMessageTemplate, PassiveFeedbackMessageTemplate, and PromoterFeedbackMessageTemplate replace the
+	// feedback request sent to users depending on the score that they gave. FeedbackRequestMessageTemplate is used
+	// for any that are left blank.
+	DetractorFeedbackMessageTemplate string
+	PassiveFeedbackMessageTemplate   string
+	PromoterFeedbackMessageTemplate  string
 }
 
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
@@ -28,6 +156,191 @@ func (c *configuration) Clone() *configuration {
 	return &clone
 }
 
+// IsValid checks that the configuration contains everything required by the selected options.
+func (c *configuration) IsValid() error {
+	switch c.AnalyticsSink {
+	case "", SINK_SEGMENT:
+	case SINK_WEBHOOK:
+		if c.AnalyticsWebhookURL == "" {
+			return errors.New("a webhook URL must be provided when sending responses to a webhook")
+		}
+
+		if err := validateWebhookURLs([]string{c.AnalyticsWebhookURL}); err != nil {
+			return err
+		}
+	case SINK_FILE:
+		if c.AnalyticsFilePath == "" {
+			return errors.New("a file path must be provided when writing responses to a file")
+		}
+	default:
+		return errors.Errorf("unknown analytics sink %s", c.AnalyticsSink)
+	}
+
+	switch c.SurveySchedule {
+	case "", SCHEDULE_UPGRADE, SCHEDULE_QUARTERLY:
+	case SCHEDULE_INTERVAL:
+		if c.SurveyIntervalDays <= 0 {
+			return errors.New("the number of days between surveys must be positive")
+		}
+	default:
+		return errors.Errorf("unknown survey schedule %s", c.SurveySchedule)
+	}
+
+	if c.DaysUntilSurvey < 0 {
+		return errors.New("the number of days until a survey is sent must not be negative")
+	}
+
+	if c.MinDaysBetweenUserSurveys < 0 {
+		return errors.New("the number of days between user surveys must not be negative")
+	}
+
+	if c.MinDaysBetweenSurveyEmails < 0 {
+		return errors.New("the number of days between survey emails must not be negative")
+	}
+
+	for _, role := range splitSetting(c.SurveyTargetRoles) {
+		if !containsString(validTargetRoles, role) {
+			return errors.Errorf("unknown survey target role %s", role)
+		}
+	}
+
+	if c.SurveyMinAccountAgeDays < 0 {
+		return errors.New("the minimum account age for surveys must not be negative")
+	}
+
+	if c.SurveySamplePercent < 0 || c.SurveySamplePercent > 100 {
+		return errors.New("the survey sample percentage must be between 0 and 100")
+	}
+
+	if c.SurveyRolloutDays < 0 {
+		return errors.New("the number of days to roll out a survey over must not be negative")
+	}
+
+	if c.SurveyReminderDays < 0 {
+		return errors.New("the number of days until a survey reminder is sent must not be negative")
+	}
+
+	if c.SurveyExpiryDays < 0 {
+		return errors.New("the number of days until a survey expires must not be negative")
+	}
+
+	if c.SurveyReminderDays > 0 && c.SurveyExpiryDays > 0 && c.SurveyReminderDays >= c.SurveyExpiryDays {
+		return errors.New("survey reminders must be sent before the survey expires")
+	}
+
+	if c.FeedbackWindowHours < 0 {
+		return errors.New("the number of hours to accept feedback for must not be negative")
+	}
+
+	if c.ScoreChangeWindowHours < 0 {
+		return errors.New("the number of hours to allow score changes for must not be negative")
+	}
+
+	if c.MinAnonymousGroupSize < 0 {
+		return errors.New("the minimum anonymous group size must not be negative")
+	}
+
+	if err := validateWebhookURLs(c.getEventWebhookURLs()); err != nil {
+		return err
+	}
+
+	if c.ResultsDigestChannelID != "" && !model.IsValidId(c.ResultsDigestChannelID) {
+		return errors.New("the results digest channel must be a channel ID")
+	}
+
+	if c.ResultsDigestIntervalDays < 0 {
+		return errors.New("the number of days between results digests must not be negative")
+	}
+
+	if c.ResponseRetentionDays < 0 {
+		return errors.New("the number of days to keep responses for must not be negative")
+	}
+
+	if _, err := c.getRedactor(); err != nil {
+		return err
+	}
+
+	if err := validateMessageTemplates(c.getMessageTemplates()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getTimeUntilSurvey returns how long after a survey is scheduled that it will be sent to users.
+func (c *configuration) getTimeUntilSurvey() time.Duration {
+	if c.DaysUntilSurvey > 0 {
+		return daysToDuration(c.DaysUntilSurvey)
+	}
+
+	return DEFAULT_TIME_UNTIL_SURVEY
+}
+
+// getDaysUntilSurvey returns getTimeUntilSurvey in days for use in notifications.
+func (c *configuration) getDaysUntilSurvey() int {
+	return int(c.getTimeUntilSurvey() / (24 * time.Hour))
+}
+
+// getMinTimeBetweenUserSurveys returns the minimum time before a user can be sent another survey.
+func (c *configuration) getMinTimeBetweenUserSurveys() time.Duration {
+	if c.MinDaysBetweenUserSurveys > 0 {
+		return daysToDuration(c.MinDaysBetweenUserSurveys)
+	}
+
+	return DEFAULT_MIN_TIME_BETWEEN_USER_SURVEYS
+}
+
+// getMinTimeBetweenSurveyEmails returns the minimum time between emails notifying admins of a scheduled survey.
+func (c *configuration) getMinTimeBetweenSurveyEmails() time.Duration {
+	if c.MinDaysBetweenSurveyEmails > 0 {
+		return daysToDuration(c.MinDaysBetweenSurveyEmails)
+	}
+
+	return DEFAULT_MIN_TIME_BETWEEN_SURVEY_EMAILS
+}
+
+// getSurveyReminderDelay returns how long after a survey is sent that an unanswered survey is followed up with a
+// reminder, or 0 if reminders are disabled.
+func (c *configuration) getSurveyReminderDelay() time.Duration {
+	return daysToDuration(c.SurveyReminderDays)
+}
+
+// getSurveyExpiry returns how long after a survey is sent that an unanswered survey is closed, or 0 if surveys don't
+// expire.
+func (c *configuration) getSurveyExpiry() time.Duration {
+	return daysToDuration(c.SurveyExpiryDays)
+}
+
+// getFeedbackWindow returns how long after a user answers a survey that their messages are treated as feedback.
+func (c *configuration) getFeedbackWindow() time.Duration {
+	if c.FeedbackWindowHours > 0 {
+		return time.Duration(c.FeedbackWindowHours) * time.Hour
+	}
+
+	return DEFAULT_FEEDBACK_WINDOW
+}
+
+// getScoreChangeWindow returns how long after a user first gives a score that they can change it, or 0 if they can
+// always change it.
+func (c *configuration) getScoreChangeWindow() time.Duration {
+	return time.Duration(c.ScoreChangeWindowHours) * time.Hour
+}
+
+// getResponseRetention returns how long survey responses are kept for, or 0 if they're kept forever.
+func (c *configuration) getResponseRetention() time.Duration {
+	return daysToDuration(c.ResponseRetentionDays)
+}
+
+// getMinAnonymousGroupSize returns the number of scores that a survey needs before its results are shown when surveys
+// are anonymous.
+func (c *configuration) getMinAnonymousGroupSize() int {
+	if c.MinAnonymousGroupSize > 0 {
+		return c.MinAnonymousGroupSize
+	}
+
+	return DEFAULT_MIN_ANONYMOUS_GROUP_SIZE
+}
+
 // getConfiguration retrieves the active configuration under lock, making it safe to use
 // concurrently. The active configuration may change underneath the client of this method, but
 // the struct returned by this API call is considered immutable.
@@ -80,16 +393,37 @@ func (p *Plugin) OnConfigurationChange() error {
 		return errors.Wrap(err, "failed to load plugin configuration")
 	}
 
+	if err := configuration.IsValid(); err != nil {
+		return errors.Wrap(err, "invalid plugin configuration")
+	}
+
 	p.setConfiguration(configuration)
 
+	if p.isActivated() {
+		// Segment may have just been selected as the AnalyticsSink
+		if err := p.initializeClient(); err != nil {
+			p.API.LogError("Failed to initialize Segment client", "err", err.Error())
+		}
+	}
+
 	if p.hasSurveyBeenEnabled(configuration, oldConfiguration) {
 		// Check if a survey needs to be sent when the survey is enabled
 		go p.checkForNextSurvey(p.now().UTC())
 	}
 
+	if p.hasSurveyScheduleChanged(configuration, oldConfiguration) {
+		// Check if a recurring survey is now due instead of waiting for the next scheduled check
+		go p.checkForRecurringSurvey(p.now().UTC())
+	}
+
 	return nil
 }
 
 func (p *Plugin) hasSurveyBeenEnabled(new *configuration, old *configuration) bool {
 	return p.isActivated() && (new.EnableSurvey && !old.EnableSurvey)
 }
+
+func (p *Plugin) hasSurveyScheduleChanged(new *configuration, old *configuration) bool {
+	return p.isActivated() && new.isRecurring() &&
+		(new.SurveySchedule != old.SurveySchedule || new.SurveyIntervalDays != old.SurveyIntervalDays || !old.EnableSurvey)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -48,3 +49,263 @@ func TestHasSurveyBeenEnabled(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigurationIsValid(t *testing.T) {
+	for _, test := range []struct {
+		Name          string
+		Configuration *configuration
+		ExpectError   bool
+	}{
+		{
+			Name:          "default configuration",
+			Configuration: &configuration{},
+		},
+		{
+			Name:          "Segment",
+			Configuration: &configuration{AnalyticsSink: SINK_SEGMENT},
+		},
+		{
+			Name: "webhook with URL",
+			Configuration: &configuration{
+				AnalyticsSink:       SINK_WEBHOOK,
+				AnalyticsWebhookURL: "https://example.com/hook",
+			},
+		},
+		{
+			Name:          "webhook without URL",
+			Configuration: &configuration{AnalyticsSink: SINK_WEBHOOK},
+			ExpectError:   true,
+		},
+		{
+			Name: "webhook with invalid URL",
+			Configuration: &configuration{
+				AnalyticsSink:       SINK_WEBHOOK,
+				AnalyticsWebhookURL: "example.com/hook",
+			},
+			ExpectError: true,
+		},
+		{
+			Name: "file with path",
+			Configuration: &configuration{
+				AnalyticsSink:     SINK_FILE,
+				AnalyticsFilePath: "/var/log/nps.json",
+			},
+		},
+		{
+			Name:          "file without path",
+			Configuration: &configuration{AnalyticsSink: SINK_FILE},
+			ExpectError:   true,
+		},
+		{
+			Name:          "unknown sink",
+			Configuration: &configuration{AnalyticsSink: "carrier_pigeon"},
+			ExpectError:   true,
+		},
+		{
+			Name:          "quarterly schedule",
+			Configuration: &configuration{SurveySchedule: SCHEDULE_QUARTERLY},
+		},
+		{
+			Name: "interval schedule with days",
+			Configuration: &configuration{
+				SurveySchedule:     SCHEDULE_INTERVAL,
+				SurveyIntervalDays: 90,
+			},
+		},
+		{
+			Name:          "interval schedule without days",
+			Configuration: &configuration{SurveySchedule: SCHEDULE_INTERVAL},
+			ExpectError:   true,
+		},
+		{
+			Name:          "unknown schedule",
+			Configuration: &configuration{SurveySchedule: "fortnightly"},
+			ExpectError:   true,
+		},
+		{
+			Name: "custom survey timing",
+			Configuration: &configuration{
+				DaysUntilSurvey:            7,
+				MinDaysBetweenUserSurveys:  180,
+				MinDaysBetweenSurveyEmails: 1,
+			},
+		},
+		{
+			Name:          "negative days until survey",
+			Configuration: &configuration{DaysUntilSurvey: -1},
+			ExpectError:   true,
+		},
+		{
+			Name:          "negative days between user surveys",
+			Configuration: &configuration{MinDaysBetweenUserSurveys: -1},
+			ExpectError:   true,
+		},
+		{
+			Name:          "negative days between survey emails",
+			Configuration: &configuration{MinDaysBetweenSurveyEmails: -1},
+			ExpectError:   true,
+		},
+		{
+			Name: "survey targeting",
+			Configuration: &configuration{
+				SurveyTargetTeams:       "team1,team2",
+				SurveyTargetChannels:    "channel1",
+				SurveyTargetRoles:       "system_admin, team_admin",
+				SurveyMinAccountAgeDays: 30,
+				SurveySamplePercent:     10,
+				SurveyRolloutDays:       7,
+				SurveyReminderDays:      3,
+				SurveyExpiryDays:        14,
+			},
+		},
+		{
+			Name:          "unknown target role",
+			Configuration: &configuration{SurveyTargetRoles: "user,channel_admin"},
+			ExpectError:   true,
+		},
+		{
+			Name:          "negative minimum account age",
+			Configuration: &configuration{SurveyMinAccountAgeDays: -1},
+			ExpectError:   true,
+		},
+		{
+			Name:          "sample percentage over 100",
+			Configuration: &configuration{SurveySamplePercent: 101},
+			ExpectError:   true,
+		},
+		{
+			Name:          "negative rollout days",
+			Configuration: &configuration{SurveyRolloutDays: -1},
+			ExpectError:   true,
+		},
+		{
+			Name:          "negative reminder days",
+			Configuration: &configuration{SurveyReminderDays: -1},
+			ExpectError:   true,
+		},
+		{
+			Name:          "negative expiry days",
+			Configuration: &configuration{SurveyExpiryDays: -1},
+			ExpectError:   true,
+		},
+		{
+			Name:          "reminder after expiry",
+			Configuration: &configuration{SurveyReminderDays: 7, SurveyExpiryDays: 7},
+			ExpectError:   true,
+		},
+		{
+			Name:          "negative feedback window",
+			Configuration: &configuration{FeedbackWindowHours: -1},
+			ExpectError:   true,
+		},
+		{
+			Name:          "negative score change window",
+			Configuration: &configuration{ScoreChangeWindowHours: -1},
+			ExpectError:   true,
+		},
+		{
+			Name:          "negative minimum anonymous group size",
+			Configuration: &configuration{AnonymousSurveys: true, MinAnonymousGroupSize: -1},
+			ExpectError:   true,
+		},
+		{
+			Name:          "event webhooks",
+			Configuration: &configuration{EventWebhookURLs: "https://example.com/1\nhttps://example.com/2"},
+		},
+		{
+			Name:          "invalid event webhook URL",
+			Configuration: &configuration{EventWebhookURLs: "https://example.com/1\nexample.com/2"},
+			ExpectError:   true,
+		},
+		{
+			Name: "results digest",
+			Configuration: &configuration{
+				ResultsDigestChannelID:    "ah3nkpdu7tbg3r81ps8oiuupsr",
+				ResultsDigestIntervalDays: 14,
+			},
+		},
+		{
+			Name:          "results digest channel name",
+			Configuration: &configuration{ResultsDigestChannelID: "town-square"},
+			ExpectError:   true,
+		},
+		{
+			Name:          "negative results digest interval",
+			Configuration: &configuration{ResultsDigestIntervalDays: -1},
+			ExpectError:   true,
+		},
+		{
+			Name:          "negative response retention",
+			Configuration: &configuration{ResponseRetentionDays: -1},
+			ExpectError:   true,
+		},
+		{
+			Name: "feedback redaction",
+			Configuration: &configuration{
+				FeedbackRedactionDetectors: "email, phone",
+				FeedbackRedactionPatterns:  "ACME-\\d+\n(?i)project \\w+",
+			},
+		},
+		{
+			Name:          "unknown feedback redaction detector",
+			Configuration: &configuration{FeedbackRedactionDetectors: "email,ssn"},
+			ExpectError:   true,
+		},
+		{
+			Name:          "invalid feedback redaction pattern",
+			Configuration: &configuration{FeedbackRedactionPatterns: "ACME-\\d+\n(unclosed"},
+			ExpectError:   true,
+		},
+		{
+			Name: "valid message templates",
+			Configuration: &configuration{
+				SurveyMessageTemplate:     "Hi @{{.Username}}!",
+				AdminEmailSubjectTemplate: "[{{.SiteName}}] Survey on {{.SurveyDate}}",
+			},
+		},
+		{
+			Name:          "invalid message template",
+			Configuration: &configuration{AdminNoticeMessageTemplate: "Survey on {{.SurveyDate"},
+			ExpectError:   true,
+		},
+		{
+			Name:          "message template with unknown variable",
+			Configuration: &configuration{SurveyMessageTemplate: "Survey on {{.SurveyDate}}"},
+			ExpectError:   true,
+		},
+	} {
+		t.Run(test.Name, func(t *testing.T) {
+			err := test.Configuration.IsValid()
+
+			if test.ExpectError {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestConfigurationSurveyTiming(t *testing.T) {
+	t.Run("should use defaults when not set", func(t *testing.T) {
+		c := &configuration{}
+
+		assert.Equal(t, DEFAULT_TIME_UNTIL_SURVEY, c.getTimeUntilSurvey())
+		assert.Equal(t, 21, c.getDaysUntilSurvey())
+		assert.Equal(t, DEFAULT_MIN_TIME_BETWEEN_USER_SURVEYS, c.getMinTimeBetweenUserSurveys())
+		assert.Equal(t, DEFAULT_MIN_TIME_BETWEEN_SURVEY_EMAILS, c.getMinTimeBetweenSurveyEmails())
+	})
+
+	t.Run("should use configured values", func(t *testing.T) {
+		c := &configuration{
+			DaysUntilSurvey:            14,
+			MinDaysBetweenUserSurveys:  180,
+			MinDaysBetweenSurveyEmails: 2,
+		}
+
+		assert.Equal(t, 14*24*time.Hour, c.getTimeUntilSurvey())
+		assert.Equal(t, 14, c.getDaysUntilSurvey())
+		assert.Equal(t, 180*24*time.Hour, c.getMinTimeBetweenUserSurveys())
+		assert.Equal(t, 2*24*time.Hour, c.getMinTimeBetweenSurveyEmails())
+	})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+const (
+	// How often to send any survey DMs and admin notices that are due to users who haven't logged in since they
+	// became due
+	DM_DELIVERY_INTERVAL = time.Hour
+
+	// Get users up to 100 at a time when delivering DMs
+	DELIVERY_USERS_PER_PAGE = 100
+
+	// How long after a survey has been rolled out to everyone that it's still delivered to users who haven't logged in
+	// if unanswered surveys are followed up on sooner than that
+	SURVEY_DELIVERY_PERIOD = 7 * 24 * time.Hour
+)
+
+// deliverScheduledDMs sends any survey DMs and admin notices that are due so that users with long-lived sessions or
+// who only use the mobile apps receive them without having to log in again. Only one instance of the plugin delivers
+// DMs at a time.
+//
+// Once every user has had time to be sent the survey and have it followed up on, users are no longer checked until
+// the next survey, and anyone who becomes due the survey after that receives it when they next log in.
+func (p *Plugin) deliverScheduledDMs(now time.Time) {
+	config := p.getConfiguration()
+
+	if !config.EnableSurvey || !p.canCollectResponses() {
+		return
+	}
+
+	locked, err := p.tryLock(DELIVERY_LOCK_KEY, now)
+	if !locked || err != nil {
+		// Either an error occurred or there's already another thread delivering DMs
+		return
+	}
+	defer p.unlock(DELIVERY_LOCK_KEY)
+
+	survey, err := p.getActiveSurvey(now)
+	if err != nil {
+		p.API.LogError("Failed to get survey state", "err", err)
+		return
+	}
+
+	// Only admins can be due an admin notice, so there's no need to check everyone else until a survey starts
+	role := ""
+	if survey == nil {
+		role = model.SYSTEM_ADMIN_ROLE_ID
+	} else if !now.Before(config.getSurveyDeliveryEnd(survey)) {
+		return
+	}
+
+	page := 0
+
+	for {
+		users, err := p.API.GetUsers(&model.UserGetOptions{Page: page, PerPage: DELIVERY_USERS_PER_PAGE, Role: role})
+		if err != nil {
+			p.API.LogError("Failed to get users to deliver DMs to", "err", err)
+			return
+		}
+
+		for _, user := range users {
+			if user.DeleteAt > 0 || user.IsBot {
+				continue
+			}
+
+			// Admins are always checked since they may also be due an admin notice
+			if survey != nil && !isSystemAdmin(user) {
+				if due, err := p.isSurveyDMDue(user, survey, now); err != nil {
+					p.API.LogError("Failed to check if user is due a survey DM", "user_id", user.Id, "err", err)
+					continue
+				} else if !due {
+					continue
+				}
+			}
+
+			p.deliverUserDMs(user, now)
+		}
+
+		if len(users) < DELIVERY_USERS_PER_PAGE {
+			break
+		}
+
+		page += 1
+	}
+}
+
+// deliverUserDMs sends the user any DMs that are due unless another instance of the plugin is already doing so.
+func (p *Plugin) deliverUserDMs(user *model.User, now time.Time) {
+	userLockKey := fmt.Sprintf(USER_LOCK_KEY, user.Id)
+
+	locked, err := p.tryLock(userLockKey, now)
+	if !locked || err != nil {
+		// Either an error occurred or the user is logging in and already receiving any DMs
+		return
+	}
+	defer p.unlock(userLockKey)
+
+	p.checkForUserDMs(user, now)
+}
+
+// isSurveyDMDue returns whether or not the user may be due to be sent the survey, reminded of it, or have it closed.
+// It only reads the user's survey state, so it's checked before taking the user's lock to avoid locking every user
+// each time that DMs are delivered. checkForSurveyDM makes the final decision.
+func (p *Plugin) isSurveyDMDue(user *model.User, survey *surveyState, now time.Time) (bool, *model.AppError) {
+	config := p.getConfiguration()
+
+	if now.Sub(time.Unix(user.CreateAt/1000, 0)) < config.getMinAccountAge() {
+		return false, nil
+	}
+
+	if now.Before(survey.StartAt.Add(getRolloutDelay(user.Id, survey.getID(), config.SurveyRolloutDays))) {
+		return false, nil
+	}
+
+	var userSurvey *userSurveyState
+	if err := p.KVGet(fmt.Sprintf(USER_SURVEY_KEY, user.Id), &userSurvey); err != nil {
+		return false, err
+	}
+
+	if userSurvey == nil {
+		return true, nil
+	}
+
+	if userSurvey.getSurveyID() == survey.getID() {
+		return userSurvey.isFollowUpDue(config.getSurveyExpiry(), config.getSurveyReminderDelay(), now), nil
+	}
+
+	minTimeBetweenSurveys := config.getMinTimeBetweenUserSurveys()
+
+	return now.Sub(userSurvey.SentAt) >= minTimeBetweenSurveys && now.Sub(userSurvey.AnsweredAt) >= minTimeBetweenSurveys, nil
+}
+
+// getSurveyDeliveryEnd returns when every user will have had time to be sent the survey along with any reminder and
+// the closing of it if it goes unanswered.
+func (c *configuration) getSurveyDeliveryEnd(survey *surveyState) time.Time {
+	period := SURVEY_DELIVERY_PERIOD
+	if delay := c.getSurveyReminderDelay(); delay > period {
+		period = delay
+	}
+	if expiry := c.getSurveyExpiry(); expiry > period {
+		period = expiry
+	}
+
+	// Allow for the survey being sent to the last users during the final delivery before the rollout ends
+	return survey.StartAt.Add(daysToDuration(c.SurveyRolloutDays) + DM_DELIVERY_INTERVAL + period)
+}
+
+// getActiveSurvey returns the survey for the current server version if it has started and hasn't been cancelled, or nil
+// otherwise.
+func (p *Plugin) getActiveSurvey(now time.Time) (*surveyState, *model.AppError) {
+	var survey *surveyState
+	if err := p.KVGet(fmt.Sprintf(SURVEY_KEY, p.serverVersion), &survey); err != nil {
+		return nil, err
+	}
+
+	if survey == nil || survey.Cancelled || now.Before(survey.StartAt) {
+		return nil, nil
+	}
+
+	return survey, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeliverScheduledDMs(t *testing.T) {
+	now := toDate(2019, time.March, 1)
+	serverVersion := "5.12.0"
+
+	survey := &surveyState{
+		ServerVersion: serverVersion,
+		StartAt:       now.Add(-1 * time.Hour),
+	}
+
+	makeAPIMockWithDiagnostics := func() *plugintest.API {
+		api := makeAPIMock()
+		api.On("GetConfig").Return(&model.Config{
+			LogSettings: model.LogSettings{
+				EnableDiagnostics: model.NewBool(true),
+			},
+		})
+		return api
+	}
+
+	makePlugin := func(api *plugintest.API) *Plugin {
+		p := &Plugin{
+			configuration: &configuration{
+				EnableSurvey: true,
+			},
+			serverVersion: serverVersion,
+		}
+		p.SetAPI(api)
+
+		return p
+	}
+
+	t.Run("should check every active user for DMs once a survey has started", func(t *testing.T) {
+		user := &model.User{
+			Id:       model.NewId(),
+			CreateAt: now.Add(-1*DEFAULT_TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
+		}
+		deactivatedUser := &model.User{Id: model.NewId(), DeleteAt: 1234}
+		bot := &model.User{Id: model.NewId(), IsBot: true}
+
+		userLockKey := fmt.Sprintf(USER_LOCK_KEY, user.Id)
+
+		api := makeAPIMockWithDiagnostics()
+		api.On("KVCompareAndSet", DELIVERY_LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVGet", fmt.Sprintf(SURVEY_KEY, serverVersion)).Return(mustMarshalJSON(survey), nil)
+		api.On("GetUsers", &model.UserGetOptions{Page: 0, PerPage: DELIVERY_USERS_PER_PAGE}).Return([]*model.User{
+			user,
+			deactivatedUser,
+			bot,
+		}, nil)
+		api.On("KVCompareAndSet", userLockKey, []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVGet", fmt.Sprintf(USER_PREFERENCES_KEY, user.Id)).Return(nil, nil)
+		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, user.Id)).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			SentAt:        now.Add(-8 * 24 * time.Hour),
+		}), nil)
+		api.On("KVSet", fmt.Sprintf(USER_SURVEY_KEY, user.Id), mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			SentAt:        now.Add(-8 * 24 * time.Hour),
+			ExpiredAt:     now,
+		})).Return(nil)
+		api.On("KVDelete", userLockKey).Return(nil)
+		api.On("KVDelete", DELIVERY_LOCK_KEY).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api)
+		p.configuration.SurveyExpiryDays = 7
+
+		p.deliverScheduledDMs(now)
+	})
+
+	t.Run("should not lock users who aren't due a survey DM", func(t *testing.T) {
+		answeredUser := &model.User{Id: model.NewId()}
+		newUser := &model.User{Id: model.NewId(), CreateAt: now.UnixNano() / int64(time.Millisecond)}
+
+		api := makeAPIMockWithDiagnostics()
+		api.On("KVCompareAndSet", DELIVERY_LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVGet", fmt.Sprintf(SURVEY_KEY, serverVersion)).Return(mustMarshalJSON(survey), nil)
+		api.On("GetUsers", &model.UserGetOptions{Page: 0, PerPage: DELIVERY_USERS_PER_PAGE}).Return([]*model.User{
+			answeredUser,
+			newUser,
+		}, nil)
+		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, answeredUser.Id)).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			SentAt:        now.Add(-time.Hour),
+			AnsweredAt:    now.Add(-time.Minute),
+		}), nil)
+		api.On("KVDelete", DELIVERY_LOCK_KEY).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api)
+		p.deliverScheduledDMs(now)
+	})
+
+	t.Run("should stop checking users once the survey has been delivered to everyone", func(t *testing.T) {
+		api := makeAPIMockWithDiagnostics()
+		api.On("KVCompareAndSet", DELIVERY_LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVGet", fmt.Sprintf(SURVEY_KEY, serverVersion)).Return(mustMarshalJSON(&surveyState{
+			ServerVersion: serverVersion,
+			StartAt:       now.Add(-22 * 24 * time.Hour),
+		}), nil)
+		api.On("KVDelete", DELIVERY_LOCK_KEY).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api)
+		p.configuration.SurveyRolloutDays = 7
+		p.configuration.SurveyExpiryDays = 14
+
+		p.deliverScheduledDMs(now)
+	})
+
+	t.Run("should only check admins when no survey has started", func(t *testing.T) {
+		api := makeAPIMockWithDiagnostics()
+		api.On("KVCompareAndSet", DELIVERY_LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVGet", fmt.Sprintf(SURVEY_KEY, serverVersion)).Return(nil, nil)
+		api.On("GetUsers", &model.UserGetOptions{Page: 0, PerPage: DELIVERY_USERS_PER_PAGE, Role: model.SYSTEM_ADMIN_ROLE_ID}).Return([]*model.User{}, nil)
+		api.On("KVDelete", DELIVERY_LOCK_KEY).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api)
+		p.deliverScheduledDMs(now)
+	})
+
+	t.Run("should check users a page at a time", func(t *testing.T) {
+		var bots []*model.User
+		for i := 0; i < DELIVERY_USERS_PER_PAGE; i++ {
+			bots = append(bots, &model.User{Id: model.NewId(), IsBot: true})
+		}
+
+		api := makeAPIMockWithDiagnostics()
+		api.On("KVCompareAndSet", DELIVERY_LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVGet", fmt.Sprintf(SURVEY_KEY, serverVersion)).Return(mustMarshalJSON(survey), nil)
+		api.On("GetUsers", &model.UserGetOptions{Page: 0, PerPage: DELIVERY_USERS_PER_PAGE}).Return(bots, nil)
+		api.On("GetUsers", &model.UserGetOptions{Page: 1, PerPage: DELIVERY_USERS_PER_PAGE}).Return([]*model.User{}, nil)
+		api.On("KVDelete", DELIVERY_LOCK_KEY).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api)
+		p.deliverScheduledDMs(now)
+	})
+
+	t.Run("should skip users that are already being checked", func(t *testing.T) {
+		user := &model.User{Id: model.NewId()}
+
+		api := makeAPIMockWithDiagnostics()
+		api.On("KVCompareAndSet", DELIVERY_LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVGet", fmt.Sprintf(SURVEY_KEY, serverVersion)).Return(mustMarshalJSON(survey), nil)
+		api.On("GetUsers", &model.UserGetOptions{Page: 0, PerPage: DELIVERY_USERS_PER_PAGE}).Return([]*model.User{user}, nil)
+		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, user.Id)).Return(nil, nil)
+		api.On("KVCompareAndSet", fmt.Sprintf(USER_LOCK_KEY, user.Id), []byte(nil), mustMarshalJSON(now)).Return(false, nil)
+		api.On("KVDelete", DELIVERY_LOCK_KEY).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api)
+		p.deliverScheduledDMs(now)
+	})
+
+	t.Run("should not deliver DMs if another instance is already doing so", func(t *testing.T) {
+		api := makeAPIMockWithDiagnostics()
+		api.On("KVCompareAndSet", DELIVERY_LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(false, nil)
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api)
+		p.deliverScheduledDMs(now)
+	})
+
+	t.Run("should not deliver DMs when surveys are disabled", func(t *testing.T) {
+		api := makeAPIMock()
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api)
+		p.configuration.EnableSurvey = false
+
+		p.deliverScheduledDMs(now)
+	})
+}
+
+func TestGetActiveSurvey(t *testing.T) {
+	now := toDate(2019, time.March, 1)
+	serverVersion := "5.12.0"
+
+	for _, test := range []struct {
+		Name     string
+		Survey   *surveyState
+		Expected bool
+	}{
+		{
+			Name:     "no survey",
+			Survey:   nil,
+			Expected: false,
+		},
+		{
+			Name:     "survey has started",
+			Survey:   &surveyState{ServerVersion: serverVersion, StartAt: now},
+			Expected: true,
+		},
+		{
+			Name:     "survey hasn't started",
+			Survey:   &surveyState{ServerVersion: serverVersion, StartAt: now.Add(time.Minute)},
+			Expected: false,
+		},
+		{
+			Name:     "survey was cancelled",
+			Survey:   &surveyState{ServerVersion: serverVersion, StartAt: now, Cancelled: true},
+			Expected: false,
+		},
+	} {
+		t.Run(test.Name, func(t *testing.T) {
+			api := makeAPIMock()
+			if test.Survey != nil {
+				api.On("KVGet", fmt.Sprintf(SURVEY_KEY, serverVersion)).Return(mustMarshalJSON(test.Survey), nil)
+			} else {
+				api.On("KVGet", fmt.Sprintf(SURVEY_KEY, serverVersion)).Return(nil, nil)
+			}
+			defer api.AssertExpectations(t)
+
+			p := &Plugin{serverVersion: serverVersion}
+			p.SetAPI(api)
+
+			survey, err := p.getActiveSurvey(now)
+
+			assert.Equal(t, test.Expected, survey != nil)
+			assert.Nil(t, err)
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+const (
+	// RESULTS_DIGEST_KEY is used to store the resultsDigestState tracking when a summary of a survey's results was last
+	// posted to the ResultsDigestChannelID. It should contain the survey's ID like "ResultsDigest-5.10.0".
+	RESULTS_DIGEST_KEY = "ResultsDigest-%s"
+
+	// How often to check whether or not a results digest should be posted
+	RESULTS_DIGEST_CHECK_INTERVAL = time.Hour
+
+	// How long to wait between results digests for a survey if ResultsDigestIntervalDays isn't set
+	DEFAULT_RESULTS_DIGEST_INTERVAL = 7 * 24 * time.Hour
+
+	// The maximum number of feedback messages included in a results digest
+	RESULTS_DIGEST_FEEDBACK_SAMPLE_SIZE = 5
+
+	// The maximum number of characters of each feedback message included in a results digest
+	RESULTS_DIGEST_MAX_FEEDBACK_LENGTH = 300
+)
+
+type resultsDigestState struct {
+	// CheckedAt is when a digest was last due for the survey, even if nothing had changed to post about.
+	CheckedAt time.Time `json:"checked_at"`
+	PostedAt  time.Time `json:"posted_at"`
+
+	// Answered is the number of users who had answered the survey when the last digest was posted.
+	Answered int `json:"answered"`
+}
+
+// digestFeedback is a feedback message included in a results digest without anything identifying who sent it.
+type digestFeedback struct {
+	Message     string
+	ScoreBucket string
+	CreateAt    time.Time
+}
+
+// getResultsDigestInterval returns how long to wait between results digests for a survey.
+func (c *configuration) getResultsDigestInterval() time.Duration {
+	if c.ResultsDigestIntervalDays > 0 {
+		return daysToDuration(c.ResultsDigestIntervalDays)
+	}
+
+	return DEFAULT_RESULTS_DIGEST_INTERVAL
+}
+
+// checkForResultsDigest posts a summary of the current survey's results to the ResultsDigestChannelID if one is due.
+// Digests are posted every ResultsDigestIntervalDays after the survey starts, but they're skipped if nobody has
+// answered the survey since the last one. Returns whether or not a digest was posted.
+func (p *Plugin) checkForResultsDigest(now time.Time) bool {
+	config := p.getConfiguration()
+
+	if config.ResultsDigestChannelID == "" {
+		return false
+	}
+
+	locked, err := p.tryLock(RESULTS_DIGEST_LOCK_KEY, now)
+	if !locked || err != nil {
+		// Either an error occurred or there's already another thread checking for a results digest
+		return false
+	}
+	defer p.unlock(RESULTS_DIGEST_LOCK_KEY)
+
+	var survey *surveyState
+	if err := p.KVGet(fmt.Sprintf(SURVEY_KEY, p.serverVersion), &survey); err != nil {
+		p.API.LogError("Failed to get survey state", "err", err)
+		return false
+	}
+
+	if survey == nil || survey.Cancelled || now.Before(survey.StartAt) {
+		return false
+	}
+
+	digestKey := fmt.Sprintf(RESULTS_DIGEST_KEY, survey.getID())
+
+	var digest *resultsDigestState
+	if err := p.KVGet(digestKey, &digest); err != nil {
+		p.API.LogError("Failed to get results digest state", "err", err)
+		return false
+	}
+
+	if digest == nil {
+		digest = &resultsDigestState{
+			CheckedAt: survey.StartAt,
+		}
+	}
+
+	if now.Sub(digest.CheckedAt) < config.getResultsDigestInterval() {
+		return false
+	}
+
+	results, err := p.getSurveyResults(survey.getID())
+	if err != nil {
+		p.API.LogError("Failed to get survey results", "err", err)
+		return false
+	}
+
+	digest.CheckedAt = now
+
+	if results.Answered == 0 || results.Answered == digest.Answered {
+		// Nothing has changed since the last digest
+		if err := p.KVSet(digestKey, digest); err != nil {
+			p.API.LogError("Failed to save results digest state", "err", err)
+		}
+
+		return false
+	}
+
+	message, err := p.buildResultsDigest(survey, results)
+	if err != nil {
+		p.API.LogError("Failed to build results digest", "err", err)
+		return false
+	}
+
+	if _, err := p.API.CreatePost(&model.Post{
+		UserId:    p.botUserID,
+		ChannelId: config.ResultsDigestChannelID,
+		Message:   message,
+	}); err != nil {
+		p.API.LogError("Failed to post results digest", "channel_id", config.ResultsDigestChannelID, "err", err)
+		return false
+	}
+
+	digest.PostedAt = now
+	digest.Answered = results.Answered
+
+	if err := p.KVSet(digestKey, digest); err != nil {
+		p.API.LogError("Failed to save results digest state", "err", err)
+	}
+
+	return true
+}
+
+// buildResultsDigest returns the message posted as a digest of the survey's results. It compares the survey to the
+// latest survey on the previous server version and includes a sample of recent feedback unless the results are being
+// withheld to keep the survey anonymous.
+func (p *Plugin) buildResultsDigest(survey *surveyState, results *surveyResults) (string, *model.AppError) {
+	var previousResults *surveyResults
+
+	previous, err := p.getPreviousSurvey(survey)
+	if err != nil {
+		return "", err
+	} else if previous != nil {
+		previousResults, err = p.getSurveyResults(previous.getID())
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var feedback []*digestFeedback
+	if !results.Withheld {
+		feedback, err = p.getRecentFeedback(survey.getID(), RESULTS_DIGEST_FEEDBACK_SAMPLE_SIZE)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return formatResultsDigest(results, previousResults, feedback), nil
+}
+
+// getPreviousSurvey returns the latest survey that started before the given one on an earlier server version, or nil if
+// there isn't one.
+func (p *Plugin) getPreviousSurvey(survey *surveyState) (*surveyState, *model.AppError) {
+	var previous *surveyState
+
+	err := p.forEachKey(getKeyPrefix(SURVEY_KEY), func(key string) *model.AppError {
+		var other *surveyState
+		if err := p.KVGet(key, &other); err != nil {
+			return err
+		}
+
+		if other == nil || other.Cancelled || other.ServerVersion == survey.ServerVersion || !other.StartAt.Before(survey.StartAt) {
+			return nil
+		}
+
+		if previous == nil || other.StartAt.After(previous.StartAt) {
+			previous = other
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return previous, nil
+}
+
+// getRecentFeedback returns up to limit of the latest feedback messages sent about the survey, newest first.
+func (p *Plugin) getRecentFeedback(surveyID string, limit int) ([]*digestFeedback, *model.AppError) {
+	var feedback []*digestFeedback
+
+	err := p.forEachKey(fmt.Sprintf(RESPONSE_KEY, surveyID, ""), func(key string) *model.AppError {
+		var response *surveyResponse
+		if err := p.KVGet(key, &response); err != nil {
+			return err
+		}
+
+		if response == nil || response.getSurveyID() != surveyID {
+			return nil
+		}
+
+		for _, entry := range response.Feedback {
+			feedback = append(feedback, &digestFeedback{
+				Message:     entry.Message,
+				ScoreBucket: response.getScoreBucket(),
+				CreateAt:    entry.CreateAt,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(feedback, func(i, j int) bool {
+		return feedback[i].CreateAt.After(feedback[j].CreateAt)
+	})
+
+	if len(feedback) > limit {
+		feedback = feedback[:limit]
+	}
+
+	return feedback, nil
+}
+
+// formatResultsDigest returns a Markdown digest of the given results. previousResults and feedback may be empty.
+func formatResultsDigest(results *surveyResults, previousResults *surveyResults, feedback []*digestFeedback) string {
+	digest := formatSurveyResults(results)
+
+	if !results.Withheld && previousResults != nil && !previousResults.Withheld && previousResults.hasScores() {
+		change := results.NPS - previousResults.NPS
+
+		trend := fmt.Sprintf("up %.1f points", change)
+		if change < 0 {
+			trend = fmt.Sprintf("down %.1f points", -change)
+		} else if change == 0 {
+			trend = "unchanged"
+		}
+
+		digest += fmt.Sprintf("\n\nNPS is %s from %.1f for %s.", trend, previousResults.NPS, previousResults.describe())
+	}
+
+	if len(feedback) > 0 {
+		digest += "\n\n##### Recent feedback"
+
+		for _, entry := range feedback {
+			from := "user who didn't give a score"
+			if entry.ScoreBucket != "" {
+				from = entry.ScoreBucket
+			}
+
+			digest += fmt.Sprintf("\n\n> %s\n\n_From a %s_", formatDigestFeedback(entry.Message), from)
+		}
+	}
+
+	return digest
+}
+
+// formatDigestFeedback shortens a feedback message and puts it on a single line so that it can be quoted in a digest.
+func formatDigestFeedback(message string) string {
+	message = strings.Join(strings.Fields(message), " ")
+
+	if runes := []rune(message); len(runes) > RESULTS_DIGEST_MAX_FEEDBACK_LENGTH {
+		message = string(runes[:RESULTS_DIGEST_MAX_FEEDBACK_LENGTH]) + "…"
+	}
+
+	return message
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatResultsDigest(t *testing.T) {
+	makeResults := func(serverVersion string, promoters, passives, detractors int) *surveyResults {
+		results := &surveyResults{
+			ServerVersion: serverVersion,
+			Promoters:     promoters,
+			Passives:      passives,
+			Detractors:    detractors,
+			Sent:          20,
+			Answered:      promoters + passives + detractors,
+		}
+		results.calculate()
+
+		return results
+	}
+
+	t.Run("should compare the results to the previous survey", func(t *testing.T) {
+		results := makeResults("5.11.0", 6, 2, 2)
+
+		assert.Contains(t, formatResultsDigest(results, makeResults("5.10.0", 5, 3, 2), nil), "NPS is up 10.0 points from 30.0 for Mattermost 5.10.0.")
+		assert.Contains(t, formatResultsDigest(results, makeResults("5.10.0", 6, 4, 0), nil), "NPS is down 20.0 points from 60.0 for Mattermost 5.10.0.")
+		assert.Contains(t, formatResultsDigest(results, makeResults("5.10.0", 4, 0, 0), nil), "NPS is down 60.0 points from 100.0 for Mattermost 5.10.0.")
+		assert.Contains(t, formatResultsDigest(results, makeResults("5.10.0", 3, 1, 1), nil), "NPS is unchanged from 40.0 for Mattermost 5.10.0.")
+	})
+
+	t.Run("should not compare the results without scores to compare to", func(t *testing.T) {
+		results := makeResults("5.11.0", 6, 2, 2)
+
+		withheld := makeResults("5.10.0", 1, 0, 0)
+		withheld.withhold(5)
+
+		assert.Equal(t, formatSurveyResults(results), formatResultsDigest(results, nil, nil))
+		assert.Equal(t, formatSurveyResults(results), formatResultsDigest(results, makeResults("5.10.0", 0, 0, 0), nil))
+		assert.Equal(t, formatSurveyResults(results), formatResultsDigest(results, withheld, nil))
+	})
+
+	t.Run("should not compare results that are being withheld", func(t *testing.T) {
+		results := makeResults("5.11.0", 1, 0, 0)
+		results.withhold(5)
+
+		assert.Equal(t, formatSurveyResults(results), formatResultsDigest(results, makeResults("5.10.0", 5, 3, 2), nil))
+	})
+
+	t.Run("should include feedback", func(t *testing.T) {
+		results := makeResults("5.11.0", 6, 2, 2)
+
+		digest := formatResultsDigest(results, nil, []*digestFeedback{
+			{Message: "Search is\n\nso slow", ScoreBucket: SCORE_BUCKET_DETRACTOR},
+			{Message: strings.Repeat("é", RESULTS_DIGEST_MAX_FEEDBACK_LENGTH+1)},
+		})
+
+		assert.Contains(t, digest, "##### Recent feedback\n\n> Search is so slow\n\n_From a detractor_")
+		assert.Contains(t, digest, "\n\n> "+strings.Repeat("é", RESULTS_DIGEST_MAX_FEEDBACK_LENGTH)+"…\n\n_From a user who didn't give a score_")
+	})
+}
+
+func TestGetPreviousSurvey(t *testing.T) {
+	now := toDate(2019, time.June, 1)
+
+	survey := &surveyState{ServerVersion: "5.12.0", StartAt: now}
+
+	surveys := map[string]*surveyState{
+		"Survey-5.9.0":      {ServerVersion: "5.9.0", StartAt: now.Add(-90 * 24 * time.Hour)},
+		"Survey-5.10.0":     {ServerVersion: "5.10.0", StartAt: now.Add(-60 * 24 * time.Hour)},
+		"Survey-5.11.0":     {ServerVersion: "5.11.0", StartAt: now.Add(-30 * 24 * time.Hour), Cancelled: true},
+		"Survey-5.12.0-dvn": {ID: "5.12.0-dvn", ServerVersion: "5.12.0", StartAt: now.Add(-45 * 24 * time.Hour)},
+		"Survey-5.12.0":     survey,
+	}
+
+	api := makeAPIMock()
+	var keys []string
+	for key, state := range surveys {
+		keys = append(keys, key)
+		api.On("KVGet", key).Return(mustMarshalJSON(state), nil)
+	}
+	api.On("KVList", 0, 100).Return(append(keys, fmt.Sprintf(SERVER_UPGRADE_KEY, "5.12.0")), nil)
+	defer api.AssertExpectations(t)
+
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	previous, err := p.getPreviousSurvey(survey)
+
+	require.Nil(t, err)
+	assert.Equal(t, surveys["Survey-5.10.0"], previous)
+}
+
+func TestGetRecentFeedback(t *testing.T) {
+	now := toDate(2019, time.June, 1)
+	surveyID := "5.12.0"
+
+	responses := map[string]*surveyResponse{
+		fmt.Sprintf(RESPONSE_KEY, surveyID, model.NewId()): {
+			ServerVersion: surveyID,
+			Score:         10,
+			ScoreAt:       now,
+			Feedback: []*feedbackEntry{
+				{Message: "first", CreateAt: now.Add(1 * time.Minute)},
+				{Message: "fourth", CreateAt: now.Add(4 * time.Minute)},
+			},
+		},
+		fmt.Sprintf(RESPONSE_KEY, surveyID, model.NewId()): {
+			ServerVersion: surveyID,
+			Feedback: []*feedbackEntry{
+				{Message: "third", CreateAt: now.Add(3 * time.Minute)},
+			},
+		},
+		fmt.Sprintf(RESPONSE_KEY, surveyID, model.NewId()): {
+			ServerVersion: surveyID,
+			Score:         2,
+			ScoreAt:       now,
+			Feedback: []*feedbackEntry{
+				{Message: "second", CreateAt: now.Add(2 * time.Minute)},
+			},
+		},
+	}
+
+	// A response to a later survey on the same server version shares the prefix of the survey's responses
+	otherKey := fmt.Sprintf(RESPONSE_KEY, "5.12.0-dxc", model.NewId())
+
+	api := makeAPIMock()
+	var keys []string
+	for key, response := range responses {
+		keys = append(keys, key)
+		api.On("KVGet", key).Return(mustMarshalJSON(response), nil)
+	}
+	api.On("KVGet", otherKey).Return(mustMarshalJSON(&surveyResponse{
+		SurveyID:      "5.12.0-dxc",
+		ServerVersion: surveyID,
+		Feedback: []*feedbackEntry{
+			{Message: "other", CreateAt: now.Add(5 * time.Minute)},
+		},
+	}), nil)
+	api.On("KVList", 0, 100).Return(append(keys, otherKey), nil)
+	defer api.AssertExpectations(t)
+
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	feedback, err := p.getRecentFeedback(surveyID, 3)
+
+	require.Nil(t, err)
+	assert.Equal(t, []*digestFeedback{
+		{Message: "fourth", ScoreBucket: SCORE_BUCKET_PROMOTER, CreateAt: now.Add(4 * time.Minute)},
+		{Message: "third", CreateAt: now.Add(3 * time.Minute)},
+		{Message: "second", ScoreBucket: SCORE_BUCKET_DETRACTOR, CreateAt: now.Add(2 * time.Minute)},
+	}, feedback)
+}
+
+func TestCheckForResultsDigest(t *testing.T) {
+	botUserID := model.NewId()
+	channelID := model.NewId()
+	serverVersion := "5.12.0"
+	startAt := toDate(2019, time.June, 1)
+	surveyKey := fmt.Sprintf(SURVEY_KEY, serverVersion)
+	digestKey := fmt.Sprintf(RESULTS_DIGEST_KEY, serverVersion)
+	userID := model.NewId()
+	responseKey := fmt.Sprintf(RESPONSE_KEY, serverVersion, userID)
+	userSurveyKey := fmt.Sprintf(USER_SURVEY_KEY, userID)
+
+	makePlugin := func(api *plugintest.API) *Plugin {
+		p := &Plugin{
+			botUserID: botUserID,
+			configuration: &configuration{
+				ResultsDigestChannelID: channelID,
+			},
+			serverVersion: serverVersion,
+		}
+		p.SetAPI(api)
+
+		return p
+	}
+
+	makeAPIMock := func(now time.Time) *plugintest.API {
+		api := makeAPIMock()
+		api.On("KVCompareAndSet", RESULTS_DIGEST_LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVDelete", RESULTS_DIGEST_LOCK_KEY).Return(nil)
+		api.On("KVGet", surveyKey).Return(mustMarshalJSON(&surveyState{
+			ServerVersion: serverVersion,
+			StartAt:       startAt,
+		}), nil)
+		return api
+	}
+
+	// mockResults sets up a survey that has been answered by a single user
+	mockResults := func(api *plugintest.API) {
+		api.On("KVList", 0, 100).Return([]string{responseKey, surveyKey, userSurveyKey}, nil)
+		api.On("KVGet", responseKey).Return(mustMarshalJSON(&surveyResponse{
+			ServerVersion: serverVersion,
+			Score:         9,
+			ScoreAt:       startAt,
+			Feedback: []*feedbackEntry{
+				{Message: "Great!", CreateAt: startAt},
+			},
+		}), nil)
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			AnsweredAt:    startAt,
+		}), nil)
+	}
+
+	t.Run("should post a digest once the survey has run for the digest interval", func(t *testing.T) {
+		now := startAt.Add(DEFAULT_RESULTS_DIGEST_INTERVAL)
+
+		api := makeAPIMock(now)
+		api.On("KVGet", digestKey).Return(nil, nil)
+		mockResults(api)
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.UserId == botUserID &&
+				post.ChannelId == channelID &&
+				strings.Contains(post.Message, "| 100.0 | 1 | 0 | 0 | 1 | 1 | 100% |") &&
+				strings.Contains(post.Message, "> Great!\n\n_From a promoter_")
+		})).Return(&model.Post{}, nil)
+		api.On("KVSet", digestKey, mustMarshalJSON(&resultsDigestState{
+			CheckedAt: now,
+			PostedAt:  now,
+			Answered:  1,
+		})).Return(nil)
+		defer api.AssertExpectations(t)
+
+		assert.True(t, makePlugin(api).checkForResultsDigest(now))
+	})
+
+	t.Run("should not post a digest before the digest interval has passed", func(t *testing.T) {
+		now := startAt.Add(DEFAULT_RESULTS_DIGEST_INTERVAL - time.Minute)
+
+		api := makeAPIMock(now)
+		api.On("KVGet", digestKey).Return(nil, nil)
+		defer api.AssertExpectations(t)
+
+		assert.False(t, makePlugin(api).checkForResultsDigest(now))
+	})
+
+	t.Run("should not post a digest when nobody has answered since the last one", func(t *testing.T) {
+		now := startAt.Add(2 * DEFAULT_RESULTS_DIGEST_INTERVAL)
+
+		api := makeAPIMock(now)
+		api.On("KVGet", digestKey).Return(mustMarshalJSON(&resultsDigestState{
+			CheckedAt: startAt.Add(DEFAULT_RESULTS_DIGEST_INTERVAL),
+			PostedAt:  startAt.Add(DEFAULT_RESULTS_DIGEST_INTERVAL),
+			Answered:  1,
+		}), nil)
+		mockResults(api)
+		api.On("KVSet", digestKey, mustMarshalJSON(&resultsDigestState{
+			CheckedAt: now,
+			PostedAt:  startAt.Add(DEFAULT_RESULTS_DIGEST_INTERVAL),
+			Answered:  1,
+		})).Return(nil)
+		defer api.AssertExpectations(t)
+
+		assert.False(t, makePlugin(api).checkForResultsDigest(now))
+	})
+
+	t.Run("should not post a digest before the survey starts", func(t *testing.T) {
+		now := startAt.Add(-time.Minute)
+
+		api := makeAPIMock(now)
+		defer api.AssertExpectations(t)
+
+		assert.False(t, makePlugin(api).checkForResultsDigest(now))
+	})
+
+	t.Run("should do nothing if another thread holds the lock", func(t *testing.T) {
+		now := startAt.Add(DEFAULT_RESULTS_DIGEST_INTERVAL)
+
+		api := &plugintest.API{}
+		api.On("KVCompareAndSet", RESULTS_DIGEST_LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(false, nil)
+		defer api.AssertExpectations(t)
+
+		assert.False(t, makePlugin(api).checkForResultsDigest(now))
+	})
+
+	t.Run("should do nothing without a digest channel", func(t *testing.T) {
+		p := &Plugin{
+			configuration: &configuration{},
+		}
+
+		assert.False(t, p.checkForResultsDigest(startAt.Add(DEFAULT_RESULTS_DIGEST_INTERVAL)))
+	})
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+const (
+	EXPORT_FORMAT_CSV  = "csv"
+	EXPORT_FORMAT_JSON = "json"
+)
+
+// exportedEvent is a single score or piece of feedback as included in an export of survey responses. The fields match
+// the properties that are sent to an eventSink.
+type exportedEvent struct {
+	Event         string `json:"event"`
+	UserID        string `json:"user_actual_id"`
+	UserRole      string `json:"user_role"`
+	UserCreateAt  int64  `json:"user_create_at"`
+	LicenseSKU    string `json:"license_sku"`
+	ServerVersion string `json:"server_version"`
+	Timestamp     int64  `json:"timestamp"`
+	Score         *int   `json:"score,omitempty"`
+	ScoreBucket   string `json:"score_bucket,omitempty"`
+	Revision      *int   `json:"revision,omitempty"`
+	Feedback      string `json:"feedback,omitempty"`
+	Redactions    *int   `json:"redaction_count,omitempty"`
+	QuestionID    string `json:"question_id,omitempty"`
+	Answer        string `json:"answer,omitempty"`
+}
+
+var exportedEventCSVHeader = []string{
+	"event",
+	"user_actual_id",
+	"user_role",
+	"user_create_at",
+	"license_sku",
+	"server_version",
+	"timestamp",
+	"score",
+	"score_bucket",
+	"revision",
+	"feedback",
+	"redaction_count",
+	"question_id",
+	"answer",
+}
+
+func (e *exportedEvent) toCSV() []string {
+	score := ""
+	if e.Score != nil {
+		score = strconv.Itoa(*e.Score)
+	}
+
+	revision := ""
+	if e.Revision != nil {
+		revision = strconv.Itoa(*e.Revision)
+	}
+
+	redactions := ""
+	if e.Redactions != nil {
+		redactions = strconv.Itoa(*e.Redactions)
+	}
+
+	return []string{
+		e.Event,
+		e.UserID,
+		e.UserRole,
+		strconv.FormatInt(e.UserCreateAt, 10),
+		e.LicenseSKU,
+		e.ServerVersion,
+		strconv.FormatInt(e.Timestamp, 10),
+		score,
+		e.ScoreBucket,
+		revision,
+		e.Feedback,
+		redactions,
+		e.QuestionID,
+		e.Answer,
+	}
+}
+
+// getExportedEvents splits a stored surveyResponse into the score, feedback, and answer events that it contains. Each
+// event includes the bucket of the user's score so that feedback and answers can be grouped by it. Scores that the user
+// later changed are included as earlier revisions of the score event.
+func getExportedEvents(response *surveyResponse) []*exportedEvent {
+	var events []*exportedEvent
+
+	makeEvent := func(event string, at time.Time) *exportedEvent {
+		return &exportedEvent{
+			Event:         event,
+			UserID:        response.UserID,
+			UserRole:      response.UserRole,
+			UserCreateAt:  response.UserCreateAt,
+			LicenseSKU:    response.LicenseSKU,
+			ServerVersion: response.ServerVersion,
+			Timestamp:     toMillis(at),
+			ScoreBucket:   response.getScoreBucket(),
+		}
+	}
+
+	for i, previous := range response.ScoreHistory {
+		event := makeEvent(NPS_SCORE, previous.ScoreAt)
+
+		score := previous.Score
+		event.Score = &score
+		event.ScoreBucket = getScoreBucket(score)
+
+		revision := i
+		event.Revision = &revision
+
+		events = append(events, event)
+	}
+
+	if response.hasScore() {
+		event := makeEvent(NPS_SCORE, response.ScoreAt)
+
+		score := response.Score
+		event.Score = &score
+
+		revision := len(response.ScoreHistory)
+		event.Revision = &revision
+
+		events = append(events, event)
+	}
+
+	for _, feedback := range response.Feedback {
+		event := makeEvent(NPS_FEEDBACK, feedback.CreateAt)
+		event.Feedback = feedback.Message
+
+		redactions := feedback.RedactionCount
+		event.Redactions = &redactions
+
+		events = append(events, event)
+	}
+
+	questionIDs := make([]string, 0, len(response.Answers))
+	for questionID := range response.Answers {
+		questionIDs = append(questionIDs, questionID)
+	}
+	sort.Strings(questionIDs)
+
+	for _, questionID := range questionIDs {
+		answer := response.Answers[questionID]
+
+		event := makeEvent(NPS_ANSWER, answer.AnswerAt)
+		event.QuestionID = questionID
+		event.Answer = answer.Value
+
+		redactions := answer.RedactionCount
+		event.Redactions = &redactions
+
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// eventWriter writes exported events to a response as they're read from the KV store.
+type eventWriter interface {
+	write(event *exportedEvent) error
+	close() error
+}
+
+type csvEventWriter struct {
+	writer *csv.Writer
+}
+
+func newCSVEventWriter(w io.Writer) (*csvEventWriter, error) {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write(exportedEventCSVHeader); err != nil {
+		return nil, err
+	}
+
+	return &csvEventWriter{writer: writer}, nil
+}
+
+func (w *csvEventWriter) write(event *exportedEvent) error {
+	return w.writer.Write(event.toCSV())
+}
+
+func (w *csvEventWriter) close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// jsonEventWriter writes events as a JSON array one element at a time so that the whole array never needs to be held
+// in memory.
+type jsonEventWriter struct {
+	w       io.Writer
+	written bool
+}
+
+func newJSONEventWriter(w io.Writer) (*jsonEventWriter, error) {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return nil, err
+	}
+
+	return &jsonEventWriter{w: w}, nil
+}
+
+func (w *jsonEventWriter) write(event *exportedEvent) error {
+	if w.written {
+		if _, err := io.WriteString(w.w, ","); err != nil {
+			return err
+		}
+	}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.w.Write(b); err != nil {
+		return err
+	}
+
+	w.written = true
+
+	return nil
+}
+
+func (w *jsonEventWriter) close() error {
+	_, err := io.WriteString(w.w, "]")
+	return err
+}
+
+// exportResponses writes every stored score and feedback message with a timestamp in the range [from, to] to the
+// given eventWriter. A to of 0 means that there is no upper bound. Responses are read from the KV store a page at a
+// time. When surveys are anonymous, responses stored before that was enabled are anonymized as they're exported, and
+// scores are withheld for any survey that doesn't have enough of them to keep it anonymous like in getSurveyResults.
+func (p *Plugin) exportResponses(writer eventWriter, from, to int64) *model.AppError {
+	config := p.getConfiguration()
+
+	var scoreCounts map[string]int
+	if config.AnonymousSurveys {
+		var err *model.AppError
+		if scoreCounts, err = p.countScores(); err != nil {
+			return err
+		}
+	}
+
+	return p.forEachKey(getKeyPrefix(RESPONSE_KEY), func(key string) *model.AppError {
+		var response *surveyResponse
+		if err := p.KVGet(key, &response); err != nil {
+			return err
+		}
+
+		if response == nil {
+			return nil
+		}
+
+		withheld := false
+		if config.AnonymousSurveys {
+			response = p.anonymize(response)
+			withheld = scoreCounts[response.getSurveyID()] < config.getMinAnonymousGroupSize()
+		}
+
+		for _, event := range getExportedEvents(response) {
+			if event.Timestamp < from || (to != 0 && event.Timestamp > to) {
+				continue
+			}
+
+			if withheld {
+				if event.Event == NPS_SCORE {
+					continue
+				}
+
+				event.ScoreBucket = ""
+			}
+
+			if err := writer.write(event); err != nil {
+				return &model.AppError{Message: err.Error()}
+			}
+		}
+
+		return nil
+	})
+}
+
+// countScores returns the number of users who have given a score to each survey keyed by survey ID.
+func (p *Plugin) countScores() (map[string]int, *model.AppError) {
+	counts := make(map[string]int)
+
+	err := p.forEachKey(getKeyPrefix(RESPONSE_KEY), func(key string) *model.AppError {
+		var response *surveyResponse
+		if err := p.KVGet(key, &response); err != nil {
+			return err
+		}
+
+		if response != nil && response.hasScore() {
+			counts[response.getSurveyID()] += 1
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+func (p *Plugin) exportResults(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format == "" {
+		format = EXPORT_FORMAT_CSV
+	}
+
+	from, err := parseTimestampParam(query.Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid from parameter", http.StatusBadRequest)
+		return
+	}
+
+	to, err := parseTimestampParam(query.Get("to"))
+	if err != nil {
+		http.Error(w, "Invalid to parameter", http.StatusBadRequest)
+		return
+	}
+
+	var writer eventWriter
+
+	switch format {
+	case EXPORT_FORMAT_CSV:
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=nps.csv")
+
+		writer, err = newCSVEventWriter(w)
+	case EXPORT_FORMAT_JSON:
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", "attachment; filename=nps.json")
+
+		writer, err = newJSONEventWriter(w)
+	default:
+		http.Error(w, "Invalid format parameter", http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		p.API.LogError("Failed to start survey export", "err", err.Error())
+		return
+	}
+
+	if appErr := p.exportResponses(writer, from, to); appErr != nil {
+		// The response has already started, so all we can do is stop writing it
+		p.API.LogError("Failed to export survey responses", "err", appErr)
+		return
+	}
+
+	if err := writer.close(); err != nil {
+		p.API.LogError("Failed to finish survey export", "err", err.Error())
+	}
+}
+
+// parseTimestampParam parses a query parameter containing a timestamp in milliseconds. An empty parameter is treated as
+// 0.
+func parseTimestampParam(param string) (int64, error) {
+	if param == "" {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(param, 10, 64)
+}
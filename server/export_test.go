@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetExportedEvents(t *testing.T) {
+	userID := model.NewId()
+	scoreAt := toDate(2019, time.June, 1)
+	feedbackAt := toDate(2019, time.June, 2)
+
+	response := &surveyResponse{
+		UserID:        userID,
+		ServerVersion: "5.10.0",
+		UserRole:      "team_admin",
+		UserCreateAt:  1234,
+		LicenseSKU:    "e20",
+		Score:         0,
+		ScoreAt:       scoreAt,
+		Feedback: []*feedbackEntry{
+			{
+				Message:  "Feedback",
+				CreateAt: feedbackAt,
+			},
+		},
+	}
+
+	score := 0
+	revision := 0
+	redactions := 0
+
+	assert.Equal(t, []*exportedEvent{
+		{
+			Event:         NPS_SCORE,
+			UserID:        userID,
+			UserRole:      "team_admin",
+			UserCreateAt:  1234,
+			LicenseSKU:    "e20",
+			ServerVersion: "5.10.0",
+			Timestamp:     toMillis(scoreAt),
+			Score:         &score,
+			ScoreBucket:   SCORE_BUCKET_DETRACTOR,
+			Revision:      &revision,
+		},
+		{
+			Event:         NPS_FEEDBACK,
+			UserID:        userID,
+			UserRole:      "team_admin",
+			UserCreateAt:  1234,
+			LicenseSKU:    "e20",
+			ServerVersion: "5.10.0",
+			Timestamp:     toMillis(feedbackAt),
+			ScoreBucket:   SCORE_BUCKET_DETRACTOR,
+			Feedback:      "Feedback",
+			Redactions:    &redactions,
+		},
+	}, getExportedEvents(response))
+
+	t.Run("should not include a score that hasn't been given", func(t *testing.T) {
+		events := getExportedEvents(&surveyResponse{
+			Feedback: []*feedbackEntry{{Message: "Feedback"}},
+		})
+
+		require.Len(t, events, 1)
+		assert.Equal(t, NPS_FEEDBACK, events[0].Event)
+		assert.Equal(t, "", events[0].ScoreBucket)
+	})
+
+	t.Run("should include earlier scores as previous revisions", func(t *testing.T) {
+		events := getExportedEvents(&surveyResponse{
+			Score:   9,
+			ScoreAt: feedbackAt,
+			ScoreHistory: []*scoreRevision{
+				{Score: 4, ScoreAt: scoreAt},
+			},
+		})
+
+		require.Len(t, events, 2)
+		assert.Equal(t, NPS_SCORE, events[0].Event)
+		assert.Equal(t, 4, *events[0].Score)
+		assert.Equal(t, SCORE_BUCKET_DETRACTOR, events[0].ScoreBucket)
+		assert.Equal(t, 0, *events[0].Revision)
+		assert.Equal(t, toMillis(scoreAt), events[0].Timestamp)
+		assert.Equal(t, 9, *events[1].Score)
+		assert.Equal(t, SCORE_BUCKET_PROMOTER, events[1].ScoreBucket)
+		assert.Equal(t, 1, *events[1].Revision)
+	})
+
+	t.Run("should include answers to other questions ordered by question ID", func(t *testing.T) {
+		events := getExportedEvents(&surveyResponse{
+			Answers: map[string]*surveyAnswer{
+				"team":   {Value: "Engineering", AnswerAt: feedbackAt, RedactionCount: 1},
+				"remote": {Value: ANSWER_YES, AnswerAt: scoreAt},
+			},
+		})
+
+		require.Len(t, events, 2)
+		assert.Equal(t, NPS_ANSWER, events[0].Event)
+		assert.Equal(t, "remote", events[0].QuestionID)
+		assert.Equal(t, ANSWER_YES, events[0].Answer)
+		assert.Equal(t, toMillis(scoreAt), events[0].Timestamp)
+		assert.Equal(t, "team", events[1].QuestionID)
+		assert.Equal(t, "Engineering", events[1].Answer)
+		assert.Equal(t, 1, *events[1].Redactions)
+	})
+}
+
+func TestExportResults(t *testing.T) {
+	userID := model.NewId()
+	scoreAt := toDate(2019, time.June, 1)
+	feedbackAt := toDate(2019, time.June, 2)
+	responseKey := fmt.Sprintf(RESPONSE_KEY, "5.10.0", userID)
+
+	makeAPI := func() *plugintest.API {
+		api := &plugintest.API{}
+		api.On("KVList", 0, 100).Return([]string{
+			fmt.Sprintf(USER_SURVEY_KEY, userID),
+			responseKey,
+		}, nil)
+		api.On("KVGet", responseKey).Return(mustMarshalJSON(&surveyResponse{
+			UserID:        userID,
+			ServerVersion: "5.10.0",
+			UserRole:      "user",
+			UserCreateAt:  1234,
+			Score:         9,
+			ScoreAt:       scoreAt,
+			Feedback: []*feedbackEntry{
+				{
+					Message:  "Great, \"really\"",
+					CreateAt: feedbackAt,
+				},
+			},
+		}), nil)
+		return api
+	}
+
+	t.Run("should export CSV by default", func(t *testing.T) {
+		api := makeAPI()
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/api/v1/export", nil)
+
+		p.exportResults(recorder, request)
+
+		result := recorder.Result()
+		body, _ := ioutil.ReadAll(result.Body)
+
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, "text/csv", result.Header.Get("Content-Type"))
+		assert.Equal(t, fmt.Sprintf(`event,user_actual_id,user_role,user_create_at,license_sku,server_version,timestamp,score,score_bucket,revision,feedback,redaction_count,question_id,answer
+nps_score,%[1]s,user,1234,,5.10.0,%[2]d,9,promoter,0,,,,
+nps_feedback,%[1]s,user,1234,,5.10.0,%[3]d,,promoter,,"Great, ""really""",0,,
+`, userID, toMillis(scoreAt), toMillis(feedbackAt)), string(body))
+	})
+
+	t.Run("should export JSON", func(t *testing.T) {
+		api := makeAPI()
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/api/v1/export?format=json", nil)
+
+		p.exportResults(recorder, request)
+
+		result := recorder.Result()
+		body, _ := ioutil.ReadAll(result.Body)
+
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, "application/json", result.Header.Get("Content-Type"))
+
+		var events []*exportedEvent
+		require.Nil(t, json.Unmarshal(body, &events))
+		require.Len(t, events, 2)
+		assert.Equal(t, NPS_SCORE, events[0].Event)
+		assert.Equal(t, 9, *events[0].Score)
+		assert.Equal(t, SCORE_BUCKET_PROMOTER, events[0].ScoreBucket)
+		assert.Equal(t, NPS_FEEDBACK, events[1].Event)
+		assert.Equal(t, "Great, \"really\"", events[1].Feedback)
+	})
+
+	t.Run("should anonymize responses when surveys are anonymous", func(t *testing.T) {
+		api := makeAPI()
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			configuration: &configuration{AnonymousSurveys: true, MinAnonymousGroupSize: 1},
+			anonymousSalt: []byte("salt"),
+		}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/api/v1/export?format=json", nil)
+
+		p.exportResults(recorder, request)
+
+		body, _ := ioutil.ReadAll(recorder.Result().Body)
+		assert.NotContains(t, string(body), userID)
+
+		var events []*exportedEvent
+		require.Nil(t, json.Unmarshal(body, &events))
+		require.Len(t, events, 2)
+		for _, event := range events {
+			assert.Equal(t, p.getAnonymousID(userID), event.UserID)
+			assert.Equal(t, "", event.UserRole)
+			assert.Equal(t, int64(0), event.UserCreateAt)
+		}
+	})
+
+	t.Run("should withhold scores for a survey without enough of them to keep it anonymous", func(t *testing.T) {
+		api := makeAPI()
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			configuration: &configuration{AnonymousSurveys: true, MinAnonymousGroupSize: 2},
+			anonymousSalt: []byte("salt"),
+		}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/api/v1/export?format=json", nil)
+
+		p.exportResults(recorder, request)
+
+		body, _ := ioutil.ReadAll(recorder.Result().Body)
+
+		var events []*exportedEvent
+		require.Nil(t, json.Unmarshal(body, &events))
+		require.Len(t, events, 1)
+		assert.Equal(t, NPS_FEEDBACK, events[0].Event)
+		assert.Equal(t, "", events[0].ScoreBucket)
+	})
+
+	t.Run("should only export events within the requested time range", func(t *testing.T) {
+		api := makeAPI()
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/export?format=json&from=%d&to=%d", toMillis(feedbackAt), toMillis(feedbackAt.Add(time.Hour))), nil)
+
+		p.exportResults(recorder, request)
+
+		body, _ := ioutil.ReadAll(recorder.Result().Body)
+
+		var events []*exportedEvent
+		require.Nil(t, json.Unmarshal(body, &events))
+		require.Len(t, events, 1)
+		assert.Equal(t, NPS_FEEDBACK, events[0].Event)
+	})
+
+	t.Run("should export an empty JSON array when nothing is stored", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVList", 0, 100).Return([]string{}, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/api/v1/export?format=json", nil)
+
+		p.exportResults(recorder, request)
+
+		body, _ := ioutil.ReadAll(recorder.Result().Body)
+
+		assert.Equal(t, "[]", string(body))
+	})
+
+	for _, query := range []string{"format=xml", "from=yesterday", "to=tomorrow"} {
+		t.Run("should return bad request for invalid parameters "+query, func(t *testing.T) {
+			p := &Plugin{}
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/api/v1/export?"+query, nil)
+
+			p.exportResults(recorder, request)
+
+			assert.Equal(t, http.StatusBadRequest, recorder.Result().StatusCode)
+		})
+	}
+}
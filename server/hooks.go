@@ -1,6 +1,9 @@
 package main
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/mattermost/mattermost-server/model"
 	"github.com/mattermost/mattermost-server/plugin"
 )
@@ -19,7 +22,7 @@ func (p *Plugin) ChannelHasBeenCreated(c *plugin.Context, channel *model.Channel
 }
 
 func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
-	if !p.canSendDiagnostics() {
+	if !p.canCollectResponses() {
 		return
 	}
 
@@ -55,23 +58,74 @@ func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
 		return
 	}
 
-	// Send the feedback to Segment
-	if err := p.sendFeedback(post.Message, post.UserId, post.CreateAt); err != nil {
-		p.API.LogError("Failed to send Surveybot feedback to Segment", "err", err.Error())
+	createAt := time.Unix(0, post.CreateAt*int64(time.Millisecond)).UTC()
+
+	// Opt the user out of or back into surveys instead of treating their reply as feedback
+	if p.handleSubscriptionCommand(user, post.Message, createAt) {
+		return
+	}
+
+	// Only treat the message as feedback if it's about the survey that the user last answered
+	var userSurvey *userSurveyState
+	if err := p.KVGet(fmt.Sprintf(USER_SURVEY_KEY, user.Id), &userSurvey); err != nil {
+		p.API.LogError("Unable to get survey state for Surveybot feedback", "err", err)
+		return
+	}
+
+	if !isSurveyFeedback(userSurvey, post, createAt, p.getConfiguration().getFeedbackWindow()) {
+		p.API.LogDebug("Ignoring message to Surveybot that isn't about a survey", "user_id", user.Id)
+		return
+	}
+
+	// Remove any personal information before the feedback is stored or sent anywhere
+	feedback, redactionCount := p.redactFeedback(post.Message)
+
+	// Send the feedback to the configured sink
+	if err := p.sendFeedback(feedback, redactionCount, post.UserId, post.CreateAt); err != nil {
+		p.API.LogError("Failed to send Surveybot feedback", "err", err.Error())
 
 		// Still appear to the end user as if their feedback was actually sent
 	}
 
-	// Respond to the feedback
+	isFirstFeedback, err := p.storeFeedback(user, feedback, redactionCount, createAt)
+	if err != nil {
+		p.API.LogWarn("Failed to store Surveybot feedback", "err", err)
+
+		// Thank the user anyway since we can't tell if they've already been thanked
+		isFirstFeedback = true
+	}
+
+	if !isFirstFeedback {
+		// The user has already been thanked for their feedback on this survey
+		return
+	}
+
+	// Respond to the feedback in the same thread that it was given in
 	_, appErr = p.CreateBotDMPost(post.UserId, &model.Post{
-		Message: feedbackResponseBody,
+		Message: p.getUserTranslateFunc(user)(feedbackResponseBody),
 		Type:    "custom_nps_thanks",
+		RootId:  post.RootId,
 	})
 	if appErr != nil {
 		p.API.LogError("Failed to respond to Surveybot feedback")
 	}
 }
 
+// isSurveyFeedback returns whether or not a message sent to Surveybot is feedback on the user's last survey. Messages
+// are treated as feedback if they reply to the feedback request or if they're sent shortly after answering the survey.
+func isSurveyFeedback(userSurvey *userSurveyState, post *model.Post, createAt time.Time, window time.Duration) bool {
+	if userSurvey == nil || userSurvey.AnsweredAt.IsZero() {
+		// The user hasn't answered a survey
+		return false
+	}
+
+	if post.RootId != "" && post.RootId == userSurvey.FeedbackPostId {
+		return true
+	}
+
+	return !createAt.Before(userSurvey.AnsweredAt) && createAt.Sub(userSurvey.AnsweredAt) <= window
+}
+
 func (p *Plugin) UserHasLoggedIn(c *plugin.Context, user *model.User) {
 	if err := p.checkForDMs(user.Id); err != nil {
 		p.API.LogError("Failed to check for user notifications on login", "user_id", user.Id, "err", err)
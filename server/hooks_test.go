@@ -3,9 +3,11 @@ package main
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/mattermost/mattermost-server/model"
 	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -43,6 +45,8 @@ func TestMessageHasBeenPosted(t *testing.T) {
 	botChannelID := model.NewId()
 	botUserID := model.NewId()
 	userID := model.NewId()
+	serverVersion := "5.10.0"
+	postCreateAt := toDate(2019, time.June, 1)
 
 	t.Run("should send feedback to segment and respond to user", func(t *testing.T) {
 		api := &plugintest.API{}
@@ -55,7 +59,26 @@ func TestMessageHasBeenPosted(t *testing.T) {
 			Type: model.CHANNEL_DIRECT,
 			Name: fmt.Sprintf("%s__%s", botUserID, userID),
 		}, nil)
-		api.On("GetUser", userID).Return(&model.User{}, nil)
+		api.On("GetUser", userID).Return(&model.User{Id: userID}, nil)
+		api.On("GetTeamMembersForUser", userID, 0, 50).Return([]*model.TeamMember{}, nil)
+		api.On("GetLicense").Return(nil)
+		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, userID)).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			AnsweredAt:    postCreateAt.Add(-time.Hour),
+		}), nil)
+		api.On("KVGet", fmt.Sprintf(RESPONSE_KEY, serverVersion, userID)).Return(nil, nil)
+		api.On("KVSet", fmt.Sprintf(RESPONSE_KEY, serverVersion, userID), mustMarshalJSON(&surveyResponse{
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			UserRole:      "user",
+			CreateAt:      postCreateAt,
+			Feedback: []*feedbackEntry{
+				{
+					Message:  "Feedback",
+					CreateAt: postCreateAt,
+				},
+			},
+		})).Return(nil)
 		api.On("GetDirectChannel", userID, botUserID).Return(&model.Channel{
 			Id: botChannelID,
 		}, nil)
@@ -65,12 +88,293 @@ func TestMessageHasBeenPosted(t *testing.T) {
 		p := &Plugin{
 			blockSegmentEvents: true,
 			botUserID:          botUserID,
+			serverVersion:      serverVersion,
 		}
 		p.SetAPI(api)
 
 		p.MessageHasBeenPosted(nil, &model.Post{
 			ChannelId: botChannelID,
 			UserId:    userID,
+			Message:   "Feedback",
+			CreateAt:  postCreateAt.UnixNano() / int64(time.Millisecond),
+		})
+	})
+
+	t.Run("should redact personal information from feedback before storing it", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetConfig").Return(&model.Config{
+			LogSettings: model.LogSettings{
+				EnableDiagnostics: model.NewBool(true),
+			},
+		})
+		api.On("GetChannel", botChannelID).Return(&model.Channel{
+			Type: model.CHANNEL_DIRECT,
+			Name: fmt.Sprintf("%s__%s", botUserID, userID),
+		}, nil)
+		api.On("GetUser", userID).Return(&model.User{Id: userID}, nil)
+		api.On("GetTeamMembersForUser", userID, 0, 50).Return([]*model.TeamMember{}, nil)
+		api.On("GetLicense").Return(nil)
+		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, userID)).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			AnsweredAt:    postCreateAt.Add(-time.Hour),
+		}), nil)
+		api.On("KVGet", fmt.Sprintf(RESPONSE_KEY, serverVersion, userID)).Return(nil, nil)
+		api.On("KVSet", fmt.Sprintf(RESPONSE_KEY, serverVersion, userID), mustMarshalJSON(&surveyResponse{
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			UserRole:      "user",
+			CreateAt:      postCreateAt,
+			Feedback: []*feedbackEntry{
+				{
+					Message:        "Email me at [REDACTED EMAIL] about ticket [REDACTED]",
+					CreateAt:       postCreateAt,
+					RedactionCount: 2,
+				},
+			},
+		})).Return(nil)
+		api.On("GetDirectChannel", userID, botUserID).Return(&model.Channel{
+			Id: botChannelID,
+		}, nil)
+		api.On("CreatePost", mock.Anything).Return(&model.Post{}, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			blockSegmentEvents: true,
+			botUserID:          botUserID,
+			configuration: &configuration{
+				FeedbackRedactionDetectors: "email",
+				FeedbackRedactionPatterns:  `ACME-\d+`,
+			},
+			serverVersion: serverVersion,
+		}
+		p.SetAPI(api)
+
+		p.MessageHasBeenPosted(nil, &model.Post{
+			ChannelId: botChannelID,
+			UserId:    userID,
+			Message:   "Email me at someone@example.com about ticket ACME-1234",
+			CreateAt:  postCreateAt.UnixNano() / int64(time.Millisecond),
+		})
+	})
+
+	t.Run("should opt the user out of surveys instead of sending feedback", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetConfig").Return(&model.Config{
+			LogSettings: model.LogSettings{
+				EnableDiagnostics: model.NewBool(true),
+			},
+		})
+		api.On("GetChannel", botChannelID).Return(&model.Channel{
+			Type: model.CHANNEL_DIRECT,
+			Name: fmt.Sprintf("%s__%s", botUserID, userID),
+		}, nil)
+		api.On("GetUser", userID).Return(&model.User{Id: userID}, nil)
+		api.On("KVGet", fmt.Sprintf(USER_PREFERENCES_KEY, userID)).Return(nil, nil)
+		api.On("KVSet", fmt.Sprintf(USER_PREFERENCES_KEY, userID), mustMarshalJSON(&userPreferences{
+			SurveysDisabled: true,
+			UpdateAt:        postCreateAt,
+		})).Return(nil)
+		api.On("GetDirectChannel", userID, botUserID).Return(&model.Channel{
+			Id: botChannelID,
+		}, nil)
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.Type == "custom_nps_unsubscribed"
+		})).Return(&model.Post{}, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			blockSegmentEvents: true,
+			botUserID:          botUserID,
+			serverVersion:      serverVersion,
+		}
+		p.SetAPI(api)
+
+		p.MessageHasBeenPosted(nil, &model.Post{
+			ChannelId: botChannelID,
+			UserId:    userID,
+			Message:   " Stop! ",
+			CreateAt:  postCreateAt.UnixNano() / int64(time.Millisecond),
+		})
+	})
+
+	t.Run("should still respond to user if unable to store feedback", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetConfig").Return(&model.Config{
+			LogSettings: model.LogSettings{
+				EnableDiagnostics: model.NewBool(true),
+			},
+		})
+		api.On("GetChannel", botChannelID).Return(&model.Channel{
+			Type: model.CHANNEL_DIRECT,
+			Name: fmt.Sprintf("%s__%s", botUserID, userID),
+		}, nil)
+		api.On("GetUser", userID).Return(&model.User{Id: userID}, nil)
+		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, userID)).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			AnsweredAt:    postCreateAt.Add(-time.Hour),
+		}), nil)
+		api.On("KVGet", fmt.Sprintf(RESPONSE_KEY, serverVersion, userID)).Return(nil, &model.AppError{})
+		api.On("LogWarn", mock.Anything, "err", mock.Anything)
+		api.On("GetDirectChannel", userID, botUserID).Return(&model.Channel{
+			Id: botChannelID,
+		}, nil)
+		api.On("CreatePost", mock.Anything).Return(&model.Post{}, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			blockSegmentEvents: true,
+			botUserID:          botUserID,
+			serverVersion:      serverVersion,
+		}
+		p.SetAPI(api)
+
+		p.MessageHasBeenPosted(nil, &model.Post{
+			ChannelId: botChannelID,
+			UserId:    userID,
+			Message:   "Feedback",
+			CreateAt:  postCreateAt.UnixNano() / int64(time.Millisecond),
+		})
+	})
+
+	t.Run("should only thank the user once per survey", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetConfig").Return(&model.Config{
+			LogSettings: model.LogSettings{
+				EnableDiagnostics: model.NewBool(true),
+			},
+		})
+		api.On("GetChannel", botChannelID).Return(&model.Channel{
+			Type: model.CHANNEL_DIRECT,
+			Name: fmt.Sprintf("%s__%s", botUserID, userID),
+		}, nil)
+		api.On("GetUser", userID).Return(&model.User{Id: userID}, nil)
+		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, userID)).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			AnsweredAt:    postCreateAt.Add(-time.Hour),
+		}), nil)
+		api.On("KVGet", fmt.Sprintf(RESPONSE_KEY, serverVersion, userID)).Return(mustMarshalJSON(&surveyResponse{
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			UserRole:      "user",
+			CreateAt:      postCreateAt.Add(-time.Hour),
+			Feedback: []*feedbackEntry{
+				{
+					Message:  "First",
+					CreateAt: postCreateAt.Add(-time.Minute),
+				},
+			},
+		}), nil)
+		api.On("KVSet", fmt.Sprintf(RESPONSE_KEY, serverVersion, userID), mustMarshalJSON(&surveyResponse{
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			UserRole:      "user",
+			CreateAt:      postCreateAt.Add(-time.Hour),
+			Feedback: []*feedbackEntry{
+				{
+					Message:  "First",
+					CreateAt: postCreateAt.Add(-time.Minute),
+				},
+				{
+					Message:  "Second",
+					CreateAt: postCreateAt,
+				},
+			},
+		})).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			blockSegmentEvents: true,
+			botUserID:          botUserID,
+			serverVersion:      serverVersion,
+		}
+		p.SetAPI(api)
+
+		p.MessageHasBeenPosted(nil, &model.Post{
+			ChannelId: botChannelID,
+			UserId:    userID,
+			Message:   "Second",
+			CreateAt:  postCreateAt.UnixNano() / int64(time.Millisecond),
+		})
+	})
+
+	t.Run("should accept a late reply to the feedback request and respond in its thread", func(t *testing.T) {
+		feedbackPostID := model.NewId()
+
+		api := &plugintest.API{}
+		api.On("GetConfig").Return(&model.Config{
+			LogSettings: model.LogSettings{
+				EnableDiagnostics: model.NewBool(true),
+			},
+		})
+		api.On("GetChannel", botChannelID).Return(&model.Channel{
+			Type: model.CHANNEL_DIRECT,
+			Name: fmt.Sprintf("%s__%s", botUserID, userID),
+		}, nil)
+		api.On("GetUser", userID).Return(&model.User{Id: userID}, nil)
+		api.On("GetTeamMembersForUser", userID, 0, 50).Return([]*model.TeamMember{}, nil)
+		api.On("GetLicense").Return(nil)
+		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, userID)).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion:  serverVersion,
+			AnsweredAt:     postCreateAt.Add(-30 * 24 * time.Hour),
+			FeedbackPostId: feedbackPostID,
+		}), nil)
+		api.On("KVGet", fmt.Sprintf(RESPONSE_KEY, serverVersion, userID)).Return(nil, nil)
+		api.On("KVSet", fmt.Sprintf(RESPONSE_KEY, serverVersion, userID), mock.Anything).Return(nil)
+		api.On("GetDirectChannel", userID, botUserID).Return(&model.Channel{
+			Id: botChannelID,
+		}, nil)
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.Type == "custom_nps_thanks" && post.RootId == feedbackPostID
+		})).Return(&model.Post{}, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			blockSegmentEvents: true,
+			botUserID:          botUserID,
+			serverVersion:      serverVersion,
+		}
+		p.SetAPI(api)
+
+		p.MessageHasBeenPosted(nil, &model.Post{
+			ChannelId: botChannelID,
+			UserId:    userID,
+			RootId:    feedbackPostID,
+			Message:   "Feedback",
+			CreateAt:  postCreateAt.UnixNano() / int64(time.Millisecond),
+		})
+	})
+
+	t.Run("should ignore messages that aren't about a survey", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetConfig").Return(&model.Config{
+			LogSettings: model.LogSettings{
+				EnableDiagnostics: model.NewBool(true),
+			},
+		})
+		api.On("GetChannel", botChannelID).Return(&model.Channel{
+			Type: model.CHANNEL_DIRECT,
+			Name: fmt.Sprintf("%s__%s", botUserID, userID),
+		}, nil)
+		api.On("GetUser", userID).Return(&model.User{Id: userID}, nil)
+		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, userID)).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			AnsweredAt:    postCreateAt.Add(-30 * 24 * time.Hour),
+		}), nil)
+		api.On("LogDebug", mock.Anything, "user_id", userID)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			blockSegmentEvents: true,
+			botUserID:          botUserID,
+			serverVersion:      serverVersion,
+		}
+		p.SetAPI(api)
+
+		p.MessageHasBeenPosted(nil, &model.Post{
+			ChannelId: botChannelID,
+			UserId:    userID,
+			Message:   "Hello?",
+			CreateAt:  postCreateAt.UnixNano() / int64(time.Millisecond),
 		})
 	})
 
@@ -213,6 +517,22 @@ func TestMessageHasBeenPosted(t *testing.T) {
 	})
 }
 
+func TestIsSurveyFeedback(t *testing.T) {
+	answeredAt := toDate(2019, time.June, 1)
+	feedbackPostID := model.NewId()
+	userSurvey := &userSurveyState{AnsweredAt: answeredAt, FeedbackPostId: feedbackPostID}
+
+	assert.False(t, isSurveyFeedback(nil, &model.Post{}, answeredAt, time.Hour))
+	assert.False(t, isSurveyFeedback(&userSurveyState{}, &model.Post{}, answeredAt, time.Hour))
+
+	assert.True(t, isSurveyFeedback(userSurvey, &model.Post{}, answeredAt.Add(time.Hour), time.Hour))
+	assert.False(t, isSurveyFeedback(userSurvey, &model.Post{}, answeredAt.Add(2*time.Hour), time.Hour))
+	assert.False(t, isSurveyFeedback(userSurvey, &model.Post{}, answeredAt.Add(-time.Minute), time.Hour))
+
+	assert.True(t, isSurveyFeedback(userSurvey, &model.Post{RootId: feedbackPostID}, answeredAt.Add(48*time.Hour), time.Hour))
+	assert.False(t, isSurveyFeedback(userSurvey, &model.Post{RootId: model.NewId()}, answeredAt.Add(48*time.Hour), time.Hour))
+}
+
 func TestUserHasLoggedIn(t *testing.T) {
 	t.Run("should check for DMs when a user logs in", func(t *testing.T) {
 		t.SkipNow()
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/nicksnyder/go-i18n/i18n/bundle"
+	"github.com/nicksnyder/go-i18n/i18n/language"
+	"github.com/nicksnyder/go-i18n/i18n/translation"
+	"github.com/pkg/errors"
+)
+
+// DEFAULT_LOCALE is the locale of defaultTranslations. It's used for any user whose locale hasn't been translated.
+const DEFAULT_LOCALE = "en"
+
+// translateFunc returns the message with the given ID in a user's locale. The data is used to fill in the message's
+// template.
+type translateFunc func(messageID string, data ...interface{}) string
+
+// englishTranslations is used until the plugin has loaded its translation files.
+var englishTranslations = newTranslationBundle()
+
+// newTranslationBundle returns a bundle containing only defaultTranslations.
+func newTranslationBundle() *bundle.Bundle {
+	b := bundle.New()
+
+	var translations []translation.Translation
+	for id, text := range defaultTranslations {
+		t, err := translation.NewTranslation(map[string]interface{}{
+			"id":          id,
+			"translation": text,
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		translations = append(translations, t)
+	}
+
+	b.AddTranslation(language.MustParse(DEFAULT_LOCALE)[0], translations...)
+
+	return b
+}
+
+// loadTranslations loads the translation files bundled with the plugin. Each file is named after the locale that it
+// contains, such as "es.json" or "pt-BR.json".
+func (p *Plugin) loadTranslations() error {
+	bundlePath, err := p.API.GetBundlePath()
+	if err != nil {
+		return err
+	}
+
+	filenames, err := filepath.Glob(filepath.Join(bundlePath, "assets", "i18n", "*.json"))
+	if err != nil {
+		return err
+	}
+
+	b := newTranslationBundle()
+
+	for _, filename := range filenames {
+		data, err := p.readFile(filename)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read translation file %s", filename)
+		}
+
+		if err := b.ParseTranslationFileBytes(filename, data); err != nil {
+			return errors.Wrapf(err, "failed to parse translation file %s", filename)
+		}
+	}
+
+	p.translations = b
+
+	return nil
+}
+
+// getTranslateFunc returns a translateFunc for the given locale. Messages that haven't been translated into that
+// locale are returned in English.
+func (p *Plugin) getTranslateFunc(locale string) translateFunc {
+	b := p.translations
+	if b == nil {
+		b = englishTranslations
+	}
+
+	english := b.MustTfunc(DEFAULT_LOCALE)
+
+	if locale == "" || locale == DEFAULT_LOCALE {
+		return translateFunc(english)
+	}
+
+	tfunc, err := b.Tfunc(locale, DEFAULT_LOCALE)
+	if err != nil {
+		return translateFunc(english)
+	}
+
+	return func(messageID string, data ...interface{}) string {
+		if message := tfunc(messageID, data...); message != messageID {
+			return message
+		}
+
+		return english(messageID, data...)
+	}
+}
+
+// getUserTranslateFunc returns a translateFunc for the user's locale. Any custom message templates configured by an
+// admin are used in place of the translated messages.
+func (p *Plugin) getUserTranslateFunc(user *model.User) translateFunc {
+	return p.applyMessageTemplates(user, p.getTranslateFunc(user.Locale))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/nicksnyder/go-i18n/i18n/language"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTranslations(t *testing.T) {
+	t.Run("should load the bundled translation files", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("GetBundlePath").Return("..", nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{readFile: ioutil.ReadFile}
+		p.SetAPI(api)
+
+		err := p.loadTranslations()
+
+		require.Nil(t, err)
+		assert.Equal(t, "Sí", p.getTranslateFunc("es")(surveyAnswerYes))
+	})
+
+	t.Run("should translate every message in each translation file", func(t *testing.T) {
+		filenames, err := filepath.Glob(filepath.Join("..", "assets", "i18n", "*.json"))
+		require.Nil(t, err)
+		require.NotEmpty(t, filenames)
+
+		for _, filename := range filenames {
+			b := newTranslationBundle()
+			require.Nil(t, b.LoadTranslationFile(filename))
+
+			locale := language.MustParse(strings.TrimSuffix(filepath.Base(filename), ".json"))[0]
+			for id := range defaultTranslations {
+				assert.Contains(t, b.LanguageTranslationIDs(locale.Tag), id, "%s is missing from %s", id, filename)
+			}
+		}
+	})
+
+	t.Run("should return an error when unable to read a translation file", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("GetBundlePath").Return("..", nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			readFile: func(path string) ([]byte, error) {
+				return nil, errors.New("failed to read file")
+			},
+		}
+		p.SetAPI(api)
+
+		err := p.loadTranslations()
+
+		assert.NotNil(t, err)
+		assert.Nil(t, p.translations)
+	})
+
+	t.Run("should return an error when GetBundlePath fails", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("GetBundlePath").Return("", &model.AppError{})
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		err := p.loadTranslations()
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGetTranslateFunc(t *testing.T) {
+	p := &Plugin{translations: newTranslationBundle()}
+	p.translations.ParseTranslationFileBytes("es.json", []byte(`[
+		{"id": "survey.body", "translation": "¡Hola @{{.Username}}!"}
+	]`))
+
+	t.Run("should translate messages into the given locale", func(t *testing.T) {
+		T := p.getTranslateFunc("es")
+
+		assert.Equal(t, "¡Hola @alice!", T(surveyBody, map[string]interface{}{"Username": "alice"}))
+	})
+
+	t.Run("should use English for messages that haven't been translated", func(t *testing.T) {
+		T := p.getTranslateFunc("es")
+
+		assert.Equal(t, "Survey Question", T(answerDialogTitle))
+	})
+
+	t.Run("should use English for locales that haven't been translated", func(t *testing.T) {
+		T := p.getTranslateFunc("ko")
+
+		assert.Equal(t, ":wave: Hey @alice! Please take a few moments to help us improve your experience with Mattermost.", T(surveyBody, map[string]interface{}{"Username": "alice"}))
+	})
+
+	t.Run("should use English before translations have been loaded", func(t *testing.T) {
+		T := (&Plugin{}).getTranslateFunc("es")
+
+		assert.Equal(t, "Survey Question", T(answerDialogTitle))
+	})
+
+	t.Run("should use the user's locale", func(t *testing.T) {
+		T := p.getUserTranslateFunc(&model.User{Username: "alice", Locale: "es"})
+
+		assert.Equal(t, "¡Hola @alice!", T(surveyBody, map[string]interface{}{"Username": "alice"}))
+	})
+}
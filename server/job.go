@@ -0,0 +1,50 @@
+package main
+
+import (
+	"time"
+)
+
+// runJob calls job every interval until stop is closed. It should be run on its own goroutine.
+func (p *Plugin) runJob(interval time.Duration, stop <-chan struct{}, job func(now time.Time)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			job(p.now().UTC())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// startJobs starts any background jobs used by the plugin.
+func (p *Plugin) startJobs() {
+	stop := make(chan struct{})
+	p.stopJobs = stop
+
+	go p.runJob(RECURRING_SURVEY_CHECK_INTERVAL, stop, func(now time.Time) {
+		p.checkForRecurringSurvey(now)
+	})
+
+	go p.runJob(DM_DELIVERY_INTERVAL, stop, p.deliverScheduledDMs)
+
+	go p.runJob(RESULTS_DIGEST_CHECK_INTERVAL, stop, func(now time.Time) {
+		p.checkForResultsDigest(now)
+	})
+
+	go p.runJob(DATA_CLEANUP_INTERVAL, stop, p.cleanUpData)
+
+	go p.runJob(WEBHOOK_DELIVERY_INTERVAL, stop, p.deliverWebhookEvents)
+
+	go p.trackQueuedEvents(stop)
+}
+
+// stopAllJobs stops the jobs started by startJobs.
+func (p *Plugin) stopAllJobs() {
+	if p.stopJobs != nil {
+		close(p.stopJobs)
+		p.stopJobs = nil
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunJob(t *testing.T) {
+	now := toDate(2019, time.June, 1)
+
+	p := &Plugin{
+		now: func() time.Time {
+			return now
+		},
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	calls := make(chan time.Time, 10)
+
+	go func() {
+		p.runJob(time.Millisecond, stop, func(now time.Time) {
+			calls <- now
+		})
+		close(done)
+	}()
+
+	select {
+	case called := <-calls:
+		assert.Equal(t, now, called)
+	case <-time.After(time.Second):
+		t.Fatal("job was never run")
+	}
+
+	close(stop)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("job did not stop")
+	}
+}
+
+func TestStopAllJobs(t *testing.T) {
+	p := &Plugin{}
+
+	p.startJobs()
+	assert.NotNil(t, p.stopJobs)
+
+	p.stopAllJobs()
+	assert.Nil(t, p.stopJobs)
+
+	// Stopping again should do nothing
+	p.stopAllJobs()
+}
@@ -12,6 +12,21 @@ const (
 	// LOCK_KEY is used to prevent multiple instances of the plugin from scheduling surveys in parallel.
 	LOCK_KEY = "Lock"
 
+	// DELIVERY_LOCK_KEY is used to prevent multiple instances of the plugin from running deliverScheduledDMs in
+	// parallel.
+	DELIVERY_LOCK_KEY = "DeliveryLock"
+
+	// DATA_CLEANUP_LOCK_KEY is used to prevent multiple instances of the plugin from running cleanUpData in parallel.
+	DATA_CLEANUP_LOCK_KEY = "DataCleanupLock"
+
+	// WEBHOOK_DELIVERY_LOCK_KEY is used to prevent multiple instances of the plugin from running deliverWebhookEvents
+	// in parallel.
+	WEBHOOK_DELIVERY_LOCK_KEY = "WebhookDeliveryLock"
+
+	// RESULTS_DIGEST_LOCK_KEY is used to prevent multiple instances of the plugin from running checkForResultsDigest in
+	// parallel.
+	RESULTS_DIGEST_LOCK_KEY = "ResultsDigestLock"
+
 	// USER_LOCK_KEY is used to prevent multiple instances of the plugin from responding to a single user's requests
 	// in parallel.
 	USER_LOCK_KEY = "UserLock-%s"
@@ -19,8 +34,17 @@ const (
 	// LOCK_EXPIRATION is how long a lock can be held before it will be automatically released the next time that an
 	// instance of the plugin is started up.
 	LOCK_EXPIRATION = time.Hour
+
+	// LOCK_WAIT_TIMEOUT is how long waitForLock tries to acquire a lock that's held by someone else before giving up.
+	LOCK_WAIT_TIMEOUT = 5 * time.Second
+
+	// LOCK_RETRY_INTERVAL is how long waitForLock waits between attempts to acquire a lock.
+	LOCK_RETRY_INTERVAL = 100 * time.Millisecond
 )
 
+// lockKeys contains every lock that isn't specific to a user.
+var lockKeys = []string{LOCK_KEY, DELIVERY_LOCK_KEY, DATA_CLEANUP_LOCK_KEY, WEBHOOK_DELIVERY_LOCK_KEY, RESULTS_DIGEST_LOCK_KEY}
+
 var userLockPattern = regexp.MustCompile("^UserLock-.{26}$")
 
 func (p *Plugin) tryLock(key string, now time.Time) (bool, *model.AppError) {
@@ -32,6 +56,19 @@ func (p *Plugin) tryLock(key string, now time.Time) (bool, *model.AppError) {
 	return p.API.KVCompareAndSet(key, nil, b)
 }
 
+// waitForLock acquires the lock, waiting up to LOCK_WAIT_TIMEOUT for whoever holds it to release it. Returns false if
+// it's still held after that.
+func (p *Plugin) waitForLock(key string, now time.Time) (bool, *model.AppError) {
+	for waited := time.Duration(0); ; waited += LOCK_RETRY_INTERVAL {
+		locked, err := p.tryLock(key, now)
+		if err != nil || locked || waited >= LOCK_WAIT_TIMEOUT {
+			return locked, err
+		}
+
+		time.Sleep(LOCK_RETRY_INTERVAL)
+	}
+}
+
 func (p *Plugin) unlock(key string) *model.AppError {
 	return p.API.KVDelete(key)
 }
@@ -49,7 +86,7 @@ func (p *Plugin) clearStaleLocks(now time.Time) *model.AppError {
 		}
 
 		for _, key := range keys {
-			if key != LOCK_KEY && !userLockPattern.MatchString(key) {
+			if !containsString(lockKeys, key) && !userLockPattern.MatchString(key) {
 				continue
 			}
 
@@ -27,6 +27,39 @@ func TestTryLock(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestWaitForLock(t *testing.T) {
+	now := toDate(2019, time.February, 18)
+
+	t.Run("should retry until the lock is released", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVCompareAndSet", LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(false, nil).Once()
+		api.On("KVCompareAndSet", LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(true, nil).Once()
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		locked, err := p.waitForLock(LOCK_KEY, now)
+
+		assert.Equal(t, true, locked)
+		assert.Nil(t, err)
+	})
+
+	t.Run("should return an error without retrying", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVCompareAndSet", LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(false, &model.AppError{}).Once()
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		locked, err := p.waitForLock(LOCK_KEY, now)
+
+		assert.Equal(t, false, locked)
+		assert.NotNil(t, err)
+	})
+}
+
 func TestUnlock(t *testing.T) {
 	api := &plugintest.API{}
 	api.On("KVDelete", LOCK_KEY).Return(nil)
@@ -95,11 +128,23 @@ func TestClearStaleLocks(t *testing.T) {
 		api := &plugintest.API{}
 		api.On("KVList", 0, 100).Return([]string{
 			LOCK_KEY,
+			DELIVERY_LOCK_KEY,
+			DATA_CLEANUP_LOCK_KEY,
+			RESULTS_DIGEST_LOCK_KEY,
 			userLockKey,
 		}, nil)
 		api.On("KVGet", LOCK_KEY).Return(lockValue, nil)
 		api.On("KVCompareAndSet", LOCK_KEY, lockValue, []byte("releasing")).Return(true, nil)
 		api.On("KVDelete", LOCK_KEY).Return(nil)
+		api.On("KVGet", DELIVERY_LOCK_KEY).Return(lockValue, nil)
+		api.On("KVCompareAndSet", DELIVERY_LOCK_KEY, lockValue, []byte("releasing")).Return(true, nil)
+		api.On("KVDelete", DELIVERY_LOCK_KEY).Return(nil)
+		api.On("KVGet", DATA_CLEANUP_LOCK_KEY).Return(lockValue, nil)
+		api.On("KVCompareAndSet", DATA_CLEANUP_LOCK_KEY, lockValue, []byte("releasing")).Return(true, nil)
+		api.On("KVDelete", DATA_CLEANUP_LOCK_KEY).Return(nil)
+		api.On("KVGet", RESULTS_DIGEST_LOCK_KEY).Return(lockValue, nil)
+		api.On("KVCompareAndSet", RESULTS_DIGEST_LOCK_KEY, lockValue, []byte("releasing")).Return(true, nil)
+		api.On("KVDelete", RESULTS_DIGEST_LOCK_KEY).Return(nil)
 		api.On("KVGet", userLockKey).Return(userLockValue, nil)
 		api.On("KVCompareAndSet", userLockKey, userLockValue, []byte("releasing")).Return(true, nil)
 		api.On("KVDelete", userLockKey).Return(nil)
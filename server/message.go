@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"text/template"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/pkg/errors"
+)
+
+// messageTemplateVariables lists the variables that can be used in the custom template for each message. Username and
+// SiteName are available to every template.
+var messageTemplateVariables = map[string][]string{
+	surveyBody:                   {"Username", "SiteName"},
+	surveyAnsweredRatingBody:     {"Username", "SiteName", "Question", "Answer"},
+	surveyAnsweredChoiceBody:     {"Username", "SiteName", "Question", "Answer"},
+	surveyAnsweredYesNoBody:      {"Username", "SiteName", "Question", "Answer"},
+	surveyAnsweredFreeTextBody:   {"Username", "SiteName", "Question", "Answer"},
+	feedbackRequestBody:          {"Username", "SiteName", "Score"},
+	feedbackRequestDetractorBody: {"Username", "SiteName", "Score"},
+	feedbackRequestPassiveBody:   {"Username", "SiteName", "Score"},
+	feedbackRequestPromoterBody:  {"Username", "SiteName", "Score"},
+	feedbackResponseBody:         {"Username", "SiteName"},
+	adminDMBody:                  {"Username", "SiteName", "SurveyDate", "PluginID"},
+	adminEmailSubject:            {"Username", "SiteName", "SurveyDate", "DaysUntilSurvey"},
+	adminEmailBody:               {"Username", "SiteName", "SiteURL", "SurveyDate", "DaysUntilSurvey", "PluginID"},
+}
+
+// getMessageTemplates returns the custom templates configured for each message, keyed by message ID. Messages without
+// a custom template aren't included.
+func (c *configuration) getMessageTemplates() map[string]string {
+	templates := make(map[string]string)
+
+	add := func(messageID string, text string) {
+		if text != "" {
+			templates[messageID] = text
+		}
+	}
+
+	add(surveyBody, c.SurveyMessageTemplate)
+	add(surveyAnsweredRatingBody, c.SurveyAnsweredMessageTemplate)
+	add(surveyAnsweredChoiceBody, c.SurveyAnsweredMessageTemplate)
+	add(surveyAnsweredYesNoBody, c.SurveyAnsweredMessageTemplate)
+	add(surveyAnsweredFreeTextBody, c.SurveyAnsweredMessageTemplate)
+	add(feedbackRequestBody, c.FeedbackRequestMessageTemplate)
+	add(feedbackRequestDetractorBody, c.FeedbackRequestMessageTemplate)
+	add(feedbackRequestPassiveBody, c.FeedbackRequestMessageTemplate)
+	add(feedbackRequestPromoterBody, c.FeedbackRequestMessageTemplate)
+	add(feedbackRequestDetractorBody, c.DetractorFeedbackMessageTemplate)
+	add(feedbackRequestPassiveBody, c.PassiveFeedbackMessageTemplate)
+	add(feedbackRequestPromoterBody, c.PromoterFeedbackMessageTemplate)
+	add(feedbackResponseBody, c.FeedbackResponseMessageTemplate)
+	add(adminDMBody, c.AdminNoticeMessageTemplate)
+	add(adminEmailSubject, c.AdminEmailSubjectTemplate)
+	add(adminEmailBody, c.AdminEmailBodyTemplate)
+
+	return templates
+}
+
+// validateMessageTemplates checks that each custom template can be parsed and only uses the variables available to
+// its message.
+func validateMessageTemplates(templates map[string]string) error {
+	for messageID, text := range templates {
+		data := make(map[string]interface{})
+		for _, variable := range messageTemplateVariables[messageID] {
+			data[variable] = ""
+		}
+
+		if _, err := executeMessageTemplate(text, data); err != nil {
+			return errors.Wrapf(err, "invalid template for message %s", messageID)
+		}
+	}
+
+	return nil
+}
+
+// executeMessageTemplate fills in a custom message template. Using a variable that isn't in data is an error.
+func executeMessageTemplate(text string, data map[string]interface{}) (string, error) {
+	tmpl, err := template.New("message").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// applyMessageTemplates wraps a translateFunc so that it returns the custom template configured for a message in
+// place of its translation. The default message is used if a template fails to execute.
+func (p *Plugin) applyMessageTemplates(user *model.User, T translateFunc) translateFunc {
+	templates := p.getConfiguration().getMessageTemplates()
+	if len(templates) == 0 {
+		return T
+	}
+
+	return func(messageID string, data ...interface{}) string {
+		text, ok := templates[messageID]
+		if !ok {
+			return T(messageID, data...)
+		}
+
+		templateData := map[string]interface{}{
+			"Username": user.Username,
+			"SiteName": *p.API.GetConfig().TeamSettings.SiteName,
+		}
+		if len(data) > 0 {
+			if values, ok := data[0].(map[string]interface{}); ok {
+				for key, value := range values {
+					templateData[key] = value
+				}
+			}
+		}
+
+		message, err := executeMessageTemplate(text, templateData)
+		if err != nil {
+			p.API.LogWarn("Failed to execute custom message template. Sending default message instead.", "message_id", messageID, "err", err.Error())
+			return T(messageID, data...)
+		}
+
+		return message
+	}
+}
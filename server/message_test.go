@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestValidateMessageTemplates(t *testing.T) {
+	for _, test := range []struct {
+		Name        string
+		Templates   map[string]string
+		ExpectError bool
+	}{
+		{
+			Name:      "no templates",
+			Templates: map[string]string{},
+		},
+		{
+			Name: "valid templates",
+			Templates: map[string]string{
+				surveyBody:          "Hi @{{.Username}}! Tell us about {{.SiteName}}.",
+				feedbackRequestBody: "{{if .Score}}You gave us {{.Score}}. {{end}}Why?",
+				adminEmailSubject:   "Survey starts on {{.SurveyDate}}",
+			},
+		},
+		{
+			Name: "unparsable template",
+			Templates: map[string]string{
+				surveyBody: "Hi @{{.Username}",
+			},
+			ExpectError: true,
+		},
+		{
+			Name: "unknown variable",
+			Templates: map[string]string{
+				feedbackResponseBody: "Thanks for the {{.Score}}!",
+			},
+			ExpectError: true,
+		},
+	} {
+		t.Run(test.Name, func(t *testing.T) {
+			err := validateMessageTemplates(test.Templates)
+
+			if test.ExpectError {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestGetMessageTemplates(t *testing.T) {
+	c := &configuration{
+		SurveyMessageTemplate:         "Hi!",
+		SurveyAnsweredMessageTemplate: "Got it: {{.Answer}}",
+	}
+
+	assert.Equal(t, map[string]string{
+		surveyBody:                 "Hi!",
+		surveyAnsweredRatingBody:   "Got it: {{.Answer}}",
+		surveyAnsweredChoiceBody:   "Got it: {{.Answer}}",
+		surveyAnsweredYesNoBody:    "Got it: {{.Answer}}",
+		surveyAnsweredFreeTextBody: "Got it: {{.Answer}}",
+	}, c.getMessageTemplates())
+}
+
+func TestGetMessageTemplatesForFeedbackRequests(t *testing.T) {
+	c := &configuration{
+		FeedbackRequestMessageTemplate:   "Why {{.Score}}?",
+		DetractorFeedbackMessageTemplate: "What should we fix?",
+	}
+
+	assert.Equal(t, map[string]string{
+		feedbackRequestBody:          "Why {{.Score}}?",
+		feedbackRequestDetractorBody: "What should we fix?",
+		feedbackRequestPassiveBody:   "Why {{.Score}}?",
+		feedbackRequestPromoterBody:  "Why {{.Score}}?",
+	}, c.getMessageTemplates())
+}
+
+func TestApplyMessageTemplates(t *testing.T) {
+	user := &model.User{
+		Username: "alice",
+	}
+
+	t.Run("should use the custom template in place of the default message", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("GetConfig").Return(&model.Config{TeamSettings: model.TeamSettings{SiteName: model.NewString("Example")}})
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{configuration: &configuration{SurveyMessageTemplate: "Hi @{{.Username}}, welcome to {{.SiteName}}!"}}
+		p.SetAPI(api)
+
+		T := p.getUserTranslateFunc(user)
+
+		assert.Equal(t, "Hi @alice, welcome to Example!", T(surveyBody, map[string]interface{}{"Username": user.Username}))
+		assert.Equal(t, "Thanks! How can we make your experience better?", T(feedbackRequestBody, map[string]interface{}{"Score": ""}))
+	})
+
+	t.Run("should use the default message if the template fails", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("GetConfig").Return(&model.Config{TeamSettings: model.TeamSettings{SiteName: model.NewString("Example")}})
+		api.On("LogWarn", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{configuration: &configuration{FeedbackRequestMessageTemplate: "You gave us {{.Score}}"}}
+		p.SetAPI(api)
+
+		T := p.getUserTranslateFunc(user)
+
+		assert.Equal(t, "Thanks! How can we make your experience better?", T(feedbackRequestBody))
+	})
+
+	t.Run("should not use templates when none are configured", func(t *testing.T) {
+		api := makeAPIMock()
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{configuration: &configuration{}}
+		p.SetAPI(api)
+
+		T := p.getUserTranslateFunc(user)
+
+		assert.Equal(t, ":tada: Thanks for helping us make Mattermost better!", T(feedbackResponseBody))
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/mattermost/mattermost-server/plugin"
+	"github.com/nicksnyder/go-i18n/i18n/bundle"
 	analytics "github.com/segmentio/analytics-go"
 )
 
@@ -23,13 +24,18 @@ const (
 	SERVER_UPGRADE_KEY = "ServerUpgrade-%s"
 
 	// SURVEY_KEY is used to store the surveyState containing when an NPS survey starts and ends on a given version
-	// of Mattermost. It should contain the server version like "Survey-5.10.0".
+	// of Mattermost. It should contain the server version like "Survey-5.10.0". Surveys scheduled by SurveySchedule
+	// are also stored by their ID like "Survey-5.10.0-20190601".
 	SURVEY_KEY = "Survey-%s"
 
 	// USER_SURVEY_KEY is used to store the userSurveyState tracking a user's progress through an NPS survey on the
 	// given version of Mattermost. It should contain the user's ID like "UserSurvey-abc123".
 	USER_SURVEY_KEY = "UserSurvey-%s"
 
+	// USER_PREFERENCES_KEY is used to store the userPreferences chosen by a user, such as whether or not they've opted
+	// out of surveys. It should contain the user's ID like "UserPreferences-abc123".
+	USER_PREFERENCES_KEY = "UserPreferences-%s"
+
 	SURVEYBOT_DESCRIPTION = "Surveybot collects user feedback to improve Mattermost. [Learn more](https://mattermost.com/pl/default-nps)."
 )
 
@@ -51,6 +57,19 @@ type Plugin struct {
 
 	botUserID string
 
+	// actionSecret signs the Context of the survey post's actions. Consult getActionContext for usage.
+	actionSecret []byte
+
+	// anonymousSalt is used to hash user IDs when surveys are anonymous. Consult getAnonymousID for usage.
+	anonymousSalt []byte
+
+	// translations contains the messages sent by Surveybot in each language. Consult getTranslateFunc for usage.
+	translations *bundle.Bundle
+
+	// clientLock synchronizes access to the client.
+	clientLock sync.Mutex
+
+	// client sends events to Segment. Consult initializeClient and getClient for usage.
 	client *analytics.Client
 
 	// blockSegmentEvents prevents the plugin from sending events to Segment during testing.
@@ -61,11 +80,18 @@ type Plugin struct {
 
 	// readFile provides access to ioutil.ReadFile in a way that is mockable for unit testing.
 	readFile func(path string) ([]byte, error)
+
+	// sinkQueue holds events waiting to be sent to a webhook or file sink. Consult queueSinkEvent for usage.
+	sinkQueue chan *queuedSinkEvent
+
+	// stopJobs is closed when the plugin is deactivated to stop any background jobs started by runJob.
+	stopJobs chan struct{}
 }
 
 func NewPlugin() *Plugin {
 	return &Plugin{
-		now:      time.Now,
-		readFile: ioutil.ReadFile,
+		now:       time.Now,
+		readFile:  ioutil.ReadFile,
+		sinkQueue: make(chan *queuedSinkEvent, SINK_QUEUE_SIZE),
 	}
 }
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// QUESTIONS_KEY is used to store the list of surveyQuestions that are sent to users as part of a survey. If it
+	// isn't set, only the NPS question is sent.
+	QUESTIONS_KEY = "Questions"
+
+	// QUESTION_TYPE_RATING asks the user to pick a number between a question's Min and Max.
+	QUESTION_TYPE_RATING = "rating"
+
+	// QUESTION_TYPE_MULTIPLE_CHOICE asks the user to pick one of a question's Options.
+	QUESTION_TYPE_MULTIPLE_CHOICE = "multiple_choice"
+
+	// QUESTION_TYPE_YES_NO asks the user to answer either yes or no.
+	QUESTION_TYPE_YES_NO = "yes_no"
+
+	// QUESTION_TYPE_FREE_TEXT asks the user to type out an answer in an interactive dialog.
+	QUESTION_TYPE_FREE_TEXT = "free_text"
+
+	// NPS_QUESTION_ID is the ID of the built-in "How likely are you to recommend Mattermost?" question. Answers to it
+	// are stored as the score of a surveyResponse and are used to calculate the Net Promoter Score.
+	NPS_QUESTION_ID = "nps"
+
+	// The largest number of choices that can be shown for a rating question
+	MAX_RATING_CHOICES = 11
+
+	// The longest answer that can be given to a free text question
+	MAX_FREE_TEXT_ANSWER_LENGTH = 2000
+
+	ANSWER_YES = "yes"
+	ANSWER_NO  = "no"
+)
+
+// surveyQuestion is a single question that's sent to users as part of a survey.
+type surveyQuestion struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Text string `json:"text"`
+
+	// Options contains the choices for a QUESTION_TYPE_MULTIPLE_CHOICE question.
+	Options []string `json:"options,omitempty"`
+
+	// Min, Max, MinLabel, and MaxLabel describe the range of a QUESTION_TYPE_RATING question.
+	Min      int    `json:"min"`
+	Max      int    `json:"max"`
+	MinLabel string `json:"min_label,omitempty"`
+	MaxLabel string `json:"max_label,omitempty"`
+}
+
+// npsQuestion is the question that's sent if an admin hasn't configured any others.
+var npsQuestion = &surveyQuestion{
+	ID:       NPS_QUESTION_ID,
+	Type:     QUESTION_TYPE_RATING,
+	Text:     defaultTranslations[surveyDropdownTitle],
+	Min:      0,
+	Max:      10,
+	MinLabel: defaultTranslations[surveyDropdownMinLabel],
+	MaxLabel: defaultTranslations[surveyDropdownMaxLabel],
+}
+
+// IsValid checks that the question contains everything required by its type.
+func (q *surveyQuestion) IsValid() error {
+	if q.ID == "" {
+		return errors.New("question is missing an ID")
+	}
+
+	if q.Text == "" {
+		return errors.Errorf("question %s is missing text", q.ID)
+	}
+
+	switch q.Type {
+	case QUESTION_TYPE_RATING:
+		if q.Max <= q.Min {
+			return errors.Errorf("question %s must have a max greater than its min", q.ID)
+		}
+
+		if q.Max-q.Min+1 > MAX_RATING_CHOICES {
+			return errors.Errorf("question %s must have at most %d choices", q.ID, MAX_RATING_CHOICES)
+		}
+	case QUESTION_TYPE_MULTIPLE_CHOICE:
+		if len(q.Options) < 2 {
+			return errors.Errorf("question %s must have at least 2 options", q.ID)
+		}
+
+		for _, option := range q.Options {
+			if option == "" {
+				return errors.Errorf("question %s must not have an empty option", q.ID)
+			}
+		}
+	case QUESTION_TYPE_YES_NO, QUESTION_TYPE_FREE_TEXT:
+	default:
+		return errors.Errorf("question %s has unknown type %s", q.ID, q.Type)
+	}
+
+	return nil
+}
+
+// parseAnswer checks that the given answer is valid for the question and returns it in the form that it's stored in.
+func (q *surveyQuestion) parseAnswer(answer string) (string, error) {
+	switch q.Type {
+	case QUESTION_TYPE_RATING:
+		rating, err := strconv.Atoi(answer)
+		if err != nil {
+			return "", err
+		}
+
+		if rating < q.Min || rating > q.Max {
+			return "", errors.New("rating out of range")
+		}
+
+		return strconv.Itoa(rating), nil
+	case QUESTION_TYPE_MULTIPLE_CHOICE:
+		for _, option := range q.Options {
+			if answer == option {
+				return answer, nil
+			}
+		}
+
+		return "", errors.New("answer is not one of the question's options")
+	case QUESTION_TYPE_YES_NO:
+		if answer != ANSWER_YES && answer != ANSWER_NO {
+			return "", errors.New("answer must be yes or no")
+		}
+
+		return answer, nil
+	case QUESTION_TYPE_FREE_TEXT:
+		answer = strings.TrimSpace(answer)
+
+		if answer == "" {
+			return "", errors.New("answer is empty")
+		}
+
+		if len(answer) > MAX_FREE_TEXT_ANSWER_LENGTH {
+			return "", errors.New("answer is too long")
+		}
+
+		return answer, nil
+	default:
+		return "", errors.Errorf("unknown question type %s", q.Type)
+	}
+}
+
+// getOptions returns the choices that are shown for a QUESTION_TYPE_RATING or QUESTION_TYPE_MULTIPLE_CHOICE question.
+// Ratings are listed from highest to lowest.
+func (q *surveyQuestion) getOptions() []*model.PostActionOptions {
+	var options []*model.PostActionOptions
+
+	switch q.Type {
+	case QUESTION_TYPE_RATING:
+		for i := q.Max; i >= q.Min; i-- {
+			text := strconv.Itoa(i)
+			if i == q.Min && q.MinLabel != "" {
+				text = fmt.Sprintf("%d (%s)", i, q.MinLabel)
+			} else if i == q.Max && q.MaxLabel != "" {
+				text = fmt.Sprintf("%d (%s)", i, q.MaxLabel)
+			}
+
+			options = append(options, &model.PostActionOptions{
+				Text:  text,
+				Value: strconv.Itoa(i),
+			})
+		}
+	case QUESTION_TYPE_MULTIPLE_CHOICE:
+		for _, option := range q.Options {
+			options = append(options, &model.PostActionOptions{
+				Text:  option,
+				Value: option,
+			})
+		}
+	}
+
+	return options
+}
+
+// translate returns the question with the text of the built-in NPS question in the user's locale. Questions written by
+// admins are returned unchanged.
+func (q *surveyQuestion) translate(T translateFunc) *surveyQuestion {
+	if q != npsQuestion {
+		return q
+	}
+
+	translated := *q
+	translated.Text = T(surveyDropdownTitle)
+	translated.MinLabel = T(surveyDropdownMinLabel)
+	translated.MaxLabel = T(surveyDropdownMaxLabel)
+
+	return &translated
+}
+
+// describeAnswer returns the text shown on the survey post after the user has answered the question.
+func (q *surveyQuestion) describeAnswer(T translateFunc, answer string) string {
+	data := map[string]interface{}{
+		"Question": q.Text,
+		"Answer":   answer,
+	}
+
+	switch q.Type {
+	case QUESTION_TYPE_RATING:
+		data["Max"] = q.Max
+
+		return T(surveyAnsweredRatingBody, data)
+	case QUESTION_TYPE_YES_NO:
+		if answer == ANSWER_YES {
+			data["Answer"] = T(surveyAnswerYes)
+		} else {
+			data["Answer"] = T(surveyAnswerNo)
+		}
+
+		return T(surveyAnsweredYesNoBody, data)
+	case QUESTION_TYPE_FREE_TEXT:
+		return T(surveyAnsweredFreeTextBody, data)
+	default:
+		return T(surveyAnsweredChoiceBody, data)
+	}
+}
+
+// validateQuestions checks that each question is valid and that no two questions share an ID.
+func validateQuestions(questions []*surveyQuestion) error {
+	ids := make(map[string]bool)
+
+	for _, question := range questions {
+		if question == nil {
+			return errors.New("question must not be null")
+		}
+
+		if err := question.IsValid(); err != nil {
+			return err
+		}
+
+		if question.ID == NPS_QUESTION_ID && (question.Type != QUESTION_TYPE_RATING || question.Min != 0 || question.Max != 10) {
+			// Answers to this question are used to calculate the Net Promoter Score
+			return errors.Errorf("question %s must be a rating from 0 to 10", NPS_QUESTION_ID)
+		}
+
+		if ids[question.ID] {
+			return errors.Errorf("question ID %s is used more than once", question.ID)
+		}
+		ids[question.ID] = true
+	}
+
+	return nil
+}
+
+// isDefaultQuestions returns whether or not the questions are just the built-in NPS question, in which case the survey
+// can be displayed using the custom NPS survey post.
+func isDefaultQuestions(questions []*surveyQuestion) bool {
+	return len(questions) == 1 && questions[0] == npsQuestion
+}
+
+// findQuestion returns the question with the given ID. Answers to the NPS question are still accepted after it has been
+// removed since it may have been sent to users before then.
+func findQuestion(questions []*surveyQuestion, questionID string) *surveyQuestion {
+	for _, question := range questions {
+		if question.ID == questionID {
+			return question
+		}
+	}
+
+	if questionID == NPS_QUESTION_ID {
+		return npsQuestion
+	}
+
+	return nil
+}
+
+// getQuestions returns the questions that should be sent as part of a survey.
+func (p *Plugin) getQuestions() ([]*surveyQuestion, *model.AppError) {
+	var questions []*surveyQuestion
+	if err := p.KVGet(QUESTIONS_KEY, &questions); err != nil {
+		return nil, err
+	}
+
+	if len(questions) == 0 {
+		return []*surveyQuestion{npsQuestion}, nil
+	}
+
+	return questions, nil
+}
+
+// saveQuestions replaces the questions that are sent as part of a survey. Saving an empty list of questions goes back
+// to only sending the NPS question.
+func (p *Plugin) saveQuestions(questions []*surveyQuestion) *model.AppError {
+	if len(questions) == 0 {
+		return p.API.KVDelete(QUESTIONS_KEY)
+	}
+
+	return p.KVSet(QUESTIONS_KEY, questions)
+}
+
+func (p *Plugin) getSurveyQuestions(w http.ResponseWriter, r *http.Request) {
+	questions, appErr := p.getQuestions()
+	if appErr != nil {
+		p.API.LogError("Failed to get survey questions", "err", appErr)
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(questions)
+}
+
+func (p *Plugin) updateSurveyQuestions(w http.ResponseWriter, r *http.Request) {
+	var questions []*surveyQuestion
+	if err := json.NewDecoder(r.Body).Decode(&questions); err != nil {
+		http.Error(w, "Invalid survey questions", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateQuestions(questions); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid survey questions: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	if appErr := p.saveQuestions(questions); appErr != nil {
+		p.API.LogError("Failed to save survey questions", "err", appErr)
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	p.getSurveyQuestions(w, r)
+}
+
+// openAnswerDialog opens an interactive dialog for the user to answer a QUESTION_TYPE_FREE_TEXT question. The ID of the
+// survey post is passed as the dialog's callback ID so that it can be updated once the dialog is submitted.
+func (p *Plugin) openAnswerDialog(user *model.User, triggerID string, postID string, question *surveyQuestion) *model.AppError {
+	siteURL := *p.API.GetConfig().ServiceSettings.SiteURL
+
+	T := p.getUserTranslateFunc(user)
+
+	return p.API.OpenInteractiveDialog(model.OpenDialogRequest{
+		TriggerId: triggerID,
+		URL:       fmt.Sprintf("%s/plugins/%s/api/v1/answer", siteURL, manifest.Id),
+		Dialog: model.Dialog{
+			CallbackId: postID,
+			Title:      T(answerDialogTitle),
+			Elements: []model.DialogElement{
+				{
+					DisplayName: T(answerDialogElementName),
+					Name:        "answer",
+					Type:        "textarea",
+					HelpText:    question.Text,
+					MaxLength:   MAX_FREE_TEXT_ANSWER_LENGTH,
+				},
+			},
+			SubmitLabel: T(answerDialogSubmitLabel),
+			State:       question.ID,
+		},
+	})
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateQuestions(t *testing.T) {
+	for _, test := range []struct {
+		Name        string
+		Questions   []*surveyQuestion
+		ExpectError bool
+	}{
+		{
+			Name:      "no questions",
+			Questions: []*surveyQuestion{},
+		},
+		{
+			Name: "one of each type",
+			Questions: []*surveyQuestion{
+				npsQuestion,
+				{ID: "rating", Type: QUESTION_TYPE_RATING, Text: "Rate this", Min: 1, Max: 5},
+				{ID: "choice", Type: QUESTION_TYPE_MULTIPLE_CHOICE, Text: "Pick one", Options: []string{"A", "B"}},
+				{ID: "yes_no", Type: QUESTION_TYPE_YES_NO, Text: "Yes or no?"},
+				{ID: "text", Type: QUESTION_TYPE_FREE_TEXT, Text: "Anything else?"},
+			},
+		},
+		{
+			Name:        "missing ID",
+			Questions:   []*surveyQuestion{{Type: QUESTION_TYPE_YES_NO, Text: "Yes or no?"}},
+			ExpectError: true,
+		},
+		{
+			Name:        "missing text",
+			Questions:   []*surveyQuestion{{ID: "yes_no", Type: QUESTION_TYPE_YES_NO}},
+			ExpectError: true,
+		},
+		{
+			Name:        "unknown type",
+			Questions:   []*surveyQuestion{{ID: "q", Type: "essay", Text: "Discuss"}},
+			ExpectError: true,
+		},
+		{
+			Name:        "rating with an empty range",
+			Questions:   []*surveyQuestion{{ID: "rating", Type: QUESTION_TYPE_RATING, Text: "Rate this", Min: 5, Max: 5}},
+			ExpectError: true,
+		},
+		{
+			Name:        "rating with too many choices",
+			Questions:   []*surveyQuestion{{ID: "rating", Type: QUESTION_TYPE_RATING, Text: "Rate this", Min: 0, Max: 100}},
+			ExpectError: true,
+		},
+		{
+			Name:        "multiple choice with one option",
+			Questions:   []*surveyQuestion{{ID: "choice", Type: QUESTION_TYPE_MULTIPLE_CHOICE, Text: "Pick one", Options: []string{"A"}}},
+			ExpectError: true,
+		},
+		{
+			Name:        "multiple choice with an empty option",
+			Questions:   []*surveyQuestion{{ID: "choice", Type: QUESTION_TYPE_MULTIPLE_CHOICE, Text: "Pick one", Options: []string{"A", ""}}},
+			ExpectError: true,
+		},
+		{
+			Name:        "NPS question with a different range",
+			Questions:   []*surveyQuestion{{ID: NPS_QUESTION_ID, Type: QUESTION_TYPE_RATING, Text: "Rate us", Min: 1, Max: 5}},
+			ExpectError: true,
+		},
+		{
+			Name: "duplicate IDs",
+			Questions: []*surveyQuestion{
+				{ID: "q", Type: QUESTION_TYPE_YES_NO, Text: "Yes or no?"},
+				{ID: "q", Type: QUESTION_TYPE_FREE_TEXT, Text: "Anything else?"},
+			},
+			ExpectError: true,
+		},
+		{
+			Name:        "null question",
+			Questions:   []*surveyQuestion{nil},
+			ExpectError: true,
+		},
+	} {
+		t.Run(test.Name, func(t *testing.T) {
+			err := validateQuestions(test.Questions)
+
+			if test.ExpectError {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestSurveyQuestionParseAnswer(t *testing.T) {
+	choice := &surveyQuestion{ID: "choice", Type: QUESTION_TYPE_MULTIPLE_CHOICE, Text: "Pick one", Options: []string{"A", "B"}}
+	yesNo := &surveyQuestion{ID: "yes_no", Type: QUESTION_TYPE_YES_NO, Text: "Yes or no?"}
+	freeText := &surveyQuestion{ID: "text", Type: QUESTION_TYPE_FREE_TEXT, Text: "Anything else?"}
+
+	for _, test := range []struct {
+		Name           string
+		Question       *surveyQuestion
+		Answer         string
+		ExpectedAnswer string
+		ExpectError    bool
+	}{
+		{
+			Name:           "NPS, a number",
+			Question:       npsQuestion,
+			Answer:         "7",
+			ExpectedAnswer: "7",
+		},
+		{
+			Name:           "NPS, zero",
+			Question:       npsQuestion,
+			Answer:         "0",
+			ExpectedAnswer: "0",
+		},
+		{
+			Name:           "NPS, ten",
+			Question:       npsQuestion,
+			Answer:         "10",
+			ExpectedAnswer: "10",
+		},
+		{
+			Name:        "NPS, too low",
+			Question:    npsQuestion,
+			Answer:      "-400",
+			ExpectError: true,
+		},
+		{
+			Name:        "NPS, too high",
+			Question:    npsQuestion,
+			Answer:      "1000000",
+			ExpectError: true,
+		},
+		{
+			Name:        "NPS, garbage",
+			Question:    npsQuestion,
+			Answer:      "garbage",
+			ExpectError: true,
+		},
+		{
+			Name:        "NPS, empty",
+			Question:    npsQuestion,
+			Answer:      "",
+			ExpectError: true,
+		},
+		{
+			Name:           "multiple choice, valid option",
+			Question:       choice,
+			Answer:         "B",
+			ExpectedAnswer: "B",
+		},
+		{
+			Name:        "multiple choice, unknown option",
+			Question:    choice,
+			Answer:      "C",
+			ExpectError: true,
+		},
+		{
+			Name:           "yes/no, yes",
+			Question:       yesNo,
+			Answer:         ANSWER_YES,
+			ExpectedAnswer: ANSWER_YES,
+		},
+		{
+			Name:        "yes/no, maybe",
+			Question:    yesNo,
+			Answer:      "maybe",
+			ExpectError: true,
+		},
+		{
+			Name:           "free text, trims whitespace",
+			Question:       freeText,
+			Answer:         "  More coffee  ",
+			ExpectedAnswer: "More coffee",
+		},
+		{
+			Name:        "free text, empty",
+			Question:    freeText,
+			Answer:      "   ",
+			ExpectError: true,
+		},
+		{
+			Name:        "free text, too long",
+			Question:    freeText,
+			Answer:      string(make([]byte, MAX_FREE_TEXT_ANSWER_LENGTH+1)),
+			ExpectError: true,
+		},
+	} {
+		t.Run(test.Name, func(t *testing.T) {
+			answer, err := test.Question.parseAnswer(test.Answer)
+
+			assert.Equal(t, test.ExpectedAnswer, answer)
+			if test.ExpectError {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestSurveyQuestionGetOptions(t *testing.T) {
+	t.Run("NPS question", func(t *testing.T) {
+		options := npsQuestion.getOptions()
+
+		require.Len(t, options, 11)
+		assert.Equal(t, &model.PostActionOptions{Text: "10 (Very Likely)", Value: "10"}, options[0])
+		assert.Equal(t, &model.PostActionOptions{Text: "5", Value: "5"}, options[5])
+		assert.Equal(t, &model.PostActionOptions{Text: "0 (Not Likely)", Value: "0"}, options[10])
+	})
+
+	t.Run("multiple choice question", func(t *testing.T) {
+		question := &surveyQuestion{Type: QUESTION_TYPE_MULTIPLE_CHOICE, Options: []string{"A", "B"}}
+
+		assert.Equal(t, []*model.PostActionOptions{
+			{Text: "A", Value: "A"},
+			{Text: "B", Value: "B"},
+		}, question.getOptions())
+	})
+}
+
+func TestSurveyQuestionDescribeAnswer(t *testing.T) {
+	T := (&Plugin{}).getTranslateFunc(DEFAULT_LOCALE)
+
+	assert.Equal(t, "You selected 7 out of 10.", npsQuestion.describeAnswer(T, "7"))
+	assert.Equal(t, "You selected B.", (&surveyQuestion{Type: QUESTION_TYPE_MULTIPLE_CHOICE}).describeAnswer(T, "B"))
+	assert.Equal(t, "You answered Yes.", (&surveyQuestion{Type: QUESTION_TYPE_YES_NO}).describeAnswer(T, ANSWER_YES))
+	assert.Equal(t, "You answered: More coffee", (&surveyQuestion{Type: QUESTION_TYPE_FREE_TEXT}).describeAnswer(T, "More coffee"))
+}
+
+func TestSurveyQuestionTranslate(t *testing.T) {
+	p := &Plugin{translations: newTranslationBundle()}
+	p.translations.ParseTranslationFileBytes("es.json", []byte(`[
+		{"id": "survey.dropdown.title", "translation": "¿Recomendarías Mattermost?"}
+	]`))
+
+	t.Run("should translate the NPS question", func(t *testing.T) {
+		translated := npsQuestion.translate(p.getTranslateFunc("es"))
+
+		assert.Equal(t, "¿Recomendarías Mattermost?", translated.Text)
+		assert.Equal(t, "Not Likely", translated.MinLabel)
+		assert.Equal(t, "How likely are you to recommend Mattermost?", npsQuestion.Text)
+	})
+
+	t.Run("should not translate other questions", func(t *testing.T) {
+		question := &surveyQuestion{ID: NPS_QUESTION_ID, Type: QUESTION_TYPE_RATING, Text: "Custom", Max: 10}
+
+		assert.Equal(t, question, question.translate(p.getTranslateFunc("es")))
+	})
+}
+
+func TestFindQuestion(t *testing.T) {
+	questions := []*surveyQuestion{
+		{ID: "yes_no", Type: QUESTION_TYPE_YES_NO, Text: "Yes or no?"},
+	}
+
+	assert.Equal(t, questions[0], findQuestion(questions, "yes_no"))
+	assert.Equal(t, npsQuestion, findQuestion(questions, NPS_QUESTION_ID))
+	assert.Nil(t, findQuestion(questions, "missing"))
+}
+
+func TestGetQuestions(t *testing.T) {
+	t.Run("should default to the NPS question", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVGet", QUESTIONS_KEY).Return(nil, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		questions, err := p.getQuestions()
+
+		require.Nil(t, err)
+		assert.True(t, isDefaultQuestions(questions))
+	})
+
+	t.Run("should return stored questions", func(t *testing.T) {
+		stored := []*surveyQuestion{
+			{ID: "yes_no", Type: QUESTION_TYPE_YES_NO, Text: "Yes or no?"},
+		}
+
+		api := &plugintest.API{}
+		api.On("KVGet", QUESTIONS_KEY).Return(mustMarshalJSON(stored), nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		questions, err := p.getQuestions()
+
+		require.Nil(t, err)
+		assert.Equal(t, stored, questions)
+		assert.False(t, isDefaultQuestions(questions))
+	})
+}
+
+func TestUpdateSurveyQuestions(t *testing.T) {
+	questions := []*surveyQuestion{
+		npsQuestion,
+		{ID: "yes_no", Type: QUESTION_TYPE_YES_NO, Text: "Yes or no?"},
+	}
+
+	t.Run("should save valid questions", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVSet", QUESTIONS_KEY, mustMarshalJSON(questions)).Return(nil)
+		api.On("KVGet", QUESTIONS_KEY).Return(mustMarshalJSON(questions), nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPut, "/api/v1/questions", bytes.NewReader(mustMarshalJSON(questions)))
+
+		p.updateSurveyQuestions(recorder, request)
+
+		result := recorder.Result()
+
+		var saved []*surveyQuestion
+		require.Nil(t, json.NewDecoder(result.Body).Decode(&saved))
+
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, questions, saved)
+	})
+
+	t.Run("should reset to the NPS question when given no questions", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVDelete", QUESTIONS_KEY).Return(nil)
+		api.On("KVGet", QUESTIONS_KEY).Return(nil, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPut, "/api/v1/questions", bytes.NewReader([]byte("[]")))
+
+		p.updateSurveyQuestions(recorder, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+	})
+
+	t.Run("should reject invalid questions", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPut, "/api/v1/questions", bytes.NewReader(mustMarshalJSON([]*surveyQuestion{
+			{ID: "choice", Type: QUESTION_TYPE_MULTIPLE_CHOICE, Text: "Pick one"},
+		})))
+
+		p.updateSurveyQuestions(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Result().StatusCode)
+	})
+
+	t.Run("should return an error when unable to save questions", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVSet", QUESTIONS_KEY, mock.Anything).Return(&model.AppError{})
+		api.On("LogError", mock.Anything, "err", mock.Anything)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPut, "/api/v1/questions", bytes.NewReader(mustMarshalJSON(questions)))
+
+		p.updateSurveyQuestions(recorder, request)
+
+		assert.Equal(t, http.StatusInternalServerError, recorder.Result().StatusCode)
+	})
+}
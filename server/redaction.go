@@ -0,0 +1,138 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// The built-in detectors that can be enabled with FeedbackRedactionDetectors.
+	REDACTION_DETECTOR_EMAIL = "email"
+	REDACTION_DETECTOR_URL   = "url"
+	REDACTION_DETECTOR_IP    = "ip"
+	REDACTION_DETECTOR_PHONE = "phone"
+
+	// The text that replaces anything matched by a pattern from FeedbackRedactionPatterns.
+	REDACTED_TEXT = "[REDACTED]"
+)
+
+// redactionDetectors contains the pattern and replacement text for each built-in detector.
+var redactionDetectors = map[string]*redactionRule{
+	REDACTION_DETECTOR_EMAIL: {
+		pattern:     regexp.MustCompile(`(?i)[a-z0-9._%+-]+@[a-z0-9-]+(?:\.[a-z0-9-]+)*\.[a-z]{2,}`),
+		replacement: "[REDACTED EMAIL]",
+	},
+	REDACTION_DETECTOR_URL: {
+		pattern:     regexp.MustCompile(`(?i)\b(?:[a-z][a-z0-9+.-]*://|www\.)[^\s<>"']+`),
+		replacement: "[REDACTED URL]",
+	},
+	REDACTION_DETECTOR_IP: {
+		pattern: regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|` +
+			`\b(?:[0-9a-fA-F]{1,4}:){3,7}[0-9a-fA-F]{1,4}\b|\b[0-9a-fA-F]{1,4}::(?:[0-9a-fA-F]{1,4}:){0,5}[0-9a-fA-F]{1,4}\b`),
+		replacement: "[REDACTED IP]",
+	},
+	REDACTION_DETECTOR_PHONE: {
+		// Numbers must either start with a country code or be split into groups so that dates and IDs aren't matched
+		pattern: regexp.MustCompile(`(?:\+\d{1,3}[\s.-]?(?:\(\d{1,4}\)[\s.-]?)?\d{1,4}(?:[\s.-]?\d{2,4}){1,4}|` +
+			`(?:\(\d{2,4}\)[\s.-]?|\b\d{2,4}[\s.-])\d{3,4}[\s.-]\d{3,4})\b`),
+		replacement: "[REDACTED PHONE]",
+	},
+}
+
+// redactionDetectorOrder is the order that the built-in detectors run in. URLs are removed before emails and IP
+// addresses since they may contain either, and IP addresses are removed before phone numbers since they look alike.
+var redactionDetectorOrder = []string{
+	REDACTION_DETECTOR_URL,
+	REDACTION_DETECTOR_EMAIL,
+	REDACTION_DETECTOR_IP,
+	REDACTION_DETECTOR_PHONE,
+}
+
+type redactionRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// redactor removes personal information from feedback before it's stored or sent anywhere.
+type redactor struct {
+	rules []*redactionRule
+}
+
+// newRedactor creates a redactor that runs the given custom patterns followed by the given built-in detectors.
+func newRedactor(detectors []string, patterns []string) (*redactor, error) {
+	r := &redactor{}
+
+	for _, pattern := range patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid redaction pattern %s", pattern)
+		}
+
+		r.rules = append(r.rules, &redactionRule{
+			pattern:     compiled,
+			replacement: REDACTED_TEXT,
+		})
+	}
+
+	for _, detector := range detectors {
+		if _, ok := redactionDetectors[detector]; !ok {
+			return nil, errors.Errorf("unknown redaction detector %s", detector)
+		}
+	}
+
+	for _, detector := range redactionDetectorOrder {
+		if containsString(detectors, detector) {
+			r.rules = append(r.rules, redactionDetectors[detector])
+		}
+	}
+
+	return r, nil
+}
+
+// redact returns the text with everything matched by the redactor replaced along with the number of replacements made.
+func (r *redactor) redact(text string) (string, int) {
+	count := 0
+
+	for _, rule := range r.rules {
+		text = rule.pattern.ReplaceAllStringFunc(text, func(string) string {
+			count += 1
+			return rule.replacement
+		})
+	}
+
+	return text, count
+}
+
+// getRedactionPatterns returns the custom patterns from FeedbackRedactionPatterns, one per line.
+func (c *configuration) getRedactionPatterns() []string {
+	var patterns []string
+
+	for _, line := range strings.Split(c.FeedbackRedactionPatterns, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			patterns = append(patterns, line)
+		}
+	}
+
+	return patterns
+}
+
+// getRedactor returns the redactor for feedback using the configured detectors and patterns.
+func (c *configuration) getRedactor() (*redactor, error) {
+	return newRedactor(splitSetting(c.FeedbackRedactionDetectors), c.getRedactionPatterns())
+}
+
+// redactFeedback removes personal information from the feedback using the configured redactor. Returns the redacted
+// feedback and the number of redactions made.
+func (p *Plugin) redactFeedback(feedback string) (string, int) {
+	r, err := p.getConfiguration().getRedactor()
+	if err != nil {
+		// This should've been caught when the configuration was saved, so just log it and redact what we can
+		p.API.LogError("Failed to create feedback redactor", "err", err.Error())
+
+		r, _ = newRedactor(redactionDetectorOrder, nil)
+	}
+
+	return r.redact(feedback)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactorRedact(t *testing.T) {
+	allDetectors := []string{REDACTION_DETECTOR_EMAIL, REDACTION_DETECTOR_URL, REDACTION_DETECTOR_IP, REDACTION_DETECTOR_PHONE}
+
+	for _, test := range []struct {
+		Name          string
+		Detectors     []string
+		Patterns      []string
+		Input         string
+		Expected      string
+		ExpectedCount int
+	}{
+		{
+			Name:      "no detectors",
+			Input:     "Email me at someone@example.com",
+			Expected:  "Email me at someone@example.com",
+			Detectors: nil,
+		},
+		{
+			Name:          "email",
+			Detectors:     allDetectors,
+			Input:         "Email me at some.one+nps@mail.example.co.uk or other@example.com.",
+			Expected:      "Email me at [REDACTED EMAIL] or [REDACTED EMAIL].",
+			ExpectedCount: 2,
+		},
+		{
+			Name:          "url",
+			Detectors:     allDetectors,
+			Input:         "See https://user@chat.example.com/team/pl/abc?x=1 and www.example.com",
+			Expected:      "See [REDACTED URL] and [REDACTED URL]",
+			ExpectedCount: 2,
+		},
+		{
+			Name:          "ip",
+			Detectors:     allDetectors,
+			Input:         "The server at 10.0.12.254 and fe80::1 and 2001:db8:0:0:0:0:2:1 is slow",
+			Expected:      "The server at [REDACTED IP] and [REDACTED IP] and [REDACTED IP] is slow",
+			ExpectedCount: 3,
+		},
+		{
+			Name:          "phone",
+			Detectors:     allDetectors,
+			Input:         "Call +1 (555) 123-4567 or 555.123.4567 or +44 20 7946 0958 or +15551234567 or (555)123-4567",
+			Expected:      "Call [REDACTED PHONE] or [REDACTED PHONE] or [REDACTED PHONE] or [REDACTED PHONE] or [REDACTED PHONE]",
+			ExpectedCount: 5,
+		},
+		{
+			Name:      "numbers that aren't phone numbers",
+			Detectors: allDetectors,
+			Input:     "Broke on 20190601 in build 5.10.0, see ticket #12345678 and order 987654321",
+			Expected:  "Broke on 20190601 in build 5.10.0, see ticket #12345678 and order 987654321",
+		},
+		{
+			Name:      "things that aren't personal information",
+			Detectors: allDetectors,
+			Input:     "Upgraded to 5.10.0 on 2019-06-01 at 12:30:45, scored it 10/10 @someone",
+			Expected:  "Upgraded to 5.10.0 on 2019-06-01 at 12:30:45, scored it 10/10 @someone",
+		},
+		{
+			Name:          "only the selected detectors",
+			Detectors:     []string{REDACTION_DETECTOR_EMAIL},
+			Input:         "Email someone@example.com or call 555-123-4567",
+			Expected:      "Email [REDACTED EMAIL] or call 555-123-4567",
+			ExpectedCount: 1,
+		},
+		{
+			Name:          "custom patterns",
+			Detectors:     allDetectors,
+			Patterns:      []string{`(?i)\b[a-z0-9-]+\.corp\.internal\b`, `Project \w+`},
+			Input:         "Project Falcon on build-01.corp.internal is down, see https://build-01.corp.internal/status",
+			Expected:      "[REDACTED] on [REDACTED] is down, see [REDACTED URL]",
+			ExpectedCount: 4,
+		},
+	} {
+		t.Run(test.Name, func(t *testing.T) {
+			r, err := newRedactor(test.Detectors, test.Patterns)
+			require.Nil(t, err)
+
+			redacted, count := r.redact(test.Input)
+
+			assert.Equal(t, test.Expected, redacted)
+			assert.Equal(t, test.ExpectedCount, count)
+		})
+	}
+}
+
+func TestNewRedactor(t *testing.T) {
+	t.Run("should return an error for an unknown detector", func(t *testing.T) {
+		_, err := newRedactor([]string{"ssn"}, nil)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("should return an error for an invalid pattern", func(t *testing.T) {
+		_, err := newRedactor(nil, []string{"(unclosed"})
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGetRedactionPatterns(t *testing.T) {
+	config := &configuration{
+		FeedbackRedactionPatterns: "first\n\n  second  \r\nthird",
+	}
+
+	assert.Equal(t, []string{"first", "second", "third"}, config.getRedactionPatterns())
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// isExpired returns whether or not the survey has been closed, either because it was already marked as expired or
+// because it went unanswered for longer than the given expiry. Surveys never expire if expiry is 0.
+func (s *userSurveyState) isExpired(expiry time.Duration, now time.Time) bool {
+	if !s.ExpiredAt.IsZero() {
+		return true
+	}
+
+	return expiry > 0 && s.AnsweredAt.IsZero() && now.Sub(s.SentAt) >= expiry
+}
+
+// isFollowUpDue returns whether the user's unanswered survey is due to be closed or for them to be reminded of it.
+func (s *userSurveyState) isFollowUpDue(expiry time.Duration, reminderDelay time.Duration, now time.Time) bool {
+	if !s.AnsweredAt.IsZero() || !s.ExpiredAt.IsZero() {
+		return false
+	}
+
+	if s.isExpired(expiry, now) {
+		return true
+	}
+
+	return s.RemindedAt.IsZero() && reminderDelay > 0 && now.Sub(s.SentAt) >= reminderDelay
+}
+
+// checkForUnansweredSurvey follows up on a survey that the user has received but not answered. The survey is closed
+// if it has expired. Otherwise, the user is sent a single reminder once enough time has passed.
+func (p *Plugin) checkForUnansweredSurvey(user *model.User, userSurvey *userSurveyState, now time.Time) *model.AppError {
+	if !userSurvey.AnsweredAt.IsZero() || !userSurvey.ExpiredAt.IsZero() {
+		// The survey has already been answered or closed
+		return nil
+	}
+
+	config := p.getConfiguration()
+
+	if userSurvey.isExpired(config.getSurveyExpiry(), now) {
+		return p.expireSurvey(user, userSurvey, now)
+	}
+
+	if !userSurvey.RemindedAt.IsZero() {
+		// The user has already been reminded
+		return nil
+	}
+
+	if delay := config.getSurveyReminderDelay(); delay == 0 || now.Sub(userSurvey.SentAt) < delay {
+		// Reminders are disabled or it's too soon to send one
+		return nil
+	}
+
+	return p.sendSurveyReminder(user, userSurvey, now)
+}
+
+// sendSurveyReminder sends the user a DM reminding them to answer their survey.
+func (p *Plugin) sendSurveyReminder(user *model.User, userSurvey *userSurveyState, now time.Time) *model.AppError {
+	p.API.LogDebug("Sending survey reminder DM", "user_id", user.Id)
+
+	if _, err := p.CreateBotDMPost(user.Id, p.buildSurveyReminderPost(user)); err != nil {
+		return err
+	}
+
+	userSurvey.RemindedAt = now
+
+	return p.KVSet(fmt.Sprintf(USER_SURVEY_KEY, user.Id), userSurvey)
+}
+
+func (p *Plugin) buildSurveyReminderPost(user *model.User) *model.Post {
+	T := p.getUserTranslateFunc(user)
+
+	return &model.Post{
+		Type:    "custom_nps_reminder",
+		Message: T(surveyReminderBody, map[string]interface{}{"Username": user.Username}),
+	}
+}
+
+// expireSurvey closes the user's survey by replacing the survey post with a message saying that it has closed. The
+// survey is still closed if the user has deleted the survey post.
+func (p *Plugin) expireSurvey(user *model.User, userSurvey *userSurveyState, now time.Time) *model.AppError {
+	p.API.LogDebug("Closing expired survey", "user_id", user.Id)
+
+	if userSurvey.ScorePostId != "" {
+		post, err := p.API.GetPost(userSurvey.ScorePostId)
+		if err != nil && err.StatusCode != http.StatusNotFound {
+			return err
+		}
+
+		if post != nil && post.DeleteAt == 0 {
+			closed := p.buildSurveyClosedPost(user)
+			post.Type = closed.Type
+			post.Message = closed.Message
+			post.Props = closed.Props
+
+			if _, err := p.API.UpdatePost(post); err != nil {
+				return err
+			}
+		}
+	}
+
+	userSurvey.ExpiredAt = now
+
+	return p.KVSet(fmt.Sprintf(USER_SURVEY_KEY, user.Id), userSurvey)
+}
+
+func (p *Plugin) buildSurveyClosedPost(user *model.User) *model.Post {
+	T := p.getUserTranslateFunc(user)
+
+	return &model.Post{
+		Type:    "custom_nps_closed",
+		Message: T(surveyClosedBody),
+		Props:   map[string]interface{}{},
+	}
+}
+
+// isSurveyClosed returns whether or not the survey sent to the user has expired, meaning that it should no longer
+// accept answers.
+func (p *Plugin) isSurveyClosed(userSurvey *userSurveyState) bool {
+	if !userSurvey.ExpiredAt.IsZero() {
+		// The survey stays closed even if expiry has since been disabled
+		return true
+	}
+
+	expiry := p.getConfiguration().getSurveyExpiry()
+	if expiry == 0 {
+		// Surveys don't expire
+		return false
+	}
+
+	return userSurvey.isExpired(expiry, p.now().UTC())
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestUserSurveyStateIsExpired(t *testing.T) {
+	now := toDate(2019, time.March, 15)
+
+	t.Run("should never expire when expiry is disabled", func(t *testing.T) {
+		s := &userSurveyState{SentAt: now.Add(-365 * 24 * time.Hour)}
+
+		assert.False(t, s.isExpired(0, now))
+	})
+
+	t.Run("should expire unanswered surveys after the expiry", func(t *testing.T) {
+		s := &userSurveyState{SentAt: now.Add(-7 * 24 * time.Hour)}
+
+		assert.False(t, s.isExpired(8*24*time.Hour, now))
+		assert.True(t, s.isExpired(7*24*time.Hour, now))
+	})
+
+	t.Run("should not expire answered surveys", func(t *testing.T) {
+		s := &userSurveyState{SentAt: now.Add(-7 * 24 * time.Hour), AnsweredAt: now.Add(-6 * 24 * time.Hour)}
+
+		assert.False(t, s.isExpired(24*time.Hour, now))
+	})
+
+	t.Run("should stay expired once closed", func(t *testing.T) {
+		s := &userSurveyState{SentAt: now.Add(-7 * 24 * time.Hour), ExpiredAt: now.Add(-time.Hour)}
+
+		assert.True(t, s.isExpired(0, now))
+	})
+}
+
+func TestUserSurveyStateIsFollowUpDue(t *testing.T) {
+	now := toDate(2019, time.March, 15)
+	day := 24 * time.Hour
+
+	t.Run("should be due once a reminder should be sent", func(t *testing.T) {
+		s := &userSurveyState{SentAt: now.Add(-3 * day)}
+
+		assert.False(t, s.isFollowUpDue(0, 0, now))
+		assert.False(t, s.isFollowUpDue(0, 4*day, now))
+		assert.True(t, s.isFollowUpDue(0, 3*day, now))
+	})
+
+	t.Run("should be due once the survey should be closed", func(t *testing.T) {
+		s := &userSurveyState{SentAt: now.Add(-7 * day), RemindedAt: now.Add(-4 * day)}
+
+		assert.False(t, s.isFollowUpDue(8*day, 3*day, now))
+		assert.True(t, s.isFollowUpDue(7*day, 3*day, now))
+	})
+
+	t.Run("should not be due once the survey is answered or closed", func(t *testing.T) {
+		answered := &userSurveyState{SentAt: now.Add(-7 * day), AnsweredAt: now.Add(-6 * day)}
+		closed := &userSurveyState{SentAt: now.Add(-7 * day), ExpiredAt: now.Add(-time.Hour)}
+
+		assert.False(t, answered.isFollowUpDue(day, day, now))
+		assert.False(t, closed.isFollowUpDue(day, day, now))
+	})
+}
+
+func TestIsSurveyClosed(t *testing.T) {
+	now := toDate(2019, time.March, 15)
+
+	makePlugin := func(config *configuration) *Plugin {
+		return &Plugin{
+			configuration: config,
+			now: func() time.Time {
+				return now
+			},
+		}
+	}
+
+	t.Run("should not close surveys when expiry is disabled", func(t *testing.T) {
+		s := &userSurveyState{SentAt: now.Add(-365 * 24 * time.Hour)}
+
+		assert.False(t, makePlugin(&configuration{}).isSurveyClosed(s))
+	})
+
+	t.Run("should close surveys once they expire", func(t *testing.T) {
+		s := &userSurveyState{SentAt: now.Add(-7 * 24 * time.Hour)}
+
+		assert.True(t, makePlugin(&configuration{SurveyExpiryDays: 7}).isSurveyClosed(s))
+	})
+
+	t.Run("should keep surveys closed after expiry is disabled", func(t *testing.T) {
+		s := &userSurveyState{SentAt: now.Add(-7 * 24 * time.Hour), ExpiredAt: now.Add(-time.Hour)}
+
+		assert.True(t, makePlugin(&configuration{}).isSurveyClosed(s))
+	})
+}
+
+func TestCheckForUnansweredSurvey(t *testing.T) {
+	botUserID := model.NewId()
+	user := &model.User{Id: model.NewId(), Username: "user"}
+	userSurveyKey := fmt.Sprintf(USER_SURVEY_KEY, user.Id)
+	postID := model.NewId()
+
+	now := toDate(2019, time.March, 15)
+
+	makePlugin := func(api *plugintest.API, config *configuration) *Plugin {
+		p := &Plugin{
+			botUserID:     botUserID,
+			configuration: config,
+		}
+		p.SetAPI(api)
+
+		return p
+	}
+
+	t.Run("should send a reminder once enough time has passed", func(t *testing.T) {
+		userSurvey := &userSurveyState{
+			ServerVersion: "5.12.0",
+			SentAt:        now.Add(-3 * 24 * time.Hour),
+			ScorePostId:   postID,
+		}
+
+		api := makeAPIMock()
+		api.On("GetDirectChannel", user.Id, botUserID).Return(&model.Channel{Id: "channel"}, nil)
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.Type == "custom_nps_reminder" && post.ChannelId == "channel"
+		})).Return(&model.Post{}, nil)
+		api.On("KVSet", userSurveyKey, mustMarshalJSON(&userSurveyState{
+			ServerVersion: "5.12.0",
+			SentAt:        now.Add(-3 * 24 * time.Hour),
+			ScorePostId:   postID,
+			RemindedAt:    now,
+		})).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api, &configuration{SurveyReminderDays: 3, SurveyExpiryDays: 7})
+
+		err := p.checkForUnansweredSurvey(user, userSurvey, now)
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("should not send a reminder too early", func(t *testing.T) {
+		userSurvey := &userSurveyState{
+			SentAt: now.Add(-2 * 24 * time.Hour),
+		}
+
+		api := makeAPIMock()
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api, &configuration{SurveyReminderDays: 3})
+
+		err := p.checkForUnansweredSurvey(user, userSurvey, now)
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("should only send one reminder", func(t *testing.T) {
+		userSurvey := &userSurveyState{
+			SentAt:     now.Add(-5 * 24 * time.Hour),
+			RemindedAt: now.Add(-2 * 24 * time.Hour),
+		}
+
+		api := makeAPIMock()
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api, &configuration{SurveyReminderDays: 3})
+
+		err := p.checkForUnansweredSurvey(user, userSurvey, now)
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("should not remind users who have answered", func(t *testing.T) {
+		userSurvey := &userSurveyState{
+			SentAt:     now.Add(-5 * 24 * time.Hour),
+			AnsweredAt: now.Add(-4 * 24 * time.Hour),
+		}
+
+		api := makeAPIMock()
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api, &configuration{SurveyReminderDays: 3, SurveyExpiryDays: 4})
+
+		err := p.checkForUnansweredSurvey(user, userSurvey, now)
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("should close the survey post once expired", func(t *testing.T) {
+		userSurvey := &userSurveyState{
+			ServerVersion: "5.12.0",
+			SentAt:        now.Add(-7 * 24 * time.Hour),
+			ScorePostId:   postID,
+			RemindedAt:    now.Add(-4 * 24 * time.Hour),
+		}
+
+		api := makeAPIMock()
+		api.On("GetPost", postID).Return(&model.Post{
+			Id:      postID,
+			Type:    "custom_nps_survey",
+			Message: "survey",
+			Props: map[string]interface{}{
+				"attachments": []*model.SlackAttachment{{}},
+			},
+		}, nil)
+		api.On("UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.Id == postID && post.Type == "custom_nps_closed" &&
+				post.Message == defaultTranslations[surveyClosedBody] && post.Props["attachments"] == nil
+		})).Return(&model.Post{}, nil)
+		api.On("KVSet", userSurveyKey, mustMarshalJSON(&userSurveyState{
+			ServerVersion: "5.12.0",
+			SentAt:        now.Add(-7 * 24 * time.Hour),
+			ScorePostId:   postID,
+			RemindedAt:    now.Add(-4 * 24 * time.Hour),
+			ExpiredAt:     now,
+		})).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api, &configuration{SurveyReminderDays: 3, SurveyExpiryDays: 7})
+
+		err := p.checkForUnansweredSurvey(user, userSurvey, now)
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("should still close the survey if the user deleted the survey post", func(t *testing.T) {
+		userSurvey := &userSurveyState{
+			SentAt:      now.Add(-7 * 24 * time.Hour),
+			ScorePostId: postID,
+		}
+
+		api := makeAPIMock()
+		api.On("GetPost", postID).Return(nil, &model.AppError{StatusCode: http.StatusNotFound})
+		api.On("KVSet", userSurveyKey, mustMarshalJSON(&userSurveyState{
+			SentAt:      now.Add(-7 * 24 * time.Hour),
+			ScorePostId: postID,
+			ExpiredAt:   now,
+		})).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api, &configuration{SurveyExpiryDays: 7})
+
+		err := p.checkForUnansweredSurvey(user, userSurvey, now)
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("should return error if unable to update the survey post", func(t *testing.T) {
+		userSurvey := &userSurveyState{
+			SentAt:      now.Add(-7 * 24 * time.Hour),
+			ScorePostId: postID,
+		}
+
+		api := makeAPIMock()
+		api.On("GetPost", postID).Return(nil, &model.AppError{})
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api, &configuration{SurveyExpiryDays: 7})
+
+		err := p.checkForUnansweredSurvey(user, userSurvey, now)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestSubmitScoreAfterExpiry(t *testing.T) {
+	userID := model.NewId()
+	postID := model.NewId()
+	userSurveyKey := fmt.Sprintf(USER_SURVEY_KEY, userID)
+
+	now := toDate(2019, time.March, 15)
+
+	makeRequest := func() *http.Request {
+		request := httptest.NewRequest(http.MethodPost, "/score", bytes.NewReader(mustMarshalJSON(&model.PostActionIntegrationRequest{
+			PostId: postID,
+			Context: makeSignedContext(userID, "5.12.0", map[string]interface{}{
+				"selected_option": "10",
+			}),
+		})))
+		request.Header.Set("Mattermost-User-ID", userID)
+
+		return request
+	}
+
+	t.Run("should reject answers to an expired survey", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("GetUser", userID).Return(&model.User{Id: userID}, nil)
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: "5.12.0",
+			SentAt:        now.Add(-8 * 24 * time.Hour),
+			ScorePostId:   postID,
+		}), nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			configuration: &configuration{SurveyExpiryDays: 7},
+			now: func() time.Time {
+				return now
+			},
+		}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+
+		p.submitScore(recorder, makeRequest())
+
+		var response *model.PostActionIntegrationResponse
+		json.NewDecoder(recorder.Result().Body).Decode(&response)
+
+		assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+		assert.Equal(t, defaultTranslations[surveyClosedError], response.EphemeralText)
+		assert.Equal(t, defaultTranslations[surveyClosedBody], response.Update.Message)
+	})
+
+	t.Run("should reject answers to a closed survey", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("GetUser", userID).Return(&model.User{Id: userID}, nil)
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: "5.12.0",
+			SentAt:        now.Add(-8 * 24 * time.Hour),
+			ScorePostId:   postID,
+			ExpiredAt:     now.Add(-24 * time.Hour),
+		}), nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			configuration: &configuration{SurveyExpiryDays: 7},
+			now: func() time.Time {
+				return now
+			},
+		}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+
+		p.submitScore(recorder, makeRequest())
+
+		var response *model.PostActionIntegrationResponse
+		json.NewDecoder(recorder.Result().Body).Decode(&response)
+
+		assert.Equal(t, defaultTranslations[surveyClosedError], response.EphemeralText)
+	})
+}
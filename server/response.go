@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+const (
+	// RESPONSE_KEY is used to store the surveyResponse containing a user's answers to an NPS survey. It should contain
+	// the survey's ID and the user's ID like "Response-5.10.0-abc123". Since the survey ID may itself contain dashes,
+	// check surveyResponse.getSurveyID when matching on it.
+	RESPONSE_KEY = "Response-%s-%s"
+)
+
+// surveyResponse is the locally stored copy of everything that a user has submitted for a single NPS survey.
+type surveyResponse struct {
+	// SurveyID matches the ID of the surveyState that the user is responding to. Like surveyState.ID, it's empty for
+	// surveys scheduled after a server upgrade.
+	SurveyID string `json:"survey_id,omitempty"`
+
+	UserID        string           `json:"user_id"`
+	ServerVersion string           `json:"server_version"`
+	UserRole      string           `json:"user_role"`
+	UserCreateAt  int64            `json:"user_create_at"`
+	LicenseSKU    string           `json:"license_sku"`
+	CreateAt      time.Time        `json:"create_at"`
+	Score         int              `json:"score"`
+	ScoreAt       time.Time        `json:"score_at"`
+	Feedback      []*feedbackEntry `json:"feedback"`
+
+	// ScoreBucket is the SCORE_BUCKET_* group that the score falls into. It's empty if the user hasn't given a score.
+	ScoreBucket string `json:"score_bucket,omitempty"`
+
+	// ScoreHistory contains the scores that the user gave before changing it to Score, oldest first.
+	ScoreHistory []*scoreRevision `json:"score_history,omitempty"`
+
+	// Answers contains the user's answers to any questions other than the NPS question keyed by question ID.
+	Answers map[string]*surveyAnswer `json:"answers,omitempty"`
+
+	// Anonymous is true when the response was stored while surveys were anonymous. UserID contains the user's
+	// anonymous ID, and the user's role and account age aren't recorded.
+	Anonymous bool `json:"anonymous,omitempty"`
+
+	// previousUserID is set when a response stored under the user's actual ID is being moved to their anonymous ID.
+	// The old copy is deleted once the response is saved.
+	previousUserID string
+}
+
+type surveyAnswer struct {
+	Value    string    `json:"value"`
+	AnswerAt time.Time `json:"answer_at"`
+
+	// RedactionCount is the number of pieces of personal information that were removed from a free text answer.
+	RedactionCount int `json:"redaction_count,omitempty"`
+}
+
+type scoreRevision struct {
+	Score   int       `json:"score"`
+	ScoreAt time.Time `json:"score_at"`
+}
+
+type feedbackEntry struct {
+	Message  string    `json:"message"`
+	CreateAt time.Time `json:"create_at"`
+
+	// RedactionCount is the number of pieces of personal information that were removed from the message.
+	RedactionCount int `json:"redaction_count,omitempty"`
+}
+
+// getSurveyID returns the unique identifier of the survey that the user is responding to.
+func (r *surveyResponse) getSurveyID() string {
+	if r.SurveyID != "" {
+		return r.SurveyID
+	}
+
+	return r.ServerVersion
+}
+
+// hasScore returns whether or not the user has submitted a score. A score of 0 is valid, so ScoreAt is used instead.
+func (r *surveyResponse) hasScore() bool {
+	return !r.ScoreAt.IsZero()
+}
+
+// getFirstScoreAt returns when the user first gave a score, even if they've changed it since.
+func (r *surveyResponse) getFirstScoreAt() time.Time {
+	if len(r.ScoreHistory) > 0 {
+		return r.ScoreHistory[0].ScoreAt
+	}
+
+	return r.ScoreAt
+}
+
+// getScoreBucket returns the SCORE_BUCKET_* group that the user's score falls into, or an empty string if they haven't
+// given a score. Responses stored before buckets were recorded have it calculated from their score.
+func (r *surveyResponse) getScoreBucket() string {
+	if !r.hasScore() {
+		return ""
+	}
+
+	if r.ScoreBucket != "" {
+		return r.ScoreBucket
+	}
+
+	return getScoreBucket(r.Score)
+}
+
+// getAnswer returns the user's answer to the given question and whether or not they've answered it. It's safe to call
+// on a nil response.
+func (r *surveyResponse) getAnswer(questionID string) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+
+	if questionID == NPS_QUESTION_ID {
+		return strconv.Itoa(r.Score), r.hasScore()
+	}
+
+	answer, ok := r.Answers[questionID]
+	if !ok {
+		return "", false
+	}
+
+	return answer.Value, true
+}
+
+// setAnswer records the user's answer to the given question. Answers to the NPS question are recorded as the score, and
+// any previous score is kept in the score history. Returns false if the user picked the score that they already gave.
+func (r *surveyResponse) setAnswer(questionID string, answer string, now time.Time) bool {
+	if questionID == NPS_QUESTION_ID {
+		score, _ := strconv.Atoi(answer)
+
+		if r.hasScore() {
+			if score == r.Score {
+				// The user picked the same score again
+				return false
+			}
+
+			r.ScoreHistory = append(r.ScoreHistory, &scoreRevision{
+				Score:   r.Score,
+				ScoreAt: r.ScoreAt,
+			})
+		}
+
+		r.Score = score
+		r.ScoreAt = now
+		r.ScoreBucket = getScoreBucket(r.Score)
+		return true
+	}
+
+	if r.Answers == nil {
+		r.Answers = make(map[string]*surveyAnswer)
+	}
+
+	r.Answers[questionID] = &surveyAnswer{
+		Value:    answer,
+		AnswerAt: now,
+	}
+
+	return true
+}
+
+// storeAnswer saves the user's answer to a question to their response for the last survey that they were sent and
+// returns the updated response and whether or not the answer changed. Free text answers are expected to have already
+// been redacted. The response is updated while holding the user's USER_LOCK_KEY so that answers submitted at the same
+// time, such as from two clients, don't overwrite each other's changes.
+func (p *Plugin) storeAnswer(user *model.User, questionID string, answer string, redactionCount int, now time.Time) (*surveyResponse, bool, *model.AppError) {
+	userLockKey := fmt.Sprintf(USER_LOCK_KEY, user.Id)
+
+	if locked, err := p.waitForLock(userLockKey, now); err != nil {
+		return nil, false, err
+	} else if !locked {
+		return nil, false, &model.AppError{Message: "Timed out waiting for another request from the user to finish"}
+	}
+	defer p.unlock(userLockKey)
+
+	response, err := p.getOrCreateSurveyResponse(user, now)
+	if err != nil {
+		return nil, false, err
+	}
+
+	changed := response.setAnswer(questionID, answer, now)
+	if stored, ok := response.Answers[questionID]; ok {
+		stored.RedactionCount = redactionCount
+	}
+
+	if err := p.saveSurveyResponse(response); err != nil {
+		return nil, false, err
+	}
+
+	return response, changed, nil
+}
+
+// storeFeedback adds a message from the user to their response for the last survey that they were sent. The message
+// is expected to have already been redacted. Returns whether or not this is the first feedback that they've given on
+// that survey.
+func (p *Plugin) storeFeedback(user *model.User, feedback string, redactionCount int, now time.Time) (bool, *model.AppError) {
+	response, err := p.getOrCreateSurveyResponse(user, now)
+	if err != nil {
+		return false, err
+	}
+
+	isFirstFeedback := len(response.Feedback) == 0
+
+	response.Feedback = append(response.Feedback, &feedbackEntry{
+		Message:        feedback,
+		CreateAt:       now,
+		RedactionCount: redactionCount,
+	})
+
+	if err := p.saveSurveyResponse(response); err != nil {
+		return false, err
+	}
+
+	return isFirstFeedback, nil
+}
+
+func (p *Plugin) getOrCreateSurveyResponse(user *model.User, now time.Time) (*surveyResponse, *model.AppError) {
+	var userSurvey *userSurveyState
+	if err := p.KVGet(fmt.Sprintf(USER_SURVEY_KEY, user.Id), &userSurvey); err != nil {
+		return nil, err
+	}
+
+	if userSurvey == nil {
+		// The user has messaged Surveybot without ever receiving a survey, so attribute it to the current version
+		userSurvey = &userSurveyState{
+			ServerVersion: p.serverVersion,
+		}
+	}
+
+	responseUserID := p.getResponseUserID(user.Id)
+
+	var response *surveyResponse
+	if err := p.KVGet(fmt.Sprintf(RESPONSE_KEY, userSurvey.getSurveyID(), responseUserID), &response); err != nil {
+		return nil, err
+	}
+
+	if response == nil && responseUserID != user.Id {
+		// The user may have responded before surveys were made anonymous, so move that response to avoid counting them
+		// twice
+		var identified *surveyResponse
+		if err := p.KVGet(fmt.Sprintf(RESPONSE_KEY, userSurvey.getSurveyID(), user.Id), &identified); err != nil {
+			return nil, err
+		}
+
+		if identified != nil {
+			response = p.anonymize(identified)
+			response.previousUserID = user.Id
+		}
+	}
+
+	if response == nil {
+		response = &surveyResponse{
+			SurveyID:      userSurvey.SurveyID,
+			UserID:        responseUserID,
+			ServerVersion: userSurvey.ServerVersion,
+			CreateAt:      now,
+		}
+
+		if responseUserID != user.Id {
+			response.Anonymous = true
+		} else {
+			response.UserRole = p.getUserRole(user)
+			response.UserCreateAt = user.CreateAt
+		}
+
+		if license := p.API.GetLicense(); license != nil {
+			response.LicenseSKU = license.SkuShortName
+		}
+	}
+
+	return response, nil
+}
+
+// saveSurveyResponse stores the response under its survey and user IDs.
+func (p *Plugin) saveSurveyResponse(response *surveyResponse) *model.AppError {
+	if err := p.KVSet(fmt.Sprintf(RESPONSE_KEY, response.getSurveyID(), response.UserID), response); err != nil {
+		return err
+	}
+
+	if response.previousUserID != "" {
+		if err := p.API.KVDelete(fmt.Sprintf(RESPONSE_KEY, response.getSurveyID(), response.previousUserID)); err != nil {
+			return err
+		}
+
+		response.previousUserID = ""
+	}
+
+	return nil
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreAnswer(t *testing.T) {
+	userID := model.NewId()
+	serverVersion := "5.10.0"
+	responseKey := fmt.Sprintf(RESPONSE_KEY, serverVersion, userID)
+	userSurveyKey := fmt.Sprintf(USER_SURVEY_KEY, userID)
+
+	now := toDate(2019, time.June, 1)
+
+	// The response is updated while holding the user's lock
+	makeAPIMock := func() *plugintest.API {
+		api := &plugintest.API{}
+		api.On("KVCompareAndSet", fmt.Sprintf(USER_LOCK_KEY, userID), []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVDelete", fmt.Sprintf(USER_LOCK_KEY, userID)).Return(nil)
+		return api
+	}
+
+	t.Run("should create a new response", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVGet", userSurveyKey).Return(nil, nil)
+		api.On("KVGet", responseKey).Return(nil, nil)
+		api.On("GetTeamMembersForUser", userID, 0, 50).Return([]*model.TeamMember{}, nil)
+		api.On("GetLicense").Return(&model.License{SkuShortName: "e20"})
+		api.On("KVSet", responseKey, mustMarshalJSON(&surveyResponse{
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			UserRole:      "user",
+			UserCreateAt:  1234,
+			LicenseSKU:    "e20",
+			CreateAt:      now,
+			Score:         0,
+			ScoreAt:       now,
+			ScoreBucket:   SCORE_BUCKET_DETRACTOR,
+		})).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			serverVersion: serverVersion,
+		}
+		p.SetAPI(api)
+
+		_, _, err := p.storeAnswer(&model.User{Id: userID, CreateAt: 1234}, NPS_QUESTION_ID, "0", 0, now)
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("should update an existing response", func(t *testing.T) {
+		existing := &surveyResponse{
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			UserRole:      "system_admin",
+			CreateAt:      now.Add(-time.Hour),
+			Feedback: []*feedbackEntry{
+				{
+					Message:  "Hello",
+					CreateAt: now.Add(-time.Hour),
+				},
+			},
+		}
+
+		api := makeAPIMock()
+		api.On("KVGet", userSurveyKey).Return(nil, nil)
+		api.On("KVGet", responseKey).Return(mustMarshalJSON(existing), nil)
+		api.On("KVSet", responseKey, mustMarshalJSON(&surveyResponse{
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			UserRole:      "system_admin",
+			CreateAt:      now.Add(-time.Hour),
+			Score:         8,
+			ScoreAt:       now,
+			ScoreBucket:   SCORE_BUCKET_PASSIVE,
+			Feedback:      existing.Feedback,
+		})).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			serverVersion: serverVersion,
+		}
+		p.SetAPI(api)
+
+		_, _, err := p.storeAnswer(&model.User{Id: userID}, NPS_QUESTION_ID, "8", 0, now)
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("should store the response for the survey that the user was sent", func(t *testing.T) {
+		surveyID := "5.10.0-dwh"
+		surveyResponseKey := fmt.Sprintf(RESPONSE_KEY, surveyID, userID)
+
+		api := makeAPIMock()
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{
+			SurveyID:      surveyID,
+			ServerVersion: serverVersion,
+		}), nil)
+		api.On("KVGet", surveyResponseKey).Return(nil, nil)
+		api.On("GetTeamMembersForUser", userID, 0, 50).Return([]*model.TeamMember{}, nil)
+		api.On("GetLicense").Return(nil)
+		api.On("KVSet", surveyResponseKey, mustMarshalJSON(&surveyResponse{
+			SurveyID:      surveyID,
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			UserRole:      "user",
+			CreateAt:      now,
+			Score:         7,
+			ScoreAt:       now,
+			ScoreBucket:   SCORE_BUCKET_PASSIVE,
+		})).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			serverVersion: "5.11.0",
+		}
+		p.SetAPI(api)
+
+		_, _, err := p.storeAnswer(&model.User{Id: userID}, NPS_QUESTION_ID, "7", 0, now)
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("should store answers to other questions separately from the score", func(t *testing.T) {
+		existing := &surveyResponse{
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			UserRole:      "user",
+			CreateAt:      now.Add(-time.Hour),
+			Score:         8,
+			ScoreAt:       now.Add(-time.Hour),
+		}
+
+		api := makeAPIMock()
+		api.On("KVGet", userSurveyKey).Return(nil, nil)
+		api.On("KVGet", responseKey).Return(mustMarshalJSON(existing), nil)
+		api.On("KVSet", responseKey, mustMarshalJSON(&surveyResponse{
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			UserRole:      "user",
+			CreateAt:      now.Add(-time.Hour),
+			Score:         8,
+			ScoreAt:       now.Add(-time.Hour),
+			Answers: map[string]*surveyAnswer{
+				"remote": {
+					Value:    ANSWER_YES,
+					AnswerAt: now,
+				},
+			},
+		})).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			serverVersion: serverVersion,
+		}
+		p.SetAPI(api)
+
+		response, _, err := p.storeAnswer(&model.User{Id: userID}, "remote", ANSWER_YES, 0, now)
+
+		require.Nil(t, err)
+
+		answer, answered := response.getAnswer("remote")
+		assert.True(t, answered)
+		assert.Equal(t, ANSWER_YES, answer)
+	})
+
+	t.Run("should store the response anonymously when surveys are anonymous", func(t *testing.T) {
+		p := &Plugin{
+			serverVersion: serverVersion,
+			configuration: &configuration{AnonymousSurveys: true},
+			anonymousSalt: []byte("salt"),
+		}
+		anonymousID := p.getAnonymousID(userID)
+		anonymousKey := fmt.Sprintf(RESPONSE_KEY, serverVersion, anonymousID)
+
+		api := makeAPIMock()
+		api.On("KVGet", userSurveyKey).Return(nil, nil)
+		api.On("KVGet", anonymousKey).Return(nil, nil)
+		api.On("KVGet", responseKey).Return(nil, nil)
+		api.On("GetLicense").Return(&model.License{SkuShortName: "e20"})
+		api.On("KVSet", anonymousKey, mustMarshalJSON(&surveyResponse{
+			UserID:        anonymousID,
+			ServerVersion: serverVersion,
+			LicenseSKU:    "e20",
+			CreateAt:      now,
+			Score:         9,
+			ScoreAt:       now,
+			ScoreBucket:   SCORE_BUCKET_PROMOTER,
+			Anonymous:     true,
+		})).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p.SetAPI(api)
+
+		_, _, err := p.storeAnswer(&model.User{Id: userID, CreateAt: 1234}, NPS_QUESTION_ID, "9", 0, now)
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("should move a response given before surveys were anonymous", func(t *testing.T) {
+		p := &Plugin{
+			serverVersion: serverVersion,
+			configuration: &configuration{AnonymousSurveys: true},
+			anonymousSalt: []byte("salt"),
+		}
+		anonymousID := p.getAnonymousID(userID)
+		anonymousKey := fmt.Sprintf(RESPONSE_KEY, serverVersion, anonymousID)
+
+		api := &plugintest.API{}
+		api.On("KVGet", userSurveyKey).Return(nil, nil)
+		api.On("KVGet", anonymousKey).Return(nil, nil)
+		api.On("KVGet", responseKey).Return(mustMarshalJSON(&surveyResponse{
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			UserRole:      "user",
+			UserCreateAt:  1234,
+			CreateAt:      now.Add(-time.Hour),
+			Score:         3,
+			ScoreAt:       now.Add(-time.Hour),
+		}), nil)
+		api.On("KVSet", anonymousKey, mustMarshalJSON(&surveyResponse{
+			UserID:        anonymousID,
+			ServerVersion: serverVersion,
+			CreateAt:      now.Add(-time.Hour),
+			Score:         3,
+			ScoreAt:       now.Add(-time.Hour),
+			Feedback: []*feedbackEntry{
+				{
+					Message:  "Feedback",
+					CreateAt: now,
+				},
+			},
+			Anonymous: true,
+		})).Return(nil)
+		api.On("KVDelete", responseKey).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p.SetAPI(api)
+
+		_, err := p.storeFeedback(&model.User{Id: userID, CreateAt: 1234}, "Feedback", 0, now)
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("should return an error if unable to lock the user", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVCompareAndSet", fmt.Sprintf(USER_LOCK_KEY, userID), []byte(nil), mustMarshalJSON(now)).Return(false, &model.AppError{})
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			serverVersion: serverVersion,
+		}
+		p.SetAPI(api)
+
+		_, _, err := p.storeAnswer(&model.User{Id: userID}, NPS_QUESTION_ID, "8", 0, now)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("should return an error if unable to get the existing response", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVGet", userSurveyKey).Return(nil, nil)
+		api.On("KVGet", responseKey).Return(nil, &model.AppError{})
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			serverVersion: serverVersion,
+		}
+		p.SetAPI(api)
+
+		_, _, err := p.storeAnswer(&model.User{Id: userID}, NPS_QUESTION_ID, "8", 0, now)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestStoreFeedback(t *testing.T) {
+	userID := model.NewId()
+	serverVersion := "5.10.0"
+	responseKey := fmt.Sprintf(RESPONSE_KEY, serverVersion, userID)
+	userSurveyKey := fmt.Sprintf(USER_SURVEY_KEY, userID)
+
+	now := toDate(2019, time.June, 1)
+
+	t.Run("should append feedback to an existing response", func(t *testing.T) {
+		existing := &surveyResponse{
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			UserRole:      "user",
+			CreateAt:      now.Add(-time.Hour),
+			Score:         9,
+			ScoreAt:       now.Add(-time.Hour),
+			Feedback: []*feedbackEntry{
+				{
+					Message:  "First",
+					CreateAt: now.Add(-time.Minute),
+				},
+			},
+		}
+
+		api := &plugintest.API{}
+		api.On("KVGet", userSurveyKey).Return(nil, nil)
+		api.On("KVGet", responseKey).Return(mustMarshalJSON(existing), nil)
+		api.On("KVSet", responseKey, mustMarshalJSON(&surveyResponse{
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			UserRole:      "user",
+			CreateAt:      now.Add(-time.Hour),
+			Score:         9,
+			ScoreAt:       now.Add(-time.Hour),
+			Feedback: []*feedbackEntry{
+				{
+					Message:  "First",
+					CreateAt: now.Add(-time.Minute),
+				},
+				{
+					Message:        "Second",
+					CreateAt:       now,
+					RedactionCount: 1,
+				},
+			},
+		})).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			serverVersion: serverVersion,
+		}
+		p.SetAPI(api)
+
+		isFirstFeedback, err := p.storeFeedback(&model.User{Id: userID}, "Second", 1, now)
+
+		assert.False(t, isFirstFeedback)
+		assert.Nil(t, err)
+	})
+
+	t.Run("should report the first feedback on a response", func(t *testing.T) {
+		existing := &surveyResponse{
+			UserID:        userID,
+			ServerVersion: serverVersion,
+			UserRole:      "user",
+			CreateAt:      now.Add(-time.Hour),
+			Score:         9,
+			ScoreAt:       now.Add(-time.Hour),
+		}
+
+		api := &plugintest.API{}
+		api.On("KVGet", userSurveyKey).Return(nil, nil)
+		api.On("KVGet", responseKey).Return(mustMarshalJSON(existing), nil)
+		api.On("KVSet", responseKey, mock.Anything).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			serverVersion: serverVersion,
+		}
+		p.SetAPI(api)
+
+		isFirstFeedback, err := p.storeFeedback(&model.User{Id: userID}, "First", 0, now)
+
+		assert.True(t, isFirstFeedback)
+		assert.Nil(t, err)
+	})
+}
+
+func TestSurveyResponseHasScore(t *testing.T) {
+	assert.False(t, (&surveyResponse{}).hasScore())
+	assert.True(t, (&surveyResponse{ScoreAt: toDate(2019, time.June, 1)}).hasScore())
+}
+
+func TestSurveyResponseGetAnswer(t *testing.T) {
+	var nilResponse *surveyResponse
+	_, answered := nilResponse.getAnswer(NPS_QUESTION_ID)
+	assert.False(t, answered)
+
+	response := &surveyResponse{}
+	_, answered = response.getAnswer(NPS_QUESTION_ID)
+	assert.False(t, answered)
+
+	response.setAnswer(NPS_QUESTION_ID, "0", toDate(2019, time.June, 1))
+	answer, answered := response.getAnswer(NPS_QUESTION_ID)
+	assert.True(t, answered)
+	assert.Equal(t, "0", answer)
+
+	response.setAnswer("team", "Engineering", toDate(2019, time.June, 1))
+	answer, answered = response.getAnswer("team")
+	assert.True(t, answered)
+	assert.Equal(t, "Engineering", answer)
+}
+
+func TestSurveyResponseSetScore(t *testing.T) {
+	first := toDate(2019, time.June, 1)
+	second := toDate(2019, time.June, 2)
+	third := toDate(2019, time.June, 3)
+
+	response := &surveyResponse{}
+	response.setAnswer(NPS_QUESTION_ID, "3", first)
+
+	assert.Equal(t, 3, response.Score)
+	assert.Empty(t, response.ScoreHistory)
+	assert.Equal(t, first, response.getFirstScoreAt())
+
+	t.Run("should keep earlier scores as history", func(t *testing.T) {
+		assert.True(t, response.setAnswer(NPS_QUESTION_ID, "7", second))
+
+		assert.Equal(t, 7, response.Score)
+		assert.Equal(t, second, response.ScoreAt)
+		assert.Equal(t, SCORE_BUCKET_PASSIVE, response.ScoreBucket)
+		assert.Equal(t, []*scoreRevision{{Score: 3, ScoreAt: first}}, response.ScoreHistory)
+		assert.Equal(t, first, response.getFirstScoreAt())
+	})
+
+	t.Run("should ignore the same score being picked again", func(t *testing.T) {
+		assert.False(t, response.setAnswer(NPS_QUESTION_ID, "7", third))
+
+		assert.Equal(t, second, response.ScoreAt)
+		assert.Len(t, response.ScoreHistory, 1)
+	})
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+const (
+	// Scores at or above PROMOTER_MIN_SCORE are from promoters
+	PROMOTER_MIN_SCORE = 9
+
+	// Scores at or below DETRACTOR_MAX_SCORE are from detractors. Anything between this and PROMOTER_MIN_SCORE is
+	// from a passive.
+	DETRACTOR_MAX_SCORE = 6
+
+	// The groups that NPS scores fall into. These are recorded with each response so that feedback can be analyzed
+	// separately for each group.
+	SCORE_BUCKET_PROMOTER  = "promoter"
+	SCORE_BUCKET_PASSIVE   = "passive"
+	SCORE_BUCKET_DETRACTOR = "detractor"
+)
+
+// getScoreBucket returns which of the SCORE_BUCKET_* groups that a score falls into.
+func getScoreBucket(score int) string {
+	if score >= PROMOTER_MIN_SCORE {
+		return SCORE_BUCKET_PROMOTER
+	} else if score <= DETRACTOR_MAX_SCORE {
+		return SCORE_BUCKET_DETRACTOR
+	}
+
+	return SCORE_BUCKET_PASSIVE
+}
+
+// surveyResults summarizes the responses to a single NPS survey.
+type surveyResults struct {
+	SurveyID      string    `json:"survey_id"`
+	ServerVersion string    `json:"server_version"`
+	StartAt       time.Time `json:"start_at"`
+
+	Promoters  int     `json:"promoters"`
+	Passives   int     `json:"passives"`
+	Detractors int     `json:"detractors"`
+	NPS        float64 `json:"nps"`
+
+	// Histogram contains the number of times that each score was given, indexed by score.
+	Histogram []int `json:"histogram"`
+
+	Sent         int     `json:"sent"`
+	Answered     int     `json:"answered"`
+	ResponseRate float64 `json:"response_rate"`
+
+	// Withheld is true when surveys are anonymous and too few scores have been given to show them without risking
+	// identifying who gave them. The score counts are left empty, and MinGroupSize is the number of scores needed.
+	Withheld     bool `json:"withheld,omitempty"`
+	MinGroupSize int  `json:"min_group_size,omitempty"`
+}
+
+func newSurveyResults(survey *surveyState) *surveyResults {
+	return &surveyResults{
+		SurveyID:      survey.getID(),
+		ServerVersion: survey.ServerVersion,
+		StartAt:       survey.StartAt,
+		Histogram:     make([]int, 11),
+	}
+}
+
+func (r *surveyResults) addScore(score int) {
+	r.Histogram[score] += 1
+
+	switch getScoreBucket(score) {
+	case SCORE_BUCKET_PROMOTER:
+		r.Promoters += 1
+	case SCORE_BUCKET_DETRACTOR:
+		r.Detractors += 1
+	default:
+		r.Passives += 1
+	}
+}
+
+// hasScores returns whether or not anyone has given a score to the survey.
+func (r *surveyResults) hasScores() bool {
+	return r.Promoters+r.Passives+r.Detractors > 0
+}
+
+// describe returns a human readable name for the survey.
+func (r *surveyResults) describe() string {
+	return describeSurvey(r.SurveyID, r.ServerVersion)
+}
+
+// calculate fills in the fields computed from the raw counts.
+func (r *surveyResults) calculate() {
+	if total := r.Promoters + r.Passives + r.Detractors; total > 0 {
+		r.NPS = float64(r.Promoters-r.Detractors) / float64(total) * 100
+	}
+
+	if r.Sent > 0 {
+		r.ResponseRate = float64(r.Answered) / float64(r.Sent)
+	}
+}
+
+// withhold hides the scores from the results when there are fewer than minGroupSize of them.
+func (r *surveyResults) withhold(minGroupSize int) {
+	if r.Promoters+r.Passives+r.Detractors >= minGroupSize {
+		return
+	}
+
+	r.Promoters = 0
+	r.Passives = 0
+	r.Detractors = 0
+	r.NPS = 0
+	r.Histogram = make([]int, 11)
+
+	r.Withheld = true
+	r.MinGroupSize = minGroupSize
+}
+
+// formatSurveyResults returns a Markdown summary of the given results.
+func formatSurveyResults(results *surveyResults) string {
+	if results.Withheld {
+		return fmt.Sprintf(`#### Net Promoter Score survey results for %s
+
+Results are hidden until at least %d users have given a score to keep the survey anonymous.
+
+| Surveys Sent | Surveys Answered | Response Rate |
+|:-------------|:-----------------|:--------------|
+| %d | %d | %.0f%% |`,
+			results.describe(),
+			results.MinGroupSize,
+			results.Sent,
+			results.Answered,
+			results.ResponseRate*100,
+		)
+	}
+
+	return fmt.Sprintf(`#### Net Promoter Score survey results for %s
+
+| NPS | Promoters | Passives | Detractors | Surveys Sent | Surveys Answered | Response Rate |
+|:----|:----------|:---------|:-----------|:-------------|:-----------------|:--------------|
+| %.1f | %d | %d | %d | %d | %d | %.0f%% |`,
+		results.describe(),
+		results.NPS,
+		results.Promoters,
+		results.Passives,
+		results.Detractors,
+		results.Sent,
+		results.Answered,
+		results.ResponseRate*100,
+	)
+}
+
+// getSurveyResults computes the results of an NPS survey. The survey can be identified either by its ID or by a server
+// version, in which case the latest survey for that version is used. Returns nil if no such survey has been scheduled.
+//
+// Note that the number of surveys sent is based on each user's userSurveyState, so it only includes users who haven't
+// received a later survey since then. When surveys are anonymous, the scores are withheld until enough have been given.
+func (p *Plugin) getSurveyResults(surveyID string) (*surveyResults, *model.AppError) {
+	var survey *surveyState
+	if err := p.KVGet(fmt.Sprintf(SURVEY_KEY, surveyID), &survey); err != nil {
+		return nil, err
+	}
+
+	if survey == nil {
+		return nil, nil
+	}
+
+	surveyID = survey.getID()
+	results := newSurveyResults(survey)
+
+	err := p.forEachKey(fmt.Sprintf(RESPONSE_KEY, surveyID, ""), func(key string) *model.AppError {
+		var response *surveyResponse
+		if err := p.KVGet(key, &response); err != nil {
+			return err
+		}
+
+		if response != nil && response.getSurveyID() == surveyID && response.hasScore() {
+			results.addScore(response.Score)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.forEachKey(getKeyPrefix(USER_SURVEY_KEY), func(key string) *model.AppError {
+		var userSurvey *userSurveyState
+		if err := p.KVGet(key, &userSurvey); err != nil {
+			return err
+		}
+
+		if userSurvey == nil || userSurvey.getSurveyID() != surveyID {
+			return nil
+		}
+
+		results.Sent += 1
+
+		if !userSurvey.AnsweredAt.IsZero() {
+			results.Answered += 1
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results.calculate()
+
+	if config := p.getConfiguration(); config.AnonymousSurveys {
+		results.withhold(config.getMinAnonymousGroupSize())
+	}
+
+	return results, nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSurveyResults(t *testing.T) {
+	serverVersion := "5.10.0"
+	startAt := toDate(2019, time.June, 1)
+
+	user1 := model.NewId()
+	user2 := model.NewId()
+	user3 := model.NewId()
+	user4 := model.NewId()
+
+	t.Run("should return nil when no survey has been scheduled", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVGet", fmt.Sprintf(SURVEY_KEY, serverVersion)).Return(nil, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		results, err := p.getSurveyResults(serverVersion)
+
+		assert.Nil(t, results)
+		assert.Nil(t, err)
+	})
+
+	t.Run("should compute results from stored responses", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVGet", fmt.Sprintf(SURVEY_KEY, serverVersion)).Return(mustMarshalJSON(&surveyState{
+			ServerVersion: serverVersion,
+			StartAt:       startAt,
+		}), nil)
+		api.On("KVList", 0, 100).Return([]string{
+			fmt.Sprintf(SURVEY_KEY, serverVersion),
+			fmt.Sprintf(RESPONSE_KEY, serverVersion, user1),
+			fmt.Sprintf(RESPONSE_KEY, serverVersion, user2),
+			fmt.Sprintf(RESPONSE_KEY, serverVersion, user3),
+			fmt.Sprintf(RESPONSE_KEY, "5.9.0", user1),
+			fmt.Sprintf(USER_SURVEY_KEY, user1),
+			fmt.Sprintf(USER_SURVEY_KEY, user2),
+			fmt.Sprintf(USER_SURVEY_KEY, user3),
+			fmt.Sprintf(USER_SURVEY_KEY, user4),
+			fmt.Sprintf(USER_LOCK_KEY, user1),
+		}, nil)
+		api.On("KVGet", fmt.Sprintf(RESPONSE_KEY, serverVersion, user1)).Return(mustMarshalJSON(&surveyResponse{
+			ServerVersion: serverVersion,
+			Score:         10,
+			ScoreAt:       startAt,
+		}), nil)
+		api.On("KVGet", fmt.Sprintf(RESPONSE_KEY, serverVersion, user2)).Return(mustMarshalJSON(&surveyResponse{
+			ServerVersion: serverVersion,
+			Score:         3,
+			ScoreAt:       startAt,
+		}), nil)
+		api.On("KVGet", fmt.Sprintf(RESPONSE_KEY, serverVersion, user3)).Return(mustMarshalJSON(&surveyResponse{
+			ServerVersion: serverVersion,
+			Feedback:      []*feedbackEntry{{Message: "No score"}},
+		}), nil)
+		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, user1)).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			AnsweredAt:    startAt,
+		}), nil)
+		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, user2)).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			AnsweredAt:    startAt,
+		}), nil)
+		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, user3)).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+		}), nil)
+		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, user4)).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: "5.9.0",
+		}), nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		results, err := p.getSurveyResults(serverVersion)
+
+		require.Nil(t, err)
+		assert.Equal(t, &surveyResults{
+			SurveyID:      serverVersion,
+			ServerVersion: serverVersion,
+			StartAt:       startAt,
+			Promoters:     1,
+			Passives:      0,
+			Detractors:    1,
+			NPS:           0,
+			Histogram:     []int{0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 1},
+			Sent:          3,
+			Answered:      2,
+			ResponseRate:  float64(2) / float64(3),
+		}, results)
+	})
+
+	t.Run("should only count responses for the requested survey when surveys are recurring", func(t *testing.T) {
+		surveyID := "5.10.0-dxc"
+
+		api := &plugintest.API{}
+		api.On("KVGet", fmt.Sprintf(SURVEY_KEY, surveyID)).Return(mustMarshalJSON(&surveyState{
+			ID:            surveyID,
+			ServerVersion: serverVersion,
+			StartAt:       startAt,
+		}), nil)
+		api.On("KVList", 0, 100).Return([]string{
+			fmt.Sprintf(RESPONSE_KEY, surveyID, user1),
+			fmt.Sprintf(USER_SURVEY_KEY, user1),
+			fmt.Sprintf(USER_SURVEY_KEY, user2),
+		}, nil)
+		api.On("KVGet", fmt.Sprintf(RESPONSE_KEY, surveyID, user1)).Return(mustMarshalJSON(&surveyResponse{
+			SurveyID:      surveyID,
+			ServerVersion: serverVersion,
+			Score:         8,
+			ScoreAt:       startAt,
+		}), nil)
+		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, user1)).Return(mustMarshalJSON(&userSurveyState{
+			SurveyID:      surveyID,
+			ServerVersion: serverVersion,
+			AnsweredAt:    startAt,
+		}), nil)
+		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, user2)).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: serverVersion,
+			AnsweredAt:    startAt,
+		}), nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		results, err := p.getSurveyResults(surveyID)
+
+		require.Nil(t, err)
+		assert.Equal(t, surveyID, results.SurveyID)
+		assert.Equal(t, 1, results.Passives)
+		assert.Equal(t, 1, results.Sent)
+		assert.Equal(t, 1, results.Answered)
+	})
+
+	t.Run("should return an error when unable to list keys", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVGet", fmt.Sprintf(SURVEY_KEY, serverVersion)).Return(mustMarshalJSON(&surveyState{
+			ServerVersion: serverVersion,
+			StartAt:       startAt,
+		}), nil)
+		api.On("KVList", 0, 100).Return(nil, &model.AppError{})
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		results, err := p.getSurveyResults(serverVersion)
+
+		assert.Nil(t, results)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGetScoreBucket(t *testing.T) {
+	for score := 0; score <= 6; score++ {
+		assert.Equal(t, SCORE_BUCKET_DETRACTOR, getScoreBucket(score))
+	}
+
+	assert.Equal(t, SCORE_BUCKET_PASSIVE, getScoreBucket(7))
+	assert.Equal(t, SCORE_BUCKET_PASSIVE, getScoreBucket(8))
+	assert.Equal(t, SCORE_BUCKET_PROMOTER, getScoreBucket(9))
+	assert.Equal(t, SCORE_BUCKET_PROMOTER, getScoreBucket(10))
+}
+
+func TestSurveyResultsCalculate(t *testing.T) {
+	results := newSurveyResults(&surveyState{})
+	for _, score := range []int{10, 9, 9, 8, 7, 6, 0} {
+		results.addScore(score)
+	}
+	results.Sent = 10
+	results.Answered = 7
+
+	results.calculate()
+
+	assert.Equal(t, 3, results.Promoters)
+	assert.Equal(t, 2, results.Passives)
+	assert.Equal(t, 2, results.Detractors)
+	assert.InDelta(t, float64(100)/7, results.NPS, 0.0001)
+	assert.InDelta(t, 0.7, results.ResponseRate, 0.0001)
+}
+
+func TestSurveyResultsWithhold(t *testing.T) {
+	makeResults := func(scores ...int) *surveyResults {
+		results := newSurveyResults(&surveyState{})
+		for _, score := range scores {
+			results.addScore(score)
+		}
+		results.Sent = 10
+		results.Answered = len(scores)
+		results.calculate()
+
+		return results
+	}
+
+	t.Run("should hide scores when too few have been given", func(t *testing.T) {
+		results := makeResults(10, 3)
+
+		results.withhold(3)
+
+		assert.True(t, results.Withheld)
+		assert.Equal(t, 3, results.MinGroupSize)
+		assert.Equal(t, 0, results.Promoters)
+		assert.Equal(t, 0, results.Detractors)
+		assert.Equal(t, float64(0), results.NPS)
+		assert.Equal(t, make([]int, 11), results.Histogram)
+		assert.Equal(t, 2, results.Answered)
+		assert.Contains(t, formatSurveyResults(results), "Results are hidden until at least 3 users have given a score")
+	})
+
+	t.Run("should show scores once enough have been given", func(t *testing.T) {
+		results := makeResults(10, 3, 8)
+
+		results.withhold(3)
+
+		assert.False(t, results.Withheld)
+		assert.Equal(t, 1, results.Promoters)
+		assert.Equal(t, 1, results.Detractors)
+		assert.NotContains(t, formatSurveyResults(results), "Results are hidden")
+	})
+}
+
+func TestGetResults(t *testing.T) {
+	serverVersion := "5.10.0"
+
+	t.Run("should return 404 when no survey exists for the version", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVGet", fmt.Sprintf(SURVEY_KEY, "5.9.0")).Return(nil, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			serverVersion: serverVersion,
+		}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/api/v1/results?version=5.9.1", nil)
+
+		p.getResults(recorder, request)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Result().StatusCode)
+	})
+
+	t.Run("should default to the current server version", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVGet", fmt.Sprintf(SURVEY_KEY, serverVersion)).Return(mustMarshalJSON(&surveyState{
+			ServerVersion: serverVersion,
+		}), nil)
+		api.On("KVList", 0, 100).Return([]string{}, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			serverVersion: serverVersion,
+		}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/api/v1/results", nil)
+
+		p.getResults(recorder, request)
+
+		result := recorder.Result()
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, "application/json", result.Header.Get("Content-Type"))
+	})
+
+	t.Run("should return 500 when unable to get results", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVGet", fmt.Sprintf(SURVEY_KEY, serverVersion)).Return(nil, &model.AppError{})
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			serverVersion: serverVersion,
+		}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/api/v1/results", nil)
+
+		p.getResults(recorder, request)
+
+		assert.Equal(t, http.StatusInternalServerError, recorder.Result().StatusCode)
+	})
+}
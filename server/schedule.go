@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	// SCHEDULE_UPGRADE schedules a survey whenever the server is upgraded to a new major or minor version. This is the
+	// default.
+	SCHEDULE_UPGRADE = "upgrade"
+
+	// SCHEDULE_QUARTERLY schedules a survey at the start of each calendar quarter.
+	SCHEDULE_QUARTERLY = "quarterly"
+
+	// SCHEDULE_INTERVAL schedules a survey every SurveyIntervalDays days.
+	SCHEDULE_INTERVAL = "interval"
+
+	// How often to check whether or not a recurring survey should be scheduled
+	RECURRING_SURVEY_CHECK_INTERVAL = time.Hour
+)
+
+// isRecurring returns whether or not surveys are scheduled on a timer instead of after server upgrades.
+func (c *configuration) isRecurring() bool {
+	return c.SurveySchedule == SCHEDULE_QUARTERLY || c.SurveySchedule == SCHEDULE_INTERVAL
+}
+
+// isRecurringSurveyDue returns whether or not enough time has passed since the last survey was scheduled that another
+// one should be scheduled.
+func (c *configuration) isRecurringSurveyDue(lastScheduledAt time.Time, now time.Time) bool {
+	switch c.SurveySchedule {
+	case SCHEDULE_QUARTERLY:
+		return getQuarter(now) > getQuarter(lastScheduledAt)
+	case SCHEDULE_INTERVAL:
+		return now.Sub(lastScheduledAt) >= daysToDuration(c.SurveyIntervalDays)
+	default:
+		return false
+	}
+}
+
+// getQuarter returns a number that increases by one for each calendar quarter.
+func getQuarter(t time.Time) int {
+	return t.Year()*4 + (int(t.Month())-1)/3
+}
+
+// getRecurringSurveyID returns the ID of a recurring survey scheduled at the given time. The date is encoded as the
+// number of days since the Unix epoch in base 36 so that response keys containing the ID and a user ID fit within
+// model.KEY_VALUE_KEY_MAX_RUNES.
+func getRecurringSurveyID(serverVersion string, now time.Time) string {
+	return serverVersion + "-" + strconv.FormatInt(now.Unix()/int64((24*time.Hour)/time.Second), 36)
+}
+
+// checkForRecurringSurvey schedules a new NPS survey if surveys are configured to recur and enough time has passed
+// since the last one. If no survey has been scheduled for the current server version, one is scheduled immediately.
+// Returns whether or not a survey was scheduled.
+//
+// Each recurring survey is given its own ID since there may be multiple of them on the same server version.
+func (p *Plugin) checkForRecurringSurvey(now time.Time) bool {
+	config := p.getConfiguration()
+
+	if !config.EnableSurvey || !config.isRecurring() {
+		return false
+	}
+
+	locked, err := p.tryLock(LOCK_KEY, now)
+	if !locked || err != nil {
+		// Either an error occurred or there's already another thread checking for surveys
+		return false
+	}
+	defer p.unlock(LOCK_KEY)
+
+	var lastSurvey *surveyState
+	if err := p.KVGet(fmt.Sprintf(SURVEY_KEY, p.serverVersion), &lastSurvey); err != nil {
+		p.API.LogError("Failed to get survey state", "err", err)
+		return false
+	}
+
+	if lastSurvey != nil && !config.isRecurringSurveyDue(lastSurvey.CreateAt, now) {
+		return false
+	}
+
+	return p.scheduleSurvey(now, &surveyState{
+		ID:            getRecurringSurveyID(p.serverVersion, now),
+		ServerVersion: p.serverVersion,
+		CreateAt:      now,
+		StartAt:       now.Add(config.getTimeUntilSurvey()),
+	})
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestIsRecurringSurveyDue(t *testing.T) {
+	for _, test := range []struct {
+		Name            string
+		Configuration   *configuration
+		LastScheduledAt time.Time
+		Now             time.Time
+		Expected        bool
+	}{
+		{
+			Name:            "upgrade schedule",
+			Configuration:   &configuration{SurveySchedule: SCHEDULE_UPGRADE},
+			LastScheduledAt: toDate(2018, time.January, 1),
+			Now:             toDate(2019, time.January, 1),
+			Expected:        false,
+		},
+		{
+			Name:            "quarterly, same quarter",
+			Configuration:   &configuration{SurveySchedule: SCHEDULE_QUARTERLY},
+			LastScheduledAt: toDate(2019, time.April, 1),
+			Now:             toDate(2019, time.June, 30),
+			Expected:        false,
+		},
+		{
+			Name:            "quarterly, next quarter",
+			Configuration:   &configuration{SurveySchedule: SCHEDULE_QUARTERLY},
+			LastScheduledAt: toDate(2019, time.June, 30),
+			Now:             toDate(2019, time.July, 1),
+			Expected:        true,
+		},
+		{
+			Name:            "quarterly, next year",
+			Configuration:   &configuration{SurveySchedule: SCHEDULE_QUARTERLY},
+			LastScheduledAt: toDate(2018, time.December, 1),
+			Now:             toDate(2019, time.January, 1),
+			Expected:        true,
+		},
+		{
+			Name:            "interval, not enough time passed",
+			Configuration:   &configuration{SurveySchedule: SCHEDULE_INTERVAL, SurveyIntervalDays: 30},
+			LastScheduledAt: toDate(2019, time.April, 1),
+			Now:             toDate(2019, time.April, 30),
+			Expected:        false,
+		},
+		{
+			Name:            "interval, enough time passed",
+			Configuration:   &configuration{SurveySchedule: SCHEDULE_INTERVAL, SurveyIntervalDays: 30},
+			LastScheduledAt: toDate(2019, time.April, 1),
+			Now:             toDate(2019, time.May, 1),
+			Expected:        true,
+		},
+	} {
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Expected, test.Configuration.isRecurringSurveyDue(test.LastScheduledAt, test.Now))
+		})
+	}
+}
+
+func TestGetRecurringSurveyID(t *testing.T) {
+	t.Run("should give each day a different ID", func(t *testing.T) {
+		now := toDate(2019, time.July, 1)
+
+		assert.Equal(t, "5.10.0-dy6", getRecurringSurveyID("5.10.0", now))
+		assert.Equal(t, getRecurringSurveyID("5.10.0", now), getRecurringSurveyID("5.10.0", now.Add(time.Hour)))
+		assert.NotEqual(t, getRecurringSurveyID("5.10.0", now), getRecurringSurveyID("5.10.0", now.Add(24*time.Hour)))
+	})
+
+	t.Run("should fit response keys in the KV store", func(t *testing.T) {
+		p := &Plugin{anonymousSalt: []byte("salt")}
+		userID := model.NewId()
+		surveyID := getRecurringSurveyID("10.10.0", toDate(2100, time.December, 31))
+
+		for _, key := range []string{
+			fmt.Sprintf(RESPONSE_KEY, surveyID, userID),
+			fmt.Sprintf(RESPONSE_KEY, surveyID, p.getAnonymousID(userID)),
+			fmt.Sprintf(SURVEY_KEY, surveyID),
+			fmt.Sprintf(RESULTS_DIGEST_KEY, surveyID),
+		} {
+			assert.True(t, utf8.RuneCountInString(key) <= model.KEY_VALUE_KEY_MAX_RUNES, key)
+		}
+	})
+}
+
+func TestCheckForRecurringSurvey(t *testing.T) {
+	now := toDate(2019, time.July, 1)
+	serverVersion := "5.10.0"
+	surveyID := "5.10.0-dy6"
+	surveyKey := fmt.Sprintf(SURVEY_KEY, serverVersion)
+
+	makeAPIMock := func() *plugintest.API {
+		api := &plugintest.API{}
+		api.On("LogInfo", mock.Anything).Maybe()
+		return api
+	}
+
+	t.Run("should schedule a new survey when the last one is from a previous quarter", func(t *testing.T) {
+		nextSurvey := &surveyState{
+			ID:            surveyID,
+			ServerVersion: serverVersion,
+			CreateAt:      now,
+			StartAt:       now.Add(DEFAULT_TIME_UNTIL_SURVEY),
+		}
+
+		api := makeAPIMock()
+		api.On("KVCompareAndSet", LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVGet", surveyKey).Return(mustMarshalJSON(&surveyState{
+			ServerVersion: serverVersion,
+			CreateAt:      toDate(2019, time.April, 1),
+		}), nil)
+		api.On("KVSet", surveyKey, mustMarshalJSON(nextSurvey)).Return(nil)
+		api.On("KVSet", fmt.Sprintf(SURVEY_KEY, surveyID), mustMarshalJSON(nextSurvey)).Return(nil)
+		api.On("KVGet", LAST_ADMIN_NOTICE_KEY).Return(mustMarshalJSON(now), nil)
+		api.On("KVDelete", LOCK_KEY).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			configuration: &configuration{
+				EnableSurvey:   true,
+				SurveySchedule: SCHEDULE_QUARTERLY,
+			},
+			serverVersion: serverVersion,
+		}
+		p.SetAPI(api)
+
+		result := p.checkForRecurringSurvey(now)
+
+		assert.True(t, result)
+	})
+
+	t.Run("should not schedule a survey when one was already scheduled this quarter", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVCompareAndSet", LOCK_KEY, []byte(nil), mustMarshalJSON(now.Add(time.Hour))).Return(true, nil)
+		api.On("KVGet", surveyKey).Return(mustMarshalJSON(&surveyState{
+			ID:            surveyID,
+			ServerVersion: serverVersion,
+			CreateAt:      now,
+		}), nil)
+		api.On("KVDelete", LOCK_KEY).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			configuration: &configuration{
+				EnableSurvey:   true,
+				SurveySchedule: SCHEDULE_QUARTERLY,
+			},
+			serverVersion: serverVersion,
+		}
+		p.SetAPI(api)
+
+		result := p.checkForRecurringSurvey(now.Add(time.Hour))
+
+		assert.False(t, result)
+	})
+
+	t.Run("should do nothing when surveys are scheduled after upgrades", func(t *testing.T) {
+		api := makeAPIMock()
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			configuration: &configuration{
+				EnableSurvey:   true,
+				SurveySchedule: SCHEDULE_UPGRADE,
+			},
+			serverVersion: serverVersion,
+		}
+		p.SetAPI(api)
+
+		result := p.checkForRecurringSurvey(now)
+
+		assert.False(t, result)
+	})
+
+	t.Run("should do nothing when surveys are disabled", func(t *testing.T) {
+		api := makeAPIMock()
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			configuration: &configuration{
+				EnableSurvey:   false,
+				SurveySchedule: SCHEDULE_QUARTERLY,
+			},
+			serverVersion: serverVersion,
+		}
+		p.SetAPI(api)
+
+		result := p.checkForRecurringSurvey(now)
+
+		assert.False(t, result)
+	})
+
+	t.Run("should not schedule a survey when unable to get the last one", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVCompareAndSet", LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVGet", surveyKey).Return(nil, &model.AppError{})
+		api.On("LogError", mock.Anything, "err", mock.Anything)
+		api.On("KVDelete", LOCK_KEY).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			configuration: &configuration{
+				EnableSurvey:       true,
+				SurveySchedule:     SCHEDULE_INTERVAL,
+				SurveyIntervalDays: 30,
+			},
+			serverVersion: serverVersion,
+		}
+		p.SetAPI(api)
+
+		result := p.checkForRecurringSurvey(now)
+
+		assert.False(t, result)
+	})
+}
@@ -4,17 +4,29 @@ import (
 	"strings"
 
 	"github.com/mattermost/mattermost-server/model"
+	"github.com/pkg/errors"
 	analytics "github.com/segmentio/analytics-go"
 )
 
 const (
+	NPS_ANSWER   = "nps_answer"
 	NPS_FEEDBACK = "nps_feedback"
 	NPS_SCORE    = "nps_score"
 
 	SEGMENT_KEY = "5xaDYWpjOoCKmJNNKK6fg1DacwZ7ZVZc"
 )
 
+// initializeClient creates the client used to send events to Segment. Nothing is sent to Segment, including the
+// server's diagnostic ID, unless it's the selected AnalyticsSink and diagnostics are enabled, so the client is only
+// created once both are true.
 func (p *Plugin) initializeClient() error {
+	p.clientLock.Lock()
+	defer p.clientLock.Unlock()
+
+	if p.client != nil || !p.shouldSendToSegment() {
+		return nil
+	}
+
 	client := analytics.New(SEGMENT_KEY)
 
 	if !p.blockSegmentEvents {
@@ -30,35 +42,49 @@ func (p *Plugin) initializeClient() error {
 	return nil
 }
 
-func (p *Plugin) sendScore(score int, userID string, timestamp int64) error {
-	return p.sendToSegment(NPS_SCORE, userID, timestamp, map[string]interface{}{
-		"score": score,
-	})
-}
+// getClient returns the Segment client, or nil if initializeClient hasn't created it.
+func (p *Plugin) getClient() *analytics.Client {
+	p.clientLock.Lock()
+	defer p.clientLock.Unlock()
 
-func (p *Plugin) sendFeedback(feedback string, userID string, timestamp int64) error {
-	return p.sendToSegment(NPS_FEEDBACK, userID, timestamp, map[string]interface{}{
-		"feedback": feedback,
-	})
+	return p.client
 }
 
-func (p *Plugin) sendToSegment(event string, userID string, timestamp int64, properties map[string]interface{}) error {
-	if !p.canSendDiagnostics() || p.blockSegmentEvents {
-		return nil
+// shouldSendToSegment returns whether or not events should be sent to Mattermost, Inc.'s Segment workspace.
+func (p *Plugin) shouldSendToSegment() bool {
+	if sink := p.getConfiguration().AnalyticsSink; sink != "" && sink != SINK_SEGMENT {
+		return false
 	}
 
-	track := &analytics.Track{
-		Event:      event,
-		UserId:     p.API.GetDiagnosticId(),
-		Properties: p.getEventProperties(userID, timestamp, properties),
+	return p.canSendDiagnostics()
+}
+
+// segmentSink sends events to Mattermost, Inc.'s Segment workspace. Events are only sent when diagnostics are enabled
+// on the server.
+type segmentSink struct {
+	plugin *Plugin
+}
+
+func (s *segmentSink) isEnabled() bool {
+	return s.plugin.canSendDiagnostics() && !s.plugin.blockSegmentEvents
+}
+
+func (s *segmentSink) track(event string, properties map[string]interface{}) error {
+	client := s.plugin.getClient()
+	if client == nil {
+		return errors.New("Segment client has not been initialized")
 	}
 
-	return p.client.Track(track)
+	return client.Track(&analytics.Track{
+		Event:      event,
+		UserId:     s.plugin.API.GetDiagnosticId(),
+		Properties: properties,
+	})
 }
 
 func (p *Plugin) getEventProperties(userID string, timestamp int64, other map[string]interface{}) map[string]interface{} {
 	properties := map[string]interface{}{
-		"user_actual_id": userID,
+		"user_actual_id": p.getResponseUserID(userID),
 		"timestamp":      timestamp,
 		"server_version": p.API.GetServerVersion(), // Note that this calls the API directly, so it gets the full version (including patch version)
 		"server_id":      p.API.GetDiagnosticId(),
@@ -70,7 +96,11 @@ func (p *Plugin) getEventProperties(userID string, timestamp int64, other map[st
 		properties["server_install_date"] = systemInstallDate
 	}
 
-	if user, err := p.API.GetUser(userID); err != nil {
+	if p.getConfiguration().AnonymousSurveys {
+		// Don't include details that could be used to identify the user
+		properties["user_role"] = ""
+		properties["user_create_at"] = int64(0)
+	} else if user, err := p.API.GetUser(userID); err != nil {
 		properties["user_role"] = ""
 		properties["user_create_at"] = int64(0)
 	} else {
@@ -125,6 +155,8 @@ func (p *Plugin) isUserTeamAdmin(user *model.User) bool {
 		if len(teamMembers) != perPage {
 			break
 		}
+
+		page += 1
 	}
 
 	return false
@@ -22,6 +22,7 @@ func TestGetEventProperties(t *testing.T) {
 
 	for _, test := range []struct {
 		Name            string
+		Configuration   *configuration
 		SetupAPI        func() *plugintest.API
 		OtherProperties map[string]interface{}
 		Expected        map[string]interface{}
@@ -185,6 +186,33 @@ func TestGetEventProperties(t *testing.T) {
 				"other_2":             "abcd",
 			},
 		},
+		{
+			Name:          "anonymous surveys",
+			Configuration: &configuration{AnonymousSurveys: true},
+			SetupAPI: func() *plugintest.API {
+				api := &plugintest.API{}
+
+				api.On("GetSystemInstallDate").Return(systemInstallDate, nil)
+
+				api.On("GetLicense").Return(&model.License{
+					Id:           licenseID,
+					SkuShortName: skuShortName,
+				})
+
+				return api
+			},
+			Expected: map[string]interface{}{
+				"user_actual_id":      (&Plugin{}).getAnonymousID(userID),
+				"timestamp":           timestamp,
+				"server_version":      serverVersion,
+				"server_install_date": systemInstallDate,
+				"server_id":           diagnosticID,
+				"user_role":           "",
+				"user_create_at":      int64(0),
+				"license_id":          licenseID,
+				"license_sku":         skuShortName,
+			},
+		},
 	} {
 		t.Run(test.Name, func(t *testing.T) {
 			api := test.SetupAPI()
@@ -195,7 +223,9 @@ func TestGetEventProperties(t *testing.T) {
 
 			api.On("GetTeamMembersForUser", userID, 0, 50).Return([]*model.TeamMember{}, nil).Maybe()
 
-			p := Plugin{}
+			p := Plugin{
+				configuration: test.Configuration,
+			}
 			p.SetAPI(api)
 
 			assert.Equal(t, test.Expected, p.getEventProperties(userID, timestamp, test.OtherProperties))
@@ -285,3 +315,125 @@ func TestGetUserRole(t *testing.T) {
 		})
 	}
 }
+
+func TestIsUserTeamAdmin(t *testing.T) {
+	user := &model.User{
+		Id:    model.NewId(),
+		Roles: model.SYSTEM_USER_ROLE_ID,
+	}
+
+	fullPage := make([]*model.TeamMember, 50)
+	for i := range fullPage {
+		fullPage[i] = &model.TeamMember{Roles: model.TEAM_USER_ROLE_ID}
+	}
+
+	t.Run("should check the next page of teams after a full page", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetTeamMembersForUser", user.Id, 0, 50).Return(fullPage, nil)
+		api.On("GetTeamMembersForUser", user.Id, 1, 50).Return([]*model.TeamMember{
+			{Roles: model.TEAM_ADMIN_ROLE_ID + " " + model.TEAM_USER_ROLE_ID},
+		}, nil)
+		defer api.AssertExpectations(t)
+
+		p := Plugin{}
+		p.SetAPI(api)
+
+		assert.True(t, p.isUserTeamAdmin(user))
+	})
+
+	t.Run("should stop after an empty page", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetTeamMembersForUser", user.Id, 0, 50).Return(fullPage, nil)
+		api.On("GetTeamMembersForUser", user.Id, 1, 50).Return([]*model.TeamMember{}, nil)
+		defer api.AssertExpectations(t)
+
+		p := Plugin{}
+		p.SetAPI(api)
+
+		assert.False(t, p.isUserTeamAdmin(user))
+	})
+}
+
+func TestInitializeClient(t *testing.T) {
+	for _, test := range []struct {
+		Name              string
+		Configuration     *configuration
+		EnableDiagnostics bool
+		ExpectClient      bool
+	}{
+		{
+			Name:              "Segment with diagnostics enabled",
+			Configuration:     &configuration{},
+			EnableDiagnostics: true,
+			ExpectClient:      true,
+		},
+		{
+			Name:              "Segment with diagnostics disabled",
+			Configuration:     &configuration{AnalyticsSink: SINK_SEGMENT},
+			EnableDiagnostics: false,
+			ExpectClient:      false,
+		},
+		{
+			Name:              "file with diagnostics disabled",
+			Configuration:     &configuration{AnalyticsSink: SINK_FILE, AnalyticsFilePath: "/tmp/nps.json"},
+			EnableDiagnostics: false,
+			ExpectClient:      false,
+		},
+		{
+			Name:              "webhook with diagnostics disabled",
+			Configuration:     &configuration{AnalyticsSink: SINK_WEBHOOK, AnalyticsWebhookURL: "https://example.com/hook"},
+			EnableDiagnostics: false,
+			ExpectClient:      false,
+		},
+		{
+			Name:              "file with diagnostics enabled",
+			Configuration:     &configuration{AnalyticsSink: SINK_FILE, AnalyticsFilePath: "/tmp/nps.json"},
+			EnableDiagnostics: true,
+			ExpectClient:      false,
+		},
+	} {
+		t.Run(test.Name, func(t *testing.T) {
+			api := &plugintest.API{}
+			api.On("GetConfig").Return(&model.Config{
+				LogSettings: model.LogSettings{
+					EnableDiagnostics: model.NewBool(test.EnableDiagnostics),
+				},
+			}).Maybe()
+			defer api.AssertExpectations(t)
+
+			p := &Plugin{
+				blockSegmentEvents: true,
+				configuration:      test.Configuration,
+			}
+			p.SetAPI(api)
+
+			assert.Nil(t, p.initializeClient())
+			assert.Equal(t, test.ExpectClient, p.getClient() != nil)
+		})
+	}
+
+	t.Run("should not identify the server to Segment when using another sink with diagnostics disabled", func(t *testing.T) {
+		for _, config := range []*configuration{
+			{AnalyticsSink: SINK_FILE, AnalyticsFilePath: "/tmp/nps.json"},
+			{AnalyticsSink: SINK_WEBHOOK, AnalyticsWebhookURL: "https://example.com/hook"},
+		} {
+			api := &plugintest.API{}
+			api.On("GetConfig").Return(&model.Config{
+				LogSettings: model.LogSettings{
+					EnableDiagnostics: model.NewBool(false),
+				},
+			}).Maybe()
+			defer api.AssertExpectations(t)
+
+			// Segment events aren't blocked, so the diagnostic ID would be sent with Identify
+			p := &Plugin{
+				configuration: config,
+			}
+			p.SetAPI(api)
+
+			assert.Nil(t, p.initializeClient())
+			assert.Nil(t, p.getClient())
+			api.AssertNotCalled(t, "GetDiagnosticId")
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+const (
+	// ACTION_SECRET_KEY is used to store the randomly generated secret that signs the context of the survey post's
+	// actions. It's generated the first time that the plugin is activated.
+	ACTION_SECRET_KEY = "ActionSecret"
+
+	// SECRET_SIZE is the number of random bytes in each secret generated by ensureSecret.
+	SECRET_SIZE = 32
+)
+
+// ensureSecret returns the random secret stored under the given key, generating and storing one if it doesn't exist
+// yet.
+func (p *Plugin) ensureSecret(key string) ([]byte, *model.AppError) {
+	secret, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	if len(secret) > 0 {
+		return secret, nil
+	}
+
+	secret = make([]byte, SECRET_SIZE)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, &model.AppError{Message: err.Error()}
+	}
+
+	saved, appErr := p.API.KVCompareAndSet(key, nil, secret)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	if !saved {
+		// Another instance of the plugin generated the secret first, so use theirs instead
+		return p.API.KVGet(key)
+	}
+
+	return secret, nil
+}
+
+// getActionSignature returns the signature of the given survey details using the action secret.
+func getActionSignature(secret []byte, surveyID, userID, serverVersion string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join([]string{surveyID, userID, serverVersion}, "\x00")))
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// getActionContext returns the signed details of the survey sent to the user. It's included in the Context of each of
+// the survey post's actions so that answers can be tied back to that survey.
+func (p *Plugin) getActionContext(userID string, userSurvey *userSurveyState) map[string]interface{} {
+	surveyID := userSurvey.getSurveyID()
+
+	return map[string]interface{}{
+		"survey_id":      surveyID,
+		"user_id":        userID,
+		"server_version": userSurvey.ServerVersion,
+		"signature":      getActionSignature(p.actionSecret, surveyID, userID, userSurvey.ServerVersion),
+	}
+}
+
+// verifyActionContext returns whether or not the Context of an action was signed by this plugin for the survey that
+// was last sent to the user. Answers with a missing or invalid signature, or from another user or an earlier survey,
+// are rejected.
+func (p *Plugin) verifyActionContext(context map[string]interface{}, userID string, userSurvey *userSurveyState) bool {
+	surveyID, _ := context["survey_id"].(string)
+	contextUserID, _ := context["user_id"].(string)
+	serverVersion, _ := context["server_version"].(string)
+	signature, _ := context["signature"].(string)
+
+	if signature == "" || contextUserID != userID || surveyID != userSurvey.getSurveyID() ||
+		serverVersion != userSurvey.ServerVersion {
+		return false
+	}
+
+	expected := getActionSignature(p.actionSecret, surveyID, contextUserID, serverVersion)
+
+	return hmac.Equal([]byte(signature), []byte(expected))
+}
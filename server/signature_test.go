@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestEnsureSecret(t *testing.T) {
+	t.Run("should return the existing secret", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVGet", ACTION_SECRET_KEY).Return([]byte("secret"), nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		secret, err := p.ensureSecret(ACTION_SECRET_KEY)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("secret"), secret)
+	})
+
+	t.Run("should generate and store a new secret", func(t *testing.T) {
+		var stored []byte
+
+		api := &plugintest.API{}
+		api.On("KVGet", ACTION_SECRET_KEY).Return(nil, nil)
+		api.On("KVCompareAndSet", ACTION_SECRET_KEY, []byte(nil), mock.Anything).Run(func(args mock.Arguments) {
+			stored = args.Get(2).([]byte)
+		}).Return(true, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		secret, err := p.ensureSecret(ACTION_SECRET_KEY)
+
+		assert.Nil(t, err)
+		assert.Len(t, secret, SECRET_SIZE)
+		assert.Equal(t, stored, secret)
+	})
+
+	t.Run("should use the secret generated by another instance of the plugin", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVGet", ACTION_SECRET_KEY).Return(nil, nil).Once()
+		api.On("KVCompareAndSet", ACTION_SECRET_KEY, []byte(nil), mock.Anything).Return(false, nil)
+		api.On("KVGet", ACTION_SECRET_KEY).Return([]byte("other"), nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		secret, err := p.ensureSecret(ACTION_SECRET_KEY)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("other"), secret)
+	})
+
+	t.Run("should return an error if unable to get the secret", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVGet", ACTION_SECRET_KEY).Return(nil, &model.AppError{})
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		_, err := p.ensureSecret(ACTION_SECRET_KEY)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestVerifyActionContext(t *testing.T) {
+	userID := model.NewId()
+	userSurvey := &userSurveyState{
+		SurveyID:      "5.10.0-dwh",
+		ServerVersion: "5.10.0",
+	}
+
+	p := &Plugin{actionSecret: []byte("secret")}
+
+	t.Run("should accept a context signed for the user's survey", func(t *testing.T) {
+		context := p.getActionContext(userID, userSurvey)
+		context["selected_option"] = "10"
+
+		assert.True(t, p.verifyActionContext(context, userID, userSurvey))
+	})
+
+	t.Run("should reject a context without a signature", func(t *testing.T) {
+		context := p.getActionContext(userID, userSurvey)
+		delete(context, "signature")
+
+		assert.False(t, p.verifyActionContext(context, userID, userSurvey))
+		assert.False(t, p.verifyActionContext(map[string]interface{}{"selected_option": "10"}, userID, userSurvey))
+	})
+
+	t.Run("should reject a context signed for another user", func(t *testing.T) {
+		context := p.getActionContext(model.NewId(), userSurvey)
+
+		assert.False(t, p.verifyActionContext(context, userID, userSurvey))
+	})
+
+	t.Run("should reject a context signed for an earlier survey", func(t *testing.T) {
+		context := p.getActionContext(userID, &userSurveyState{
+			SurveyID:      "5.9.0-dt5",
+			ServerVersion: "5.9.0",
+		})
+
+		assert.False(t, p.verifyActionContext(context, userID, userSurvey))
+	})
+
+	t.Run("should reject a context with modified survey details", func(t *testing.T) {
+		context := p.getActionContext(userID, &userSurveyState{ServerVersion: "5.9.0"})
+		context["survey_id"] = userSurvey.SurveyID
+		context["server_version"] = userSurvey.ServerVersion
+
+		assert.False(t, p.verifyActionContext(context, userID, userSurvey))
+	})
+
+	t.Run("should reject a context signed with another secret", func(t *testing.T) {
+		other := &Plugin{actionSecret: []byte("other")}
+		context := other.getActionContext(userID, userSurvey)
+
+		assert.False(t, p.verifyActionContext(context, userID, userSurvey))
+	})
+}
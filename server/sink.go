@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+const (
+	// SINK_SEGMENT sends survey events to Mattermost, Inc. using Segment. This is the default.
+	SINK_SEGMENT = "segment"
+
+	// SINK_WEBHOOK sends survey events as JSON to a URL specified in the plugin configuration. Failed requests are
+	// retried like those to the EventWebhookURLs.
+	SINK_WEBHOOK = "webhook"
+
+	// SINK_FILE appends survey events as lines of JSON to a file on the server specified in the plugin configuration.
+	SINK_FILE = "file"
+
+	// The number of events that can be waiting to be sent to a webhook or file sink before any more are dropped
+	SINK_QUEUE_SIZE = 1000
+)
+
+// eventSink is a destination for the events generated when users respond to an NPS survey.
+type eventSink interface {
+	// isEnabled returns whether or not events can currently be sent to the sink.
+	isEnabled() bool
+
+	// track sends a single event along with its properties to the sink.
+	track(event string, properties map[string]interface{}) error
+}
+
+type sinkEvent struct {
+	Event      string                 `json:"event"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// queuedSinkEvent is an event waiting to be sent to a sink by trackQueuedEvents.
+type queuedSinkEvent struct {
+	sink       eventSink
+	event      string
+	properties map[string]interface{}
+}
+
+// sendScore sends the user's answer to the NPS question. The revision is the number of times that the user had
+// already given a score to the survey, so the event with the highest revision contains their final score.
+func (p *Plugin) sendScore(score int, revision int, userID string, timestamp int64) error {
+	return p.sendEvent(NPS_SCORE, userID, timestamp, map[string]interface{}{
+		"score":        score,
+		"score_bucket": getScoreBucket(score),
+		"revision":     revision,
+	})
+}
+
+// sendFeedback sends a message from the user about the survey. The message is expected to have already been redacted.
+func (p *Plugin) sendFeedback(feedback string, redactionCount int, userID string, timestamp int64) error {
+	return p.sendEvent(NPS_FEEDBACK, userID, timestamp, map[string]interface{}{
+		"feedback":        feedback,
+		"redaction_count": redactionCount,
+	})
+}
+
+// sendAnswer sends the user's answer to a question other than the NPS question. Those questions are written by an
+// admin for their own organization, so the answers are never sent to Mattermost, Inc.
+func (p *Plugin) sendAnswer(questionID string, answer string, redactionCount int, userID string, timestamp int64) error {
+	if _, ok := p.getEventSink().(*segmentSink); ok {
+		return nil
+	}
+
+	return p.sendEvent(NPS_ANSWER, userID, timestamp, map[string]interface{}{
+		"question_id":     questionID,
+		"answer":          answer,
+		"redaction_count": redactionCount,
+	})
+}
+
+// sendEvent sends the event to the configured eventSink and queues it to be sent to any EventWebhookURLs. Events are
+// sent to every sink in the background, so a slow sink or webhook never delays the user.
+func (p *Plugin) sendEvent(event string, userID string, timestamp int64, properties map[string]interface{}) error {
+	sink := p.getEventSink()
+	sendWebhooks := containsString(webhookEvents, event) && len(p.getConfiguration().getEventWebhookURLs()) > 0
+
+	if !sink.isEnabled() && !sendWebhooks {
+		return nil
+	}
+
+	properties = p.getEventProperties(userID, timestamp, properties)
+
+	if sendWebhooks {
+		now := p.now().UTC()
+
+		if queued, err := p.queueWebhookEvent(event, properties, now); err != nil {
+			p.API.LogError("Failed to queue webhook event", "err", err)
+		} else if queued {
+			go p.deliverWebhookEvents(now)
+		}
+	}
+
+	if !sink.isEnabled() {
+		return nil
+	}
+
+	if _, ok := sink.(*segmentSink); ok {
+		// The Segment client already sends events in the background
+		return sink.track(event, properties)
+	}
+
+	return p.queueSinkEvent(sink, event, properties)
+}
+
+// queueSinkEvent hands the event to trackQueuedEvents so that a slow sink never delays the user. The event is sent
+// immediately if there's no queue to add it to.
+func (p *Plugin) queueSinkEvent(sink eventSink, event string, properties map[string]interface{}) error {
+	if p.sinkQueue == nil {
+		return sink.track(event, properties)
+	}
+
+	select {
+	case p.sinkQueue <- &queuedSinkEvent{sink: sink, event: event, properties: properties}:
+		return nil
+	default:
+		return fmt.Errorf("dropped %s event because too many events are waiting to be sent", event)
+	}
+}
+
+// trackQueuedEvents sends the events queued by queueSinkEvent until stop is closed. It should be run on its own
+// goroutine.
+func (p *Plugin) trackQueuedEvents(stop <-chan struct{}) {
+	for {
+		select {
+		case queued := <-p.sinkQueue:
+			if err := queued.sink.track(queued.event, queued.properties); err != nil {
+				p.API.LogError("Failed to send survey event", "event", queued.event, "err", err.Error())
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// getEventSink returns the eventSink selected in the plugin configuration.
+func (p *Plugin) getEventSink() eventSink {
+	config := p.getConfiguration()
+
+	switch config.AnalyticsSink {
+	case SINK_WEBHOOK:
+		return &webhookSink{
+			plugin: p,
+			url:    config.AnalyticsWebhookURL,
+		}
+	case SINK_FILE:
+		return &fileSink{
+			path: config.AnalyticsFilePath,
+		}
+	default:
+		return &segmentSink{
+			plugin: p,
+		}
+	}
+}
+
+// canCollectResponses returns whether or not survey responses can be collected with the current configuration. Segment
+// can only be used when diagnostics are enabled, but the other sinks keep the data on infrastructure owned by the
+// customer, so they can be used regardless.
+func (p *Plugin) canCollectResponses() bool {
+	if sink := p.getConfiguration().AnalyticsSink; sink != "" && sink != SINK_SEGMENT {
+		return true
+	}
+
+	return p.canSendDiagnostics()
+}
+
+// webhookSink POSTs each event as JSON to a URL. Events are queued and delivered by deliverWebhookEvents, so they're
+// retried the same way as the events sent to the EventWebhookURLs.
+type webhookSink struct {
+	plugin *Plugin
+	url    string
+}
+
+func (s *webhookSink) isEnabled() bool {
+	return s.url != ""
+}
+
+func (s *webhookSink) track(event string, properties map[string]interface{}) error {
+	now := s.plugin.now().UTC()
+
+	if _, err := s.plugin.queueWebhookDeliveries([]string{s.url}, event, properties, now); err != nil {
+		return err
+	}
+
+	go s.plugin.deliverWebhookEvents(now)
+
+	return nil
+}
+
+// fileSinkLock prevents events from being interleaved when multiple requests write to the same file at once.
+var fileSinkLock sync.Mutex
+
+// fileSink appends each event as a line of JSON to a file.
+type fileSink struct {
+	path string
+}
+
+func (s *fileSink) isEnabled() bool {
+	return s.path != ""
+}
+
+func (s *fileSink) track(event string, properties map[string]interface{}) error {
+	b, err := json.Marshal(&sinkEvent{
+		Event:      event,
+		Properties: properties,
+	})
+	if err != nil {
+		return err
+	}
+
+	fileSinkLock.Lock()
+	defer fileSinkLock.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
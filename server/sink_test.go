@@ -0,0 +1,409 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetEventSink(t *testing.T) {
+	t.Run("should default to Segment", func(t *testing.T) {
+		p := &Plugin{}
+
+		assert.IsType(t, &segmentSink{}, p.getEventSink())
+	})
+
+	t.Run("should use Segment when selected", func(t *testing.T) {
+		p := &Plugin{
+			configuration: &configuration{AnalyticsSink: SINK_SEGMENT},
+		}
+
+		assert.IsType(t, &segmentSink{}, p.getEventSink())
+	})
+
+	t.Run("should use a webhook when selected", func(t *testing.T) {
+		p := &Plugin{
+			configuration: &configuration{
+				AnalyticsSink:       SINK_WEBHOOK,
+				AnalyticsWebhookURL: "https://example.com/hook",
+			},
+		}
+
+		sink := p.getEventSink()
+
+		require.IsType(t, &webhookSink{}, sink)
+		assert.Equal(t, "https://example.com/hook", sink.(*webhookSink).url)
+	})
+
+	t.Run("should use a file when selected", func(t *testing.T) {
+		p := &Plugin{
+			configuration: &configuration{
+				AnalyticsSink:     SINK_FILE,
+				AnalyticsFilePath: "/tmp/nps.json",
+			},
+		}
+
+		sink := p.getEventSink()
+
+		require.IsType(t, &fileSink{}, sink)
+		assert.Equal(t, "/tmp/nps.json", sink.(*fileSink).path)
+	})
+}
+
+func TestCanCollectResponses(t *testing.T) {
+	for _, test := range []struct {
+		Name              string
+		AnalyticsSink     string
+		EnableDiagnostics bool
+		Expected          bool
+	}{
+		{
+			Name:              "default sink with diagnostics enabled",
+			EnableDiagnostics: true,
+			Expected:          true,
+		},
+		{
+			Name:              "default sink with diagnostics disabled",
+			EnableDiagnostics: false,
+			Expected:          false,
+		},
+		{
+			Name:              "Segment with diagnostics disabled",
+			AnalyticsSink:     SINK_SEGMENT,
+			EnableDiagnostics: false,
+			Expected:          false,
+		},
+		{
+			Name:              "webhook with diagnostics disabled",
+			AnalyticsSink:     SINK_WEBHOOK,
+			EnableDiagnostics: false,
+			Expected:          true,
+		},
+		{
+			Name:              "file with diagnostics disabled",
+			AnalyticsSink:     SINK_FILE,
+			EnableDiagnostics: false,
+			Expected:          true,
+		},
+	} {
+		t.Run(test.Name, func(t *testing.T) {
+			api := &plugintest.API{}
+			api.On("GetConfig").Return(&model.Config{
+				LogSettings: model.LogSettings{
+					EnableDiagnostics: model.NewBool(test.EnableDiagnostics),
+				},
+			}).Maybe()
+			defer api.AssertExpectations(t)
+
+			p := &Plugin{
+				configuration: &configuration{AnalyticsSink: test.AnalyticsSink},
+			}
+			p.SetAPI(api)
+
+			assert.Equal(t, test.Expected, p.canCollectResponses())
+		})
+	}
+}
+
+func TestSendEvent(t *testing.T) {
+	t.Run("should not send anything when the sink is disabled", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetConfig").Return(&model.Config{
+			LogSettings: model.LogSettings{
+				EnableDiagnostics: model.NewBool(true),
+			},
+		})
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			blockSegmentEvents: true,
+		}
+		p.SetAPI(api)
+
+		err := p.sendEvent(NPS_SCORE, model.NewId(), 1234, map[string]interface{}{"score": 10})
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("should queue the event for the event webhooks even when the sink is disabled", func(t *testing.T) {
+		now := toDate(2019, time.June, 1)
+
+		api := &plugintest.API{}
+		api.On("GetConfig").Return(&model.Config{
+			LogSettings: model.LogSettings{
+				EnableDiagnostics: model.NewBool(false),
+			},
+		})
+		api.On("GetServerVersion").Return("5.10.0")
+		api.On("GetDiagnosticId").Return("diagnostic")
+		api.On("GetSystemInstallDate").Return(int64(0), nil)
+		api.On("GetLicense").Return(nil)
+		api.On("KVSet", mock.Anything, matchWebhookDelivery(func(delivery *webhookDelivery) bool {
+			return delivery.URL == "https://example.com/hook" &&
+				delivery.Event.Event == NPS_SCORE &&
+				delivery.Event.Properties["score"] == float64(10) &&
+				delivery.Event.Properties["timestamp"] == float64(1234)
+		})).Return(nil)
+		api.On("KVGet", WEBHOOK_QUEUE_INDEX_KEY).Return(nil, nil)
+		api.On("KVCompareAndSet", WEBHOOK_QUEUE_INDEX_KEY, []byte(nil), mock.Anything).Return(true, nil)
+
+		// The queued event is delivered in the background
+		api.On("KVCompareAndSet", WEBHOOK_DELIVERY_LOCK_KEY, []byte(nil), mock.Anything).Return(false, nil).Maybe()
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			anonymousSalt: []byte("salt"),
+			configuration: &configuration{
+				AnonymousSurveys: true,
+				EventWebhookURLs: "https://example.com/hook",
+			},
+			now: func() time.Time {
+				return now
+			},
+		}
+		p.SetAPI(api)
+
+		err := p.sendEvent(NPS_SCORE, model.NewId(), 1234, map[string]interface{}{"score": 10})
+
+		assert.Nil(t, err)
+	})
+}
+
+// channelSink is an eventSink that passes each event that it's sent to a channel.
+type channelSink struct {
+	events chan *sinkEvent
+	err    error
+}
+
+func (s *channelSink) isEnabled() bool {
+	return true
+}
+
+func (s *channelSink) track(event string, properties map[string]interface{}) error {
+	s.events <- &sinkEvent{Event: event, Properties: properties}
+	return s.err
+}
+
+func TestQueueSinkEvent(t *testing.T) {
+	t.Run("should queue the event to be sent in the background", func(t *testing.T) {
+		sink := &channelSink{events: make(chan *sinkEvent, 1)}
+
+		p := &Plugin{
+			sinkQueue: make(chan *queuedSinkEvent, 1),
+		}
+
+		require.Nil(t, p.queueSinkEvent(sink, NPS_SCORE, map[string]interface{}{"score": 7}))
+
+		assert.Len(t, sink.events, 0)
+		require.Len(t, p.sinkQueue, 1)
+
+		queued := <-p.sinkQueue
+		assert.Equal(t, sink, queued.sink)
+		assert.Equal(t, NPS_SCORE, queued.event)
+	})
+
+	t.Run("should return an error without blocking when the queue is full", func(t *testing.T) {
+		sink := &channelSink{events: make(chan *sinkEvent, 1)}
+
+		p := &Plugin{
+			sinkQueue: make(chan *queuedSinkEvent, 1),
+		}
+
+		require.Nil(t, p.queueSinkEvent(sink, NPS_SCORE, map[string]interface{}{"score": 7}))
+		assert.NotNil(t, p.queueSinkEvent(sink, NPS_SCORE, map[string]interface{}{"score": 8}))
+
+		assert.Len(t, sink.events, 0)
+	})
+
+	t.Run("should send the event immediately without a queue", func(t *testing.T) {
+		sink := &channelSink{events: make(chan *sinkEvent, 1)}
+
+		p := &Plugin{}
+
+		require.Nil(t, p.queueSinkEvent(sink, NPS_SCORE, map[string]interface{}{"score": 7}))
+
+		assert.Len(t, sink.events, 1)
+	})
+}
+
+func TestTrackQueuedEvents(t *testing.T) {
+	logged := make(chan struct{})
+
+	api := &plugintest.API{}
+	api.On("LogError", "Failed to send survey event", "event", NPS_FEEDBACK, "err", "failed").Return(nil).Once().Run(func(mock.Arguments) {
+		close(logged)
+	})
+
+	working := &channelSink{events: make(chan *sinkEvent, 1)}
+	failing := &channelSink{events: make(chan *sinkEvent, 1), err: errors.New("failed")}
+
+	p := &Plugin{
+		sinkQueue: make(chan *queuedSinkEvent, 2),
+	}
+	p.SetAPI(api)
+
+	p.sinkQueue <- &queuedSinkEvent{sink: working, event: NPS_SCORE, properties: map[string]interface{}{"score": 7}}
+	p.sinkQueue <- &queuedSinkEvent{sink: failing, event: NPS_FEEDBACK, properties: map[string]interface{}{"feedback": "Good"}}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		p.trackQueuedEvents(stop)
+		close(done)
+	}()
+
+	assert.Equal(t, &sinkEvent{Event: NPS_SCORE, Properties: map[string]interface{}{"score": 7}}, <-working.events)
+	assert.Equal(t, &sinkEvent{Event: NPS_FEEDBACK, Properties: map[string]interface{}{"feedback": "Good"}}, <-failing.events)
+
+	<-logged
+
+	close(stop)
+	<-done
+
+	api.AssertExpectations(t)
+}
+
+func TestSendAnswer(t *testing.T) {
+	t.Run("should never send answers to Segment", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		err := p.sendAnswer("remote", ANSWER_YES, 0, model.NewId(), 1234)
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("should send answers to other sinks", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "nps")
+		require.Nil(t, err)
+		defer os.RemoveAll(dir)
+
+		userID := model.NewId()
+
+		api := &plugintest.API{}
+		api.On("GetServerVersion").Return("5.12.0")
+		api.On("GetDiagnosticId").Return("diagnostic")
+		api.On("GetSystemInstallDate").Return(int64(0), nil)
+		api.On("GetUser", userID).Return(nil, &model.AppError{})
+		api.On("GetLicense").Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			configuration: &configuration{
+				AnalyticsSink:     SINK_FILE,
+				AnalyticsFilePath: filepath.Join(dir, "events.json"),
+			},
+		}
+		p.SetAPI(api)
+
+		require.Nil(t, p.sendAnswer("remote", ANSWER_YES, 0, userID, 1234))
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, "events.json"))
+		require.Nil(t, err)
+
+		var event *sinkEvent
+		require.Nil(t, json.Unmarshal(data, &event))
+		assert.Equal(t, NPS_ANSWER, event.Event)
+		assert.Equal(t, "remote", event.Properties["question_id"])
+		assert.Equal(t, ANSWER_YES, event.Properties["answer"])
+	})
+}
+
+func TestWebhookSink(t *testing.T) {
+	t.Run("should queue the event to be delivered to the URL", func(t *testing.T) {
+		now := toDate(2019, time.June, 1)
+
+		api := &plugintest.API{}
+		api.On("KVSet", mock.MatchedBy(func(key string) bool {
+			return strings.HasPrefix(key, getKeyPrefix(WEBHOOK_QUEUE_KEY))
+		}), matchWebhookDelivery(func(delivery *webhookDelivery) bool {
+			return delivery.URL == "https://example.com/hook" &&
+				delivery.Event.Event == NPS_ANSWER &&
+				delivery.Event.Properties["answer"] == ANSWER_YES &&
+				delivery.NextAttemptAt.Equal(now)
+		})).Return(nil)
+		api.On("KVGet", WEBHOOK_QUEUE_INDEX_KEY).Return(nil, nil)
+		api.On("KVCompareAndSet", WEBHOOK_QUEUE_INDEX_KEY, []byte(nil), mock.Anything).Return(true, nil)
+
+		// The queued event is delivered in the background
+		api.On("KVCompareAndSet", WEBHOOK_DELIVERY_LOCK_KEY, []byte(nil), mock.Anything).Return(false, nil).Maybe()
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			now: func() time.Time {
+				return now
+			},
+		}
+		p.SetAPI(api)
+
+		sink := &webhookSink{
+			plugin: p,
+			url:    "https://example.com/hook",
+		}
+
+		assert.Nil(t, sink.track(NPS_ANSWER, map[string]interface{}{"answer": ANSWER_YES}))
+	})
+
+	t.Run("should return an error when unable to queue the event", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVSet", mock.Anything, mock.Anything).Return(&model.AppError{})
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			now: time.Now,
+		}
+		p.SetAPI(api)
+
+		sink := &webhookSink{
+			plugin: p,
+			url:    "https://example.com/hook",
+		}
+
+		assert.NotNil(t, sink.track(NPS_SCORE, map[string]interface{}{"score": 7}))
+	})
+
+	t.Run("should be disabled without a URL", func(t *testing.T) {
+		assert.False(t, (&webhookSink{}).isEnabled())
+	})
+}
+
+func TestFileSink(t *testing.T) {
+	t.Run("should append events to file", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "nps")
+		require.Nil(t, err)
+		defer os.RemoveAll(dir)
+
+		sink := &fileSink{
+			path: filepath.Join(dir, "events.json"),
+		}
+
+		require.Nil(t, sink.track(NPS_SCORE, map[string]interface{}{"score": 7}))
+		require.Nil(t, sink.track(NPS_FEEDBACK, map[string]interface{}{"feedback": "Good stuff"}))
+
+		data, err := ioutil.ReadFile(sink.path)
+		require.Nil(t, err)
+
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		require.Len(t, lines, 2)
+		assert.Equal(t, `{"event":"nps_score","properties":{"score":7}}`, lines[0])
+		assert.Equal(t, `{"event":"nps_feedback","properties":{"feedback":"Good stuff"}}`, lines[1])
+	})
+
+	t.Run("should be disabled without a path", func(t *testing.T) {
+		assert.False(t, (&fileSink{}).isEnabled())
+	})
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// userPreferences contains the choices that a user has made about how Surveybot interacts with them.
+type userPreferences struct {
+	// SurveysDisabled is true when the user has opted out of receiving surveys.
+	SurveysDisabled bool      `json:"surveys_disabled"`
+	UpdateAt        time.Time `json:"update_at"`
+}
+
+// unsubscribeCommands and subscribeCommands are the replies to Surveybot that opt a user out of or back into surveys.
+var (
+	unsubscribeCommands = []string{"stop", "unsubscribe"}
+	subscribeCommands   = []string{"subscribe"}
+)
+
+// parseSubscriptionCommand returns whether or not the message opts the user out of surveys or back into them. The
+// second return value is false if the message isn't a subscription command at all.
+func parseSubscriptionCommand(message string) (surveysDisabled bool, ok bool) {
+	command := strings.ToLower(strings.Trim(strings.TrimSpace(message), ".!"))
+
+	if containsString(unsubscribeCommands, command) {
+		return true, true
+	}
+
+	if containsString(subscribeCommands, command) {
+		return false, true
+	}
+
+	return false, false
+}
+
+func (p *Plugin) getUserPreferences(userID string) (*userPreferences, *model.AppError) {
+	var preferences *userPreferences
+	if err := p.KVGet(fmt.Sprintf(USER_PREFERENCES_KEY, userID), &preferences); err != nil {
+		return nil, err
+	}
+
+	if preferences == nil {
+		preferences = &userPreferences{}
+	}
+
+	return preferences, nil
+}
+
+// hasUserOptedOut returns whether or not the user has asked not to be sent surveys.
+func (p *Plugin) hasUserOptedOut(userID string) (bool, *model.AppError) {
+	preferences, err := p.getUserPreferences(userID)
+	if err != nil {
+		return false, err
+	}
+
+	return preferences.SurveysDisabled, nil
+}
+
+// setSurveysDisabled opts the user out of surveys or back into them.
+func (p *Plugin) setSurveysDisabled(userID string, surveysDisabled bool, now time.Time) *model.AppError {
+	preferences, err := p.getUserPreferences(userID)
+	if err != nil {
+		return err
+	}
+
+	preferences.SurveysDisabled = surveysDisabled
+	preferences.UpdateAt = now
+
+	return p.KVSet(fmt.Sprintf(USER_PREFERENCES_KEY, userID), preferences)
+}
+
+// handleSubscriptionCommand opts the user out of surveys or back into them if the message is a subscription command.
+// Returns whether or not the message was handled.
+func (p *Plugin) handleSubscriptionCommand(user *model.User, message string, now time.Time) bool {
+	surveysDisabled, ok := parseSubscriptionCommand(message)
+	if !ok {
+		return false
+	}
+
+	if err := p.setSurveysDisabled(user.Id, surveysDisabled, now); err != nil {
+		p.API.LogError("Failed to update survey subscription", "user_id", user.Id, "err", err)
+		return true
+	}
+
+	if _, err := p.CreateBotDMPost(user.Id, p.buildSubscriptionPost(user, surveysDisabled)); err != nil {
+		p.API.LogError("Failed to confirm survey subscription change", "user_id", user.Id, "err", err)
+	}
+
+	return true
+}
+
+func (p *Plugin) buildSubscriptionPost(user *model.User, surveysDisabled bool) *model.Post {
+	T := p.getUserTranslateFunc(user)
+
+	if surveysDisabled {
+		return &model.Post{
+			Type:    "custom_nps_unsubscribed",
+			Message: T(unsubscribeBody),
+		}
+	}
+
+	return &model.Post{
+		Type:    "custom_nps_subscribed",
+		Message: T(subscribeBody),
+	}
+}
+
+// submitOptOut handles the opt-out button on the survey post.
+func (p *Plugin) submitOptOut(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	var request *model.PostActionIntegrationRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 2048)).Decode(&request); err != nil {
+		p.API.LogError("Failed to decode survey opt out request", "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		p.API.LogError("Failed to get user", "user_id", userID, "err", appErr)
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if appErr := p.setSurveysDisabled(userID, true, p.now().UTC()); appErr != nil {
+		p.API.LogError("Failed to opt user out of surveys", "user_id", userID, "err", appErr)
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := model.PostActionIntegrationResponse{
+		EphemeralText: p.getUserTranslateFunc(user)(unsubscribeBody),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response.ToJson())
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestParseSubscriptionCommand(t *testing.T) {
+	for _, test := range []struct {
+		Message                 string
+		ExpectedSurveysDisabled bool
+		ExpectedOk              bool
+	}{
+		{Message: "stop", ExpectedSurveysDisabled: true, ExpectedOk: true},
+		{Message: "  STOP!", ExpectedSurveysDisabled: true, ExpectedOk: true},
+		{Message: "Unsubscribe.", ExpectedSurveysDisabled: true, ExpectedOk: true},
+		{Message: "subscribe", ExpectedSurveysDisabled: false, ExpectedOk: true},
+		{Message: "please stop", ExpectedSurveysDisabled: false, ExpectedOk: false},
+		{Message: "Great product!", ExpectedSurveysDisabled: false, ExpectedOk: false},
+		{Message: "", ExpectedSurveysDisabled: false, ExpectedOk: false},
+	} {
+		t.Run(test.Message, func(t *testing.T) {
+			surveysDisabled, ok := parseSubscriptionCommand(test.Message)
+
+			assert.Equal(t, test.ExpectedSurveysDisabled, surveysDisabled)
+			assert.Equal(t, test.ExpectedOk, ok)
+		})
+	}
+}
+
+func TestHandleSubscriptionCommand(t *testing.T) {
+	botUserID := model.NewId()
+	user := &model.User{Id: model.NewId()}
+	preferencesKey := fmt.Sprintf(USER_PREFERENCES_KEY, user.Id)
+
+	now := toDate(2019, time.June, 1)
+
+	t.Run("should opt the user back into surveys", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVGet", preferencesKey).Return(mustMarshalJSON(&userPreferences{
+			SurveysDisabled: true,
+			UpdateAt:        now.Add(-time.Hour),
+		}), nil)
+		api.On("KVSet", preferencesKey, mustMarshalJSON(&userPreferences{
+			SurveysDisabled: false,
+			UpdateAt:        now,
+		})).Return(nil)
+		api.On("GetDirectChannel", user.Id, botUserID).Return(&model.Channel{}, nil)
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.Type == "custom_nps_subscribed"
+		})).Return(&model.Post{}, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{botUserID: botUserID}
+		p.SetAPI(api)
+
+		assert.True(t, p.handleSubscriptionCommand(user, "subscribe", now))
+	})
+
+	t.Run("should not handle other messages", func(t *testing.T) {
+		api := makeAPIMock()
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{botUserID: botUserID}
+		p.SetAPI(api)
+
+		assert.False(t, p.handleSubscriptionCommand(user, "This is feedback", now))
+	})
+
+	t.Run("should still handle the command if unable to save preferences", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVGet", preferencesKey).Return(nil, &model.AppError{})
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{botUserID: botUserID}
+		p.SetAPI(api)
+
+		assert.True(t, p.handleSubscriptionCommand(user, "stop", now))
+	})
+}
+
+func TestSubmitOptOut(t *testing.T) {
+	userID := model.NewId()
+	preferencesKey := fmt.Sprintf(USER_PREFERENCES_KEY, userID)
+
+	now := toDate(2019, time.June, 1)
+
+	makeRequest := func() *http.Request {
+		request := httptest.NewRequest(http.MethodPost, "/opt_out", bytes.NewReader(mustMarshalJSON(&model.PostActionIntegrationRequest{})))
+		request.Header.Set("Mattermost-User-ID", userID)
+
+		return request
+	}
+
+	t.Run("should opt the user out of surveys", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("GetUser", userID).Return(&model.User{Id: userID}, nil)
+		api.On("KVGet", preferencesKey).Return(nil, nil)
+		api.On("KVSet", preferencesKey, mustMarshalJSON(&userPreferences{
+			SurveysDisabled: true,
+			UpdateAt:        now,
+		})).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			now: func() time.Time {
+				return now
+			},
+		}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+
+		p.submitOptOut(recorder, makeRequest())
+
+		var response *model.PostActionIntegrationResponse
+		json.NewDecoder(recorder.Result().Body).Decode(&response)
+
+		assert.Equal(t, http.StatusOK, recorder.Result().StatusCode)
+		assert.Equal(t, defaultTranslations[unsubscribeBody], response.EphemeralText)
+	})
+
+	t.Run("should return error if unable to save preferences", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("GetUser", userID).Return(&model.User{Id: userID}, nil)
+		api.On("KVGet", preferencesKey).Return(nil, nil)
+		api.On("KVSet", preferencesKey, mock.Anything).Return(&model.AppError{})
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			now: func() time.Time {
+				return now
+			},
+		}
+		p.SetAPI(api)
+
+		recorder := httptest.NewRecorder()
+
+		p.submitOptOut(recorder, makeRequest())
+
+		assert.Equal(t, http.StatusInternalServerError, recorder.Result().StatusCode)
+	})
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"html/template"
 	"strconv"
 	"strings"
 	"time"
@@ -12,20 +13,22 @@ import (
 
 const (
 	// How often "survey scheduled" emails can be sent to prevent multiple emails from being sent if multiple server
-	// upgrades occur within a short time
-	MIN_TIME_BETWEEN_SURVEY_EMAILS = 7 * 24 * time.Hour
+	// upgrades occur within a short time. Can be overridden by MinDaysBetweenSurveyEmails.
+	DEFAULT_MIN_TIME_BETWEEN_SURVEY_EMAILS = 7 * 24 * time.Hour
 
-	// How long until a survey occurs after a server upgrade in days (for use in notifications)
-	DAYS_UNTIL_SURVEY = 21
-
-	// How long until a survey occurs after a server upgrade as a time.Duration
-	TIME_UNTIL_SURVEY = 21 * 24 * time.Hour
+	// How long until a survey occurs after it's scheduled. Can be overridden by DaysUntilSurvey.
+	DEFAULT_TIME_UNTIL_SURVEY = 21 * 24 * time.Hour
 
 	// Get admin users up to 100 at a time when sending email notifications
 	ADMIN_USERS_PER_PAGE = 100
 
-	// The minimum time before a user can be sent a survey after completing the previous one
-	MIN_TIME_BETWEEN_USER_SURVEYS = 90 * 24 * time.Hour
+	// The minimum time before a user can be sent a survey after completing the previous one. Can be overridden by
+	// MinDaysBetweenUserSurveys.
+	DEFAULT_MIN_TIME_BETWEEN_USER_SURVEYS = 90 * 24 * time.Hour
+
+	// How long after a user answers a survey that their messages to Surveybot are treated as feedback. Can be
+	// overridden by FeedbackWindowHours.
+	DEFAULT_FEEDBACK_WINDOW = 24 * time.Hour
 )
 
 type adminNotice struct {
@@ -35,16 +38,83 @@ type adminNotice struct {
 }
 
 type surveyState struct {
+	// ID identifies a survey scheduled by SurveySchedule since there may be multiple of them for one server version.
+	// It's empty for surveys scheduled after a server upgrade, in which case the server version is used instead.
+	ID string `json:"id,omitempty"`
+
 	ServerVersion string    `json:"server_version"`
 	CreateAt      time.Time `json:"create_at"`
 	StartAt       time.Time `json:"start_at"`
+
+	// Cancelled is set when an admin has cancelled the survey. Users won't receive a cancelled survey unless it's
+	// rescheduled.
+	Cancelled bool `json:"cancelled"`
+}
+
+// getID returns the unique identifier for the survey.
+func (s *surveyState) getID() string {
+	if s.ID != "" {
+		return s.ID
+	}
+
+	return s.ServerVersion
+}
+
+// describe returns a human readable name for the survey.
+func (s *surveyState) describe() string {
+	return describeSurvey(s.ID, s.ServerVersion)
+}
+
+func describeSurvey(id string, serverVersion string) string {
+	if id == "" || id == serverVersion {
+		return "Mattermost " + serverVersion
+	}
+
+	return fmt.Sprintf("Mattermost %s (survey %s)", serverVersion, id)
 }
 
 type userSurveyState struct {
+	// SurveyID matches the ID of the surveyState that was sent to the user. Like surveyState.ID, it's empty for surveys
+	// scheduled after a server upgrade.
+	SurveyID string `json:"survey_id,omitempty"`
+
 	ServerVersion string    `json:"server_version"`
 	SentAt        time.Time `json:"sent_at"`
 	AnsweredAt    time.Time `json:"answered_at"`
 	ScorePostId   string    `json:"score_post_id"`
+
+	// RemindedAt and ExpiredAt track when the user was reminded of an unanswered survey and when it was closed.
+	RemindedAt time.Time `json:"reminded_at"`
+	ExpiredAt  time.Time `json:"expired_at"`
+
+	// FeedbackPostId is the ID of the post asking the user for feedback after they answered the survey. Replies to it
+	// are treated as feedback on the survey.
+	FeedbackPostId string `json:"feedback_post_id,omitempty"`
+}
+
+// getSurveyID returns the unique identifier of the survey that was sent to the user.
+func (s *userSurveyState) getSurveyID() string {
+	if s.SurveyID != "" {
+		return s.SurveyID
+	}
+
+	return s.ServerVersion
+}
+
+// isSurveyPost returns whether or not the given post is the survey that was sent to the user. This is false if the user
+// has never been sent a survey.
+func (s *userSurveyState) isSurveyPost(postID string) bool {
+	return s != nil && s.ScorePostId != "" && s.ScorePostId == postID
+}
+
+// getUserSurveyState returns the state of the last survey sent to the user, or nil if they've never been sent one.
+func (p *Plugin) getUserSurveyState(userID string) (*userSurveyState, *model.AppError) {
+	var userSurvey *userSurveyState
+	if err := p.KVGet(fmt.Sprintf(USER_SURVEY_KEY, userID), &userSurvey); err != nil {
+		return nil, err
+	}
+
+	return userSurvey, nil
 }
 
 // checkForNextSurvey schedules a new NPS survey if a major or minor version change has occurred. Returns whether or
@@ -81,12 +151,18 @@ func (p *Plugin) checkForNextSurvey(now time.Time) bool {
 	nextSurvey = &surveyState{
 		ServerVersion: p.serverVersion,
 		CreateAt:      now,
-		StartAt:       now.Add(TIME_UNTIL_SURVEY),
+		StartAt:       now.Add(p.getConfiguration().getTimeUntilSurvey()),
 	}
 
+	return p.scheduleSurvey(now, nextSurvey)
+}
+
+// scheduleSurvey stores the given survey and notifies admins that it has been scheduled. Returns whether or not the
+// survey was scheduled successfully. The caller is expected to hold LOCK_KEY.
+func (p *Plugin) scheduleSurvey(now time.Time, nextSurvey *surveyState) bool {
 	p.API.LogInfo(fmt.Sprintf("Scheduling next survey for %s", nextSurvey.StartAt.Format("Jan 2, 2006")))
 
-	if err := p.KVSet(fmt.Sprintf(SURVEY_KEY, p.serverVersion), nextSurvey); err != nil {
+	if err := p.saveSurveyState(nextSurvey); err != nil {
 		p.API.LogError("Failed to schedule next survey", "err", err)
 		return false
 	}
@@ -103,13 +179,27 @@ func (p *Plugin) checkForNextSurvey(now time.Time) bool {
 	return true
 }
 
+// saveSurveyState stores the survey as the latest one for its server version. Surveys with an ID are also stored
+// under that ID so that they can still be found after the next one is scheduled.
+func (p *Plugin) saveSurveyState(survey *surveyState) *model.AppError {
+	if err := p.KVSet(fmt.Sprintf(SURVEY_KEY, survey.ServerVersion), survey); err != nil {
+		return err
+	}
+
+	if survey.ID != "" && survey.ID != survey.ServerVersion {
+		return p.KVSet(fmt.Sprintf(SURVEY_KEY, survey.ID), survey)
+	}
+
+	return nil
+}
+
 func (p *Plugin) sendAdminNotices(now time.Time, nextSurvey *surveyState) (bool, error) {
 	var lastSentAt *time.Time
 	if err := p.KVGet(LAST_ADMIN_NOTICE_KEY, &lastSentAt); err != nil {
 		return false, err
 	}
 
-	if lastSentAt != nil && now.Sub(*lastSentAt) < MIN_TIME_BETWEEN_SURVEY_EMAILS {
+	if lastSentAt != nil && now.Sub(*lastSentAt) < p.getConfiguration().getMinTimeBetweenSurveyEmails() {
 		// Not enough time has passed since the last survey notification, so don't send a new one
 		return false, nil
 	}
@@ -119,7 +209,7 @@ func (p *Plugin) sendAdminNotices(now time.Time, nextSurvey *surveyState) (bool,
 		return false, err
 	}
 
-	p.sendAdminNoticeEmails(admins)
+	p.sendAdminNoticeEmails(admins, nextSurvey)
 	p.sendAdminNoticeDMs(admins, nextSurvey)
 
 	if err := p.KVSet(LAST_ADMIN_NOTICE_KEY, now); err != nil {
@@ -129,30 +219,23 @@ func (p *Plugin) sendAdminNotices(now time.Time, nextSurvey *surveyState) (bool,
 	return true, nil
 }
 
-func (p *Plugin) sendAdminNoticeEmails(admins []*model.User) {
+func (p *Plugin) sendAdminNoticeEmails(admins []*model.User, nextSurvey *surveyState) {
 	config := p.API.GetConfig()
 
-	subject := fmt.Sprintf(adminEmailSubject, *config.TeamSettings.SiteName, DAYS_UNTIL_SURVEY)
+	daysUntilSurvey := p.getConfiguration().getDaysUntilSurvey()
 
-	bodyProps := map[string]interface{}{
-		"PluginID":        manifest.Id,
-		"SiteURL":         *config.ServiceSettings.SiteURL,
-		"DaysUntilSurvey": DAYS_UNTIL_SURVEY,
-	}
-	if config.EmailSettings.FeedbackOrganization != nil && *config.EmailSettings.FeedbackOrganization != "" {
-		bodyProps["Organization"] = "Sent by " + *config.EmailSettings.FeedbackOrganization
-	} else {
-		bodyProps["Organization"] = ""
-	}
-
-	var buf bytes.Buffer
-	if err := adminEmailBodyTemplate.Execute(&buf, bodyProps); err != nil {
-		p.API.LogError("Failed to prepare NPS survey notification email", "err", err)
-		return
+	organization := ""
+	if config.EmailSettings.FeedbackOrganization != nil {
+		organization = *config.EmailSettings.FeedbackOrganization
 	}
-	body := buf.String()
 
 	for _, admin := range admins {
+		subject, body, err := p.buildAdminNoticeEmail(admin, nextSurvey, *config.TeamSettings.SiteName, *config.ServiceSettings.SiteURL, organization, daysUntilSurvey)
+		if err != nil {
+			p.API.LogError("Failed to prepare NPS survey notification email", "err", err)
+			return
+		}
+
 		p.API.LogDebug("Sending NPS survey notification email", "email", admin.Email)
 
 		if err := p.API.SendMail(admin.Email, subject, body); err != nil {
@@ -161,6 +244,40 @@ func (p *Plugin) sendAdminNoticeEmails(admins []*model.User) {
 	}
 }
 
+// buildAdminNoticeEmail returns the subject and body of the email notifying an admin that a survey has been scheduled
+// in the admin's locale.
+func (p *Plugin) buildAdminNoticeEmail(admin *model.User, nextSurvey *surveyState, siteName, siteURL, organization string, daysUntilSurvey int) (string, string, error) {
+	T := p.getUserTranslateFunc(admin)
+
+	data := map[string]interface{}{
+		"SiteName":        siteName,
+		"SiteURL":         template.HTMLEscapeString(siteURL),
+		"PluginID":        manifest.Id,
+		"SurveyDate":      nextSurvey.StartAt.Format("January 2, 2006"),
+		"DaysUntilSurvey": daysUntilSurvey,
+		"Organization":    organization,
+	}
+
+	// The body and link are trusted HTML from the plugin's translations or an admin's template, so they aren't escaped
+	bodyProps := map[string]interface{}{
+		"SiteURL":      siteURL,
+		"Title":        T(adminEmailTitle, data),
+		"Body":         template.HTML(T(adminEmailBody, data)),
+		"Link":         template.HTML(T(adminEmailLink, data)),
+		"Organization": "",
+	}
+	if organization != "" {
+		bodyProps["Organization"] = T(adminEmailOrganization, data)
+	}
+
+	var buf bytes.Buffer
+	if err := adminEmailBodyTemplate.Execute(&buf, bodyProps); err != nil {
+		return "", "", err
+	}
+
+	return T(adminEmailSubject, data), buf.String(), nil
+}
+
 func (p *Plugin) sendAdminNoticeDMs(admins []*model.User, nextSurvey *surveyState) {
 	// Actual DMs will be sent when the admins next log in, so just mark that they're scheduled to receive one
 	for _, admin := range admins {
@@ -248,7 +365,7 @@ func (p *Plugin) sendAdminNoticeDM(user *model.User, notice *adminNotice) *model
 	p.API.LogDebug("Sending admin notice DM", "user_id", user.Id)
 
 	// Send the DM
-	if _, err := p.CreateBotDMPost(user.Id, p.buildAdminNoticePost(notice.SurveyStartAt)); err != nil {
+	if _, err := p.CreateBotDMPost(user.Id, p.buildAdminNoticePost(user, notice.SurveyStartAt)); err != nil {
 		return err
 	}
 
@@ -263,20 +380,27 @@ func (p *Plugin) sendAdminNoticeDM(user *model.User, notice *adminNotice) *model
 	return nil
 }
 
-func (p *Plugin) buildAdminNoticePost(surveyStartAt time.Time) *model.Post {
+func (p *Plugin) buildAdminNoticePost(user *model.User, surveyStartAt time.Time) *model.Post {
+	T := p.getUserTranslateFunc(user)
+
 	return &model.Post{
-		Message: fmt.Sprintf(adminDMBody, surveyStartAt.Format("January 2, 2006"), manifest.Id),
-		Type:    "custom_nps_admin_notice",
+		Message: T(adminDMBody, map[string]interface{}{
+			"SurveyDate": surveyStartAt.Format("January 2, 2006"),
+			"PluginID":   manifest.Id,
+		}),
+		Type: "custom_nps_admin_notice",
 	}
 }
 
 func (p *Plugin) checkForSurveyDM(user *model.User, now time.Time) (bool, *model.AppError) {
-	if !p.getConfiguration().EnableSurvey {
+	config := p.getConfiguration()
+
+	if !config.EnableSurvey {
 		// Surveys are disabled
 		return false, nil
 	}
 
-	if now.Sub(time.Unix(user.CreateAt/1000, 0)) < TIME_UNTIL_SURVEY {
+	if now.Sub(time.Unix(user.CreateAt/1000, 0)) < config.getMinAccountAge() {
 		// The user hasn't existed for long enough to receive a survey
 		return false, nil
 	}
@@ -291,11 +415,26 @@ func (p *Plugin) checkForSurveyDM(user *model.User, now time.Time) (bool, *model
 		return false, nil
 	}
 
+	if survey.Cancelled {
+		// Survey was cancelled by an admin
+		return false, nil
+	}
+
 	if now.Before(survey.StartAt) {
 		// Survey hasn't started yet
 		return false, nil
 	}
 
+	if now.Before(survey.StartAt.Add(getRolloutDelay(user.Id, survey.getID(), config.SurveyRolloutDays))) {
+		// Survey hasn't been rolled out to this user yet
+		return false, nil
+	}
+
+	if optedOut, err := p.hasUserOptedOut(user.Id); err != nil || optedOut {
+		// The user has asked not to be sent surveys
+		return false, err
+	}
+
 	// And that it has been long enough since the survey last occurred
 	var userSurvey *userSurveyState
 	if err := p.KVGet(fmt.Sprintf(USER_SURVEY_KEY, user.Id), &userSurvey); err != nil {
@@ -303,40 +442,52 @@ func (p *Plugin) checkForSurveyDM(user *model.User, now time.Time) (bool, *model
 	}
 
 	if userSurvey != nil {
-		if userSurvey.ServerVersion == p.serverVersion {
-			// The user has already received this survey
-			return false, nil
+		if userSurvey.getSurveyID() == survey.getID() {
+			// The user has already received this survey, but they may need to be reminded of it if they haven't answered
+			return false, p.checkForUnansweredSurvey(user, userSurvey, now)
 		}
 
-		if now.Sub(userSurvey.SentAt) < MIN_TIME_BETWEEN_USER_SURVEYS {
+		if now.Sub(userSurvey.SentAt) < config.getMinTimeBetweenUserSurveys() {
 			// Not enough time has passed since the user was last sent a survey
 			return false, nil
 		}
 
-		if now.Sub(userSurvey.AnsweredAt) < MIN_TIME_BETWEEN_USER_SURVEYS {
+		if now.Sub(userSurvey.AnsweredAt) < config.getMinTimeBetweenUserSurveys() {
 			// Not enough time has passed since the user last completed a survey
 			return false, nil
 		}
 	}
 
-	return true, p.sendSurveyDM(user, now)
+	if targeted, err := p.isUserTargeted(user, survey); err != nil || !targeted {
+		// The user isn't part of the survey's audience
+		return false, err
+	}
+
+	return true, p.sendSurveyDM(user, survey, now)
 }
 
-func (p *Plugin) sendSurveyDM(user *model.User, now time.Time) *model.AppError {
+func (p *Plugin) sendSurveyDM(user *model.User, survey *surveyState, now time.Time) *model.AppError {
 	p.API.LogDebug("Sending survey DM", "user_id", user.Id)
 
-	// Send the DM
-	post, err := p.CreateBotDMPost(user.Id, p.buildSurveyPost(user))
+	questions, err := p.getQuestions()
 	if err != nil {
 		return err
 	}
 
 	userSurveyState := &userSurveyState{
+		SurveyID:      survey.ID,
 		ServerVersion: p.serverVersion,
 		SentAt:        now,
-		ScorePostId:   post.Id,
 	}
 
+	// Send the DM
+	post, err := p.CreateBotDMPost(user.Id, p.buildSurveyPost(user, userSurveyState, questions, nil))
+	if err != nil {
+		return err
+	}
+
+	userSurveyState.ScorePostId = post.Id
+
 	// Store that the survey has been sent
 	err = p.KVSet(fmt.Sprintf(USER_SURVEY_KEY, user.Id), userSurveyState)
 	if err != nil {
@@ -347,72 +498,159 @@ func (p *Plugin) sendSurveyDM(user *model.User, now time.Time) *model.AppError {
 	return nil
 }
 
-func (p *Plugin) buildSurveyPost(user *model.User) *model.Post {
-	return &model.Post{
-		Message: fmt.Sprintf(surveyBody, user.Username),
-		Type:    "custom_nps_survey",
-		Props: map[string]interface{}{
-			"attachments": []*model.SlackAttachment{
-				{
-					Title:   surveyDropdownTitle,
-					Actions: []*model.PostAction{p.buildSurveyPostAction()},
-				},
+// buildSurveyPost creates a post containing each of the survey questions. If the user has already answered any of
+// them, their answers are shown on the post. The actions used to answer the questions are signed for the given survey.
+func (p *Plugin) buildSurveyPost(user *model.User, userSurvey *userSurveyState, questions []*surveyQuestion, response *surveyResponse) *model.Post {
+	siteURL := *p.API.GetConfig().ServiceSettings.SiteURL
+
+	T := p.getUserTranslateFunc(user)
+
+	actionContext := p.getActionContext(user.Id, userSurvey)
+
+	scoreLocked := p.isScoreLocked(response)
+
+	var attachments []*model.SlackAttachment
+	for _, question := range questions {
+		attachment := p.buildQuestionAttachment(T, question.translate(T), response, actionContext, siteURL)
+		if scoreLocked && question.ID == NPS_QUESTION_ID {
+			// The score can no longer be changed, so only show the answer
+			attachment.Actions = nil
+		}
+
+		attachments = append(attachments, attachment)
+	}
+
+	// Let the user opt out of future surveys from the last question
+	if len(attachments) > 0 {
+		last := attachments[len(attachments)-1]
+		last.Actions = append(last.Actions, &model.PostAction{
+			Name: T(surveyOptOutButton),
+			Type: model.POST_ACTION_TYPE_BUTTON,
+			Integration: &model.PostActionIntegration{
+				URL: fmt.Sprintf("%s/plugins/%s/api/v1/opt_out", siteURL, manifest.Id),
 			},
-		},
+		})
+	}
+
+	props := map[string]interface{}{
+		"attachments": attachments,
+	}
+
+	// The webapp only knows how to display the NPS question, so other questions use regular message attachments. The
+	// webapp's version always lets the score be changed, so it isn't used once the score is locked either.
+	postType := ""
+	if isDefaultQuestions(questions) && !scoreLocked {
+		postType = "custom_nps_survey"
+
+		// The webapp shows the labels at either end of the scores itself since they're only part of the dropdown's
+		// options in the attachment
+		translated := npsQuestion.translate(T)
+		props["min_label"] = translated.MinLabel
+		props["max_label"] = translated.MaxLabel
+	}
+
+	return &model.Post{
+		Message: T(surveyBody, map[string]interface{}{"Username": user.Username}),
+		Type:    postType,
+		Props:   props,
 	}
 }
 
-func (p *Plugin) buildSurveyPostAction() *model.PostAction {
-	var options []*model.PostActionOptions
-	for i := 10; i >= 0; i-- {
-		text := strconv.Itoa(i)
-		if i == 0 {
-			text = "0 (Not Likely)"
-		} else if i == 10 {
-			text = "10 (Very Likely)"
+func (p *Plugin) buildQuestionAttachment(T translateFunc, question *surveyQuestion, response *surveyResponse, actionContext map[string]interface{}, siteURL string) *model.SlackAttachment {
+	makeIntegration := func(selectedOption string) *model.PostActionIntegration {
+		context := map[string]interface{}{
+			"question_id": question.ID,
+		}
+		for key, value := range actionContext {
+			context[key] = value
+		}
+		if selectedOption != "" {
+			context["selected_option"] = selectedOption
 		}
 
-		options = append(options, &model.PostActionOptions{
-			Text:  text,
-			Value: strconv.Itoa(i),
-		})
+		return &model.PostActionIntegration{
+			URL:     fmt.Sprintf("%s/plugins/%s/api/v1/score", siteURL, manifest.Id),
+			Context: context,
+		}
 	}
 
-	siteURL := *p.API.GetConfig().ServiceSettings.SiteURL
+	answer, answered := response.getAnswer(question.ID)
 
-	return &model.PostAction{
-		Name:    "Select an option...",
-		Type:    model.POST_ACTION_TYPE_SELECT,
-		Options: options,
-		Integration: &model.PostActionIntegration{
-			URL: fmt.Sprintf("%s/plugins/%s/api/v1/score", siteURL, manifest.Id),
-		},
+	attachment := &model.SlackAttachment{
+		Title: question.Text,
 	}
-}
 
-func (p *Plugin) buildAnsweredSurveyPost(user *model.User, score int) *model.Post {
-	action := p.buildSurveyPostAction()
-	action.DefaultOption = strconv.Itoa(score)
+	switch question.Type {
+	case QUESTION_TYPE_RATING, QUESTION_TYPE_MULTIPLE_CHOICE:
+		action := &model.PostAction{
+			Name:        T(surveySelectOption),
+			Type:        model.POST_ACTION_TYPE_SELECT,
+			Options:     question.getOptions(),
+			Integration: makeIntegration(""),
+		}
 
-	return &model.Post{
-		Type:    "custom_nps_survey",
-		Message: fmt.Sprintf(surveyBody, user.Username),
-		Props: map[string]interface{}{
-			"attachments": []*model.SlackAttachment{
-				{
-					Title:   surveyDropdownTitle,
-					Text:    fmt.Sprintf(surveyAnsweredBody, score),
-					Actions: []*model.PostAction{action},
-				},
+		if answered {
+			action.DefaultOption = answer
+		}
+
+		attachment.Actions = []*model.PostAction{action}
+	case QUESTION_TYPE_YES_NO:
+		attachment.Actions = []*model.PostAction{
+			{
+				Name:        T(surveyAnswerYes),
+				Type:        model.POST_ACTION_TYPE_BUTTON,
+				Integration: makeIntegration(ANSWER_YES),
+			},
+			{
+				Name:        T(surveyAnswerNo),
+				Type:        model.POST_ACTION_TYPE_BUTTON,
+				Integration: makeIntegration(ANSWER_NO),
+			},
+		}
+	case QUESTION_TYPE_FREE_TEXT:
+		name := T(surveyAnswerButton)
+		if answered {
+			name = T(surveyChangeAnswerButton)
+		}
+
+		attachment.Actions = []*model.PostAction{
+			{
+				Name:        name,
+				Type:        model.POST_ACTION_TYPE_BUTTON,
+				Integration: makeIntegration(""),
 			},
-		},
+		}
 	}
+
+	if answered {
+		attachment.Text = question.describeAnswer(T, answer)
+	}
+
+	return attachment
+}
+
+// feedbackRequestBodies contains the follow-up question asked for each SCORE_BUCKET_* group.
+var feedbackRequestBodies = map[string]string{
+	SCORE_BUCKET_DETRACTOR: feedbackRequestDetractorBody,
+	SCORE_BUCKET_PASSIVE:   feedbackRequestPassiveBody,
+	SCORE_BUCKET_PROMOTER:  feedbackRequestPromoterBody,
 }
 
-func (p *Plugin) buildFeedbackRequestPost() *model.Post {
+// buildFeedbackRequestPost creates the post asking the user for feedback after they first answer the survey. The
+// question asked depends on the score that they gave, and a general question is asked if they haven't given a score.
+func (p *Plugin) buildFeedbackRequestPost(user *model.User, response *surveyResponse) *model.Post {
+	T := p.getUserTranslateFunc(user)
+
+	messageID := feedbackRequestBody
+	score := ""
+	if response.hasScore() {
+		messageID = feedbackRequestBodies[getScoreBucket(response.Score)]
+		score = strconv.Itoa(response.Score)
+	}
+
 	return &model.Post{
 		Type:    "custom_nps_feedback",
-		Message: feedbackRequestBody,
+		Message: T(messageID, map[string]interface{}{"Score": score}),
 	}
 }
 
@@ -435,3 +673,30 @@ func (p *Plugin) markSurveyAnswered(userID string, now time.Time) (bool, *model.
 
 	return true, nil
 }
+
+// setFeedbackPostID stores the ID of the post asking the user for feedback on the last survey that they were sent.
+func (p *Plugin) setFeedbackPostID(userID string, postID string) *model.AppError {
+	var userSurvey *userSurveyState
+	if err := p.KVGet(fmt.Sprintf(USER_SURVEY_KEY, userID), &userSurvey); err != nil {
+		return err
+	}
+
+	if userSurvey == nil {
+		return nil
+	}
+
+	userSurvey.FeedbackPostId = postID
+
+	return p.KVSet(fmt.Sprintf(USER_SURVEY_KEY, userID), userSurvey)
+}
+
+// isScoreLocked returns whether or not the score given in the response can no longer be changed because
+// ScoreChangeWindowHours have passed since it was first given.
+func (p *Plugin) isScoreLocked(response *surveyResponse) bool {
+	window := p.getConfiguration().getScoreChangeWindow()
+	if window == 0 || response == nil || !response.hasScore() {
+		return false
+	}
+
+	return p.now().Sub(response.getFirstScoreAt()) >= window
+}
@@ -4,7 +4,101 @@ import (
 	"html/template"
 )
 
-const adminEmailSubject = "[%s] Net Promoter Score survey scheduled in %d days"
+// The IDs of each message sent by Surveybot. Messages are written using text/template, and their English text is
+// below in defaultTranslations. Translations into other languages are loaded from the plugin's assets/i18n directory.
+const (
+	adminEmailSubject      = "admin_email.subject"
+	adminEmailTitle        = "admin_email.title"
+	adminEmailBody         = "admin_email.body"
+	adminEmailLink         = "admin_email.link"
+	adminEmailOrganization = "admin_email.organization"
+
+	adminDMBody = "admin_dm.body"
+
+	surveyBody                 = "survey.body"
+	surveyDropdownTitle        = "survey.dropdown.title"
+	surveyDropdownMinLabel     = "survey.dropdown.min_label"
+	surveyDropdownMaxLabel     = "survey.dropdown.max_label"
+	surveySelectOption         = "survey.select_option"
+	surveyAnswerYes            = "survey.answer.yes"
+	surveyAnswerNo             = "survey.answer.no"
+	surveyAnswerButton         = "survey.answer.button"
+	surveyChangeAnswerButton   = "survey.answer.change_button"
+	surveyAnsweredRatingBody   = "survey.answered.rating"
+	surveyAnsweredChoiceBody   = "survey.answered.choice"
+	surveyAnsweredYesNoBody    = "survey.answered.yes_no"
+	surveyAnsweredFreeTextBody = "survey.answered.free_text"
+	surveyReminderBody         = "survey.reminder"
+	surveyClosedBody           = "survey.closed"
+	surveyClosedError          = "survey.closed_error"
+	surveyOptOutButton         = "survey.opt_out_button"
+	surveyScoreLockedError     = "survey.score_locked_error"
+	surveyUnsignedError        = "survey.unsigned_error"
+
+	answerDialogTitle        = "answer_dialog.title"
+	answerDialogElementName  = "answer_dialog.element_name"
+	answerDialogSubmitLabel  = "answer_dialog.submit_label"
+	answerDialogInvalidError = "answer_dialog.invalid_error"
+
+	feedbackRequestBody          = "feedback.request"
+	feedbackRequestDetractorBody = "feedback.request.detractor"
+	feedbackRequestPassiveBody   = "feedback.request.passive"
+	feedbackRequestPromoterBody  = "feedback.request.promoter"
+	feedbackResponseBody         = "feedback.response"
+
+	unsubscribeBody = "subscription.unsubscribed"
+	subscribeBody   = "subscription.subscribed"
+)
+
+// defaultTranslations contains the English text of each message. It's used for users whose locale hasn't been
+// translated and for any messages missing from a translation.
+var defaultTranslations = map[string]string{
+	adminEmailSubject:      "[{{.SiteName}}] Net Promoter Score survey scheduled in {{.DaysUntilSurvey}} days",
+	adminEmailTitle:        "Net Promoter Survey Scheduled",
+	adminEmailBody:         "Mattermost is introducing feedback surveys to measure user satisfaction and improve product quality. Surveys will start to be sent to users in <strong>{{.DaysUntilSurvey}} days</strong>.",
+	adminEmailLink:         `<a href="{{.SiteURL}}/admin_console/plugins/plugin_{{.PluginID}}">Click here</a> to disable or learn more about Net Promoter surveys.`,
+	adminEmailOrganization: "Sent by {{.Organization}}",
+
+	adminDMBody: `Mattermost uses feedback surveys to measure user satisfaction and improve product quality. User surveys will start to be sent on {{.SurveyDate}}.
+
+[Click here](/admin_console/plugins/plugin_{{.PluginID}}) to disable or learn more about Net Promoter Score Surveys.
+
+*This message is only visible to System Admins.*`,
+
+	surveyBody:                 ":wave: Hey @{{.Username}}! Please take a few moments to help us improve your experience with Mattermost.",
+	surveyDropdownTitle:        "How likely are you to recommend Mattermost?",
+	surveyDropdownMinLabel:     "Not Likely",
+	surveyDropdownMaxLabel:     "Very Likely",
+	surveySelectOption:         "Select an option...",
+	surveyAnswerYes:            "Yes",
+	surveyAnswerNo:             "No",
+	surveyAnswerButton:         "Answer",
+	surveyChangeAnswerButton:   "Change Answer",
+	surveyAnsweredRatingBody:   "You selected {{.Answer}} out of {{.Max}}.",
+	surveyAnsweredChoiceBody:   "You selected {{.Answer}}.",
+	surveyAnsweredYesNoBody:    "You answered {{.Answer}}.",
+	surveyAnsweredFreeTextBody: "You answered: {{.Answer}}",
+	surveyReminderBody:         "Hey @{{.Username}}, just a reminder that there's still time to answer the survey above. Your feedback helps us make Mattermost better!",
+	surveyClosedBody:           "This survey has closed. Thanks anyway!",
+	surveyClosedError:          "Sorry, this survey has closed and is no longer accepting answers.",
+	surveyOptOutButton:         "Stop Sending Surveys",
+	surveyScoreLockedError:     "Sorry, your score can no longer be changed.",
+	surveyUnsignedError:        "Sorry, this survey was sent before Surveybot was updated. Please answer it again.",
+
+	answerDialogTitle:        "Survey Question",
+	answerDialogElementName:  "Your Answer",
+	answerDialogSubmitLabel:  "Submit",
+	answerDialogInvalidError: "Please enter a valid answer.",
+
+	feedbackRequestBody:          "Thanks! How can we make your experience better?",
+	feedbackRequestDetractorBody: "Thanks for your honesty! What's the main thing we should fix?",
+	feedbackRequestPassiveBody:   "Thanks! What would make Mattermost a 10 for you?",
+	feedbackRequestPromoterBody:  "Thanks! What do you like most about Mattermost?",
+	feedbackResponseBody:         ":tada: Thanks for helping us make Mattermost better!",
+
+	unsubscribeBody: "You won't be sent any more surveys. If you change your mind, reply \"subscribe\" at any time.",
+	subscribeBody:   "Thanks! You'll be sent surveys again. Reply \"stop\" at any time to opt out.",
+}
 
 var adminEmailBodyTemplate = template.Must(template.New("emailBody").Parse(`
 <table align="center" border="0" cellpadding="0" cellspacing="0" width="100%" style="margin-top: 20px; line-height: 1.7; color: #555;">
@@ -24,9 +118,9 @@ var adminEmailBodyTemplate = template.Must(template.New("emailBody").Parse(`
                                     <table border="0" cellpadding="0" cellspacing="0" style="padding: 20px 50px 0; text-align: center; margin: 0 auto">
                                         <tr>
                                             <td style="padding: 0 0 20px;">
-                                                <h2 style="font-weight: normal; margin-top: 10px;">Net Promoter Survey Scheduled</h2>
-                                                <p>Mattermost is introducing feedback surveys to measure user satisfaction and improve product quality. Surveys will start to be sent to users in <strong>{{.DaysUntilSurvey}} days</strong>.</p>
-                                                <p><a href="{{.SiteURL}}/admin_console/plugins/plugin_{{.PluginID}}">Click here</a> to disable or learn more about Net Promoter surveys.</p>
+                                                <h2 style="font-weight: normal; margin-top: 10px;">{{.Title}}</h2>
+                                                <p>{{.Body}}</p>
+                                                <p>{{.Link}}</p>
                                             </td>
                                         </tr>
                                         <tr>
@@ -52,16 +146,3 @@ var adminEmailBodyTemplate = template.Must(template.New("emailBody").Parse(`
     </tr>
 </table>
 `))
-
-const adminDMBody = `Mattermost uses feedback surveys to measure user satisfaction and improve product quality. User surveys will start to be sent on %s.
-
-[Click here](/admin_console/plugins/plugin_%s) to disable or learn more about Net Promoter Score Surveys.
-
-*This message is only visible to System Admins.*`
-
-const surveyBody = ":wave: Hey @%s! Please take a few moments to help us improve your experience with Mattermost."
-const surveyDropdownTitle = "How likely are you to recommend Mattermost?"
-const surveyAnsweredBody = "You selected %d out of 10."
-
-const feedbackRequestBody = "Thanks! How can we make your experience better?"
-const feedbackResponseBody = ":tada: Thanks for helping us make Mattermost better!"
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"net/http"
+	"strings"
 	"testing"
 	"time"
 
@@ -9,6 +11,7 @@ import (
 	"github.com/mattermost/mattermost-server/plugin/plugintest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCheckForNextSurvey(t *testing.T) {
@@ -34,7 +37,7 @@ func TestCheckForNextSurvey(t *testing.T) {
 		api.On("KVSet", surveyKey, mustMarshalJSON(&surveyState{
 			ServerVersion: serverVersion,
 			CreateAt:      now(),
-			StartAt:       now().Add(TIME_UNTIL_SURVEY),
+			StartAt:       now().Add(DEFAULT_TIME_UNTIL_SURVEY),
 		})).Return(nil)
 		api.On("KVGet", LAST_ADMIN_NOTICE_KEY).Return(nil, nil)
 		api.On("GetUsers", mock.Anything).Return([]*model.User{
@@ -263,7 +266,85 @@ func TestSendAdminNoticeEmails(t *testing.T) {
 	p := Plugin{}
 	p.SetAPI(api)
 
-	p.sendAdminNoticeEmails(admins)
+	p.sendAdminNoticeEmails(admins, &surveyState{StartAt: toDate(2019, time.March, 1)})
+}
+
+func TestSendAdminNoticeEmailsWithConfiguredDelay(t *testing.T) {
+	admins := []*model.User{
+		{
+			Email: "admin1@example.com",
+		},
+	}
+
+	api := &plugintest.API{}
+	api.On("GetConfig").Return(&model.Config{
+		ServiceSettings: model.ServiceSettings{
+			SiteURL: model.NewString("https://mattermost.example.com"),
+		},
+		TeamSettings: model.TeamSettings{
+			SiteName: model.NewString("SiteName"),
+		},
+		EmailSettings: model.EmailSettings{
+			FeedbackOrganization: model.NewString(""),
+		},
+	})
+	api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything)
+	api.On("SendMail", admins[0].Email, "[SiteName] Net Promoter Score survey scheduled in 14 days", mock.MatchedBy(func(body string) bool {
+		return strings.Contains(body, "<strong>14 days</strong>")
+	})).Return(nil)
+	defer api.AssertExpectations(t)
+
+	p := Plugin{
+		configuration: &configuration{
+			DaysUntilSurvey: 14,
+		},
+	}
+	p.SetAPI(api)
+
+	p.sendAdminNoticeEmails(admins, &surveyState{StartAt: toDate(2019, time.March, 1)})
+}
+
+func TestSendAdminNoticeEmailsInAdminLocale(t *testing.T) {
+	admins := []*model.User{
+		{
+			Email:  "admin1@example.com",
+			Locale: "es",
+		},
+		{
+			Email: "admin2@example.com",
+		},
+	}
+
+	api := &plugintest.API{}
+	api.On("GetConfig").Return(&model.Config{
+		ServiceSettings: model.ServiceSettings{
+			SiteURL: model.NewString("https://mattermost.example.com"),
+		},
+		TeamSettings: model.TeamSettings{
+			SiteName: model.NewString("SiteName"),
+		},
+		EmailSettings: model.EmailSettings{
+			FeedbackOrganization: model.NewString("Example <Org>"),
+		},
+	})
+	api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything)
+	api.On("SendMail", admins[0].Email, "[SiteName] Encuesta Net Promoter Score programada en 21 días", mock.MatchedBy(func(body string) bool {
+		return strings.Contains(body, "<strong>21 días</strong>") && strings.Contains(body, "Enviado por Example &lt;Org&gt;")
+	})).Return(nil)
+	api.On("SendMail", admins[1].Email, "[SiteName] Net Promoter Score survey scheduled in 21 days", mock.MatchedBy(func(body string) bool {
+		return strings.Contains(body, "<strong>21 days</strong>") && strings.Contains(body, "Sent by Example &lt;Org&gt;")
+	})).Return(nil)
+	defer api.AssertExpectations(t)
+
+	p := Plugin{translations: newTranslationBundle()}
+	p.translations.ParseTranslationFileBytes("es.json", []byte(`[
+		{"id": "admin_email.subject", "translation": "[{{.SiteName}}] Encuesta Net Promoter Score programada en {{.DaysUntilSurvey}} días"},
+		{"id": "admin_email.body", "translation": "Las encuestas comenzarán en <strong>{{.DaysUntilSurvey}} días</strong>."},
+		{"id": "admin_email.organization", "translation": "Enviado por {{.Organization}}"}
+	]`))
+	p.SetAPI(api)
+
+	p.sendAdminNoticeEmails(admins, &surveyState{StartAt: toDate(2019, time.March, 1)})
 }
 
 func TestSendAdminNoticeDMs(t *testing.T) {
@@ -593,7 +674,7 @@ func TestCheckForSurveyDM(t *testing.T) {
 	t.Run("should send first ever survey DM", func(t *testing.T) {
 		user := &model.User{
 			Id:       model.NewId(),
-			CreateAt: now.Add(-1*TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
+			CreateAt: now.Add(-1*DEFAULT_TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
 		}
 
 		api := makeAPIMock()
@@ -601,9 +682,11 @@ func TestCheckForSurveyDM(t *testing.T) {
 			ServerVersion: serverVersion,
 			StartAt:       now,
 		}), nil)
+		api.On("KVGet", fmt.Sprintf(USER_PREFERENCES_KEY, user.Id)).Return(nil, nil)
 		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, user.Id)).Return(nil, nil)
 		api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: model.NewString("https://mattermost.example.com")}})
 		api.On("GetDirectChannel", user.Id, botUserID).Return(&model.Channel{}, nil)
+		api.On("KVGet", QUESTIONS_KEY).Return(nil, nil)
 		api.On("CreatePost", mock.Anything).Return(&model.Post{Id: postID}, nil)
 		api.On("KVSet", fmt.Sprintf(USER_SURVEY_KEY, user.Id), newSurveyStateBytes).Return(nil)
 		defer api.AssertExpectations(t)
@@ -618,7 +701,7 @@ func TestCheckForSurveyDM(t *testing.T) {
 	t.Run("should return error if unable to save survey state", func(t *testing.T) {
 		user := &model.User{
 			Id:       model.NewId(),
-			CreateAt: now.Add(-1*TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
+			CreateAt: now.Add(-1*DEFAULT_TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
 		}
 
 		api := makeAPIMock()
@@ -626,9 +709,11 @@ func TestCheckForSurveyDM(t *testing.T) {
 			ServerVersion: serverVersion,
 			StartAt:       now,
 		}), nil)
+		api.On("KVGet", fmt.Sprintf(USER_PREFERENCES_KEY, user.Id)).Return(nil, nil)
 		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, user.Id)).Return(nil, nil)
 		api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: model.NewString("https://mattermost.example.com")}})
 		api.On("GetDirectChannel", user.Id, botUserID).Return(&model.Channel{}, nil)
+		api.On("KVGet", QUESTIONS_KEY).Return(nil, nil)
 		api.On("CreatePost", mock.Anything).Return(&model.Post{Id: postID}, nil)
 		api.On("KVSet", fmt.Sprintf(USER_SURVEY_KEY, user.Id), newSurveyStateBytes).Return(&model.AppError{})
 		defer api.AssertExpectations(t)
@@ -643,7 +728,7 @@ func TestCheckForSurveyDM(t *testing.T) {
 	t.Run("should return error if unable to send DM", func(t *testing.T) {
 		user := &model.User{
 			Id:       model.NewId(),
-			CreateAt: now.Add(-1*TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
+			CreateAt: now.Add(-1*DEFAULT_TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
 		}
 
 		api := makeAPIMock()
@@ -651,9 +736,11 @@ func TestCheckForSurveyDM(t *testing.T) {
 			ServerVersion: serverVersion,
 			StartAt:       now,
 		}), nil)
+		api.On("KVGet", fmt.Sprintf(USER_PREFERENCES_KEY, user.Id)).Return(nil, nil)
 		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, user.Id)).Return(nil, nil)
 		api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: model.NewString("https://mattermost.example.com")}})
 		api.On("GetDirectChannel", user.Id, botUserID).Return(&model.Channel{}, nil)
+		api.On("KVGet", QUESTIONS_KEY).Return(nil, nil)
 		api.On("CreatePost", mock.Anything).Return(nil, &model.AppError{})
 		defer api.AssertExpectations(t)
 
@@ -667,7 +754,7 @@ func TestCheckForSurveyDM(t *testing.T) {
 	t.Run("should send survey DM if it's been long enough since the last survey", func(t *testing.T) {
 		user := &model.User{
 			Id:       model.NewId(),
-			CreateAt: now.Add(-1*TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
+			CreateAt: now.Add(-1*DEFAULT_TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
 		}
 
 		api := makeAPIMock()
@@ -675,13 +762,15 @@ func TestCheckForSurveyDM(t *testing.T) {
 			ServerVersion: serverVersion,
 			StartAt:       now,
 		}), nil)
+		api.On("KVGet", fmt.Sprintf(USER_PREFERENCES_KEY, user.Id)).Return(nil, nil)
 		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, user.Id)).Return(mustMarshalJSON(&userSurveyState{
 			ServerVersion: "5.11.0",
-			SentAt:        now.Add(-1 * MIN_TIME_BETWEEN_USER_SURVEYS),
-			AnsweredAt:    now.Add(-1 * MIN_TIME_BETWEEN_USER_SURVEYS),
+			SentAt:        now.Add(-1 * DEFAULT_MIN_TIME_BETWEEN_USER_SURVEYS),
+			AnsweredAt:    now.Add(-1 * DEFAULT_MIN_TIME_BETWEEN_USER_SURVEYS),
 		}), nil)
 		api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: model.NewString("https://mattermost.example.com")}})
 		api.On("GetDirectChannel", user.Id, botUserID).Return(&model.Channel{}, nil)
+		api.On("KVGet", QUESTIONS_KEY).Return(nil, nil)
 		api.On("CreatePost", mock.Anything).Return(&model.Post{Id: postID}, nil)
 		api.On("KVSet", fmt.Sprintf(USER_SURVEY_KEY, user.Id), newSurveyStateBytes).Return(nil)
 		defer api.AssertExpectations(t)
@@ -696,7 +785,7 @@ func TestCheckForSurveyDM(t *testing.T) {
 	t.Run("should not send survey or return error if last survey was answered too recently", func(t *testing.T) {
 		user := &model.User{
 			Id:       model.NewId(),
-			CreateAt: now.Add(-1*TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
+			CreateAt: now.Add(-1*DEFAULT_TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
 		}
 
 		api := makeAPIMock()
@@ -704,10 +793,11 @@ func TestCheckForSurveyDM(t *testing.T) {
 			ServerVersion: serverVersion,
 			StartAt:       now,
 		}), nil)
+		api.On("KVGet", fmt.Sprintf(USER_PREFERENCES_KEY, user.Id)).Return(nil, nil)
 		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, user.Id)).Return(mustMarshalJSON(&userSurveyState{
 			ServerVersion: "5.11.0",
-			SentAt:        now.Add(-1 * MIN_TIME_BETWEEN_USER_SURVEYS),
-			AnsweredAt:    now.Add(-1 * MIN_TIME_BETWEEN_USER_SURVEYS).Add(time.Millisecond),
+			SentAt:        now.Add(-1 * DEFAULT_MIN_TIME_BETWEEN_USER_SURVEYS),
+			AnsweredAt:    now.Add(-1 * DEFAULT_MIN_TIME_BETWEEN_USER_SURVEYS).Add(time.Millisecond),
 		}), nil)
 		defer api.AssertExpectations(t)
 
@@ -718,10 +808,38 @@ func TestCheckForSurveyDM(t *testing.T) {
 		assert.Nil(t, err)
 	})
 
+	t.Run("should use the configured minimum time between user surveys", func(t *testing.T) {
+		user := &model.User{
+			Id:       model.NewId(),
+			CreateAt: now.Add(-1*DEFAULT_TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
+		}
+
+		api := makeAPIMock()
+		api.On("KVGet", fmt.Sprintf(SURVEY_KEY, serverVersion)).Return(mustMarshalJSON(&surveyState{
+			ServerVersion: serverVersion,
+			StartAt:       now,
+		}), nil)
+		api.On("KVGet", fmt.Sprintf(USER_PREFERENCES_KEY, user.Id)).Return(nil, nil)
+		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, user.Id)).Return(mustMarshalJSON(&userSurveyState{
+			ServerVersion: "5.11.0",
+			SentAt:        now.Add(-1 * DEFAULT_MIN_TIME_BETWEEN_USER_SURVEYS),
+			AnsweredAt:    now.Add(-1 * DEFAULT_MIN_TIME_BETWEEN_USER_SURVEYS),
+		}), nil)
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api)
+		p.configuration.MinDaysBetweenUserSurveys = 180
+
+		sent, err := p.checkForSurveyDM(user, now)
+
+		assert.False(t, sent)
+		assert.Nil(t, err)
+	})
+
 	t.Run("should not send survey or return error if last survey was sent too recently", func(t *testing.T) {
 		user := &model.User{
 			Id:       model.NewId(),
-			CreateAt: now.Add(-1*TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
+			CreateAt: now.Add(-1*DEFAULT_TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
 		}
 
 		api := makeAPIMock()
@@ -729,9 +847,10 @@ func TestCheckForSurveyDM(t *testing.T) {
 			ServerVersion: serverVersion,
 			StartAt:       now,
 		}), nil)
+		api.On("KVGet", fmt.Sprintf(USER_PREFERENCES_KEY, user.Id)).Return(nil, nil)
 		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, user.Id)).Return(mustMarshalJSON(&userSurveyState{
 			ServerVersion: "5.11.0",
-			SentAt:        now.Add(-1 * MIN_TIME_BETWEEN_USER_SURVEYS).Add(time.Millisecond),
+			SentAt:        now.Add(-1 * DEFAULT_MIN_TIME_BETWEEN_USER_SURVEYS).Add(time.Millisecond),
 		}), nil)
 		defer api.AssertExpectations(t)
 
@@ -745,7 +864,7 @@ func TestCheckForSurveyDM(t *testing.T) {
 	t.Run("should not send survey or return error if survey was already sent", func(t *testing.T) {
 		user := &model.User{
 			Id:       model.NewId(),
-			CreateAt: now.Add(-1*TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
+			CreateAt: now.Add(-1*DEFAULT_TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
 		}
 
 		api := makeAPIMock()
@@ -753,6 +872,7 @@ func TestCheckForSurveyDM(t *testing.T) {
 			ServerVersion: serverVersion,
 			StartAt:       now,
 		}), nil)
+		api.On("KVGet", fmt.Sprintf(USER_PREFERENCES_KEY, user.Id)).Return(nil, nil)
 		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, user.Id)).Return(mustMarshalJSON(&userSurveyState{
 			ServerVersion: serverVersion,
 		}), nil)
@@ -765,10 +885,33 @@ func TestCheckForSurveyDM(t *testing.T) {
 		assert.Nil(t, err)
 	})
 
+	t.Run("should not send survey DM to a user who has opted out", func(t *testing.T) {
+		user := &model.User{
+			Id:       model.NewId(),
+			CreateAt: now.Add(-1*DEFAULT_TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
+		}
+
+		api := makeAPIMock()
+		api.On("KVGet", fmt.Sprintf(SURVEY_KEY, serverVersion)).Return(mustMarshalJSON(&surveyState{
+			ServerVersion: serverVersion,
+			StartAt:       now,
+		}), nil)
+		api.On("KVGet", fmt.Sprintf(USER_PREFERENCES_KEY, user.Id)).Return(mustMarshalJSON(&userPreferences{
+			SurveysDisabled: true,
+		}), nil)
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api)
+		sent, err := p.checkForSurveyDM(user, now)
+
+		assert.False(t, sent)
+		assert.Nil(t, err)
+	})
+
 	t.Run("should return error if unable to get user survey state", func(t *testing.T) {
 		user := &model.User{
 			Id:       model.NewId(),
-			CreateAt: now.Add(-1*TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
+			CreateAt: now.Add(-1*DEFAULT_TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
 		}
 
 		api := makeAPIMock()
@@ -776,6 +919,7 @@ func TestCheckForSurveyDM(t *testing.T) {
 			ServerVersion: serverVersion,
 			StartAt:       now,
 		}), nil)
+		api.On("KVGet", fmt.Sprintf(USER_PREFERENCES_KEY, user.Id)).Return(nil, nil)
 		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, user.Id)).Return(nil, &model.AppError{})
 		defer api.AssertExpectations(t)
 
@@ -789,7 +933,7 @@ func TestCheckForSurveyDM(t *testing.T) {
 	t.Run("should not send survey or return error if survey hasn't started yet", func(t *testing.T) {
 		user := &model.User{
 			Id:       model.NewId(),
-			CreateAt: now.Add(-1*TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
+			CreateAt: now.Add(-1*DEFAULT_TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
 		}
 
 		api := makeAPIMock()
@@ -806,10 +950,32 @@ func TestCheckForSurveyDM(t *testing.T) {
 		assert.Nil(t, err)
 	})
 
+	t.Run("should not send survey or return error if the survey hasn't been rolled out to the user yet", func(t *testing.T) {
+		user := &model.User{
+			Id:       model.NewId(),
+			CreateAt: now.Add(-1*DEFAULT_TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
+		}
+
+		api := makeAPIMock()
+		api.On("KVGet", fmt.Sprintf(SURVEY_KEY, serverVersion)).Return(mustMarshalJSON(&surveyState{
+			ServerVersion: serverVersion,
+			StartAt:       now.Add(-1 * getRolloutDelay(user.Id, serverVersion, 7)).Add(time.Millisecond),
+		}), nil)
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api)
+		p.configuration.SurveyRolloutDays = 7
+
+		sent, err := p.checkForSurveyDM(user, now)
+
+		assert.False(t, sent)
+		assert.Nil(t, err)
+	})
+
 	t.Run("should not send survey or return error if there's no survey scheduled", func(t *testing.T) {
 		user := &model.User{
 			Id:       model.NewId(),
-			CreateAt: now.Add(-1*TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
+			CreateAt: now.Add(-1*DEFAULT_TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
 		}
 
 		api := makeAPIMock()
@@ -823,10 +989,31 @@ func TestCheckForSurveyDM(t *testing.T) {
 		assert.Nil(t, err)
 	})
 
+	t.Run("should not send survey or return error if the survey was cancelled", func(t *testing.T) {
+		user := &model.User{
+			Id:       model.NewId(),
+			CreateAt: now.Add(-1*DEFAULT_TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
+		}
+
+		api := makeAPIMock()
+		api.On("KVGet", fmt.Sprintf(SURVEY_KEY, serverVersion)).Return(mustMarshalJSON(&surveyState{
+			ServerVersion: serverVersion,
+			StartAt:       now,
+			Cancelled:     true,
+		}), nil)
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api)
+		sent, err := p.checkForSurveyDM(user, now)
+
+		assert.False(t, sent)
+		assert.Nil(t, err)
+	})
+
 	t.Run("should return error if unable to get the scheduled survey", func(t *testing.T) {
 		user := &model.User{
 			Id:       model.NewId(),
-			CreateAt: now.Add(-1*TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
+			CreateAt: now.Add(-1*DEFAULT_TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
 		}
 
 		api := makeAPIMock()
@@ -843,13 +1030,57 @@ func TestCheckForSurveyDM(t *testing.T) {
 	t.Run("should not send survey or return error if the user hasn't existed for long enough", func(t *testing.T) {
 		user := &model.User{
 			Id:       model.NewId(),
-			CreateAt: now.Add(-1*TIME_UNTIL_SURVEY).Add(time.Minute).UnixNano() / int64(time.Millisecond),
+			CreateAt: now.Add(-1*DEFAULT_TIME_UNTIL_SURVEY).Add(time.Minute).UnixNano() / int64(time.Millisecond),
+		}
+
+		api := makeAPIMock()
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api)
+		sent, err := p.checkForSurveyDM(user, now)
+
+		assert.False(t, sent)
+		assert.Nil(t, err)
+	})
+
+	t.Run("should not send survey or return error if the user isn't targeted", func(t *testing.T) {
+		user := &model.User{
+			Id:       model.NewId(),
+			CreateAt: now.Add(-1*DEFAULT_TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
 		}
+		channelID := model.NewId()
 
 		api := makeAPIMock()
+		api.On("KVGet", fmt.Sprintf(SURVEY_KEY, serverVersion)).Return(mustMarshalJSON(&surveyState{
+			ServerVersion: serverVersion,
+			StartAt:       now,
+		}), nil)
+		api.On("KVGet", fmt.Sprintf(USER_PREFERENCES_KEY, user.Id)).Return(nil, nil)
+		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, user.Id)).Return(nil, nil)
+		api.On("GetChannelMember", channelID, user.Id).Return(nil, &model.AppError{StatusCode: http.StatusNotFound})
 		defer api.AssertExpectations(t)
 
 		p := makePlugin(api)
+		p.configuration.SurveyTargetChannels = channelID
+
+		sent, err := p.checkForSurveyDM(user, now)
+
+		assert.False(t, sent)
+		assert.Nil(t, err)
+	})
+
+	t.Run("should use the configured minimum account age", func(t *testing.T) {
+		user := &model.User{
+			Id:       model.NewId(),
+			CreateAt: now.Add(-1*DEFAULT_TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
+		}
+
+		api := makeAPIMock()
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api)
+		p.configuration.SurveyMinAccountAgeDays = 30
+
 		sent, err := p.checkForSurveyDM(user, now)
 
 		assert.False(t, sent)
@@ -859,7 +1090,7 @@ func TestCheckForSurveyDM(t *testing.T) {
 	t.Run("should not send survey or return error if surveys are disabled", func(t *testing.T) {
 		user := &model.User{
 			Id:       model.NewId(),
-			CreateAt: now.Add(-1*TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
+			CreateAt: now.Add(-1*DEFAULT_TIME_UNTIL_SURVEY).UnixNano() / int64(time.Millisecond),
 		}
 
 		api := makeAPIMock()
@@ -923,3 +1154,163 @@ func TestMarkSurveyAnswered(t *testing.T) {
 		assert.Nil(t, err)
 	})
 }
+
+func TestBuildSurveyPost(t *testing.T) {
+	user := &model.User{Id: model.NewId(), Username: "someone"}
+	userSurvey := &userSurveyState{ServerVersion: "5.10.0"}
+
+	makePlugin := func() *Plugin {
+		api := &plugintest.API{}
+		api.On("GetConfig").Return(&model.Config{
+			ServiceSettings: model.ServiceSettings{
+				SiteURL: model.NewString("https://mattermost.example.com"),
+			},
+		})
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		return p
+	}
+
+	t.Run("should use the custom NPS survey post for the default questions", func(t *testing.T) {
+		post := makePlugin().buildSurveyPost(user, userSurvey, []*surveyQuestion{npsQuestion}, nil)
+
+		assert.Equal(t, "custom_nps_survey", post.Type)
+		assert.Equal(t, "Not Likely", post.Props["min_label"])
+		assert.Equal(t, "Very Likely", post.Props["max_label"])
+
+		attachments := post.Props["attachments"].([]*model.SlackAttachment)
+		require.Len(t, attachments, 1)
+		assert.Equal(t, "How likely are you to recommend Mattermost?", attachments[0].Title)
+		require.Len(t, attachments[0].Actions, 2)
+		assert.Len(t, attachments[0].Actions[0].Options, 11)
+		assert.Equal(t, "", attachments[0].Actions[0].DefaultOption)
+		assert.Equal(t, NPS_QUESTION_ID, attachments[0].Actions[0].Integration.Context["question_id"])
+		assert.Equal(t, "5.10.0", attachments[0].Actions[0].Integration.Context["survey_id"])
+		assert.Equal(t, user.Id, attachments[0].Actions[0].Integration.Context["user_id"])
+		assert.NotEmpty(t, attachments[0].Actions[0].Integration.Context["signature"])
+		assert.Equal(t, "Stop Sending Surveys", attachments[0].Actions[1].Name)
+		assert.Equal(t, "https://mattermost.example.com/plugins/com.mattermost.nps/api/v1/opt_out", attachments[0].Actions[1].Integration.URL)
+	})
+
+	t.Run("should show answers that have been given", func(t *testing.T) {
+		response := &surveyResponse{}
+		response.setAnswer(NPS_QUESTION_ID, "8", toDate(2019, time.June, 1))
+
+		post := makePlugin().buildSurveyPost(user, userSurvey, []*surveyQuestion{npsQuestion}, response)
+
+		attachments := post.Props["attachments"].([]*model.SlackAttachment)
+		assert.Equal(t, "You selected 8 out of 10.", attachments[0].Text)
+		assert.Equal(t, "8", attachments[0].Actions[0].DefaultOption)
+	})
+
+	t.Run("should remove the score dropdown once the score is locked", func(t *testing.T) {
+		response := &surveyResponse{}
+		response.setAnswer(NPS_QUESTION_ID, "8", toDate(2019, time.June, 1))
+
+		p := makePlugin()
+		p.configuration = &configuration{ScoreChangeWindowHours: 24}
+		p.now = func() time.Time {
+			return toDate(2019, time.June, 2)
+		}
+
+		post := p.buildSurveyPost(user, userSurvey, []*surveyQuestion{npsQuestion}, response)
+
+		assert.Equal(t, "", post.Type)
+
+		attachments := post.Props["attachments"].([]*model.SlackAttachment)
+		assert.Equal(t, "You selected 8 out of 10.", attachments[0].Text)
+		require.Len(t, attachments[0].Actions, 1)
+		assert.Equal(t, "Stop Sending Surveys", attachments[0].Actions[0].Name)
+	})
+
+	t.Run("should use regular attachments for other questions", func(t *testing.T) {
+		post := makePlugin().buildSurveyPost(user, userSurvey, []*surveyQuestion{
+			{ID: "remote", Type: QUESTION_TYPE_YES_NO, Text: "Do you work remotely?"},
+			{ID: "comments", Type: QUESTION_TYPE_FREE_TEXT, Text: "Anything else?"},
+		}, nil)
+
+		assert.Equal(t, "", post.Type)
+
+		attachments := post.Props["attachments"].([]*model.SlackAttachment)
+		require.Len(t, attachments, 2)
+
+		require.Len(t, attachments[0].Actions, 2)
+		assert.Equal(t, model.POST_ACTION_TYPE_BUTTON, attachments[0].Actions[0].Type)
+		assert.Equal(t, ANSWER_YES, attachments[0].Actions[0].Integration.Context["selected_option"])
+		assert.Equal(t, ANSWER_NO, attachments[0].Actions[1].Integration.Context["selected_option"])
+
+		require.Len(t, attachments[1].Actions, 2)
+		assert.Equal(t, "Answer", attachments[1].Actions[0].Name)
+		assert.Equal(t, "comments", attachments[1].Actions[0].Integration.Context["question_id"])
+		assert.Equal(t, "Stop Sending Surveys", attachments[1].Actions[1].Name)
+	})
+}
+
+func TestIsScoreLocked(t *testing.T) {
+	now := toDate(2019, time.June, 2)
+
+	makePlugin := func(config *configuration) *Plugin {
+		return &Plugin{
+			configuration: config,
+			now: func() time.Time {
+				return now
+			},
+		}
+	}
+
+	scored := &surveyResponse{Score: 8, ScoreAt: now.Add(-2 * time.Hour)}
+
+	t.Run("should never lock the score when the window is disabled", func(t *testing.T) {
+		assert.False(t, makePlugin(&configuration{}).isScoreLocked(scored))
+	})
+
+	t.Run("should not lock a score that hasn't been given", func(t *testing.T) {
+		p := makePlugin(&configuration{ScoreChangeWindowHours: 1})
+
+		assert.False(t, p.isScoreLocked(nil))
+		assert.False(t, p.isScoreLocked(&surveyResponse{}))
+	})
+
+	t.Run("should lock the score once the window has passed", func(t *testing.T) {
+		assert.False(t, makePlugin(&configuration{ScoreChangeWindowHours: 3}).isScoreLocked(scored))
+		assert.True(t, makePlugin(&configuration{ScoreChangeWindowHours: 2}).isScoreLocked(scored))
+	})
+
+	t.Run("should measure the window from the first score given", func(t *testing.T) {
+		changed := &surveyResponse{
+			Score:   9,
+			ScoreAt: now.Add(-time.Minute),
+			ScoreHistory: []*scoreRevision{
+				{Score: 8, ScoreAt: now.Add(-2 * time.Hour)},
+			},
+		}
+
+		assert.True(t, makePlugin(&configuration{ScoreChangeWindowHours: 1}).isScoreLocked(changed))
+	})
+}
+
+func TestBuildFeedbackRequestPost(t *testing.T) {
+	user := &model.User{Username: "someone"}
+	now := toDate(2019, time.June, 1)
+
+	p := &Plugin{configuration: &configuration{}}
+
+	makeResponse := func(score string) *surveyResponse {
+		response := &surveyResponse{}
+		response.setAnswer(NPS_QUESTION_ID, score, now)
+		return response
+	}
+
+	assert.Equal(t, "Thanks for your honesty! What's the main thing we should fix?", p.buildFeedbackRequestPost(user, makeResponse("6")).Message)
+	assert.Equal(t, "Thanks! What would make Mattermost a 10 for you?", p.buildFeedbackRequestPost(user, makeResponse("7")).Message)
+	assert.Equal(t, "Thanks! What do you like most about Mattermost?", p.buildFeedbackRequestPost(user, makeResponse("10")).Message)
+
+	t.Run("should ask a general question if no score has been given", func(t *testing.T) {
+		response := &surveyResponse{}
+		response.setAnswer("remote", ANSWER_YES, now)
+
+		assert.Equal(t, "Thanks! How can we make your experience better?", p.buildFeedbackRequestPost(user, response).Message)
+	})
+}
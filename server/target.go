@@ -0,0 +1,151 @@
+package main
+
+import (
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// validTargetRoles are the roles returned by getUserRole that can be used in SurveyTargetRoles.
+var validTargetRoles = []string{"system_admin", "team_admin", "user"}
+
+// splitSetting splits a comma-separated plugin setting into its values, ignoring any blank ones.
+func splitSetting(setting string) []string {
+	var values []string
+
+	for _, value := range strings.Split(setting, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+// getMinAccountAge returns how old a user's account must be before they can be sent a survey.
+func (c *configuration) getMinAccountAge() time.Duration {
+	if c.SurveyMinAccountAgeDays > 0 {
+		return daysToDuration(c.SurveyMinAccountAgeDays)
+	}
+
+	return c.getTimeUntilSurvey()
+}
+
+// isUserTargeted returns whether or not the user is part of the audience selected in the plugin configuration for the
+// given survey. A user must match every configured criteria, but they only need to match one of the teams, channels,
+// or roles listed for each one. The user's account age is checked separately by checkForSurveyDM.
+func (p *Plugin) isUserTargeted(user *model.User, survey *surveyState) (bool, *model.AppError) {
+	config := p.getConfiguration()
+
+	if !isUserSampled(user.Id, survey.getID(), config.SurveySamplePercent) {
+		return false, nil
+	}
+
+	if roles := splitSetting(config.SurveyTargetRoles); len(roles) > 0 && !containsString(roles, p.getUserRole(user)) {
+		return false, nil
+	}
+
+	if teams := splitSetting(config.SurveyTargetTeams); len(teams) > 0 {
+		if inTeam, err := p.isUserInAnyTeam(user.Id, teams); err != nil || !inTeam {
+			return false, err
+		}
+	}
+
+	if channels := splitSetting(config.SurveyTargetChannels); len(channels) > 0 {
+		if inChannel, err := p.isUserInAnyChannel(user.Id, channels); err != nil || !inChannel {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// isUserSampled returns whether or not the user falls into the given percentage of users sent a survey. The decision
+// is based on a hash of the user and survey IDs so that it stays the same each time the user is checked.
+func isUserSampled(userID string, surveyID string, percent int) bool {
+	if percent <= 0 || percent >= 100 {
+		return true
+	}
+
+	return hashUser(userID, surveyID, "sample")%100 < uint32(percent)
+}
+
+// getRolloutDelay returns how long after a survey starts that the user will be sent it so that surveys are spread out
+// over the given number of days instead of being sent to everyone at once. Like isUserSampled, the delay stays the
+// same each time the user is checked.
+func getRolloutDelay(userID string, surveyID string, rolloutDays int) time.Duration {
+	if rolloutDays <= 0 {
+		return 0
+	}
+
+	fraction := float64(hashUser(userID, surveyID, "rollout")) / (1 << 32)
+
+	return time.Duration(fraction * float64(daysToDuration(rolloutDays)))
+}
+
+// hashUser returns a hash of the user and survey IDs. The salt allows separate decisions to be made for the same user
+// and survey without them being correlated.
+func hashUser(userID string, surveyID string, salt string) uint32 {
+	hash := fnv.New32a()
+	hash.Write([]byte(salt + ":" + surveyID + ":" + userID))
+
+	return hash.Sum32()
+}
+
+func (p *Plugin) isUserInAnyTeam(userID string, teamNames []string) (bool, *model.AppError) {
+	for _, teamName := range teamNames {
+		team, err := p.API.GetTeamByName(teamName)
+		if err != nil {
+			if err.StatusCode == http.StatusNotFound {
+				p.API.LogWarn("Unable to find team used for survey targeting", "team", teamName)
+				continue
+			}
+
+			return false, err
+		}
+
+		member, err := p.API.GetTeamMember(team.Id, userID)
+		if err != nil {
+			if err.StatusCode == http.StatusNotFound {
+				continue
+			}
+
+			return false, err
+		}
+
+		if member.DeleteAt == 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (p *Plugin) isUserInAnyChannel(userID string, channelIDs []string) (bool, *model.AppError) {
+	for _, channelID := range channelIDs {
+		if _, err := p.API.GetChannelMember(channelID, userID); err != nil {
+			if err.StatusCode == http.StatusNotFound {
+				continue
+			}
+
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitSetting(t *testing.T) {
+	assert.Nil(t, splitSetting(""))
+	assert.Nil(t, splitSetting(" , "))
+	assert.Equal(t, []string{"a", "b"}, splitSetting("a,b"))
+	assert.Equal(t, []string{"a", "b"}, splitSetting(" a , ,b, "))
+}
+
+func TestGetMinAccountAge(t *testing.T) {
+	t.Run("should default to the time until survey", func(t *testing.T) {
+		c := &configuration{DaysUntilSurvey: 14}
+
+		assert.Equal(t, 14*24*time.Hour, c.getMinAccountAge())
+	})
+
+	t.Run("should use the configured account age", func(t *testing.T) {
+		c := &configuration{DaysUntilSurvey: 14, SurveyMinAccountAgeDays: 30}
+
+		assert.Equal(t, 30*24*time.Hour, c.getMinAccountAge())
+	})
+}
+
+func TestIsUserSampled(t *testing.T) {
+	t.Run("should include everyone when not sampling", func(t *testing.T) {
+		for _, percent := range []int{0, 100} {
+			for i := 0; i < 100; i++ {
+				assert.True(t, isUserSampled(model.NewId(), "5.12.0", percent))
+			}
+		}
+	})
+
+	t.Run("should make the same decision each time", func(t *testing.T) {
+		userID := model.NewId()
+
+		sampled := isUserSampled(userID, "5.12.0", 50)
+		for i := 0; i < 10; i++ {
+			assert.Equal(t, sampled, isUserSampled(userID, "5.12.0", 50))
+		}
+	})
+
+	t.Run("should include roughly the given percentage of users", func(t *testing.T) {
+		count := 0
+		for i := 0; i < 1000; i++ {
+			if isUserSampled(model.NewId(), "5.12.0", 10) {
+				count += 1
+			}
+		}
+
+		assert.InDelta(t, 100, count, 50)
+	})
+}
+
+func TestGetRolloutDelay(t *testing.T) {
+	t.Run("should not delay anyone when not rolling out", func(t *testing.T) {
+		assert.Equal(t, time.Duration(0), getRolloutDelay(model.NewId(), "5.12.0", 0))
+	})
+
+	t.Run("should return the same delay each time", func(t *testing.T) {
+		userID := model.NewId()
+
+		delay := getRolloutDelay(userID, "5.12.0", 7)
+		for i := 0; i < 10; i++ {
+			assert.Equal(t, delay, getRolloutDelay(userID, "5.12.0", 7))
+		}
+	})
+
+	t.Run("should spread users over the rollout window", func(t *testing.T) {
+		counts := make([]int, 7)
+		for i := 0; i < 700; i++ {
+			delay := getRolloutDelay(model.NewId(), "5.12.0", 7)
+
+			assert.True(t, delay >= 0)
+			assert.True(t, delay < 7*24*time.Hour)
+
+			counts[delay/(24*time.Hour)] += 1
+		}
+
+		for _, count := range counts {
+			assert.InDelta(t, 100, count, 50)
+		}
+	})
+}
+
+func TestIsUserTargeted(t *testing.T) {
+	survey := &surveyState{ServerVersion: "5.12.0"}
+
+	t.Run("should target everyone by default", func(t *testing.T) {
+		api := makeAPIMock()
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{configuration: &configuration{}}
+		p.SetAPI(api)
+
+		targeted, err := p.isUserTargeted(&model.User{Id: model.NewId()}, survey)
+
+		assert.True(t, targeted)
+		assert.Nil(t, err)
+	})
+
+	t.Run("should target users with one of the given roles", func(t *testing.T) {
+		api := makeAPIMock()
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{configuration: &configuration{SurveyTargetRoles: "team_admin, system_admin"}}
+		p.SetAPI(api)
+
+		targeted, err := p.isUserTargeted(&model.User{Id: model.NewId(), Roles: "system_user system_admin"}, survey)
+
+		assert.True(t, targeted)
+		assert.Nil(t, err)
+	})
+
+	t.Run("should not target users without one of the given roles", func(t *testing.T) {
+		user := &model.User{Id: model.NewId(), Roles: "system_user"}
+
+		api := makeAPIMock()
+		api.On("GetTeamMembersForUser", user.Id, 0, 50).Return([]*model.TeamMember{}, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{configuration: &configuration{SurveyTargetRoles: "system_admin,team_admin"}}
+		p.SetAPI(api)
+
+		targeted, err := p.isUserTargeted(user, survey)
+
+		assert.False(t, targeted)
+		assert.Nil(t, err)
+	})
+
+	t.Run("should target members of one of the given teams", func(t *testing.T) {
+		user := &model.User{Id: model.NewId()}
+		team1 := &model.Team{Id: model.NewId(), Name: "team1"}
+		team2 := &model.Team{Id: model.NewId(), Name: "team2"}
+
+		api := makeAPIMock()
+		api.On("GetTeamByName", "missing").Return(nil, &model.AppError{StatusCode: http.StatusNotFound})
+		api.On("GetTeamByName", team1.Name).Return(team1, nil)
+		api.On("GetTeamMember", team1.Id, user.Id).Return(nil, &model.AppError{StatusCode: http.StatusNotFound})
+		api.On("GetTeamByName", team2.Name).Return(team2, nil)
+		api.On("GetTeamMember", team2.Id, user.Id).Return(&model.TeamMember{TeamId: team2.Id, UserId: user.Id}, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{configuration: &configuration{SurveyTargetTeams: "missing,team1,team2"}}
+		p.SetAPI(api)
+
+		targeted, err := p.isUserTargeted(user, survey)
+
+		assert.True(t, targeted)
+		assert.Nil(t, err)
+	})
+
+	t.Run("should not target users who have left the given teams", func(t *testing.T) {
+		user := &model.User{Id: model.NewId()}
+		team := &model.Team{Id: model.NewId(), Name: "team"}
+
+		api := makeAPIMock()
+		api.On("GetTeamByName", team.Name).Return(team, nil)
+		api.On("GetTeamMember", team.Id, user.Id).Return(&model.TeamMember{TeamId: team.Id, UserId: user.Id, DeleteAt: 1234}, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{configuration: &configuration{SurveyTargetTeams: team.Name}}
+		p.SetAPI(api)
+
+		targeted, err := p.isUserTargeted(user, survey)
+
+		assert.False(t, targeted)
+		assert.Nil(t, err)
+	})
+
+	t.Run("should return error if unable to get team member", func(t *testing.T) {
+		user := &model.User{Id: model.NewId()}
+		team := &model.Team{Id: model.NewId(), Name: "team"}
+
+		api := makeAPIMock()
+		api.On("GetTeamByName", team.Name).Return(team, nil)
+		api.On("GetTeamMember", team.Id, user.Id).Return(nil, &model.AppError{StatusCode: http.StatusInternalServerError})
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{configuration: &configuration{SurveyTargetTeams: team.Name}}
+		p.SetAPI(api)
+
+		targeted, err := p.isUserTargeted(user, survey)
+
+		assert.False(t, targeted)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("should target members of one of the given channels", func(t *testing.T) {
+		user := &model.User{Id: model.NewId()}
+		channel1 := model.NewId()
+		channel2 := model.NewId()
+
+		api := makeAPIMock()
+		api.On("GetChannelMember", channel1, user.Id).Return(nil, &model.AppError{StatusCode: http.StatusNotFound})
+		api.On("GetChannelMember", channel2, user.Id).Return(&model.ChannelMember{ChannelId: channel2, UserId: user.Id}, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{configuration: &configuration{SurveyTargetChannels: channel1 + "," + channel2}}
+		p.SetAPI(api)
+
+		targeted, err := p.isUserTargeted(user, survey)
+
+		assert.True(t, targeted)
+		assert.Nil(t, err)
+	})
+
+	t.Run("should not target users outside of the given channels", func(t *testing.T) {
+		user := &model.User{Id: model.NewId()}
+		channel := model.NewId()
+
+		api := makeAPIMock()
+		api.On("GetChannelMember", channel, user.Id).Return(nil, &model.AppError{StatusCode: http.StatusNotFound})
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{configuration: &configuration{SurveyTargetChannels: channel}}
+		p.SetAPI(api)
+
+		targeted, err := p.isUserTargeted(user, survey)
+
+		assert.False(t, targeted)
+		assert.Nil(t, err)
+	})
+}
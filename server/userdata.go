@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+const (
+	// How often to delete expired responses and data stored about users that no longer exist
+	DATA_CLEANUP_INTERVAL = 24 * time.Hour
+)
+
+// userData contains everything that the plugin stores about a single user.
+type userData struct {
+	UserID       string            `json:"user_id"`
+	Preferences  *userPreferences  `json:"preferences"`
+	SurveyState  *userSurveyState  `json:"survey_state"`
+	AdminNotices []*adminNotice    `json:"admin_notices"`
+	Responses    []*surveyResponse `json:"responses"`
+
+	// WebhookDeliveries contains events about the user that are waiting to be sent to a webhook or that failed too
+	// many times.
+	WebhookDeliveries []*webhookDelivery `json:"webhook_deliveries"`
+
+	// keys contains the KV store keys that the data was read from.
+	keys []string
+}
+
+// getKeyUserID returns the ID of the user that a key stores data about, or an empty string if it isn't specific to a
+// user. Responses stored while surveys were anonymous return the user's anonymous ID.
+func getKeyUserID(key string) string {
+	for _, format := range []string{USER_SURVEY_KEY, USER_PREFERENCES_KEY} {
+		if prefix := getKeyPrefix(format); strings.HasPrefix(key, prefix) {
+			return strings.TrimPrefix(key, prefix)
+		}
+	}
+
+	if prefix := getKeyPrefix(ADMIN_DM_NOTICE_KEY); strings.HasPrefix(key, prefix) {
+		// The user ID is followed by the server version
+		return strings.SplitN(strings.TrimPrefix(key, prefix), "-", 2)[0]
+	}
+
+	if strings.HasPrefix(key, getKeyPrefix(RESPONSE_KEY)) {
+		// The survey ID may contain dashes, but the user ID never does
+		return key[strings.LastIndex(key, "-")+1:]
+	}
+
+	return ""
+}
+
+// getUserData returns everything stored about the user. Responses and webhook deliveries stored under the user's
+// anonymous ID are only included if includeAnonymous is true so that they can't be traced back to the user while
+// surveys are anonymous.
+func (p *Plugin) getUserData(userID string, includeAnonymous bool) (*userData, *model.AppError) {
+	anonymousID := p.getAnonymousID(userID)
+
+	isUser := func(id string) bool {
+		return id == userID || (includeAnonymous && id == anonymousID)
+	}
+
+	data := &userData{
+		UserID:            userID,
+		AdminNotices:      []*adminNotice{},
+		Responses:         []*surveyResponse{},
+		WebhookDeliveries: []*webhookDelivery{},
+	}
+
+	err := p.forEachKey("", func(key string) *model.AppError {
+		if isWebhookDeliveryKey(key) {
+			// Webhook deliveries are stored by their own ID, so the user is found in the event instead
+			var delivery *webhookDelivery
+			if err := p.KVGet(key, &delivery); err != nil {
+				return err
+			}
+
+			if delivery != nil && isUser(delivery.getUserID()) {
+				data.keys = append(data.keys, key)
+				data.WebhookDeliveries = append(data.WebhookDeliveries, delivery)
+			}
+
+			return nil
+		}
+
+		if !isUser(getKeyUserID(key)) {
+			return nil
+		}
+
+		data.keys = append(data.keys, key)
+
+		switch {
+		case key == fmt.Sprintf(USER_PREFERENCES_KEY, userID):
+			return p.KVGet(key, &data.Preferences)
+		case key == fmt.Sprintf(USER_SURVEY_KEY, userID):
+			return p.KVGet(key, &data.SurveyState)
+		case strings.HasPrefix(key, getKeyPrefix(ADMIN_DM_NOTICE_KEY)):
+			var notice *adminNotice
+			if err := p.KVGet(key, &notice); err != nil {
+				return err
+			}
+
+			if notice != nil {
+				data.AdminNotices = append(data.AdminNotices, notice)
+			}
+		default:
+			var response *surveyResponse
+			if err := p.KVGet(key, &response); err != nil {
+				return err
+			}
+
+			if response != nil {
+				data.Responses = append(data.Responses, response)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// deleteUserData deletes everything stored about the user. Returns the deleted data, or nil without deleting anything
+// if another instance of the plugin is busy with the user.
+func (p *Plugin) deleteUserData(userID string, now time.Time) (*userData, *model.AppError) {
+	userLockKey := fmt.Sprintf(USER_LOCK_KEY, userID)
+
+	locked, err := p.tryLock(userLockKey, now)
+	if err != nil {
+		return nil, err
+	} else if !locked {
+		return nil, nil
+	}
+	defer p.unlock(userLockKey)
+
+	data, err := p.getUserData(userID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// The keys are deleted after they've all been listed so that no pages of keys are skipped
+	for _, key := range data.keys {
+		if err := p.API.KVDelete(key); err != nil {
+			return nil, err
+		}
+	}
+
+	var queuedIDs []string
+	for _, key := range data.keys {
+		if prefix := getKeyPrefix(WEBHOOK_QUEUE_KEY); strings.HasPrefix(key, prefix) {
+			queuedIDs = append(queuedIDs, strings.TrimPrefix(key, prefix))
+		}
+	}
+
+	if len(queuedIDs) > 0 {
+		if err := p.removeFromWebhookQueueIndex(queuedIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// cleanUpData deletes any responses and failed webhook deliveries older than ResponseRetentionDays along with
+// everything stored about users that have been deleted or, if DeleteDeactivatedUserData is set, deactivated. Only one
+// instance of the plugin cleans up data at a time.
+func (p *Plugin) cleanUpData(now time.Time) {
+	locked, err := p.tryLock(DATA_CLEANUP_LOCK_KEY, now)
+	if !locked || err != nil {
+		// Either an error occurred or there's already another thread cleaning up data
+		return
+	}
+	defer p.unlock(DATA_CLEANUP_LOCK_KEY)
+
+	retention := p.getConfiguration().getResponseRetention()
+
+	var expiredKeys []string
+	var userIDs []string
+	seenUserIDs := make(map[string]bool)
+
+	err = p.forEachKey("", func(key string) *model.AppError {
+		if strings.HasPrefix(key, getKeyPrefix(WEBHOOK_DEAD_LETTER_KEY)) {
+			if retention == 0 {
+				return nil
+			}
+
+			var delivery *webhookDelivery
+			if err := p.KVGet(key, &delivery); err != nil {
+				return err
+			}
+
+			if delivery != nil && now.Sub(delivery.CreateAt) >= retention {
+				expiredKeys = append(expiredKeys, key)
+			}
+
+			return nil
+		}
+
+		userID := getKeyUserID(key)
+		if userID == "" {
+			return nil
+		}
+
+		// Anonymous IDs aren't valid user IDs, so those responses are only deleted along with the user's other data
+		if model.IsValidId(userID) && !seenUserIDs[userID] {
+			seenUserIDs[userID] = true
+			userIDs = append(userIDs, userID)
+		}
+
+		if retention == 0 || !strings.HasPrefix(key, getKeyPrefix(RESPONSE_KEY)) {
+			return nil
+		}
+
+		var response *surveyResponse
+		if err := p.KVGet(key, &response); err != nil {
+			return err
+		}
+
+		if response != nil && now.Sub(response.CreateAt) >= retention {
+			expiredKeys = append(expiredKeys, key)
+		}
+
+		return nil
+	})
+	if err != nil {
+		p.API.LogError("Failed to find data to clean up", "err", err)
+		return
+	}
+
+	for _, key := range expiredKeys {
+		if err := p.API.KVDelete(key); err != nil {
+			p.API.LogError("Failed to delete expired survey data", "key", key, "err", err)
+		}
+	}
+
+	if len(expiredKeys) > 0 {
+		p.API.LogInfo(fmt.Sprintf("Deleted %d expired survey responses and failed webhook deliveries", len(expiredKeys)))
+	}
+
+	for _, userID := range userIDs {
+		if !p.shouldDeleteUserData(userID) {
+			continue
+		}
+
+		data, err := p.deleteUserData(userID, now)
+		if err != nil {
+			p.API.LogError("Failed to delete data for removed user", "user_id", userID, "err", err)
+		} else if data != nil {
+			p.API.LogInfo("Deleted survey data for removed user", "user_id", userID)
+		}
+	}
+}
+
+// shouldDeleteUserData returns whether or not everything stored about the user should be deleted because they no
+// longer exist or have been deactivated.
+func (p *Plugin) shouldDeleteUserData(userID string) bool {
+	user, err := p.API.GetUser(userID)
+	if err != nil {
+		// Only a user that is known to be gone has their data deleted in case the error is temporary
+		return err.StatusCode == http.StatusNotFound
+	}
+
+	return user.DeleteAt != 0 && p.getConfiguration().DeleteDeactivatedUserData
+}
+
+func (p *Plugin) exportUserData(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if !model.IsValidId(userID) {
+		http.Error(w, "Invalid user_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	data, appErr := p.getUserData(userID, !p.getConfiguration().AnonymousSurveys)
+	if appErr != nil {
+		p.API.LogError("Failed to get user data", "user_id", userID, "err", appErr)
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=nps-%s.json", userID))
+	json.NewEncoder(w).Encode(data)
+}
+
+func (p *Plugin) purgeUserData(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if !model.IsValidId(userID) {
+		http.Error(w, "Invalid user_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	data, appErr := p.deleteUserData(userID, p.now().UTC())
+	if appErr != nil {
+		p.API.LogError("Failed to delete user data", "user_id", userID, "err", appErr)
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if data == nil {
+		http.Error(w, "Surveybot is currently busy with the user. Please try again.", http.StatusConflict)
+		return
+	}
+
+	p.API.LogInfo("Deleted survey data for user", "user_id", userID, "requested_by", r.Header.Get("Mattermost-User-ID"))
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetKeyUserID(t *testing.T) {
+	userID := model.NewId()
+
+	assert.Equal(t, userID, getKeyUserID(fmt.Sprintf(USER_SURVEY_KEY, userID)))
+	assert.Equal(t, userID, getKeyUserID(fmt.Sprintf(USER_PREFERENCES_KEY, userID)))
+	assert.Equal(t, userID, getKeyUserID(fmt.Sprintf(ADMIN_DM_NOTICE_KEY, userID, "5.10.0")))
+	assert.Equal(t, userID, getKeyUserID(fmt.Sprintf(RESPONSE_KEY, "5.10.0", userID)))
+	assert.Equal(t, userID, getKeyUserID(fmt.Sprintf(RESPONSE_KEY, "5.10.0-dxc", userID)))
+
+	assert.Equal(t, "", getKeyUserID(fmt.Sprintf(SURVEY_KEY, "5.10.0")))
+	assert.Equal(t, "", getKeyUserID(fmt.Sprintf(USER_LOCK_KEY, userID)))
+	assert.Equal(t, "", getKeyUserID(LAST_ADMIN_NOTICE_KEY))
+}
+
+func TestGetUserData(t *testing.T) {
+	userID := model.NewId()
+	otherUserID := model.NewId()
+
+	p := &Plugin{anonymousSalt: []byte("salt")}
+
+	preferences := &userPreferences{SurveysDisabled: true}
+	userSurvey := &userSurveyState{ServerVersion: "5.11.0"}
+	notice := &adminNotice{ServerVersion: "5.11.0"}
+	response := &surveyResponse{UserID: userID, ServerVersion: "5.10.0", Score: 7}
+	anonymousResponse := &surveyResponse{UserID: p.getAnonymousID(userID), ServerVersion: "5.11.0", Score: 9, Anonymous: true}
+	queued := &webhookDelivery{ID: model.NewId(), Event: sinkEvent{Event: NPS_SCORE, Properties: map[string]interface{}{"user_actual_id": userID}}}
+	deadLetter := &webhookDelivery{ID: model.NewId(), Event: sinkEvent{Event: NPS_FEEDBACK, Properties: map[string]interface{}{"user_actual_id": p.getAnonymousID(userID)}}}
+	otherDelivery := &webhookDelivery{ID: model.NewId(), Event: sinkEvent{Event: NPS_SCORE, Properties: map[string]interface{}{"user_actual_id": otherUserID}}}
+
+	makeAPIMock := func(includeAnonymous bool) *plugintest.API {
+		api := makeAPIMock()
+		api.On("KVList", 0, 100).Return([]string{
+			fmt.Sprintf(ADMIN_DM_NOTICE_KEY, userID, "5.11.0"),
+			fmt.Sprintf(ADMIN_DM_NOTICE_KEY, otherUserID, "5.11.0"),
+			fmt.Sprintf(RESPONSE_KEY, "5.10.0", userID),
+			fmt.Sprintf(RESPONSE_KEY, "5.10.0", otherUserID),
+			fmt.Sprintf(RESPONSE_KEY, "5.11.0", p.getAnonymousID(userID)),
+			fmt.Sprintf(RESPONSE_KEY, "5.11.0", p.getAnonymousID(otherUserID)),
+			fmt.Sprintf(SURVEY_KEY, "5.11.0"),
+			fmt.Sprintf(USER_PREFERENCES_KEY, userID),
+			fmt.Sprintf(USER_SURVEY_KEY, userID),
+			fmt.Sprintf(USER_SURVEY_KEY, otherUserID),
+			fmt.Sprintf(WEBHOOK_DEAD_LETTER_KEY, deadLetter.ID),
+			fmt.Sprintf(WEBHOOK_QUEUE_KEY, otherDelivery.ID),
+			fmt.Sprintf(WEBHOOK_QUEUE_KEY, queued.ID),
+		}, nil)
+		api.On("KVGet", fmt.Sprintf(WEBHOOK_DEAD_LETTER_KEY, deadLetter.ID)).Return(mustMarshalJSON(deadLetter), nil)
+		api.On("KVGet", fmt.Sprintf(WEBHOOK_QUEUE_KEY, otherDelivery.ID)).Return(mustMarshalJSON(otherDelivery), nil)
+		api.On("KVGet", fmt.Sprintf(WEBHOOK_QUEUE_KEY, queued.ID)).Return(mustMarshalJSON(queued), nil)
+		api.On("KVGet", fmt.Sprintf(ADMIN_DM_NOTICE_KEY, userID, "5.11.0")).Return(mustMarshalJSON(notice), nil)
+		api.On("KVGet", fmt.Sprintf(RESPONSE_KEY, "5.10.0", userID)).Return(mustMarshalJSON(response), nil)
+		api.On("KVGet", fmt.Sprintf(USER_PREFERENCES_KEY, userID)).Return(mustMarshalJSON(preferences), nil)
+		api.On("KVGet", fmt.Sprintf(USER_SURVEY_KEY, userID)).Return(mustMarshalJSON(userSurvey), nil)
+
+		if includeAnonymous {
+			api.On("KVGet", fmt.Sprintf(RESPONSE_KEY, "5.11.0", p.getAnonymousID(userID))).Return(mustMarshalJSON(anonymousResponse), nil)
+		}
+
+		return api
+	}
+
+	t.Run("should include data stored under the user's anonymous ID when requested", func(t *testing.T) {
+		api := makeAPIMock(true)
+		defer api.AssertExpectations(t)
+
+		p.SetAPI(api)
+
+		data, err := p.getUserData(userID, true)
+
+		require.Nil(t, err)
+		assert.Equal(t, &userData{
+			UserID:            userID,
+			Preferences:       preferences,
+			SurveyState:       userSurvey,
+			AdminNotices:      []*adminNotice{notice},
+			Responses:         []*surveyResponse{response, anonymousResponse},
+			WebhookDeliveries: []*webhookDelivery{deadLetter, queued},
+			keys: []string{
+				fmt.Sprintf(ADMIN_DM_NOTICE_KEY, userID, "5.11.0"),
+				fmt.Sprintf(RESPONSE_KEY, "5.10.0", userID),
+				fmt.Sprintf(RESPONSE_KEY, "5.11.0", p.getAnonymousID(userID)),
+				fmt.Sprintf(USER_PREFERENCES_KEY, userID),
+				fmt.Sprintf(USER_SURVEY_KEY, userID),
+				fmt.Sprintf(WEBHOOK_DEAD_LETTER_KEY, deadLetter.ID),
+				fmt.Sprintf(WEBHOOK_QUEUE_KEY, queued.ID),
+			},
+		}, data)
+	})
+
+	t.Run("should leave out data stored under the user's anonymous ID otherwise", func(t *testing.T) {
+		api := makeAPIMock(false)
+		defer api.AssertExpectations(t)
+
+		p.SetAPI(api)
+
+		data, err := p.getUserData(userID, false)
+
+		require.Nil(t, err)
+		assert.Equal(t, []*surveyResponse{response}, data.Responses)
+		assert.Equal(t, []*webhookDelivery{queued}, data.WebhookDeliveries)
+		assert.NotContains(t, data.keys, fmt.Sprintf(RESPONSE_KEY, "5.11.0", p.getAnonymousID(userID)))
+	})
+}
+
+func TestDeleteUserData(t *testing.T) {
+	now := toDate(2019, time.June, 1)
+	userID := model.NewId()
+	userLockKey := fmt.Sprintf(USER_LOCK_KEY, userID)
+
+	t.Run("should delete everything stored about the user", func(t *testing.T) {
+		responseKey := fmt.Sprintf(RESPONSE_KEY, "5.10.0", userID)
+		userSurveyKey := fmt.Sprintf(USER_SURVEY_KEY, userID)
+
+		api := makeAPIMock()
+		api.On("KVCompareAndSet", userLockKey, []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVList", 0, 100).Return([]string{responseKey, userSurveyKey}, nil)
+		api.On("KVGet", responseKey).Return(mustMarshalJSON(&surveyResponse{UserID: userID}), nil)
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{}), nil)
+		api.On("KVDelete", responseKey).Return(nil)
+		api.On("KVDelete", userSurveyKey).Return(nil)
+		api.On("KVDelete", userLockKey).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		data, err := p.deleteUserData(userID, now)
+
+		assert.Nil(t, err)
+		assert.NotNil(t, data)
+	})
+
+	t.Run("should remove the user's webhook deliveries from the queue", func(t *testing.T) {
+		delivery := &webhookDelivery{ID: model.NewId(), Event: sinkEvent{Properties: map[string]interface{}{"user_actual_id": userID}}}
+		queueKey := fmt.Sprintf(WEBHOOK_QUEUE_KEY, delivery.ID)
+		otherID := model.NewId()
+
+		api := makeAPIMock()
+		api.On("KVCompareAndSet", userLockKey, []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVList", 0, 100).Return([]string{queueKey}, nil)
+		api.On("KVGet", queueKey).Return(mustMarshalJSON(delivery), nil)
+		api.On("KVDelete", queueKey).Return(nil)
+		api.On("KVGet", WEBHOOK_QUEUE_INDEX_KEY).Return(mustMarshalJSON([]string{delivery.ID, otherID}), nil)
+		api.On("KVCompareAndSet", WEBHOOK_QUEUE_INDEX_KEY, mustMarshalJSON([]string{delivery.ID, otherID}), mustMarshalJSON([]string{otherID})).Return(true, nil)
+		api.On("KVDelete", userLockKey).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		data, err := p.deleteUserData(userID, now)
+
+		assert.Nil(t, err)
+		require.NotNil(t, data)
+		assert.Len(t, data.WebhookDeliveries, 1)
+	})
+
+	t.Run("should not delete anything while another thread is busy with the user", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVCompareAndSet", userLockKey, []byte(nil), mustMarshalJSON(now)).Return(false, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		data, err := p.deleteUserData(userID, now)
+
+		assert.Nil(t, err)
+		assert.Nil(t, data)
+	})
+}
+
+func TestCleanUpData(t *testing.T) {
+	now := toDate(2019, time.June, 1)
+
+	t.Run("should delete responses older than the retention period", func(t *testing.T) {
+		userID := model.NewId()
+		oldKey := fmt.Sprintf(RESPONSE_KEY, "5.8.0", userID)
+		newKey := fmt.Sprintf(RESPONSE_KEY, "5.10.0", userID)
+
+		api := makeAPIMock()
+		api.On("KVCompareAndSet", DATA_CLEANUP_LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVList", 0, 100).Return([]string{oldKey, newKey}, nil)
+		api.On("KVGet", oldKey).Return(mustMarshalJSON(&surveyResponse{CreateAt: now.Add(-91 * 24 * time.Hour)}), nil)
+		api.On("KVGet", newKey).Return(mustMarshalJSON(&surveyResponse{CreateAt: now.Add(-89 * 24 * time.Hour)}), nil)
+		api.On("KVDelete", oldKey).Return(nil)
+		api.On("LogInfo", "Deleted 1 expired survey responses and failed webhook deliveries").Return(nil)
+		api.On("GetUser", userID).Return(&model.User{Id: userID}, nil)
+		api.On("KVDelete", DATA_CLEANUP_LOCK_KEY).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			configuration: &configuration{ResponseRetentionDays: 90},
+		}
+		p.SetAPI(api)
+
+		p.cleanUpData(now)
+	})
+
+	t.Run("should delete failed webhook deliveries older than the retention period", func(t *testing.T) {
+		oldKey := fmt.Sprintf(WEBHOOK_DEAD_LETTER_KEY, model.NewId())
+		newKey := fmt.Sprintf(WEBHOOK_DEAD_LETTER_KEY, model.NewId())
+
+		api := makeAPIMock()
+		api.On("KVCompareAndSet", DATA_CLEANUP_LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVList", 0, 100).Return([]string{oldKey, newKey}, nil)
+		api.On("KVGet", oldKey).Return(mustMarshalJSON(&webhookDelivery{CreateAt: now.Add(-91 * 24 * time.Hour)}), nil)
+		api.On("KVGet", newKey).Return(mustMarshalJSON(&webhookDelivery{CreateAt: now.Add(-89 * 24 * time.Hour)}), nil)
+		api.On("KVDelete", oldKey).Return(nil)
+		api.On("LogInfo", "Deleted 1 expired survey responses and failed webhook deliveries").Return(nil)
+		api.On("KVDelete", DATA_CLEANUP_LOCK_KEY).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			configuration: &configuration{ResponseRetentionDays: 90},
+		}
+		p.SetAPI(api)
+
+		p.cleanUpData(now)
+	})
+
+	t.Run("should keep responses forever without a retention period", func(t *testing.T) {
+		userID := model.NewId()
+
+		api := makeAPIMock()
+		api.On("KVCompareAndSet", DATA_CLEANUP_LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVList", 0, 100).Return([]string{fmt.Sprintf(RESPONSE_KEY, "5.8.0", userID)}, nil)
+		api.On("GetUser", userID).Return(&model.User{Id: userID}, nil)
+		api.On("KVDelete", DATA_CLEANUP_LOCK_KEY).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			configuration: &configuration{},
+		}
+		p.SetAPI(api)
+
+		p.cleanUpData(now)
+	})
+
+	t.Run("should delete data stored about deleted users", func(t *testing.T) {
+		deletedUserID := model.NewId()
+		deactivatedUserID := model.NewId()
+		errorUserID := model.NewId()
+
+		deletedUserKey := fmt.Sprintf(USER_SURVEY_KEY, deletedUserID)
+
+		api := makeAPIMock()
+		api.On("KVCompareAndSet", DATA_CLEANUP_LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVList", 0, 100).Return([]string{
+			fmt.Sprintf(USER_PREFERENCES_KEY, deactivatedUserID),
+			deletedUserKey,
+			fmt.Sprintf(USER_SURVEY_KEY, errorUserID),
+		}, nil)
+		api.On("GetUser", deletedUserID).Return(nil, &model.AppError{StatusCode: http.StatusNotFound})
+		api.On("GetUser", deactivatedUserID).Return(&model.User{Id: deactivatedUserID, DeleteAt: 1234}, nil)
+		api.On("GetUser", errorUserID).Return(nil, &model.AppError{StatusCode: http.StatusInternalServerError})
+		api.On("KVCompareAndSet", fmt.Sprintf(USER_LOCK_KEY, deletedUserID), []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVGet", deletedUserKey).Return(mustMarshalJSON(&userSurveyState{}), nil)
+		api.On("KVDelete", deletedUserKey).Return(nil)
+		api.On("KVDelete", fmt.Sprintf(USER_LOCK_KEY, deletedUserID)).Return(nil)
+		api.On("LogInfo", "Deleted survey data for removed user", "user_id", deletedUserID).Return(nil)
+		api.On("KVDelete", DATA_CLEANUP_LOCK_KEY).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			anonymousSalt: []byte("salt"),
+			configuration: &configuration{},
+		}
+		p.SetAPI(api)
+
+		p.cleanUpData(now)
+	})
+
+	t.Run("should delete data stored about deactivated users when enabled", func(t *testing.T) {
+		userID := model.NewId()
+		userPreferencesKey := fmt.Sprintf(USER_PREFERENCES_KEY, userID)
+
+		api := makeAPIMock()
+		api.On("KVCompareAndSet", DATA_CLEANUP_LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVList", 0, 100).Return([]string{userPreferencesKey}, nil)
+		api.On("GetUser", userID).Return(&model.User{Id: userID, DeleteAt: 1234}, nil)
+		api.On("KVCompareAndSet", fmt.Sprintf(USER_LOCK_KEY, userID), []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVGet", userPreferencesKey).Return(mustMarshalJSON(&userPreferences{}), nil)
+		api.On("KVDelete", userPreferencesKey).Return(nil)
+		api.On("KVDelete", fmt.Sprintf(USER_LOCK_KEY, userID)).Return(nil)
+		api.On("LogInfo", "Deleted survey data for removed user", "user_id", userID).Return(nil)
+		api.On("KVDelete", DATA_CLEANUP_LOCK_KEY).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			anonymousSalt: []byte("salt"),
+			configuration: &configuration{DeleteDeactivatedUserData: true},
+		}
+		p.SetAPI(api)
+
+		p.cleanUpData(now)
+	})
+
+	t.Run("should do nothing if another thread is cleaning up data", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVCompareAndSet", DATA_CLEANUP_LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(false, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		p.cleanUpData(now)
+	})
+}
+
+func TestUserDataAPI(t *testing.T) {
+	adminID := model.NewId()
+	userID := model.NewId()
+	now := toDate(2019, time.June, 1)
+
+	makePlugin := func(api *plugintest.API) *Plugin {
+		api.On("GetUser", adminID).Return(&model.User{
+			Id:    adminID,
+			Roles: model.SYSTEM_ADMIN_ROLE_ID,
+		}, nil)
+
+		p := &Plugin{
+			now: func() time.Time {
+				return now
+			},
+		}
+		p.SetAPI(api)
+
+		return p
+	}
+
+	request := func(p *Plugin, method string, url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, url, nil)
+		r.Header.Set("Mattermost-User-ID", adminID)
+
+		p.ServeHTTP(nil, w, r)
+
+		return w
+	}
+
+	t.Run("should export everything stored about the user", func(t *testing.T) {
+		userSurveyKey := fmt.Sprintf(USER_SURVEY_KEY, userID)
+
+		api := makeAPIMock()
+		api.On("KVList", 0, 100).Return([]string{userSurveyKey}, nil)
+		api.On("KVGet", userSurveyKey).Return(mustMarshalJSON(&userSurveyState{ServerVersion: "5.10.0"}), nil)
+		defer api.AssertExpectations(t)
+
+		w := request(makePlugin(api), http.MethodGet, "/api/v1/user_data?user_id="+userID)
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var data *userData
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&data))
+		assert.Equal(t, userID, data.UserID)
+		assert.Equal(t, "5.10.0", data.SurveyState.ServerVersion)
+	})
+
+	t.Run("should not export anonymous responses while surveys are anonymous", func(t *testing.T) {
+		responseKey := fmt.Sprintf(RESPONSE_KEY, "5.10.0", userID)
+		anonymousResponseKey := fmt.Sprintf(RESPONSE_KEY, "5.11.0", (&Plugin{anonymousSalt: []byte("salt")}).getAnonymousID(userID))
+
+		api := makeAPIMock()
+		api.On("KVList", 0, 100).Return([]string{responseKey, anonymousResponseKey}, nil)
+		api.On("KVGet", responseKey).Return(mustMarshalJSON(&surveyResponse{UserID: userID, Score: 7}), nil)
+		defer api.AssertExpectations(t)
+
+		p := makePlugin(api)
+		p.anonymousSalt = []byte("salt")
+		p.configuration = &configuration{AnonymousSurveys: true}
+
+		w := request(p, http.MethodGet, "/api/v1/user_data?user_id="+userID)
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var data *userData
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&data))
+		require.Len(t, data.Responses, 1)
+		assert.Equal(t, 7, data.Responses[0].Score)
+	})
+
+	t.Run("should delete everything stored about the user", func(t *testing.T) {
+		userLockKey := fmt.Sprintf(USER_LOCK_KEY, userID)
+
+		api := makeAPIMock()
+		api.On("KVCompareAndSet", userLockKey, []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVList", 0, 100).Return([]string{}, nil)
+		api.On("KVDelete", userLockKey).Return(nil)
+		api.On("LogInfo", "Deleted survey data for user", "user_id", userID, "requested_by", adminID).Return(nil)
+		defer api.AssertExpectations(t)
+
+		w := request(makePlugin(api), http.MethodDelete, "/api/v1/user_data?user_id="+userID)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("should report when Surveybot is busy with the user", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVCompareAndSet", fmt.Sprintf(USER_LOCK_KEY, userID), []byte(nil), mustMarshalJSON(now)).Return(false, nil)
+		defer api.AssertExpectations(t)
+
+		w := request(makePlugin(api), http.MethodDelete, "/api/v1/user_data?user_id="+userID)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("should reject an invalid user ID", func(t *testing.T) {
+		api := makeAPIMock()
+		defer api.AssertExpectations(t)
+
+		w := request(makePlugin(api), http.MethodGet, "/api/v1/user_data?user_id=someone")
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("should only be available to system admins", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("GetUser", userID).Return(&model.User{Id: userID, Roles: model.SYSTEM_USER_ROLE_ID}, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodDelete, "/api/v1/user_data?user_id="+userID, nil)
+		r.Header.Set("Mattermost-User-ID", userID)
+
+		p.ServeHTTP(nil, w, r)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
@@ -43,6 +43,44 @@ func (p *Plugin) KVSet(key string, v interface{}) *model.AppError {
 	return p.API.KVSet(key, data)
 }
 
+// getKeyPrefix returns the constant part at the start of one of the *_KEY format strings, such as "UserSurvey-" for
+// USER_SURVEY_KEY, for use with forEachKey.
+func getKeyPrefix(key string) string {
+	return strings.SplitN(key, "%", 2)[0]
+}
+
+// forEachKey calls f with every key in the KV store that starts with the given prefix. Keys are listed a page at a time
+// so that large numbers of them aren't loaded into memory at once.
+func (p *Plugin) forEachKey(prefix string, f func(key string) *model.AppError) *model.AppError {
+	page := 0
+	perPage := 100
+
+	for {
+		keys, err := p.API.KVList(page, perPage)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+
+			if err := f(key); err != nil {
+				return err
+			}
+		}
+
+		if len(keys) < perPage {
+			break
+		}
+
+		page += 1
+	}
+
+	return nil
+}
+
 func (p *Plugin) CreateBotDMPost(userID string, post *model.Post) (*model.Post, *model.AppError) {
 	channel, err := p.API.GetDirectChannel(userID, p.botUserID)
 	if err != nil {
@@ -74,6 +112,16 @@ func (p *Plugin) IsBotDMChannel(channel *model.Channel) bool {
 	return true
 }
 
+// toMillis converts a time.Time into milliseconds since the Unix epoch as used for timestamps by the Mattermost server.
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// daysToDuration converts a number of days from the plugin settings into a time.Duration.
+func daysToDuration(days int) time.Duration {
+	return time.Duration(days) * 24 * time.Hour
+}
+
 func (p *Plugin) sleepUpTo(maxDelay time.Duration) {
 	r := rand.New(rand.NewSource(p.now().UnixNano()))
 	delay := time.Duration(r.Int63n(int64(maxDelay) + 1))
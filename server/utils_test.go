@@ -108,6 +108,51 @@ func TestKVGet(t *testing.T) {
 	})
 }
 
+func TestForEachKey(t *testing.T) {
+	t.Run("should call f for each matching key across pages", func(t *testing.T) {
+		firstPage := make([]string, 100)
+		for i := range firstPage {
+			firstPage[i] = "other"
+		}
+		firstPage[0] = "Prefix-1"
+
+		api := makeAPIMock()
+		api.On("KVList", 0, 100).Return(firstPage, nil)
+		api.On("KVList", 1, 100).Return([]string{"Prefix-2", "other"}, nil)
+		defer api.AssertExpectations(t)
+
+		p := Plugin{}
+		p.SetAPI(api)
+
+		var keys []string
+		err := p.forEachKey("Prefix-", func(key string) *model.AppError {
+			keys = append(keys, key)
+			return nil
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"Prefix-1", "Prefix-2"}, keys)
+	})
+
+	t.Run("should stop and return an error from f", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVList", 0, 100).Return([]string{"Prefix-1", "Prefix-2"}, nil)
+		defer api.AssertExpectations(t)
+
+		p := Plugin{}
+		p.SetAPI(api)
+
+		calls := 0
+		err := p.forEachKey("Prefix-", func(key string) *model.AppError {
+			calls += 1
+			return &model.AppError{}
+		})
+
+		assert.NotNil(t, err)
+		assert.Equal(t, 1, calls)
+	})
+}
+
 func TestCreateBotDMPost(t *testing.T) {
 	t.Run("should send bot DM correctly", func(t *testing.T) {
 		api := makeAPIMock()
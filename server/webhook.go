@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// WEBHOOK_QUEUE_KEY is used to store a webhookDelivery that is waiting to be sent to one of the EventWebhookURLs. It
+	// should contain the delivery's ID like "WebhookQueue-abc123".
+	WEBHOOK_QUEUE_KEY = "WebhookQueue-%s"
+
+	// WEBHOOK_DEAD_LETTER_KEY is used to store a webhookDelivery that failed too many times to be retried again. It
+	// should contain the delivery's ID like "WebhookDeadLetter-abc123".
+	WEBHOOK_DEAD_LETTER_KEY = "WebhookDeadLetter-%s"
+
+	// WEBHOOK_QUEUE_INDEX_KEY is used to store the IDs of every webhookDelivery in the queue, oldest first, so that
+	// queued deliveries can be found without listing every key in the KV store.
+	WEBHOOK_QUEUE_INDEX_KEY = "WebhookQueueIndex"
+
+	// The number of times to try updating the queue index when other instances of the plugin keep changing it first
+	WEBHOOK_QUEUE_INDEX_MAX_RETRIES = 10
+
+	// How long to wait for a webhook to respond before giving up on a delivery attempt
+	WEBHOOK_TIMEOUT = 10 * time.Second
+
+	// How often to retry any webhook deliveries that have failed
+	WEBHOOK_DELIVERY_INTERVAL = time.Minute
+
+	// How long to wait before retrying a failed webhook delivery for the first time. The delay doubles after each
+	// failure up to WEBHOOK_MAX_RETRY_DELAY.
+	WEBHOOK_RETRY_DELAY     = time.Minute
+	WEBHOOK_MAX_RETRY_DELAY = 6 * time.Hour
+
+	// The number of times that a webhook delivery is attempted before it's moved to the dead-letter list
+	WEBHOOK_MAX_ATTEMPTS = 10
+)
+
+// webhookEvents are the events sent to the EventWebhookURLs.
+var webhookEvents = []string{NPS_SCORE, NPS_FEEDBACK}
+
+// webhookDelivery is a single event waiting to be sent to one of the EventWebhookURLs.
+type webhookDelivery struct {
+	ID            string    `json:"id"`
+	URL           string    `json:"url"`
+	Event         sinkEvent `json:"event"`
+	CreateAt      time.Time `json:"create_at"`
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	LastAttemptAt time.Time `json:"last_attempt_at"`
+	LastError     string    `json:"last_error,omitempty"`
+}
+
+// getUserID returns the ID of the user that the delivery's event is about, or their anonymous ID if surveys were
+// anonymous when it was sent.
+func (d *webhookDelivery) getUserID() string {
+	userID, _ := d.Event.Properties["user_actual_id"].(string)
+	return userID
+}
+
+// isWebhookDeliveryKey returns whether or not the key stores a webhookDelivery, either in the queue or the dead-letter
+// list.
+func isWebhookDeliveryKey(key string) bool {
+	return strings.HasPrefix(key, getKeyPrefix(WEBHOOK_QUEUE_KEY)) || strings.HasPrefix(key, getKeyPrefix(WEBHOOK_DEAD_LETTER_KEY))
+}
+
+// getRetryDelay returns how long to wait after the delivery's latest failed attempt before trying it again.
+func (d *webhookDelivery) getRetryDelay() time.Duration {
+	delay := WEBHOOK_RETRY_DELAY
+
+	for i := 1; i < d.Attempts && delay < WEBHOOK_MAX_RETRY_DELAY; i++ {
+		delay *= 2
+	}
+
+	if delay > WEBHOOK_MAX_RETRY_DELAY {
+		return WEBHOOK_MAX_RETRY_DELAY
+	}
+
+	return delay
+}
+
+// getEventWebhookURLs returns the URLs from EventWebhookURLs.
+func (c *configuration) getEventWebhookURLs() []string {
+	return strings.Fields(c.EventWebhookURLs)
+}
+
+// validateWebhookURLs returns an error if any of the URLs can't be sent events.
+func validateWebhookURLs(urls []string) error {
+	for _, webhookURL := range urls {
+		parsed, err := url.Parse(webhookURL)
+		if err != nil {
+			return errors.Wrapf(err, "invalid webhook URL %s", webhookURL)
+		}
+
+		if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return errors.Errorf("invalid webhook URL %s", webhookURL)
+		}
+	}
+
+	return nil
+}
+
+// queueWebhookEvent stores a delivery of the event for each of the EventWebhookURLs. Returns whether or not anything
+// was queued.
+func (p *Plugin) queueWebhookEvent(event string, properties map[string]interface{}, now time.Time) (bool, *model.AppError) {
+	if !containsString(webhookEvents, event) {
+		return false, nil
+	}
+
+	return p.queueWebhookDeliveries(p.getConfiguration().getEventWebhookURLs(), event, properties, now)
+}
+
+// queueWebhookDeliveries stores a delivery of the event for each of the URLs to be sent by deliverWebhookEvents.
+// Returns whether or not anything was queued.
+func (p *Plugin) queueWebhookDeliveries(urls []string, event string, properties map[string]interface{}, now time.Time) (bool, *model.AppError) {
+	var ids []string
+
+	for _, webhookURL := range urls {
+		delivery := &webhookDelivery{
+			ID:  model.NewId(),
+			URL: webhookURL,
+			Event: sinkEvent{
+				Event:      event,
+				Properties: properties,
+			},
+			CreateAt:      now,
+			NextAttemptAt: now,
+		}
+
+		if err := p.KVSet(fmt.Sprintf(WEBHOOK_QUEUE_KEY, delivery.ID), delivery); err != nil {
+			return false, err
+		}
+
+		ids = append(ids, delivery.ID)
+	}
+
+	if len(ids) == 0 {
+		return false, nil
+	}
+
+	if err := p.addToWebhookQueueIndex(ids); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// getWebhookQueueIndex returns the IDs of the queued webhook deliveries, oldest first.
+func (p *Plugin) getWebhookQueueIndex() ([]string, *model.AppError) {
+	var ids []string
+	if err := p.KVGet(WEBHOOK_QUEUE_INDEX_KEY, &ids); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// updateWebhookQueueIndex replaces the IDs of the queued webhook deliveries with the result of update. The index is
+// shared by every instance of the plugin, so it's only saved if nobody else changed it in the meantime, and update is
+// called again with the latest IDs if they did.
+func (p *Plugin) updateWebhookQueueIndex(update func(ids []string) []string) *model.AppError {
+	for i := 0; i < WEBHOOK_QUEUE_INDEX_MAX_RETRIES; i++ {
+		value, err := p.API.KVGet(WEBHOOK_QUEUE_INDEX_KEY)
+		if err != nil {
+			return err
+		}
+
+		var ids []string
+		if value != nil {
+			if err := json.Unmarshal(value, &ids); err != nil {
+				return &model.AppError{Message: err.Error()}
+			}
+		}
+
+		b, jsonErr := json.Marshal(update(ids))
+		if jsonErr != nil {
+			return &model.AppError{Message: jsonErr.Error()}
+		}
+
+		if saved, err := p.API.KVCompareAndSet(WEBHOOK_QUEUE_INDEX_KEY, value, b); err != nil {
+			return err
+		} else if saved {
+			return nil
+		}
+	}
+
+	return &model.AppError{Message: "Too many attempts to update the webhook queue index"}
+}
+
+// addToWebhookQueueIndex adds newly queued webhook deliveries to the end of the queue index.
+func (p *Plugin) addToWebhookQueueIndex(ids []string) *model.AppError {
+	return p.updateWebhookQueueIndex(func(existing []string) []string {
+		return append(existing, ids...)
+	})
+}
+
+// removeFromWebhookQueueIndex removes webhook deliveries that are no longer queued from the queue index.
+func (p *Plugin) removeFromWebhookQueueIndex(ids []string) *model.AppError {
+	return p.updateWebhookQueueIndex(func(existing []string) []string {
+		remaining := []string{}
+
+		for _, id := range existing {
+			if !containsString(ids, id) {
+				remaining = append(remaining, id)
+			}
+		}
+
+		return remaining
+	})
+}
+
+// deliverWebhookEvents sends any queued webhook deliveries that are due. Deliveries that fail are retried with an
+// exponential backoff until they've been attempted WEBHOOK_MAX_ATTEMPTS times, after which they're moved to the
+// dead-letter list. Only one instance of the plugin delivers webhook events at a time.
+func (p *Plugin) deliverWebhookEvents(now time.Time) {
+	if ids, err := p.getWebhookQueueIndex(); err != nil {
+		p.API.LogError("Failed to get queued webhook deliveries", "err", err)
+		return
+	} else if len(ids) == 0 {
+		// Nothing is queued, so there's no need to lock anything
+		return
+	}
+
+	locked, err := p.tryLock(WEBHOOK_DELIVERY_LOCK_KEY, now)
+	if !locked || err != nil {
+		// Either an error occurred or there's already another thread delivering webhook events
+		return
+	}
+	defer p.unlock(WEBHOOK_DELIVERY_LOCK_KEY)
+
+	ids, err := p.getWebhookQueueIndex()
+	if err != nil {
+		p.API.LogError("Failed to get queued webhook deliveries", "err", err)
+		return
+	}
+
+	client := &http.Client{Timeout: WEBHOOK_TIMEOUT}
+
+	// The IDs of deliveries that are no longer in the queue
+	var removed []string
+
+	for _, id := range ids {
+		var delivery *webhookDelivery
+		if err := p.KVGet(fmt.Sprintf(WEBHOOK_QUEUE_KEY, id), &delivery); err != nil {
+			p.API.LogError("Failed to get webhook delivery", "delivery_id", id, "err", err)
+			continue
+		}
+
+		if delivery == nil {
+			// The delivery was deleted along with the user's data
+			removed = append(removed, id)
+			continue
+		}
+
+		if now.Before(delivery.NextAttemptAt) {
+			continue
+		}
+
+		if done, err := p.deliverWebhookEvent(client, delivery, now); err != nil {
+			p.API.LogError("Failed to update webhook delivery", "delivery_id", delivery.ID, "err", err)
+		} else if done {
+			removed = append(removed, id)
+		}
+	}
+
+	if len(removed) > 0 {
+		if err := p.removeFromWebhookQueueIndex(removed); err != nil {
+			p.API.LogError("Failed to update webhook queue index", "err", err)
+		}
+	}
+}
+
+// deliverWebhookEvent makes a single attempt to send the delivery and then removes it from the queue or schedules it to
+// be retried. Returns whether or not the delivery was removed from the queue.
+func (p *Plugin) deliverWebhookEvent(client *http.Client, delivery *webhookDelivery, now time.Time) (bool, *model.AppError) {
+	queueKey := fmt.Sprintf(WEBHOOK_QUEUE_KEY, delivery.ID)
+
+	err := postWebhookEvent(client, delivery.URL, &delivery.Event)
+	if err == nil {
+		return true, p.API.KVDelete(queueKey)
+	}
+
+	delivery.Attempts += 1
+	delivery.LastAttemptAt = now
+	delivery.LastError = err.Error()
+
+	if delivery.Attempts >= WEBHOOK_MAX_ATTEMPTS {
+		p.API.LogWarn("Giving up on webhook delivery", "delivery_id", delivery.ID, "url", delivery.URL, "err", delivery.LastError)
+
+		if err := p.KVSet(fmt.Sprintf(WEBHOOK_DEAD_LETTER_KEY, delivery.ID), delivery); err != nil {
+			return false, err
+		}
+
+		return true, p.API.KVDelete(queueKey)
+	}
+
+	delivery.NextAttemptAt = now.Add(delivery.getRetryDelay())
+
+	return false, p.KVSet(queueKey, delivery)
+}
+
+// postWebhookEvent sends the event as JSON in a POST request to the URL.
+func postWebhookEvent(client *http.Client, url string, event *sinkEvent) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("webhook returned unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// getWebhookDeliveries returns every webhook delivery stored under the given key format, oldest first. This lists every
+// key in the KV store, so it shouldn't be used to find queued deliveries.
+func (p *Plugin) getWebhookDeliveries(keyFormat string) ([]*webhookDelivery, *model.AppError) {
+	deliveries := []*webhookDelivery{}
+
+	err := p.forEachKey(getKeyPrefix(keyFormat), func(key string) *model.AppError {
+		var delivery *webhookDelivery
+		if err := p.KVGet(key, &delivery); err != nil {
+			return err
+		}
+
+		if delivery != nil {
+			deliveries = append(deliveries, delivery)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].CreateAt.Before(deliveries[j].CreateAt)
+	})
+
+	return deliveries, nil
+}
+
+// retryDeadLetters moves every webhook delivery on the dead-letter list back into the queue so that it's attempted
+// again the next time that webhook events are delivered. Returns the number of deliveries that were moved.
+func (p *Plugin) retryDeadLetters(now time.Time) (int, *model.AppError) {
+	deadLetters, err := p.getWebhookDeliveries(WEBHOOK_DEAD_LETTER_KEY)
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []string
+
+	for _, delivery := range deadLetters {
+		delivery.Attempts = 0
+		delivery.NextAttemptAt = now
+
+		if err := p.KVSet(fmt.Sprintf(WEBHOOK_QUEUE_KEY, delivery.ID), delivery); err != nil {
+			return 0, err
+		}
+
+		ids = append(ids, delivery.ID)
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	// The deliveries are added to the index before they're removed from the dead-letter list so that none are lost
+	if err := p.addToWebhookQueueIndex(ids); err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		if err := p.API.KVDelete(fmt.Sprintf(WEBHOOK_DEAD_LETTER_KEY, id)); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(deadLetters), nil
+}
+
+func (p *Plugin) getWebhookFailures(w http.ResponseWriter, r *http.Request) {
+	deadLetters, appErr := p.getWebhookDeliveries(WEBHOOK_DEAD_LETTER_KEY)
+	if appErr != nil {
+		p.API.LogError("Failed to get failed webhook deliveries", "err", appErr)
+
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deadLetters)
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRetryDelay(t *testing.T) {
+	for _, test := range []struct {
+		Attempts int
+		Expected time.Duration
+	}{
+		{Attempts: 1, Expected: time.Minute},
+		{Attempts: 2, Expected: 2 * time.Minute},
+		{Attempts: 3, Expected: 4 * time.Minute},
+		{Attempts: 9, Expected: 256 * time.Minute},
+		{Attempts: 10, Expected: WEBHOOK_MAX_RETRY_DELAY},
+		{Attempts: 100, Expected: WEBHOOK_MAX_RETRY_DELAY},
+	} {
+		t.Run(fmt.Sprintf("%d attempts", test.Attempts), func(t *testing.T) {
+			delivery := &webhookDelivery{Attempts: test.Attempts}
+
+			assert.Equal(t, test.Expected, delivery.getRetryDelay())
+		})
+	}
+}
+
+func TestValidateWebhookURLs(t *testing.T) {
+	assert.Nil(t, validateWebhookURLs(nil))
+	assert.Nil(t, validateWebhookURLs([]string{"https://example.com/hook", "http://tickets.internal:8080/nps?token=abc"}))
+
+	assert.NotNil(t, validateWebhookURLs([]string{"https://example.com/hook", "example.com/hook"}))
+	assert.NotNil(t, validateWebhookURLs([]string{"ftp://example.com/hook"}))
+	assert.NotNil(t, validateWebhookURLs([]string{"https://"}))
+	assert.NotNil(t, validateWebhookURLs([]string{"https://example.com/%zz"}))
+}
+
+func TestPostWebhookEvent(t *testing.T) {
+	t.Run("should post event as JSON", func(t *testing.T) {
+		var received *sinkEvent
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+			require.Nil(t, json.NewDecoder(r.Body).Decode(&received))
+
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		err := postWebhookEvent(server.Client(), server.URL, &sinkEvent{
+			Event:      NPS_SCORE,
+			Properties: map[string]interface{}{"score": 7},
+		})
+
+		require.Nil(t, err)
+		assert.Equal(t, &sinkEvent{
+			Event:      NPS_SCORE,
+			Properties: map[string]interface{}{"score": float64(7)},
+		}, received)
+	})
+
+	t.Run("should return an error when the webhook fails", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		err := postWebhookEvent(server.Client(), server.URL, &sinkEvent{
+			Event:      NPS_SCORE,
+			Properties: map[string]interface{}{"score": 7},
+		})
+
+		assert.NotNil(t, err)
+	})
+}
+
+// matchWebhookDelivery matches the JSON of a webhookDelivery that passes the given check.
+func matchWebhookDelivery(check func(delivery *webhookDelivery) bool) interface{} {
+	return mock.MatchedBy(func(b []byte) bool {
+		var delivery *webhookDelivery
+		if err := json.Unmarshal(b, &delivery); err != nil {
+			return false
+		}
+
+		return check(delivery)
+	})
+}
+
+func TestQueueWebhookEvent(t *testing.T) {
+	now := toDate(2019, time.June, 1)
+	properties := map[string]interface{}{"score": 7}
+
+	t.Run("should queue a delivery for each URL", func(t *testing.T) {
+		api := makeAPIMock()
+		for _, url := range []string{"https://example.com/1", "https://example.com/2"} {
+			url := url
+
+			api.On("KVSet", mock.MatchedBy(func(key string) bool {
+				return strings.HasPrefix(key, getKeyPrefix(WEBHOOK_QUEUE_KEY))
+			}), matchWebhookDelivery(func(delivery *webhookDelivery) bool {
+				return delivery.URL == url &&
+					delivery.Event.Event == NPS_SCORE &&
+					delivery.Event.Properties["score"] == float64(7) &&
+					delivery.NextAttemptAt.Equal(now) &&
+					delivery.Attempts == 0
+			})).Return(nil).Once()
+		}
+		existingID := model.NewId()
+		api.On("KVGet", WEBHOOK_QUEUE_INDEX_KEY).Return(mustMarshalJSON([]string{existingID}), nil)
+		api.On("KVCompareAndSet", WEBHOOK_QUEUE_INDEX_KEY, mustMarshalJSON([]string{existingID}), mock.MatchedBy(func(b []byte) bool {
+			var ids []string
+			return json.Unmarshal(b, &ids) == nil && len(ids) == 3 && ids[0] == existingID
+		})).Return(true, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			configuration: &configuration{EventWebhookURLs: "https://example.com/1\nhttps://example.com/2"},
+		}
+		p.SetAPI(api)
+
+		queued, err := p.queueWebhookEvent(NPS_SCORE, properties, now)
+
+		assert.Nil(t, err)
+		assert.True(t, queued)
+	})
+
+	t.Run("should not queue anything without any URLs", func(t *testing.T) {
+		p := &Plugin{
+			configuration: &configuration{},
+		}
+
+		queued, err := p.queueWebhookEvent(NPS_SCORE, properties, now)
+
+		assert.Nil(t, err)
+		assert.False(t, queued)
+	})
+
+	t.Run("should not queue answers", func(t *testing.T) {
+		p := &Plugin{
+			configuration: &configuration{EventWebhookURLs: "https://example.com/1"},
+		}
+
+		queued, err := p.queueWebhookEvent(NPS_ANSWER, properties, now)
+
+		assert.Nil(t, err)
+		assert.False(t, queued)
+	})
+
+	t.Run("should return an error if unable to store the delivery", func(t *testing.T) {
+		api := makeAPIMock()
+		api.On("KVSet", mock.Anything, mock.Anything).Return(&model.AppError{})
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{
+			configuration: &configuration{EventWebhookURLs: "https://example.com/1"},
+		}
+		p.SetAPI(api)
+
+		_, err := p.queueWebhookEvent(NPS_FEEDBACK, properties, now)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestDeliverWebhookEvents(t *testing.T) {
+	now := toDate(2019, time.June, 1)
+
+	makeServer := func(status int, received *[]*sinkEvent) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var event *sinkEvent
+			require.Nil(t, json.NewDecoder(r.Body).Decode(&event))
+
+			*received = append(*received, event)
+
+			w.WriteHeader(status)
+		}))
+	}
+
+	makeDelivery := func(url string, attempts int, nextAttemptAt time.Time) *webhookDelivery {
+		return &webhookDelivery{
+			ID:  model.NewId(),
+			URL: url,
+			Event: sinkEvent{
+				Event:      NPS_SCORE,
+				Properties: map[string]interface{}{"score": float64(7)},
+			},
+			CreateAt:      now.Add(-time.Hour),
+			Attempts:      attempts,
+			NextAttemptAt: nextAttemptAt,
+		}
+	}
+
+	makeAPIMock := func(deliveries ...*webhookDelivery) *plugintest.API {
+		api := makeAPIMock()
+		api.On("KVCompareAndSet", WEBHOOK_DELIVERY_LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(true, nil)
+		api.On("KVDelete", WEBHOOK_DELIVERY_LOCK_KEY).Return(nil)
+
+		if len(deliveries) > 0 {
+			var ids []string
+			for _, delivery := range deliveries {
+				ids = append(ids, delivery.ID)
+				api.On("KVGet", fmt.Sprintf(WEBHOOK_QUEUE_KEY, delivery.ID)).Return(mustMarshalJSON(delivery), nil)
+			}
+			api.On("KVGet", WEBHOOK_QUEUE_INDEX_KEY).Return(mustMarshalJSON(ids), nil)
+		}
+
+		return api
+	}
+
+	// mockIndexUpdate expects the queue index to be saved with only the remaining IDs.
+	mockIndexUpdate := func(api *plugintest.API, previous []string, remaining []string) {
+		api.On("KVCompareAndSet", WEBHOOK_QUEUE_INDEX_KEY, mustMarshalJSON(previous), mustMarshalJSON(remaining)).Return(true, nil)
+	}
+
+	t.Run("should send due deliveries and remove them from the queue", func(t *testing.T) {
+		var received []*sinkEvent
+
+		server := makeServer(http.StatusOK, &received)
+		defer server.Close()
+
+		due := makeDelivery(server.URL, 0, now)
+		notDue := makeDelivery(server.URL, 1, now.Add(time.Minute))
+
+		api := makeAPIMock(due, notDue)
+		api.On("KVDelete", fmt.Sprintf(WEBHOOK_QUEUE_KEY, due.ID)).Return(nil)
+		mockIndexUpdate(api, []string{due.ID, notDue.ID}, []string{notDue.ID})
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		p.deliverWebhookEvents(now)
+
+		assert.Equal(t, []*sinkEvent{&due.Event}, received)
+	})
+
+	t.Run("should retry failed deliveries later", func(t *testing.T) {
+		var received []*sinkEvent
+
+		server := makeServer(http.StatusInternalServerError, &received)
+		defer server.Close()
+
+		delivery := makeDelivery(server.URL, 2, now.Add(-time.Minute))
+
+		api := makeAPIMock(delivery)
+		api.On("KVSet", fmt.Sprintf(WEBHOOK_QUEUE_KEY, delivery.ID), matchWebhookDelivery(func(updated *webhookDelivery) bool {
+			return updated.Attempts == 3 &&
+				updated.LastAttemptAt.Equal(now) &&
+				updated.NextAttemptAt.Equal(now.Add(4*time.Minute)) &&
+				updated.LastError != ""
+		})).Return(nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		p.deliverWebhookEvents(now)
+
+		assert.Len(t, received, 1)
+	})
+
+	t.Run("should move deliveries that fail too many times to the dead-letter list", func(t *testing.T) {
+		var received []*sinkEvent
+
+		server := makeServer(http.StatusBadRequest, &received)
+		defer server.Close()
+
+		delivery := makeDelivery(server.URL, WEBHOOK_MAX_ATTEMPTS-1, now)
+
+		api := makeAPIMock(delivery)
+		api.On("KVSet", fmt.Sprintf(WEBHOOK_DEAD_LETTER_KEY, delivery.ID), matchWebhookDelivery(func(updated *webhookDelivery) bool {
+			return updated.Attempts == WEBHOOK_MAX_ATTEMPTS && updated.LastAttemptAt.Equal(now)
+		})).Return(nil)
+		api.On("KVDelete", fmt.Sprintf(WEBHOOK_QUEUE_KEY, delivery.ID)).Return(nil)
+		api.On("LogWarn", "Giving up on webhook delivery", "delivery_id", delivery.ID, "url", server.URL, "err", mock.Anything).Return(nil)
+		mockIndexUpdate(api, []string{delivery.ID}, []string{})
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		p.deliverWebhookEvents(now)
+
+		assert.Len(t, received, 1)
+	})
+
+	t.Run("should remove deliveries that no longer exist from the queue", func(t *testing.T) {
+		id := model.NewId()
+
+		api := makeAPIMock()
+		api.On("KVGet", WEBHOOK_QUEUE_INDEX_KEY).Return(mustMarshalJSON([]string{id}), nil)
+		api.On("KVGet", fmt.Sprintf(WEBHOOK_QUEUE_KEY, id)).Return(nil, nil)
+		mockIndexUpdate(api, []string{id}, []string{})
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		p.deliverWebhookEvents(now)
+	})
+
+	t.Run("should do nothing without locking when nothing is queued", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVGet", WEBHOOK_QUEUE_INDEX_KEY).Return(nil, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		p.deliverWebhookEvents(now)
+	})
+
+	t.Run("should do nothing if another thread is delivering webhook events", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVGet", WEBHOOK_QUEUE_INDEX_KEY).Return(mustMarshalJSON([]string{model.NewId()}), nil)
+		api.On("KVCompareAndSet", WEBHOOK_DELIVERY_LOCK_KEY, []byte(nil), mustMarshalJSON(now)).Return(false, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		p.deliverWebhookEvents(now)
+	})
+}
+
+func TestRetryDeadLetters(t *testing.T) {
+	now := toDate(2019, time.June, 1)
+
+	delivery := &webhookDelivery{
+		ID:            model.NewId(),
+		URL:           "https://example.com/hook",
+		Attempts:      WEBHOOK_MAX_ATTEMPTS,
+		NextAttemptAt: now.Add(-24 * time.Hour),
+		LastError:     "webhook returned unexpected status code 500",
+	}
+	deadLetterKey := fmt.Sprintf(WEBHOOK_DEAD_LETTER_KEY, delivery.ID)
+
+	api := makeAPIMock()
+	api.On("KVList", 0, 100).Return([]string{deadLetterKey}, nil)
+	api.On("KVGet", deadLetterKey).Return(mustMarshalJSON(delivery), nil)
+	api.On("KVSet", fmt.Sprintf(WEBHOOK_QUEUE_KEY, delivery.ID), matchWebhookDelivery(func(updated *webhookDelivery) bool {
+		return updated.Attempts == 0 && updated.NextAttemptAt.Equal(now) && updated.LastError == delivery.LastError
+	})).Return(nil)
+	api.On("KVGet", WEBHOOK_QUEUE_INDEX_KEY).Return(nil, nil)
+	api.On("KVCompareAndSet", WEBHOOK_QUEUE_INDEX_KEY, []byte(nil), mustMarshalJSON([]string{delivery.ID})).Return(true, nil)
+	api.On("KVDelete", deadLetterKey).Return(nil)
+	defer api.AssertExpectations(t)
+
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	count, err := p.retryDeadLetters(now)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestUpdateWebhookQueueIndex(t *testing.T) {
+	t.Run("should try again when another thread changes the index first", func(t *testing.T) {
+		first := []string{model.NewId()}
+		second := []string{model.NewId(), model.NewId()}
+		added := model.NewId()
+
+		api := &plugintest.API{}
+		api.On("KVGet", WEBHOOK_QUEUE_INDEX_KEY).Return(mustMarshalJSON(first), nil).Once()
+		api.On("KVCompareAndSet", WEBHOOK_QUEUE_INDEX_KEY, mustMarshalJSON(first), mustMarshalJSON([]string{first[0], added})).Return(false, nil)
+		api.On("KVGet", WEBHOOK_QUEUE_INDEX_KEY).Return(mustMarshalJSON(second), nil).Once()
+		api.On("KVCompareAndSet", WEBHOOK_QUEUE_INDEX_KEY, mustMarshalJSON(second), mustMarshalJSON([]string{second[0], second[1], added})).Return(true, nil)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		assert.Nil(t, p.addToWebhookQueueIndex([]string{added}))
+	})
+
+	t.Run("should give up after too many attempts", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVGet", WEBHOOK_QUEUE_INDEX_KEY).Return(nil, nil).Times(WEBHOOK_QUEUE_INDEX_MAX_RETRIES)
+		api.On("KVCompareAndSet", WEBHOOK_QUEUE_INDEX_KEY, []byte(nil), mock.Anything).Return(false, nil).Times(WEBHOOK_QUEUE_INDEX_MAX_RETRIES)
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		assert.NotNil(t, p.removeFromWebhookQueueIndex([]string{model.NewId()}))
+	})
+}
@@ -19,18 +19,39 @@ export default class SurveyPost extends React.PureComponent {
         this.props.doPostActionWithCookie(this.props.post.id, action.id, action.cookie, score.toString());
     }
 
-    getAction = () => {
+    optOut = (e) => {
+        e.preventDefault();
+
+        const action = this.getOptOutAction();
+
+        this.props.doPostActionWithCookie(this.props.post.id, action.id, action.cookie);
+    }
+
+    getAttachment = () => {
         const {post} = this.props;
         if (!post || !post.props || !post.props.attachments) {
             return null;
         }
 
-        const attachment = post.props.attachments[0];
+        return post.props.attachments[0] || null;
+    }
+
+    getActions = () => {
+        const attachment = this.getAttachment();
         if (!attachment || !attachment.actions) {
-            return null;
+            return [];
         }
 
-        return attachment.actions[0];
+        return attachment.actions;
+    }
+
+    getAction = () => {
+        return this.getActions()[0] || null;
+    }
+
+    getOptOutAction = () => {
+        // The server adds a button to opt out of surveys after the score dropdown
+        return this.getActions()[1] || null;
     }
 
     getSelectedScore = () => {
@@ -47,6 +68,7 @@ export default class SurveyPost extends React.PureComponent {
     }
 
     renderScores = (style) => {
+        const props = this.props.post.props || {};
         const selectedScore = this.getSelectedScore();
 
         const scores = [];
@@ -66,8 +88,8 @@ export default class SurveyPost extends React.PureComponent {
         return (
             <div style={this.props.isSmall ? style.scoreContainerSmall : style.scoreContainer}>
                 <div style={style.scoreLabels}>
-                    <span>{'Not Likely'}</span>
-                    <span style={style.scoreLabelRight}>{'Very Likely'}</span>
+                    <span>{props.min_label}</span>
+                    <span style={style.scoreLabelRight}>{props.max_label}</span>
                 </div>
                 <div style={this.props.isSmall ? style.scoresSmall : style.scores}>
                     {scores}
@@ -76,15 +98,37 @@ export default class SurveyPost extends React.PureComponent {
         );
     }
 
+    renderOptOut = (style) => {
+        const action = this.getOptOutAction();
+        if (!action) {
+            return null;
+        }
+
+        return (
+            <div style={style.optOut}>
+                <a
+                    href='#'
+                    onClick={this.optOut}
+                >
+                    {action.name}
+                </a>
+            </div>
+        );
+    }
+
     render() {
         const style = getStyle(this.props.theme);
 
+        // The title and labels are translated into the user's language by the server
+        const attachment = this.getAttachment();
+
         return (
             <React.Fragment>
                 {window.PostUtils.messageHtmlToComponent(window.PostUtils.formatText(this.props.post.message, {atMentions: true}))}
                 <div style={style.container}>
-                    <h1 style={style.title}>{'How likely are you to recommend Mattermost?'}</h1>
+                    <h1 style={style.title}>{attachment && attachment.title}</h1>
                     {this.renderScores(style)}
+                    {this.renderOptOut(style)}
                 </div>
             </React.Fragment>
         );
@@ -106,6 +150,10 @@ const getStyle = makeStyleFromTheme((theme) => {
             marginTop: 5,
             padding: 12,
         },
+        optOut: {
+            fontSize: 12,
+            marginTop: 8,
+        },
         scoreContainer: {
             display: 'flex',
             flexDirection: 'column',